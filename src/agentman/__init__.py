@@ -1,10 +1,19 @@
 """Agentman package for building MCP agents from Agentfiles."""
 
+import importlib
 import sys
 
-from agentman.cli import HelpException, init_cli, print_version
 from agentman.common import perror
 
 assert sys.version_info >= (3, 10), "Python 3.10 or greater is required."
 
 __all__ = ["perror", "init_cli", "print_version", "HelpException"]
+
+# Loaded on first use, so tools importing agentman.api do not import the command line
+CLI_NAMES = ["init_cli", "print_version", "HelpException"]
+
+
+def __getattr__(name):
+    if name in CLI_NAMES:
+        return getattr(importlib.import_module("agentman.cli"), name)
+    raise AttributeError(f"module {__name__!r} has no attribute {name!r}")
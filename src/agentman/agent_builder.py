@@ -1,28 +1,159 @@
 """Agent builder module for generating files from Agentfile configuration."""
 
 import json
+import os
+import posixpath
 import subprocess
+import sys
+from datetime import datetime, timezone
 from pathlib import Path
+from typing import Dict, Optional
 
 import yaml
 
-from agentman.agentfile_parser import AgentfileConfig, AgentfileParser
+from agentman.agentfile_parser import (
+    DOCKER_SOCKET_MOUNT,
+    AgentfileConfig,
+    AgentfileParser,
+    instruction_image_path,
+)
+from agentman.common import perror
+from agentman.config_check import CONFIG_CHECK_FILENAME, build_config_check_script
+from agentman.diagnostics import count_warnings, format_diagnostics
+from agentman.environment import collect_environment, render_env_example
 from agentman.frameworks import AgnoFramework, FastAgentFramework
+from agentman.lockfile import LockApplier, lockfile_path, read_lock
+from agentman.manifest import MANIFEST_FILENAME, MANIFEST_LABEL, build_manifest, label_value, manifest_label_value
+from agentman.prune import prune_unused
+from agentman.run_hints import build_run_hints
+from agentman.secrets_entrypoint import SECRETS_ENTRYPOINT_FILENAME, build_secrets_entrypoint
+from agentman.stats import Stats
+from agentman.supervisor import SUPERVISOR_FILENAME, build_supervisor_script
+from agentman.vendor import (
+    VENDOR_DIRNAME,
+    check_offline_compatible,
+    collect_packages,
+    offline_dockerfile_lines,
+    vendor_packages,
+)
+
+# User and group --rootless creates to run the container as
+ROOTLESS_USER = "agent"
+
+
+def check_runtime_secrets(config: AgentfileConfig, combined_config: bool):
+    """Reject builds where --runtime-secrets could not keep the secrets out of the image."""
+    if config.framework != "fast-agent":
+        raise ValueError("--runtime-secrets writes fastagent.secrets.yaml, which only FRAMEWORK fast-agent reads")
+    if combined_config:
+        raise ValueError(
+            "--runtime-secrets writes fastagent.secrets.yaml when the container starts, "
+            "but --combined-config puts the secrets in fastagent.config.yaml"
+        )
+    if config.entrypoint:
+        raise ValueError("--runtime-secrets sets the image ENTRYPOINT; remove ENTRYPOINT from the Agentfile")
+
+
+def build_timestamp(agentfile: Optional[str] = None) -> str:
+    """Return the image creation time in RFC 3339.
+
+    SOURCE_DATE_EPOCH sets it when present. Otherwise it is when the Agentfile was last committed,
+    or last modified if it has uncommitted changes, so an unchanged Agentfile always gives the same
+    Dockerfile. Only a configuration that was not read from a file gets the current time.
+    """
+    epoch = os.environ.get("SOURCE_DATE_EPOCH")
+    if epoch:
+        if not epoch.isdigit():
+            raise ValueError(f"SOURCE_DATE_EPOCH must be a whole number of seconds, got {epoch}")
+        seconds = int(epoch)
+    elif agentfile and os.path.isfile(agentfile):
+        seconds = _commit_time(agentfile) or int(os.path.getmtime(agentfile))
+    else:
+        seconds = int(datetime.now(timezone.utc).timestamp())
+    return datetime.fromtimestamp(seconds, timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def _commit_time(path: str) -> Optional[int]:
+    """Return when git last committed path, or None when it is untracked, modified or not in a repository."""
+    directory, name = os.path.split(os.path.abspath(path))
+    try:
+        status = subprocess.run(
+            ["git", "status", "--porcelain", "--", name], cwd=directory, check=True, capture_output=True, text=True
+        )
+        log = subprocess.run(
+            ["git", "log", "-1", "--format=%ct", "--", name], cwd=directory, check=True, capture_output=True, text=True
+        )
+    except (OSError, subprocess.CalledProcessError):
+        return None
+    if status.stdout.strip() or not log.stdout.strip().isdigit():
+        return None
+    return int(log.stdout.strip())
+
+
+def busybox_base(image: str) -> bool:
+    """Whether an image is Alpine or BusyBox based, judged by its name and tag, so it lacks useradd."""
+    name = image.split("@", 1)[0].rsplit("/", 1)[-1]
+    return "alpine" in name or "busybox" in name
+
+
+def image_workdir(instructions) -> str:
+    """Return the working directory the image ends up with, /app unless a WORKDIR instruction sets it."""
+    workdir = None
+    for instruction in instructions:
+        if instruction.instruction == "WORKDIR" and instruction.args:
+            # A relative WORKDIR is resolved against the previous one, as docker does
+            workdir = posixpath.join(workdir or "/", " ".join(instruction.args))
+    return posixpath.normpath(workdir) if workdir else "/app"
 
 
 class AgentBuilder:
     """Builds agent files from Agentfile configuration."""
 
-    def __init__(self, config: AgentfileConfig, output_dir: str = "output", source_dir: str = "."):
+    def __init__(
+        self,
+        config: AgentfileConfig,
+        output_dir: str = "output",
+        source_dir: str = ".",
+        offline: bool = False,
+        lock: Optional[dict] = None,
+        frozen_lock: bool = False,
+        combined_config: bool = False,
+        annotate: bool = False,
+        stats: Optional[Stats] = None,
+        prompt: Optional[str] = None,
+        verify_configs: bool = True,
+        inline_instructions: bool = False,
+        copy_config: bool = False,
+        runtime_secrets: bool = False,
+        rootless: bool = False,
+    ):
+        if runtime_secrets:
+            check_runtime_secrets(config, combined_config)
+        # Parsing expands PROMPT references; a configuration built in code may still hold them
+        config.expand_prompts()
         self.config = config
         self._output_dir = Path(output_dir)
         self.source_dir = Path(source_dir)
-        # Check if prompt.txt exists in the source directory
+        self.offline = offline
+        self.combined_config = combined_config
+        self.annotate = annotate
+        self.verify_configs = verify_configs  # Check the generated config files while the image builds
+        self.rootless = rootless  # Run the container as ROOTLESS_USER unless the Agentfile sets USER
+        self.stats = stats or Stats()
+        self.created = build_timestamp(config.source_path)
+        self.lock_applier = LockApplier(lock, frozen_lock) if lock is not None else None
+        # Check if prompt.txt exists in the source directory; an explicit prompt replaces it
+        self.prompt = prompt
         self.prompt_file_path = self.source_dir / "prompt.txt"
-        self.has_prompt_file = self.prompt_file_path.exists()
+        self.has_prompt_file = prompt is not None or self.prompt_file_path.exists()
 
         # Initialize framework handler
         self.framework = self._get_framework_handler()
+        self.framework.has_prompt_file = self.has_prompt_file
+        self.framework.inline_instructions = inline_instructions
+        # The fast-agent config is written into the Dockerfile unless it holds the secrets or copy_config is set
+        self.framework.embed_config = config.framework == "fast-agent" and not (copy_config or combined_config)
+        self.framework.runtime_secrets = runtime_secrets
 
     @property
     def output_dir(self):
@@ -39,20 +170,37 @@ class AgentBuilder:
     def _get_framework_handler(self):
         """Get the appropriate framework handler based on configuration."""
         if self.config.framework == "agno":
-            return AgnoFramework(self.config, self._output_dir, self.source_dir)
+            return AgnoFramework(self.config, self._output_dir, self.source_dir, self.combined_config, self.annotate)
         else:
-            return FastAgentFramework(self.config, self._output_dir, self.source_dir)
+            return FastAgentFramework(
+                self.config, self._output_dir, self.source_dir, self.combined_config, self.annotate
+            )
 
     def build_all(self):
         """Build all generated files."""
-        self._ensure_output_dir()
-        self._copy_prompt_file()
-        self._generate_python_agent()
-        self._generate_config_yaml()
-        self._generate_dockerfile()
-        self._generate_requirements_txt()
-        self._generate_dockerignore()
-        self._validate_output()
+        with self.stats.phase("validate"):
+            if self.offline:
+                check_offline_compatible(self.config)
+            if self.lock_applier:
+                self.lock_applier.apply_to_servers(self.config)
+        with self.stats.phase("generate"):
+            self._ensure_output_dir()
+            self._copy_prompt_file()
+            self._write_instruction_files()
+            self._vendor_dependencies()
+            self._generate_python_agent()
+            self._generate_supervisor()
+            self._generate_secrets_entrypoint()
+            self._generate_config_yaml()
+            self._generate_dockerfile()
+            self._generate_requirements_txt()
+            self._generate_dockerignore()
+            self._generate_env_example()
+            self._generate_manifest()
+            self._generate_config_check()
+        self._warn_stale_lock()
+        with self.stats.phase("validate"):
+            self._validate_output()
 
     def _ensure_output_dir(self):
         """Ensure output directory exists."""
@@ -60,12 +208,56 @@ class AgentBuilder:
 
     def _copy_prompt_file(self):
         """Copy prompt.txt to output directory if it exists."""
-        if self.has_prompt_file:
+        if self.prompt is not None:
+            (self.output_dir / "prompt.txt").write_text(self.prompt + "\n", encoding="utf-8")
+        elif self.has_prompt_file:
             import shutil
 
             dest_path = self.output_dir / "prompt.txt"
             shutil.copy2(self.prompt_file_path, dest_path)
 
+    def _write_instruction_files(self):
+        """Write the INSTRUCTION_FILE contents the parser read to where the agent reads them in the image."""
+        for entity in self.framework.instruction_files():
+            path = self.output_dir / instruction_image_path(entity)
+            path.parent.mkdir(exist_ok=True)
+            path.write_text(entity.instruction, encoding="utf-8")
+
+    def _vendor_dependencies(self):
+        """Download dependencies into the output directory for offline builds."""
+        if self.offline:
+            vendor_packages(self.get_packages(), self.output_dir / VENDOR_DIRNAME)
+
+    def get_packages(self):
+        """Get the packages the image installs, grouped by installer."""
+        return collect_packages(self.config, self._get_requirements())
+
+    def _get_requirements(self):
+        """Get the framework requirements, pinned by the lockfile when present."""
+        requirements = self.framework.get_requirements()
+        if self.lock_applier:
+            requirements = self.lock_applier.apply_to_requirements(requirements)
+        return requirements
+
+    def _warn_stale_lock(self):
+        """Warn about packages the lockfile does not cover."""
+        if self.lock_applier and self.lock_applier.missing:
+            perror("⚠️  Lockfile is stale, unpinned packages: " + ", ".join(self.lock_applier.missing))
+
+    def _image_labels(self) -> Dict[str, str]:
+        """Return the image labels with their Dockerfile values, the Agentfile's replacing generated ones."""
+        escape = self.config.escape_char
+        # The manifest comes first so `agentman inspect` can read it back
+        labels = {MANIFEST_LABEL: manifest_label_value(build_manifest(self.config, self.has_prompt_file), escape)}
+        labels["org.opencontainers.image.created"] = label_value(self.created, escape)
+        labels["agentman.framework"] = label_value(self.config.framework, escape)
+        if self.config.default_model:
+            labels["agentman.default_model"] = label_value(self.config.default_model, escape)
+        if self.config.source_sha256:
+            labels["agentman.agentfile.sha256"] = label_value(self.config.source_sha256, escape)
+        labels.update(self.config.labels)
+        return labels
+
     def _generate_python_agent(self):
         """Generate the main Python agent file."""
         content = self.framework.build_agent_content()
@@ -74,45 +266,151 @@ class AgentBuilder:
         with open(agent_file, 'w', encoding='utf-8') as f:
             f.write(content)
 
+    def _generate_supervisor(self):
+        """Generate the supervisor that runs a CMD_MODE append command next to the agent."""
+        if self.config.resolved_cmd_mode != "append":
+            return
+        content = build_supervisor_script([self.config.cmd, self.config.generated_cmd()], self.config.shutdown_grace)
+        with open(self.output_dir / SUPERVISOR_FILENAME, 'w', encoding='utf-8') as f:
+            f.write(content)
+
+    def _generate_secrets_entrypoint(self):
+        """Generate the entrypoint that writes the secrets file from the environment when the container starts."""
+        if not self.framework.runtime_secrets:
+            return
+        content = build_secrets_entrypoint(self.framework.secrets_layout())
+        with open(self.output_dir / SECRETS_ENTRYPOINT_FILENAME, 'w', encoding='utf-8') as f:
+            f.write(content)
+
+    def _checked_config_files(self):
+        """Return the generated config files the image build checks."""
+        return self.framework.get_config_files() + [MANIFEST_FILENAME]
+
+    def _generate_config_check(self):
+        """Generate the script the Dockerfile runs to check the generated config files."""
+        if not self.verify_configs:
+            return
+        content = build_config_check_script(self._checked_config_files())
+        with open(self.output_dir / CONFIG_CHECK_FILENAME, 'w', encoding='utf-8') as f:
+            f.write(content)
+
     def _generate_config_yaml(self):
         """Generate the configuration file based on framework."""
         self.framework.generate_config_files()
 
     def _generate_dockerfile(self):
         """Generate the Dockerfile."""
-        lines = []
+        dockerfile = self.output_dir / "Dockerfile"
+        with open(dockerfile, 'w', encoding='utf-8') as f:
+            f.write(self.dockerfile_content())
+
+    def dockerfile_content(self) -> str:
+        """Return the text of the Dockerfile build_all writes."""
+        # Parser directives must stay the first lines of the Dockerfile
+        lines = self.config.directive_lines()
+        # Heredocs need the Dockerfile 1.4 syntax, which the dockerfile:1 frontend provides
+        if self.framework.embed_config and "syntax" not in self.config.directives:
+            lines.insert(0, "# syntax=docker/dockerfile:1")
+
+        # Record a command-line base image override above the FROM line
+        if self.config.agentfile_base_image is not None:
+            lines.append(f"# Base image overridden by --base-image (Agentfile: {self.config.agentfile_base_image})")
+
+        # Build stages other than the agent's come first, passed through as written
+        agent_stage = self.config.agent_stage
+        for index in range(len(self.config.stages)):
+            if index == agent_stage:
+                continue
+            for instruction in self.config.dockerfile_instructions:
+                if instruction.stage == index:
+                    lines.extend(self.framework.source_comment(instruction.line, instruction.instruction))
+                    lines.append(instruction.passthrough_text())
+            lines.append("")
 
         # Start with FROM instruction
-        lines.extend([f"FROM {self.config.base_image}", ""])
+        instructions = self.config.agent_instructions
+        from_lines = [inst for inst in instructions if inst.instruction == "FROM"]
+        from_args = [self.config.base_image]
+        if from_lines:
+            lines.extend(self.framework.source_comment(from_lines[-1].line, "FROM"))
+            flags = [arg for arg in from_lines[-1].args if arg.startswith("--")]
+            from_args = flags + from_args
+        if self.config.stages and self.config.stages[agent_stage].name:
+            from_args += ["AS", self.config.stages[agent_stage].name]
+        lines.extend([f"FROM {' '.join(from_args)}", ""])
 
         # Copy requirements and install Python dependencies
-        lines.extend(
-            [
-                "# Copy requirements and install Python dependencies",
-                "COPY requirements.txt .",
-                "RUN pip install --no-cache-dir -r requirements.txt",
-                "",
-            ]
-        )
+        if self.offline:
+            workdir = image_workdir(instructions)
+            lines.extend(["COPY requirements.txt ."] + offline_dockerfile_lines(self.get_packages(), workdir))
+        else:
+            lines.extend(
+                [
+                    "# Copy requirements and install Python dependencies",
+                    "COPY requirements.txt .",
+                    "RUN pip install --no-cache-dir -r requirements.txt",
+                    "",
+                ]
+            )
+
+        # Servers launched through docker need the docker CLI inside the image
+        docker_servers = [server for server in self.config.servers.values() if server.uses_docker]
+        if docker_servers:
+            for server in docker_servers:
+                lines.extend(self.framework.source_comment(server.line, f"MCP_SERVER {server.name}"))
+            lines.extend(
+                [
+                    "# Install the docker CLI for docker-launched MCP servers",
+                    f"# Run the image with -v {DOCKER_SOCKET_MOUNT}",
+                    "COPY --from=docker:cli /usr/local/bin/docker /usr/local/bin/docker",
+                    "",
+                ]
+            )
 
         # Add all other Dockerfile instructions in order (except FROM)
-        # We'll handle EXPOSE and CMD at the end in their proper positions
-        for instruction in self.config.dockerfile_instructions:
-            if instruction.instruction not in ["FROM", "EXPOSE", "CMD"]:
-                lines.append(instruction.to_dockerfile_line())
+        # We'll handle EXPOSE and CMD at the end in their proper positions, and LABEL with the generated labels
+        for instruction in instructions:
+            if instruction.instruction not in ["FROM", "EXPOSE", "CMD", "LABEL"]:
+                lines.extend(self.framework.source_comment(instruction.line, instruction.instruction))
+                lines.append(instruction.passthrough_text())
 
         # Add a blank line if we have custom instructions
         custom_instructions = [
-            inst for inst in self.config.dockerfile_instructions if inst.instruction not in ["FROM", "EXPOSE", "CMD"]
+            inst for inst in instructions if inst.instruction not in ["FROM", "EXPOSE", "CMD", "LABEL"]
         ]
         if custom_instructions:
             lines.append("")
 
         # Set working directory if not already set by custom instructions
-        workdir_set = any(inst.instruction == "WORKDIR" for inst in self.config.dockerfile_instructions)
+        workdir_set = any(inst.instruction == "WORKDIR" for inst in instructions)
         if not workdir_set:
             lines.extend(["WORKDIR /app", ""])
 
+        # The user must exist before the COPY lines below can hand it their files
+        rootless = self.rootless and not any(inst.instruction == "USER" for inst in instructions)
+        if rootless:
+            escape = self.config.escape_char
+            lines.extend(
+                [
+                    f"# Create the {ROOTLESS_USER} user the container runs as; it owns the working directory",
+                ]
+            )
+            if busybox_base(self.config.base_image):
+                lines.extend(
+                    [
+                        f"RUN addgroup -S {ROOTLESS_USER} {escape}",
+                        f"    && adduser -S -G {ROOTLESS_USER} {ROOTLESS_USER} {escape}",
+                    ]
+                )
+            else:
+                lines.extend(
+                    [
+                        f"RUN groupadd --system {ROOTLESS_USER} {escape}",
+                        f"    && useradd --system --gid {ROOTLESS_USER} --create-home {ROOTLESS_USER} {escape}",
+                    ]
+                )
+            lines.extend([f"    && chown {ROOTLESS_USER}:{ROOTLESS_USER} .", ""])
+
         # Copy application files
         copy_lines = [
             "# Copy application files",
@@ -126,40 +424,90 @@ class AgentBuilder:
         # Add prompt.txt copy if it exists
         if self.has_prompt_file:
             copy_lines.append("COPY prompt.txt .")
+        if self.framework.instruction_files():
+            copy_lines.append("COPY instructions/ instructions/")
 
+        append_cmd = self.config.resolved_cmd_mode == "append"
+        if append_cmd:
+            copy_lines.append(f"COPY {SUPERVISOR_FILENAME} .")
+
+        copy_lines.append(f"COPY {MANIFEST_FILENAME} .")
         copy_lines.append("")
+        if rootless:
+            # Heredoc bodies hold YAML, so only the COPY instructions themselves start with "COPY "
+            chown = f"COPY --chown={ROOTLESS_USER}:{ROOTLESS_USER} "
+            copy_lines = [chown + line[5:] if line.startswith("COPY ") else line for line in copy_lines]
         lines.extend(copy_lines)
 
+        # Fail the build on a malformed generated config file instead of when the container starts
+        if self.verify_configs:
+            lines.extend(
+                [
+                    "# Check the generated configuration files",
+                    f"COPY {CONFIG_CHECK_FILENAME} .",
+                    f"RUN python {CONFIG_CHECK_FILENAME} && rm {CONFIG_CHECK_FILENAME}",
+                    "",
+                ]
+            )
+
+        # Agentfile LABELs and the generated labels go in one LABEL instruction, which adds a single layer
+        for instruction in instructions:
+            if instruction.instruction == "LABEL":
+                lines.extend(self.framework.source_comment(instruction.line, instruction.instruction))
+        separator = f" {self.config.escape_char}\n      "
+        labels = [f"{key}={value}" for key, value in self._image_labels().items()]
+        lines.extend(["LABEL " + separator.join(labels), ""])
+
+        # Write the secrets file from the container's environment before the CMD runs
+        if self.framework.runtime_secrets:
+            lines.extend([f"ENTRYPOINT {json.dumps(['python', SECRETS_ENTRYPOINT_FILENAME])}", ""])
+
         # Add EXPOSE instructions from custom dockerfile instructions first
-        expose_instructions = [inst for inst in self.config.dockerfile_instructions if inst.instruction == "EXPOSE"]
+        expose_instructions = [inst for inst in instructions if inst.instruction == "EXPOSE"]
         if expose_instructions:
             for instruction in expose_instructions:
-                lines.append(instruction.to_dockerfile_line())
+                lines.extend(self.framework.source_comment(instruction.line, instruction.instruction))
+                lines.append(instruction.passthrough_text())
             lines.append("")
 
-        # Add EXPOSE from config.expose_ports if not already handled
-        if self.config.expose_ports and not expose_instructions:
-            expose_lines = [f"EXPOSE {port}" for port in self.config.expose_ports]
+        # Add EXPOSE for the ports in config.expose_ports that no EXPOSE instruction covers, such as SERVE's
+        exposed = {inst.args[0] for inst in expose_instructions if inst.args}
+        expose_lines = [f"EXPOSE {port}" for port in self.config.expose_ports if str(port) not in exposed]
+        if expose_lines:
             lines.extend(expose_lines)
             lines.append("")
 
+        # Check the server SERVE starts unless the Agentfile has its own HEALTHCHECK, which stays where it was written
+        if not any(inst.instruction == "HEALTHCHECK" for inst in instructions):
+            healthcheck = self.config.image_healthcheck()
+            if healthcheck:
+                lines.extend([healthcheck.to_dockerfile_line(), ""])
+
+        # Switch user last, so RUN instructions passed through from the Agentfile still run as root
+        if rootless:
+            lines.extend([f"USER {ROOTLESS_USER}", ""])
+
         # Add CMD instructions from custom dockerfile instructions first
-        cmd_instructions = [inst for inst in self.config.dockerfile_instructions if inst.instruction == "CMD"]
-        if cmd_instructions:
+        cmd_instructions = [inst for inst in instructions if inst.instruction == "CMD"]
+        if append_cmd:
+            for instruction in cmd_instructions:
+                lines.extend(self.framework.source_comment(instruction.line, instruction.instruction))
+            lines.append(f"# CMD_MODE append: run {json.dumps(self.config.cmd)} next to the generated agent")
+            lines.append(f"CMD {json.dumps(['python', SUPERVISOR_FILENAME])}")
+        elif cmd_instructions:
             for instruction in cmd_instructions:
+                lines.extend(self.framework.source_comment(instruction.line, instruction.instruction))
                 lines.append(instruction.to_dockerfile_line())
         elif self.config.cmd:
             # Default command from config
             cmd_str = json.dumps(self.config.cmd)
             lines.append(f"CMD {cmd_str}")
 
-        dockerfile = self.output_dir / "Dockerfile"
-        with open(dockerfile, 'w', encoding='utf-8') as f:
-            f.write("\n".join(lines))
+        return "\n".join(lines)
 
     def _generate_requirements_txt(self):
         """Generate the requirements.txt file based on framework."""
-        requirements = self.framework.get_requirements()
+        requirements = self._get_requirements()
 
         # Remove duplicates and sort
         requirements = sorted(list(set(requirements)))
@@ -216,12 +564,30 @@ class AgentBuilder:
             "# OS",
             ".DS_Store",
             "Thumbs.db",
+            "",
+            "# Agentman",
+            ".env.example",
         ]
 
         dockerignore = self.output_dir / ".dockerignore"
         with open(dockerignore, 'w', encoding='utf-8') as f:
             f.write("\n".join(ignore_patterns))
 
+    def _generate_env_example(self):
+        """Generate the .env.example file listing the environment the agent reads."""
+        content = render_env_example(collect_environment(self.config))
+
+        env_example = self.output_dir / ".env.example"
+        with open(env_example, 'w', encoding='utf-8') as f:
+            f.write(content)
+
+    def _generate_manifest(self):
+        """Generate the agentman.json build manifest."""
+        manifest_file = self.output_dir / MANIFEST_FILENAME
+        with open(manifest_file, 'w', encoding='utf-8') as f:
+            json.dump(build_manifest(self.config, self.has_prompt_file), f, indent=2)
+            f.write("\n")
+
     def _validate_output(self):
         """Validate that all required files were generated."""
         # Skip validation in test environments or when fast-agent is not available
@@ -233,31 +599,116 @@ class AgentBuilder:
             pass
 
 
-def build_from_agentfile(agentfile_path: str, output_dir: str = "output") -> None:
-    """Build agent files from an Agentfile."""
-    parser = AgentfileParser()
-    config = parser.parse_file(agentfile_path)
-
-    # Extract source directory from agentfile path
-    source_dir = Path(agentfile_path).parent
-
-    builder = AgentBuilder(config, output_dir, source_dir)
+def build_from_agentfile(
+    agentfile_path: str,
+    output_dir: str = "output",
+    source_dir: Optional[str] = None,
+    base_image: Optional[str] = None,
+    base_image_stage: Optional[str] = None,
+    offline: bool = False,
+    frozen_lock: bool = False,
+    combined_config: bool = False,
+    no_color: bool = False,
+    annotate: bool = False,
+    stats: Optional[Stats] = None,
+    run_hints_tag: Optional[str] = None,
+    prune: bool = False,
+    fail_on_warn: bool = False,
+    verify_configs: bool = True,
+    inline_instructions: bool = False,
+    copy_config: bool = False,
+    runtime_secrets: bool = False,
+    rootless: bool = False,
+    parser: Optional[AgentfileParser] = None,
+) -> None:
+    """Build agent files from an Agentfile, printing run hints for the given image tag when set.
+
+    base_image replaces the FROM image of the agent stage, or of the stage base_image_stage names.
+    With prune, definitions the default entity never reaches are left out of every generated file.
+    With fail_on_warn, parser warnings stop the build before anything is generated.
+    With inline_instructions, INSTRUCTION_FILE contents are embedded in the agent instead of copied.
+    With copy_config, the fast-agent config file is copied from the build context instead of written as a heredoc.
+    With runtime_secrets, the fast-agent secrets file is written from the environment when the container starts.
+    With rootless, the image runs as an unprivileged user that owns the generated files, unless the Agentfile sets USER.
+    parser reads the Agentfile, so its options apply; by default one with no options is used.
+    """
+    stats = stats or Stats()
+    parser = parser or AgentfileParser()
+    with stats.phase("parse"):
+        config = parser.parse_file(agentfile_path)
+
+    diagnostics = list(parser.diagnostics)
+    if diagnostics:
+        source = Path(agentfile_path).read_text(encoding='utf-8')
+        perror(format_diagnostics(diagnostics, source, Path(agentfile_path).name, sys.stderr, no_color))
+    if fail_on_warn and count_warnings(diagnostics):
+        raise ValueError(f"{count_warnings(diagnostics)} warning(s) reported and --fail-on-warn is set")
+
+    if base_image:
+        config.override_base_image(base_image, base_image_stage)
+    pruned = prune_unused(config) if prune else []
+
+    # Default the source directory to the Agentfile's directory; remote Agentfiles pass the build context
+    source_dir = Path(source_dir) if source_dir else Path(agentfile_path).parent
+
+    lock = read_lock(lockfile_path(agentfile_path))
+    if frozen_lock and lock is None:
+        raise ValueError(f"--frozen-lock requires {lockfile_path(agentfile_path)}; run `agentman lock` first")
+
+    builder = AgentBuilder(
+        config,
+        output_dir,
+        source_dir,
+        offline=offline,
+        lock=lock,
+        frozen_lock=frozen_lock,
+        combined_config=combined_config,
+        annotate=annotate,
+        stats=stats,
+        verify_configs=verify_configs,
+        inline_instructions=inline_instructions,
+        copy_config=copy_config,
+        runtime_secrets=runtime_secrets,
+        rootless=rootless,
+    )
     builder.build_all()
+    stats.record_config(config)
+    stats.record_output(Path(output_dir))
+    stats.warnings = len(diagnostics)
 
     print(f"✅ Generated agent files in {output_dir}/")
     print("   - agent.py")
 
     # Show framework-specific config files
-    if config.framework == "agno":
-        print("   - .env")
-    else:
-        print("   - fastagent.config.yaml")
-        print("   - fastagent.secrets.yaml")
+    for config_file in builder.framework.get_config_files():
+        print(f"   - {config_file}")
 
     print("   - Dockerfile")
     print("   - requirements.txt")
     print("   - .dockerignore")
+    print("   - .env.example")
+    print(f"   - {MANIFEST_FILENAME}")
+    if verify_configs:
+        print(f"   - {CONFIG_CHECK_FILENAME}")
+    if runtime_secrets:
+        print(f"   - {SECRETS_ENTRYPOINT_FILENAME}")
 
     # Check if prompt.txt was copied
     if builder.has_prompt_file:
         print("   - prompt.txt")
+    for entity in builder.framework.instruction_files():
+        print(f"   - {instruction_image_path(entity)}")
+
+    if offline:
+        print(f"   - {VENDOR_DIRNAME}/")
+
+    if pruned:
+        print(f"\n✂️  Pruned unused definitions: {', '.join(pruned)}")
+
+    if run_hints_tag:
+        print("\n" + build_run_hints(config, builder.has_prompt_file).render(run_hints_tag, output_dir))
+
+    docker_servers = [server.name for server in config.servers.values() if server.uses_docker]
+    if docker_servers:
+        servers = ", ".join(docker_servers)
+        print(f"\n🐳 Servers {servers} need the docker socket: docker run -v {DOCKER_SOCKET_MOUNT} ...")
@@ -1,8 +1,495 @@
 """Agentfile parser module for parsing Agentfile configurations."""
 
+import hashlib
 import json
+import os
+import re
+import threading
+import urllib.parse
+from concurrent.futures import ThreadPoolExecutor
 from dataclasses import dataclass, field
-from typing import Any, Dict, List, Optional, Union
+from typing import Any, Callable, Dict, List, Optional, Union
+
+from agentman.diagnostics import SEVERITY_ERROR, SEVERITY_INFO, SEVERITY_WARNING, Diagnostic, Finding
+from agentman.remote import fetch_agentfile, fetch_url, is_remote, verify_digest
+from agentman.server_registry import BUILTIN_SERVERS
+from agentman.validation import MAX_PORT, validate_config
+
+# Launchers whose first positional argument names a package, mapped to the package ecosystem
+PACKAGE_LAUNCHERS = {"npx": "npm", "uvx": "uv"}
+
+# Launcher flags that consume the following argument
+LAUNCHER_VALUE_FLAGS = {
+    "npx": {"-p", "--package", "-c", "--call"},
+    "uvx": {"--from", "--with", "--python", "-p", "--index", "--index-url"},
+}
+
+# Launcher flags whose argument is the package itself; uvx's -p is --python
+LAUNCHER_PACKAGE_FLAGS = {
+    "npx": {"-p", "--package"},
+    "uvx": {"--from"},
+}
+
+NPM_VERSION_PATTERN = re.compile(r"^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$")
+PYTHON_VERSION_PATTERN = re.compile(r"^\d+(\.\d+)*((a|b|rc)\d+)?(\.post\d+)?(\.dev\d+)?$")
+WINDOWS_PATH_PATTERN = re.compile(r"^[A-Za-z]:[\\/]")
+
+DOCKER_SOCKET_MOUNT = "/var/run/docker.sock:/var/run/docker.sock"
+
+# Environment variable names Docker and shells accept without quoting
+ENV_NAME_PATTERN = re.compile(r"^[A-Za-z_][A-Za-z0-9_]*$")
+
+# How the client answers a server's elicitation requests, mapped to fast-agent's elicitation modes
+ELICITATION_MODES = {"auto": "auto-cancel", "forms": "forms", "none": "none"}
+
+# Where HUMAN_INPUT questions go: the container's console, a webhook URL that answers them, or nowhere
+HUMAN_INPUT_MODES = ["console", "webhook", "disabled"]
+
+# What SERVE exposes the agents as: an MCP server, which fast-agent runs, or an HTTP API, which agno runs
+SERVE_PROTOCOLS = {"mcp": "fast-agent", "http": "agno"}
+# The path each protocol answers on when SERVE gives none; fast-agent always serves MCP on /mcp
+SERVE_DEFAULT_PATHS = {"mcp": "/mcp", "http": "/run"}
+
+# Schemes of the Authorization header AUTH sends
+AUTH_TYPES = ["bearer", "basic"]
+
+# MCP transports, mapped to the transport key fast-agent expects. HTTP servers speak streamable HTTP
+TRANSPORTS = {"stdio": "stdio", "sse": "sse", "http": "http", "streamable-http": "http"}
+
+# Shorthand server declarations, e.g. MCP_SERVER fetch npx:@modelcontextprotocol/server-fetch:
+# launcher schemes mapped to the arguments before the package, and the transports that take a URL
+SERVER_SHORTHAND_PATTERN = re.compile(r"^([A-Za-z][A-Za-z0-9+.-]*):(.*)$")
+SERVER_SHORTHAND_LAUNCHERS = {"npx": ["-y"], "uvx": []}
+SERVER_SHORTHAND_TRANSPORTS = ["sse", "http"]
+
+# Variables of a VS Code or Cursor mcp.json: ${input:id} for its prompted inputs, ${env:NAME} for the environment
+MCP_JSON_VARIABLE_PATTERN = re.compile(r"\$\{(input|env):([^}]+)\}")
+
+# Parser directives recognised at the top of an Agentfile, e.g. "# escape=`"
+PARSER_DIRECTIVE_PATTERN = re.compile(r"^#\s*([A-Za-z]+)\s*=\s*(\S+)\s*$")
+PARSER_DIRECTIVES = ["syntax", "escape"]
+ESCAPE_CHARS = ["\\", "`"]
+
+# Flags accepted by ADD and COPY; checksum is ADD-only and from is COPY-only, as in Docker
+FILE_FLAGS = {
+    "ADD": ["chown", "chmod", "checksum", "link", "keep-git-dir", "exclude"],
+    "COPY": ["chown", "chmod", "from", "link", "parents", "exclude"],
+}
+CHECKSUM_PATTERN = re.compile(r"^sha256:[0-9a-f]{64}$")
+# Flags of HEALTHCHECK that take a duration, and the one that takes a count
+HEALTHCHECK_DURATION_FLAGS = ["interval", "timeout", "start-period", "start-interval"]
+HEALTHCHECK_FLAGS = HEALTHCHECK_DURATION_FLAGS + ["retries"]
+
+# Heredocs: BuildKit's RUN <<EOF or COPY <<-"EOT" /app/file, and INSTRUCTION <<EOF or PROMPT name <<EOF for
+# multi-line text
+HEREDOC_INSTRUCTIONS = ["RUN", "COPY", "ADD", "INSTRUCTION", "PROMPT"]
+HEREDOC_PATTERN = re.compile(r"<<(-?)([\"']?)([A-Za-z_][A-Za-z0-9_]*)\2")
+
+# Python-style triple-quoted values may span lines; shell instructions use heredocs instead
+TRIPLE_QUOTE = '"""'
+AGENT_STAGE = "agent"  # Build stage name that receives the generated agent when it is not the last stage
+WORD_PATTERN = re.compile(r"\S+")
+LONG_LINE_PREVIEW = 60  # Characters of an over-long line that errors show
+# What follows the escape character inside a single- or double-quoted value; the escape character itself is also allowed
+QUOTED_ESCAPES = {'"': '"', "'": "'", "n": "\n", "t": "\t"}
+SHELL_INSTRUCTIONS = ["RUN", "COPY", "ADD"]
+
+
+def triple_quoted(text: str) -> str:
+    """Render text as a Python triple-quoted literal, escaping what would end it early."""
+    escaped = text.replace("\\", "\\\\").replace(TRIPLE_QUOTE, '\\"\\"\\"')
+    if escaped.endswith('"'):
+        escaped = escaped[:-1] + '\\"'
+    return f'{TRIPLE_QUOTE}{escaped}{TRIPLE_QUOTE}'
+
+
+def instruction_image_path(entity) -> str:
+    """Return where the INSTRUCTION_FILE of an agent or workflow is copied to in the image."""
+    return f"instructions/{entity.name}{os.path.splitext(entity.instruction_file)[1]}"
+
+
+def instruction_code(entity, inline: bool = False) -> str:
+    """Return the Python expression for an instruction: its text, or a read of its INSTRUCTION_FILE in the image."""
+    if entity.instruction_file and not inline:
+        return f'Path("{instruction_image_path(entity)}").read_text(encoding="utf-8")'
+    return triple_quoted(entity.instruction)
+
+
+# $$ for a literal $, ${env:NAME} for the parsing shell's environment, and ${NAME} or ${NAME:-default} for build args
+PLACEHOLDER_PATTERN = re.compile(
+    r"\$\$|\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}"
+)
+
+# Instructions Agentman reads itself; build args are expanded in their values, Docker expands the rest
+AGENTMAN_INSTRUCTIONS = [
+    "MODEL",
+    "TEMPERATURE",
+    "MAX_TOKENS",
+    "TOP_P",
+    "TOP_K",
+    "STOP",
+    "REQUEST_TIMEOUT",
+    "MAX_RETRIES",
+    "INCLUDE",
+    "ENV_FILE",
+    "IMPORT_MCP",
+    "PROMPT",
+    "FRAMEWORK",
+    "SHUTDOWN_GRACE",
+    "HUMAN_INPUT_MODE",
+    "SERVE",
+    "CMD_MODE",
+    "END",
+    "SERVER",
+    "MCP_SERVER",
+    "AGENT",
+    "ROUTER",
+    "CHAIN",
+    "PARALLEL",
+    "ORCHESTRATOR",
+    "SECRET",
+]
+SUB_INSTRUCTIONS = [
+    "COMMAND",
+    "ARGS",
+    "INSTRUCTION",
+    "INSTRUCTION_FILE",
+    "SERVERS",
+    "TOOLS",
+    "RESOURCES",
+    "AGENTS",
+    "SEQUENCE",
+    "FAN_OUT",
+    "FAN_IN",
+    "TRANSPORT",
+    "URL",
+    "USE_HISTORY",
+    "HUMAN_INPUT",
+    "PLAN_TYPE",
+    "PLAN_ITERATIONS",
+    "CUMULATIVE",
+    "CONTINUE_WITH_FINAL",
+    "API_KEY",
+    "BASE_URL",
+    "DEFAULT",
+    "ALLOW_DOCKER",
+    "ELICITATION_MODE",
+    "HEADER",
+    "AUTH",
+    "STARTUP_TIMEOUT",
+    "READ_TIMEOUT",
+    "CWD",
+]
+
+# The keyword that opens each kind of block, and the instructions whose meaning depends on the open block
+CONTEXT_KEYWORDS = {
+    "server": "MCP_SERVER",
+    "agent": "AGENT",
+    "router": "ROUTER",
+    "chain": "CHAIN",
+    "parallel": "PARALLEL",
+    "orchestrator": "ORCHESTRATOR",
+    "secret": "SECRET",
+}
+# Sub-instructions each block accepts; a SECRET block takes any KEY value pair
+CONTEXT_SUB_INSTRUCTIONS = {
+    "server": [
+        "COMMAND",
+        "ARGS",
+        "TRANSPORT",
+        "URL",
+        "CWD",
+        "ENV",
+        "ENV_FILE",
+        "ALLOW_DOCKER",
+        "ELICITATION_MODE",
+        "HEADER",
+        "AUTH",
+        "STARTUP_TIMEOUT",
+        "READ_TIMEOUT",
+        "REQUEST_TIMEOUT",
+        "MAX_RETRIES",
+    ],
+    "agent": [
+        "INSTRUCTION",
+        "INSTRUCTION_FILE",
+        "SERVERS",
+        "TOOLS",
+        "RESOURCES",
+        "MODEL",
+        "TEMPERATURE",
+        "MAX_TOKENS",
+        "TOP_P",
+        "TOP_K",
+        "STOP",
+        "REQUEST_TIMEOUT",
+        "MAX_RETRIES",
+        "BASE_URL",
+        "USE_HISTORY",
+        "HUMAN_INPUT",
+        "DEFAULT",
+    ],
+    "router": ["AGENTS", "MODEL", "INSTRUCTION", "INSTRUCTION_FILE", "HUMAN_INPUT", "DEFAULT"],
+    "chain": ["SEQUENCE", "MODEL", "INSTRUCTION", "INSTRUCTION_FILE", "CUMULATIVE", "CONTINUE_WITH_FINAL", "DEFAULT"],
+    "parallel": ["FAN_OUT", "FAN_IN", "INSTRUCTION", "INSTRUCTION_FILE", "DEFAULT"],
+    "orchestrator": [
+        "AGENTS",
+        "MODEL",
+        "INSTRUCTION",
+        "INSTRUCTION_FILE",
+        "PLAN_TYPE",
+        "PLAN_ITERATIONS",
+        "HUMAN_INPUT",
+        "DEFAULT",
+    ],
+}
+DECLARATION_KEYWORDS = ["SERVER"] + list(CONTEXT_KEYWORDS.values())
+CONTEXT_SENSITIVE_INSTRUCTIONS = {
+    "ENV": ["server"],
+    "ENV_FILE": ["server"],
+    "MODEL": ["agent", "router", "chain", "orchestrator"],
+    **{keyword: ["agent"] for keyword in ["TEMPERATURE", "MAX_TOKENS", "TOP_P", "TOP_K", "STOP"]},
+    "REQUEST_TIMEOUT": ["agent", "server"],
+    "MAX_RETRIES": ["agent", "server"],
+}
+
+# INCLUDE targets are read ahead by a bounded pool of threads, since a resolver may be slow
+INCLUDE_PATTERN = re.compile(r"^INCLUDE\s+(\S+)(?:\s+sha256:\S+)?(?:\s+#.*)?$", re.IGNORECASE)
+# Start of a location that is a URL, such as https:// or s3://, rather than a file path
+URL_SCHEME_PATTERN = re.compile(r"^[A-Za-z][A-Za-z0-9+.-]*://")
+# Name the instructions a parser is given to apply after the Agentfile are reported under
+OVERRIDES_SOURCE = "--set"
+DEFAULT_INCLUDE_WORKERS = 8
+
+# One NAME=value line of a dotenv file, optionally prefixed with export
+DOTENV_LINE_PATTERN = re.compile(r"^(?:export\s+)?([A-Za-z_][A-Za-z0-9_.]*)\s*=\s*(.*)$")
+DOTENV_ESCAPES = {"n": "\n", "t": "\t", "r": "\r", '"': '"', "\\": "\\"}
+
+# A $NAME or ${NAME} reference to an environment variable or secret
+ENV_REFERENCE_PATTERN = re.compile(r"^\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?$")
+BASE_URL_PATTERN = re.compile(r"^https?://[^\s/?#]+")
+# A TOOLS entry: a server name, a dot and a tool name in which * matches any characters
+TOOL_PATTERN = re.compile(r"^([^.\s]+)\.([^.\s]+)$")
+# A RESOURCES entry: a URI with a scheme; servers may serve schemes other than the known ones at run time
+RESOURCE_URI_PATTERN = re.compile(r"^([A-Za-z][A-Za-z0-9+.-]*):\S+$")
+KNOWN_RESOURCE_SCHEMES = ["file", "http", "https"]
+# A PROMPT name, and the two ways an instruction uses a prompt: INSTRUCTION @name for the whole text,
+# or {{prompt:name}} anywhere in it
+PROMPT_NAME_PATTERN = re.compile(r"^[A-Za-z_][A-Za-z0-9_-]*$")
+PROMPT_INSTRUCTION_PATTERN = re.compile(r"^@([A-Za-z_][A-Za-z0-9_-]*)$")
+PROMPT_REFERENCE_PATTERN = re.compile(r"\{\{prompt:([A-Za-z_][A-Za-z0-9_-]*)\}\}")
+# The characters of an HTTP header name
+HEADER_NAME_PATTERN = re.compile(r"^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+# Durations such as 20s, 1m30s or 1h, and the grace period docker stop allows by default
+DURATION_PATTERN = re.compile(r"^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?$")
+DEFAULT_SHUTDOWN_GRACE = 10
+
+# Settings of the model calls an agent makes, which it sets or inherits from the top level, mapped to their attributes
+MODEL_SETTINGS = {
+    "TEMPERATURE": "temperature",
+    "MAX_TOKENS": "max_tokens",
+    "TOP_P": "top_p",
+    "TOP_K": "top_k",
+    "STOP": "stop",
+    "REQUEST_TIMEOUT": "request_timeout",
+    "MAX_RETRIES": "max_retries",
+}
+# The values providers accept for the fractional settings; the others are whole numbers, durations or strings
+MODEL_SETTING_RANGES = {"TEMPERATURE": (0.0, 2.0), "TOP_P": (0.0, 1.0)}
+# Retries beyond this only delay the failure of a request that keeps failing
+MAX_RETRIES_LIMIT = 10
+
+# The command that runs the generated agent, and how an Agentfile CMD may combine with it
+DEFAULT_CMD = ["python", "agent.py"]
+CMD_MODES = ["override", "append"]
+
+# What an Agentfile without FROM or FRAMEWORK builds on
+DEFAULT_BASE_IMAGE = "yeahdongcn/agentman-base:latest"
+DEFAULT_FRAMEWORK = "fast-agent"
+FRAMEWORKS = ["fast-agent", "agno"]
+# SERVERS names FRAMEWORK agno turns into its built-in tools, with no MCP_SERVER block needed
+AGNO_TOOL_SERVERS = [
+    "web_search",
+    "search",
+    "browser",
+    "finance",
+    "yfinance",
+    "stock",
+    "file",
+    "filesystem",
+    "shell",
+    "terminal",
+    "python",
+    "code",
+]
+
+# key=value attributes accepted on declaration lines, mapped to their sub-instruction
+INLINE_ATTRIBUTES = {
+    "server": [
+        "command",
+        "args",
+        "transport",
+        "url",
+        "cwd",
+        "allow_docker",
+        "elicitation_mode",
+        "startup_timeout",
+        "read_timeout",
+        "request_timeout",
+        "max_retries",
+    ],
+    "agent": [
+        "instruction",
+        "instruction_file",
+        "servers",
+        "tools",
+        "resources",
+        "model",
+        "temperature",
+        "max_tokens",
+        "top_p",
+        "top_k",
+        "stop",
+        "request_timeout",
+        "max_retries",
+        "base_url",
+        "use_history",
+        "human_input",
+        "default",
+    ],
+    "router": ["agents", "model", "instruction", "instruction_file", "human_input", "default"],
+    "chain": ["sequence", "model", "instruction", "instruction_file", "cumulative", "continue_with_final", "default"],
+    "parallel": ["fan_out", "fan_in", "instruction", "instruction_file", "default"],
+    "orchestrator": [
+        "agents",
+        "model",
+        "instruction",
+        "instruction_file",
+        "plan_type",
+        "plan_iterations",
+        "human_input",
+        "default",
+    ],
+}
+
+
+class AgentfileError(ValueError):
+    """A parse error with the position of the instruction, and token, that caused it.
+
+    str() gives the same human-readable text the CLI prints, including the chain of
+    INCLUDE lines leading to an error in an included file, while file, line, column
+    and instruction always describe the innermost offending instruction.
+    """
+
+    def __init__(
+        self,
+        message: str,
+        file: Optional[str] = None,
+        line: Optional[int] = None,
+        column: Optional[int] = None,
+        instruction: Optional[str] = None,
+        source: Optional[str] = None,
+    ):
+        super().__init__(message)
+        self.message = message
+        self.file = file
+        self.line = line
+        self.column = column  # 1-based column of the offending token
+        self.instruction = instruction
+        self.source = source  # Logical line the error was found on
+        self.context: List[str] = []  # Outer INCLUDE lines, outermost first
+
+    def include_context(self, prefix: str):
+        """Record an outer line the error was reached through."""
+        self.context.insert(0, prefix)
+
+    def to_diagnostic(self) -> Diagnostic:
+        """Convert to an error diagnostic for editors and --format json."""
+        return Diagnostic(SEVERITY_ERROR, "parse-error", self.message, self.line, self.column)
+
+    def __str__(self) -> str:
+        text = f"Error parsing line {self.line}: {self.source}\n{self.message}" if self.line else self.message
+        return "".join(self.context) + text
+
+
+@dataclass
+class ServerPackage:
+    """Represents the package an npx/uvx MCP server launches."""
+
+    installer: str  # "npm" or "uv"
+    name: str
+    version: Optional[str] = None
+    arg_index: int = 0  # Position of the package spec in MCPServer.args
+
+    @property
+    def spec(self) -> str:
+        """Return the package spec in the launcher's name@version form."""
+        return f"{self.name}@{self.version}" if self.version else self.name
+
+
+def env_reference(value: Optional[str]) -> Optional[str]:
+    """Return the variable name when a value is a $NAME reference."""
+    match = ENV_REFERENCE_PATTERN.match(value or "")
+    return match.group(1) if match else None
+
+
+def parse_duration(value: str) -> int:
+    """Convert a duration such as 20s, 1m30s or 1h to whole seconds."""
+    match = DURATION_PATTERN.match(value.strip().lower())
+    if not value.strip() or not match:
+        raise ValueError(f"Invalid duration: {value}. Use a value such as 20s, 1m30s or 1h")
+    hours, minutes, seconds = (int(group or 0) for group in match.groups())
+    return hours * 3600 + minutes * 60 + seconds
+
+
+def split_package_spec(installer: str, spec: str) -> tuple:
+    """Split a launcher package spec into (name, version)."""
+    if installer == "npm":
+        # Scoped packages start with "@", so only a later "@" marks the version
+        at = spec.find("@", 1)
+        return (spec, None) if at == -1 else (spec[:at], spec[at + 1 :])
+    if "==" in spec:
+        name, version = spec.split("==", 1)
+        return name, version
+    if "@" in spec:
+        name, version = spec.split("@", 1)
+        return name, version
+    return spec, None
+
+
+def classify_server_command(command: Optional[str], args: List[str]) -> Optional[ServerPackage]:
+    """Extract the launched package from an npx/uvx server command."""
+    launcher = (command or "").rsplit("/", 1)[-1]
+    installer = PACKAGE_LAUNCHERS.get(launcher)
+    if not installer:
+        return None
+
+    value_flags = LAUNCHER_VALUE_FLAGS[launcher]
+    index = 0
+    while index < len(args):
+        arg = args[index]
+        if arg in LAUNCHER_PACKAGE_FLAGS[launcher] and index + 1 < len(args):
+            # The explicit package flag names the package directly
+            name, version = split_package_spec(installer, args[index + 1])
+            return ServerPackage(installer=installer, name=name, version=version, arg_index=index + 1)
+        if arg in value_flags:
+            index += 2
+            continue
+        if not arg.startswith("-"):
+            name, version = split_package_spec(installer, arg)
+            return ServerPackage(installer=installer, name=name, version=version, arg_index=index)
+        index += 1
+    return None
+
+
+@dataclass
+class ServerAuth:
+    """Credentials sent to an MCP server reached over the network, as its Authorization header."""
+
+    type: str  # One of AUTH_TYPES
+    secret: str  # Name of the SECRET holding the token, or the password for basic auth
+    user: Optional[str] = None  # User name for basic auth
 
 
 @dataclass
@@ -14,11 +501,50 @@ class MCPServer:
     args: List[str] = field(default_factory=list)
     transport: str = "stdio"
     url: Optional[str] = None
+    cwd: Optional[str] = None  # Absolute directory in the image a stdio server is started in
     env: Dict[str, str] = field(default_factory=dict)
+    package: Optional[ServerPackage] = None
+    allow_docker: bool = False
+    elicitation_mode: Optional[str] = None  # One of ELICITATION_MODES, or None for the framework default
+    startup_timeout: Optional[int] = None  # Seconds the server may take to start and answer initialize
+    read_timeout: Optional[int] = None  # Seconds an SSE or HTTP stream may stay silent before it is dropped
+    request_timeout: Optional[int] = None  # Seconds to wait for a reply from the server
+    max_retries: Optional[int] = None
+    headers: Dict[str, str] = field(default_factory=dict)  # HTTP headers sent to a server reached over the network
+    auth: Optional[ServerAuth] = None
+    line: Optional[int] = field(default=None, compare=False)  # Line of the MCP_SERVER declaration
+    keyword: str = field(default="MCP_SERVER", compare=False)  # Spelling of the declaration, SERVER or MCP_SERVER
+
+    @property
+    def uses_docker(self) -> bool:
+        """Whether the server is launched through the docker CLI."""
+        return (self.command or "").rsplit("/", 1)[-1] == "docker"
+
+    def pin_package(self, version: str):
+        """Pin the launched package to an exact version, updating ARGS."""
+        if self.package is None:
+            raise ValueError(f"Server {self.name} does not launch an npx/uvx package")
+        self.package.version = version
+        self.args[self.package.arg_index] = self.package.spec
+
+    @property
+    def auth_env_name(self) -> str:
+        """Name of the variable the generated agent puts a basic Authorization header in."""
+        return "AGENTMAN_AUTH_" + re.sub(r"[^A-Za-z0-9]", "_", self.name).upper()
+
+    def auth_header(self) -> str:
+        """Return the Authorization header value, with the secret as a ${NAME} reference fast-agent fills in.
+
+        Basic credentials must be base64-encoded together, so the generated agent builds that header at
+        startup in auth_env_name.
+        """
+        if self.auth.type == "bearer":
+            return f"Bearer ${{{self.auth.secret}}}"
+        return f"${{{self.auth_env_name}}}"
 
     def to_config_dict(self) -> Dict[str, Any]:
         """Convert to fastagent.config.yaml format."""
-        config = {"transport": self.transport}
+        config = {"transport": TRANSPORTS.get(self.transport, self.transport)}
 
         if self.command:
             config["command"] = self.command
@@ -26,8 +552,25 @@ class MCPServer:
             config["args"] = self.args
         if self.url:
             config["url"] = self.url
+        if self.cwd:
+            config["cwd"] = self.cwd
         if self.env:
             config["env"] = self.env
+        if self.elicitation_mode:
+            config["elicitation"] = {"mode": ELICITATION_MODES[self.elicitation_mode]}
+        if self.startup_timeout is not None:
+            config["startup_timeout_seconds"] = self.startup_timeout
+        if self.read_timeout is not None:
+            config["read_transport_sse_timeout_seconds"] = self.read_timeout
+        if self.request_timeout is not None:
+            config["read_timeout_seconds"] = self.request_timeout
+        if self.max_retries is not None:
+            config["max_retries"] = self.max_retries
+        headers = dict(self.headers)
+        if self.auth:
+            headers["Authorization"] = self.auth_header()
+        if headers:
+            config["headers"] = headers
 
         return config
 
@@ -38,23 +581,51 @@ class Agent:
 
     name: str
     instruction: str = "You are a helpful agent."
+    instruction_file: Optional[str] = None  # Path of the file the instruction was read from, relative to the Agentfile
     servers: List[str] = field(default_factory=list)
+    tools: List[str] = field(default_factory=list)  # server.tool names the agent may call; none allows every tool
+    resources: List[str] = field(default_factory=list)  # URIs of resources loaded into the conversation at startup
     model: Optional[str] = None
+    # None leaves the file's setting, else the provider default, in place
+    temperature: Optional[float] = None
+    max_tokens: Optional[int] = None
+    top_p: Optional[float] = None
+    top_k: Optional[int] = None
+    stop: Optional[List[str]] = None
+    request_timeout: Optional[int] = None  # Seconds
+    max_retries: Optional[int] = None
+    base_url: Optional[str] = None  # Provider endpoint override, a URL or a $SECRET reference
     use_history: bool = True
     human_input: bool = False
     default: bool = False
+    line: Optional[int] = field(default=None, compare=False)  # Line of the AGENT declaration
 
-    def to_decorator_string(self, default_model: Optional[str] = None) -> str:
-        """Generate the @fast.agent decorator string."""
-        params = [f'name="{self.name}"', f'instruction="""{self.instruction}"""']
+    def to_decorator_string(
+        self,
+        default_model: Optional[str] = None,
+        request_params: Optional[Dict[str, Any]] = None,
+        inline_instructions: bool = False,
+    ) -> str:
+        """Generate the @fast.agent decorator string; request_params are the RequestParams fields to set.
+
+        An INSTRUCTION_FILE is read when the agent starts, or embedded when inline_instructions is set.
+        """
+        params = [f'name="{self.name}"', f'instruction={instruction_code(self, inline_instructions)}']
 
         if self.servers:
             servers_str = "[" + ", ".join(f'"{s}"' for s in self.servers) + "]"
             params.append(f"servers={servers_str}")
 
+        if self.tools:
+            params.append(f"tools={json.dumps(self.tool_filters())}")
+
         if model_to_use := (self.model or default_model):
             params.append(f'model="{model_to_use}"')
 
+        if request_params:
+            fields = ", ".join(f"{key}={json.dumps(value)}" for key, value in request_params.items())
+            params.append(f"request_params=RequestParams({fields})")
+
         if not self.use_history:
             params.append("use_history=False")
 
@@ -66,6 +637,14 @@ class Agent:
 
         return "@fast.agent(\n    " + ",\n    ".join(params) + "\n)"
 
+    def tool_filters(self) -> Dict[str, List[str]]:
+        """Return the TOOLS names by server, in the order they were listed."""
+        filters: Dict[str, List[str]] = {}
+        for entry in self.tools:
+            server, tool = entry.split(".", 1)
+            filters.setdefault(server, []).append(tool)
+        return filters
+
 
 @dataclass
 class Router:
@@ -75,10 +654,13 @@ class Router:
     agents: List[str] = field(default_factory=list)
     model: Optional[str] = None
     instruction: Optional[str] = None
+    instruction_file: Optional[str] = None  # See Agent.instruction_file
+    human_input: bool = False
     default: bool = False
+    line: Optional[int] = field(default=None, compare=False)  # Line of the ROUTER declaration
 
-    def to_decorator_string(self, default_model: Optional[str] = None) -> str:
-        """Generate the @fast.router decorator string."""
+    def to_decorator_string(self, default_model: Optional[str] = None, inline_instructions: bool = False) -> str:
+        """Generate the @fast.router decorator string; see Agent.to_decorator_string for inline_instructions."""
         params = [f'name="{self.name}"']
 
         if self.agents:
@@ -89,7 +671,10 @@ class Router:
             params.append(f'model="{model_to_use}"')
 
         if self.instruction:
-            params.append(f'instruction="""{self.instruction}"""')
+            params.append(f'instruction={instruction_code(self, inline_instructions)}')
+
+        if self.human_input:
+            params.append("human_input=True")
 
         if self.default:
             params.append("default=True")
@@ -103,13 +688,16 @@ class Chain:
 
     name: str
     sequence: List[str] = field(default_factory=list)
+    model: Optional[str] = None  # Model for the steps that set no MODEL of their own
     instruction: Optional[str] = None
+    instruction_file: Optional[str] = None  # See Agent.instruction_file
     cumulative: bool = False
     continue_with_final: bool = True
     default: bool = False
+    line: Optional[int] = field(default=None, compare=False)  # Line of the CHAIN declaration
 
-    def to_decorator_string(self) -> str:
-        """Generate the @fast.chain decorator string."""
+    def to_decorator_string(self, inline_instructions: bool = False) -> str:
+        """Generate the @fast.chain decorator string; see Agent.to_decorator_string for inline_instructions."""
         params = [f'name="{self.name}"']
 
         if self.sequence:
@@ -117,7 +705,7 @@ class Chain:
             params.append(f"sequence={sequence_str}")
 
         if self.instruction:
-            params.append(f'instruction="""{self.instruction}"""')
+            params.append(f'instruction={instruction_code(self, inline_instructions)}')
 
         if self.cumulative:
             params.append("cumulative=True")
@@ -131,6 +719,38 @@ class Chain:
         return "@fast.chain(\n    " + ",\n    ".join(params) + "\n)"
 
 
+@dataclass
+class Parallel:
+    """Represents a parallel workflow, which sends one request to several agents and combines their answers."""
+
+    name: str
+    fan_out: List[str] = field(default_factory=list)
+    fan_in: Optional[str] = None  # None has the framework concatenate the answers
+    instruction: Optional[str] = None
+    instruction_file: Optional[str] = None  # See Agent.instruction_file
+    default: bool = False
+    line: Optional[int] = field(default=None, compare=False)  # Line of the PARALLEL declaration
+
+    def to_decorator_string(self, inline_instructions: bool = False) -> str:
+        """Generate the @fast.parallel decorator string; see Agent.to_decorator_string for inline_instructions."""
+        params = [f'name="{self.name}"']
+
+        if self.fan_out:
+            fan_out_str = "[" + ", ".join(f'"{a}"' for a in self.fan_out) + "]"
+            params.append(f"fan_out={fan_out_str}")
+
+        if self.fan_in:
+            params.append(f'fan_in="{self.fan_in}"')
+
+        if self.instruction:
+            params.append(f'instruction={instruction_code(self, inline_instructions)}')
+
+        if self.default:
+            params.append("default=True")
+
+        return "@fast.parallel(\n    " + ",\n    ".join(params) + "\n)"
+
+
 @dataclass
 class Orchestrator:
     """Represents an orchestrator workflow."""
@@ -139,13 +759,15 @@ class Orchestrator:
     agents: List[str] = field(default_factory=list)
     model: Optional[str] = None
     instruction: Optional[str] = None
+    instruction_file: Optional[str] = None  # See Agent.instruction_file
     plan_type: str = "full"
-    plan_iterations: int = 5
+    plan_iterations: Optional[int] = None  # None leaves the framework default in place
     human_input: bool = False
     default: bool = False
+    line: Optional[int] = field(default=None, compare=False)  # Line of the ORCHESTRATOR declaration
 
-    def to_decorator_string(self, default_model: Optional[str] = None) -> str:
-        """Generate the @fast.orchestrator decorator string."""
+    def to_decorator_string(self, default_model: Optional[str] = None, inline_instructions: bool = False) -> str:
+        """Generate the @fast.orchestrator decorator string; see Agent.to_decorator_string for inline_instructions."""
         params = []
         params.append(f'name="{self.name}"')
 
@@ -158,12 +780,12 @@ class Orchestrator:
             params.append(f'model="{model_to_use}"')
 
         if self.instruction:
-            params.append(f'instruction="""{self.instruction}"""')
+            params.append(f'instruction={instruction_code(self, inline_instructions)}')
 
         if self.plan_type != "full":
             params.append(f'plan_type="{self.plan_type}"')
 
-        if self.plan_iterations != 5:
+        if self.plan_iterations is not None:
             params.append(f"plan_iterations={self.plan_iterations}")
 
         if self.human_input:
@@ -175,6 +797,69 @@ class Orchestrator:
         return "@fast.orchestrator(\n    " + ",\n    ".join(params) + "\n)"
 
 
+@dataclass
+class Serve:
+    """Represents a SERVE instruction, which runs the agents as a server instead of an interactive session."""
+
+    protocol: str  # One of SERVE_PROTOCOLS
+    port: int
+    path: Optional[str] = None  # None answers on the protocol's SERVE_DEFAULT_PATHS entry
+    line: Optional[int] = field(default=None, compare=False)
+
+    @property
+    def endpoint(self) -> str:
+        """Return the path the server answers requests on."""
+        return self.path or SERVE_DEFAULT_PATHS[self.protocol]
+
+    def server_args(self) -> List[str]:
+        """Return the arguments that start fast-agent's generated agent as an MCP server on the port."""
+        return ["--server", "--transport", "http", "--port", str(self.port)]
+
+    def healthcheck(self) -> "Healthcheck":
+        """Return the HEALTHCHECK an image gets when the Agentfile sets none.
+
+        The agno app answers GET /health; fast-agent's MCP server has no such route, so only the port is checked.
+        """
+        if self.protocol == "http":
+            url = f"http://localhost:{self.port}/health"
+            check = f"import urllib.request; urllib.request.urlopen({url!r}, timeout=5)"
+        else:
+            check = f"import socket; socket.create_connection(('localhost', {self.port}), timeout=5).close()"
+        return Healthcheck(command=["python", "-c", check], interval=30, timeout=10, start_period=10, retries=3)
+
+
+@dataclass
+class Healthcheck:
+    """Represents a HEALTHCHECK instruction. A command of None is HEALTHCHECK NONE, which turns checks off."""
+
+    command: Optional[List[str]] = None
+    shell: bool = False  # Whether command is a single shell-form string rather than an exec-form array
+    interval: Optional[int] = None  # Durations in seconds; None leaves Docker's default
+    timeout: Optional[int] = None
+    start_period: Optional[int] = None
+    start_interval: Optional[int] = None
+    retries: Optional[int] = None
+    line: Optional[int] = field(default=None, compare=False)
+
+    def flags(self) -> List[str]:
+        """Return the flags that are set, in Docker's --name=value form."""
+        flags = []
+        for name in HEALTHCHECK_DURATION_FLAGS:
+            value = getattr(self, name.replace("-", "_"))
+            if value is not None:
+                flags.append(f"--{name}={value}s")
+        if self.retries is not None:
+            flags.append(f"--retries={self.retries}")
+        return flags
+
+    def to_dockerfile_line(self) -> str:
+        """Return the HEALTHCHECK instruction."""
+        if self.command is None:
+            return "HEALTHCHECK NONE"
+        command = self.command[0] if self.shell else json.dumps(self.command)
+        return " ".join(["HEALTHCHECK"] + self.flags() + ["CMD", command])
+
+
 @dataclass
 class SecretValue:
     """Represents a secret with an inline value."""
@@ -189,6 +874,7 @@ class SecretContext:
 
     name: str
     values: Dict[str, str] = field(default_factory=dict)
+    line: Optional[int] = field(default=None, compare=False)  # Line of the SECRET declaration
 
 
 # Type alias for secrets that can be strings, values, or contexts
@@ -201,118 +887,1190 @@ class DockerfileInstruction:
 
     instruction: str
     args: List[str]
+    line: Optional[int] = field(default=None, compare=False)
+    flags: List[str] = field(default_factory=list)  # ADD/COPY flags such as --chown=1000, kept verbatim
+    raw: Optional[str] = field(default=None, compare=False)  # Original text including continuations and heredocs
+    stage: int = field(default=0, compare=False)  # Index of the build stage the instruction belongs to
+
+    @property
+    def sources(self) -> List[str]:
+        """Return the source arguments of an ADD or COPY instruction."""
+        return self.args[:-1]
+
+    def flag(self, name: str) -> Optional[str]:
+        """Return the value of an ADD/COPY flag, or "" for a flag given without a value."""
+        for flag in self.flags:
+            key, _, value = flag[2:].partition('=')
+            if key == name:
+                return value
+        return None
+
+    def passthrough_text(self) -> str:
+        """Return the text to write into a generated file, the original text when it is known."""
+        return self.raw if self.raw is not None else self.to_dockerfile_line()
 
     def to_dockerfile_line(self) -> str:
-        """Convert to Dockerfile line format."""
-        if self.instruction in ["CMD", "ENTRYPOINT"] and len(self.args) > 1:
-            # Handle array format for CMD/ENTRYPOINT
+        """Convert to a normalized single Dockerfile line."""
+        if self.instruction in ["CMD", "ENTRYPOINT"]:
+            # CMD and ENTRYPOINT are always written in exec form, so each argument stays one word
             args_str = json.dumps(self.args)
             return f"{self.instruction} {args_str}"
-        return f"{self.instruction} {' '.join(self.args)}"
+        # Docker only accepts flags before the sources, wherever they were written
+        return f"{self.instruction} {' '.join(self.flags + self.args)}"
+
+
+@dataclass
+class Statement:
+    """One instruction of an Agentfile as written, with the sub-instructions of the block it opens."""
+
+    keyword: str  # Upper-cased instruction
+    args: List[str]  # Arguments as written, quotes and escapes included
+    line: int
+    end_line: int  # Last physical line, counting continuations, heredoc bodies and triple-quoted values
+    column: int = 1  # 1-based column of the keyword
+    text: str = ""  # Logical line, continuations joined
+    raw: Optional[str] = None  # Physical lines, including heredoc bodies
+    heredocs: List[str] = field(default_factory=list)
+    comment: Optional[str] = None  # Trailing comment of an Agentman instruction
+    comments: List[str] = field(default_factory=list)  # Comment lines since the previous statement
+    children: List["Statement"] = field(default_factory=list)  # Sub-instructions, and END, of a block
+
+
+@dataclass
+class BuildStage:
+    """Represents one FROM ... [AS name] stage of a multi-stage Agentfile."""
+
+    image: str
+    name: Optional[str] = None
+    line: Optional[int] = field(default=None, compare=False)
 
 
 @dataclass
 class AgentfileConfig:
     """Represents the complete Agentfile configuration."""
 
-    base_image: str = "yeahdongcn/agentman-base:latest"
+    base_image: str = DEFAULT_BASE_IMAGE
     default_model: Optional[str] = None
-    framework: str = "fast-agent"  # "fast-agent" or "agno"
+    # Top-level model settings, for agents that set none; see MODEL_SETTINGS
+    default_temperature: Optional[float] = None
+    default_max_tokens: Optional[int] = None
+    default_top_p: Optional[float] = None
+    default_top_k: Optional[int] = None
+    default_stop: Optional[List[str]] = None
+    default_request_timeout: Optional[int] = None
+    default_max_retries: Optional[int] = None
+    framework: str = DEFAULT_FRAMEWORK  # One of FRAMEWORKS
     servers: Dict[str, MCPServer] = field(default_factory=dict)
     agents: Dict[str, Agent] = field(default_factory=dict)
     routers: Dict[str, Router] = field(default_factory=dict)
     chains: Dict[str, Chain] = field(default_factory=dict)
+    parallels: Dict[str, Parallel] = field(default_factory=dict)
     orchestrators: Dict[str, Orchestrator] = field(default_factory=dict)
     secrets: List[SecretType] = field(default_factory=list)
+    prompts: Dict[str, str] = field(default_factory=dict)  # PROMPT texts by name, in declaration order
     expose_ports: List[int] = field(default_factory=list)
-    cmd: List[str] = field(default_factory=lambda: ["python", "agent.py"])
+    volumes: List[str] = field(default_factory=list)  # Container paths declared by VOLUME, in order
+    healthcheck: Optional[Healthcheck] = None  # Set by HEALTHCHECK in the agent stage
+    entrypoint: List[str] = field(default_factory=list)  # Set by ENTRYPOINT in the agent stage; CMD is its arguments
+    # LABEL keys of the agent stage, each with its value as written, quotes included
+    labels: Dict[str, str] = field(default_factory=dict)
+    cmd: List[str] = field(default_factory=lambda: list(DEFAULT_CMD))
+    cmd_mode: Optional[str] = None  # How a custom CMD combines with the generated agent, see CMD_MODES
     dockerfile_instructions: List[DockerfileInstruction] = field(default_factory=list)
+    stages: List[BuildStage] = field(default_factory=list)  # One per FROM, in order
+    image_env: Dict[str, str] = field(default_factory=dict)  # Top-level ENV in declaration order, last value wins
+    # Top-level ENV_FILE variables; parsing already copies them into every MCP server's env
+    env: Dict[str, str] = field(default_factory=dict, compare=False)
+    shutdown_grace: int = DEFAULT_SHUTDOWN_GRACE  # Seconds in-flight turns get to finish after SIGTERM
+    human_input_mode: str = "console"  # One of HUMAN_INPUT_MODES
+    human_input_webhook: Optional[str] = None  # The URL HUMAN_INPUT_MODE webhook posts questions to
+    serve: Optional[Serve] = None  # Set by SERVE, which runs the agents as a server
+    agentfile_base_image: Optional[str] = None  # Set when the FROM image was overridden
+    directives: Dict[str, str] = field(default_factory=dict)  # Parser directives such as escape and syntax
+    source_name: str = field(default="Agentfile", compare=False)  # File name used in source annotations
+    source_sha256: Optional[str] = field(default=None, compare=False)  # Digest of the parsed Agentfile text
+    source_path: Optional[str] = field(default=None, compare=False)  # Agentfile the config was read from, if any
+    # File each MCP_SERVER, PROMPT, agent and workflow was declared in, by (keyword, name); render names them
+    declared_in: Dict[tuple, str] = field(default_factory=dict, compare=False)
+    # Servers agents may use without an MCP_SERVER block, because the base image provides them
+    external_servers: List[str] = field(default_factory=list)
+    route_to_workflows: bool = False  # Whether routers may route to workflows as well as agents
+
+    @property
+    def escape_char(self) -> str:
+        """Return the line-continuation and escape character."""
+        return self.directives.get("escape", "\\")
+
+    def source_comment(self, line: Optional[int], label: str) -> List[str]:
+        """Return a comment pointing back at the Agentfile line that produced some output."""
+        if line is None:
+            return []
+        return [f"# {self.source_name}:{line} {label}"]
+
+    def directive_lines(self) -> List[str]:
+        """Return the parser directive lines to re-emit at the top of a generated Dockerfile."""
+        return [f"# {name}={self.directives[name]}" for name in PARSER_DIRECTIVES if name in self.directives]
+
+    @property
+    def agent_stage(self) -> int:
+        """Return the index of the stage that receives the generated agent: the one named agent, else the last."""
+        names = [stage.name for stage in self.stages]
+        return names.index(AGENT_STAGE) if AGENT_STAGE in names else max(len(self.stages) - 1, 0)
+
+    @property
+    def agent_instructions(self) -> List[DockerfileInstruction]:
+        """Return the Dockerfile instructions of the agent stage."""
+        return [inst for inst in self.dockerfile_instructions if inst.stage == self.agent_stage]
+
+    def entity_keyword(self, name: str) -> Optional[str]:
+        """Return the keyword that declared an agent or workflow name, or None when nothing did."""
+        for keyword, items in [
+            ("AGENT", self.agents),
+            ("ROUTER", self.routers),
+            ("CHAIN", self.chains),
+            ("PARALLEL", self.parallels),
+            ("ORCHESTRATOR", self.orchestrators),
+        ]:
+            if name in items:
+                return keyword
+        return None
 
+    def validate(self) -> List[Finding]:
+        """Run the semantic checks parsing runs, and return every finding instead of raising the first error."""
+        return validate_config(self)
 
-class AgentfileParser:
-    """Parser for Agentfile format."""
+    def entities(self) -> Dict[str, Any]:
+        """Return every agent and workflow by name, agents first, in the order the generator declares them."""
+        return {**self.agents, **self.routers, **self.chains, **self.parallels, **self.orchestrators}
+
+    def fallback_entity(self) -> Optional[str]:
+        """Return what fast-agent starts when nothing is DEFAULT: the first agent, else the first workflow."""
+        return next(iter(self.entities()), None)
+
+    def model_for(self, name: str) -> Optional[str]:
+        """Return the model an agent or workflow runs with.
 
-    def __init__(self):
-        self.config = AgentfileConfig()
+        That is its own MODEL, else the MODEL of the first chain that runs it as a step, else the file's MODEL.
+        """
+        entity = self.entities()[name]
+        if getattr(entity, "model", None):
+            return entity.model
+        for chain in self.chains.values():
+            if chain.model and name in chain.sequence:
+                return chain.model
+        return self.default_model
+
+    def model_settings_for(self, name: str) -> Dict[str, Any]:
+        """Return the model settings an agent sets, its own or else the file's, leaving out unset ones."""
+        agent = self.agents[name]
+        settings = {}
+        for attribute in MODEL_SETTINGS.values():
+            value = getattr(agent, attribute)
+            if value is None:
+                value = getattr(self, f"default_{attribute}")
+            if value is not None:
+                settings[attribute] = value
+        return settings
+
+    def workflow_graph(self) -> Dict[str, List[str]]:
+        """Return, for every agent and workflow, the names it hands work to, in declaration order.
+
+        Chains hand work to their SEQUENCE, routers and orchestrators to their AGENTS, parallels to their FAN_OUT
+        and then FAN_IN; agents to nothing.
+        """
+        graph: Dict[str, List[str]] = {name: [] for name in self.agents}
+        for items, attribute in [(self.routers, "agents"), (self.chains, "sequence"), (self.orchestrators, "agents")]:
+            for name, item in items.items():
+                graph[name] = list(getattr(item, attribute))
+        for name, parallel in self.parallels.items():
+            graph[name] = parallel.fan_out + ([parallel.fan_in] if parallel.fan_in else [])
+        return graph
+
+    def prompt_references(self, text: str) -> List[str]:
+        """Return the names of the prompts an instruction uses, in order."""
+        match = PROMPT_INSTRUCTION_PATTERN.match(text)
+        return [match.group(1)] if match else PROMPT_REFERENCE_PATTERN.findall(text)
+
+    def expand_prompts(self):
+        """Replace the prompt references in every instruction with the prompt's text.
+
+        References to prompts that are not defined stay in place, for validation to report. Prompt texts
+        are used as they are, so a prompt cannot use another one.
+        """
+        for entity in self.entities().values():
+            if not entity.instruction:
+                continue
+            match = PROMPT_INSTRUCTION_PATTERN.match(entity.instruction)
+            if match and match.group(1) in self.prompts:
+                entity.instruction = self.prompts[match.group(1)]
+            elif not match:
+                entity.instruction = PROMPT_REFERENCE_PATTERN.sub(
+                    lambda reference: self.prompts.get(reference.group(1), reference.group(0)), entity.instruction
+                )
+
+    def secret_names(self) -> List[str]:
+        """Return the environment variable names the declared secrets provide, without their values."""
+        names = []
+        for secret in self.secrets:
+            if isinstance(secret, str):
+                names.append(secret)
+            elif isinstance(secret, SecretValue):
+                names.append(secret.name)
+            elif isinstance(secret, SecretContext):
+                if secret.values:
+                    names.extend(f"{secret.name.upper()}_{key}" for key in secret.values)
+                else:
+                    names.append(secret.name)
+        return names
+
+    def secret_values(self) -> Dict[str, str]:
+        """Return the values written into the Agentfile for secrets, by environment variable name."""
+        values = {}
+        for secret in self.secrets:
+            if isinstance(secret, SecretValue):
+                values[secret.name] = secret.value
+            elif isinstance(secret, SecretContext):
+                values.update({f"{secret.name.upper()}_{key}": value for key, value in secret.values.items()})
+        return values
+
+    def undefined_servers(self) -> List[tuple]:
+        """Return (agent, name) for each SERVERS name that is not defined, external or an agno built-in tool."""
+        known = set(self.servers) | set(self.external_servers)
+        if self.framework == "agno":
+            known |= set(AGNO_TOOL_SERVERS)
+        return [(agent, name) for agent in self.agents.values() for name in agent.servers if name not in known]
+
+    def generated_cmd(self) -> List[str]:
+        """Return the CMD that starts the generated agent: interactively, or as a server under SERVE."""
+        if self.serve and self.framework == "agno":
+            return [
+                "uvicorn",
+                "agent:app",
+                "--host",
+                "0.0.0.0",
+                "--port",
+                str(self.serve.port),
+                "--timeout-graceful-shutdown",
+                str(self.shutdown_grace),
+            ]
+        if self.serve:
+            return DEFAULT_CMD + self.serve.server_args()
+        return list(DEFAULT_CMD)
+
+    def image_healthcheck(self) -> Optional[Healthcheck]:
+        """Return the HEALTHCHECK the image runs: the Agentfile's own, or else the one SERVE adds."""
+        if self.healthcheck or not self.serve:
+            return self.healthcheck
+        return self.serve.healthcheck()
+
+    @property
+    def custom_cmd(self) -> bool:
+        """Whether the CMD bypasses the generated agent."""
+        return self.cmd != self.generated_cmd() and not any(part.endswith("agent.py") for part in self.cmd)
+
+    @property
+    def resolved_cmd_mode(self) -> str:
+        """Return what the container runs: the generated agent, the custom CMD, or both."""
+        if not self.custom_cmd:
+            return "generated"
+        return self.cmd_mode or "override"
+
+    def stage_index(self, stage: str) -> int:
+        """Return the index of the stage a name or 0-based index selects."""
+        names = [build_stage.name for build_stage in self.stages]
+        if stage in names:
+            return names.index(stage)
+        if stage.isdigit() and int(stage) < len(self.stages):
+            return int(stage)
+        listed = ", ".join(name or str(index) for index, name in enumerate(names)) or "none"
+        raise ValueError(f"No build stage {stage}; the stages are {listed}")
+
+    def override_base_image(self, base_image: str, stage: Optional[str] = None):
+        """Replace the image of a stage's FROM, the agent stage's by default, remembering the Agentfile's.
+
+        stage is a stage name or 0-based index. The image is used as given, so one without a registry
+        is pulled from Docker Hub. A FROM pinned by digest can only be replaced by another pinned image.
+        """
+        index = self.agent_stage if stage is None else self.stage_index(stage)
+        current = self.stages[index].image if self.stages else self.base_image
+        if "@sha256:" in current and "@sha256:" not in base_image:
+            raise ValueError(
+                f"FROM pins {current} by digest; --base-image {base_image} would drop the pin, "
+                "so pass a pinned image (name@sha256:<digest>)"
+            )
+        if index == self.agent_stage:
+            if self.agentfile_base_image is None:
+                self.agentfile_base_image = self.base_image
+            self.base_image = base_image
+
+        from_instructions = [
+            inst for inst in self.dockerfile_instructions if inst.stage == index and inst.instruction == "FROM"
+        ]
+        if from_instructions:
+            args = from_instructions[-1].args
+            image_index = next(position for position, arg in enumerate(args) if not arg.startswith("--"))
+            args[image_index] = base_image
+            from_instructions[-1].raw = None
+        if self.stages:
+            self.stages[index].image = base_image
+
+
+def parse(content: str, **options) -> AgentfileConfig:
+    """Parse Agentfile content; options are those of AgentfileParser."""
+    return AgentfileParser(**options).parse_string(content)
+
+
+def parse_ast(content: str, **options) -> List[Statement]:
+    """Parse Agentfile content and return its statements in source order; options are those of AgentfileParser.
+
+    Declarations hold the sub-instructions of their block as children. INCLUDE is one statement,
+    without the statements of the included file.
+    """
+    parser = AgentfileParser(**options)
+    parser.parse_content(content)
+    return parser.statements
+
+
+def _read_file(path: str) -> str:
+    """Read an included Agentfile from disk."""
+    with open(path, 'r', encoding='utf-8') as f:
+        return f.read()
+
+
+class AgentfileParser:
+    """Parser for Agentfile format.
+
+    parse_file and parse_content build on the state of earlier calls, so each parser parses one
+    Agentfile. parse_string and parse_bytes leave the parser untouched and can be called again,
+    from several threads at once.
+    """
+
+    def __init__(
+        self,
+        resolver: Optional[Callable[[str], str]] = None,
+        build_args: Optional[Dict[str, str]] = None,
+        env_lookup: Optional[Callable[[str], Optional[str]]] = None,
+        max_workers: int = DEFAULT_INCLUDE_WORKERS,
+        cancel: Optional[threading.Event] = None,
+        allow_server_alias: bool = True,
+        max_line_size: Optional[int] = None,
+        default_base_image: str = DEFAULT_BASE_IMAGE,
+        default_framework: str = DEFAULT_FRAMEWORK,
+        default_cmd: Optional[List[str]] = None,
+        strict: bool = False,
+        external_servers: Optional[List[str]] = None,
+        route_to_workflows: bool = False,
+        validate: bool = True,
+        server_registry: Optional[Dict[str, dict]] = None,
+        allow_override: bool = False,
+        source_url: Optional[str] = None,
+        insecure_http: bool = False,
+        fetch: Callable[[str], bytes] = fetch_url,
+        overrides: Optional[List[str]] = None,
+    ):
+        if default_framework not in FRAMEWORKS:
+            raise ValueError(f"Unsupported framework: {default_framework}. Supported: {', '.join(FRAMEWORKS)}")
+        # What the Agentfile gets when it has no FROM, FRAMEWORK or CMD of its own
+        self.default_base_image = default_base_image
+        self.default_framework = default_framework
+        # None gives each Agentfile the CMD of its framework, worked out once parsing is done
+        self.default_cmd = list(default_cmd) if default_cmd else None
+        self.strict = strict  # Whether warnings fail the parse, at the first one
+        self.validate = validate  # Whether parsing fails on the first error config.validate() finds
+        self.config = AgentfileConfig(
+            base_image=default_base_image,
+            framework=default_framework,
+            external_servers=list(external_servers or []),
+            route_to_workflows=route_to_workflows,
+        )
+        # Values passed with --build-arg, and the ARGs declared so far with their defaults
+        self.build_args = dict(build_args or {})
+        self.declared_args: Dict[str, Optional[str]] = {}
+        # Reads ${env:NAME}, such as os.environ.get; off by default so builds do not depend on the shell
+        self.env_lookup = env_lookup
+        # Reads an INCLUDE path; tests pass an in-memory resolver instead of the file system
+        self.resolver = resolver or _read_file
+        self.base_dir = ""  # Directory, or URL ending in /, INCLUDE paths are relative to
+        # URL a fetched Agentfile came from, so its relative INCLUDEs are fetched from next to it
+        self.source_url = source_url
+        self.insecure_http = insecure_http  # Whether INCLUDE may fetch over plain HTTP
+        self.fetch = fetch  # Downloads an INCLUDE URL; tests pass a stub instead of the network
+        # Instruction lines parsed after the Agentfile as if it INCLUDEd them last, such as render --set gives
+        self.overrides = list(overrides or [])
+        self._root_dir = ""  # Directory of the Agentfile itself, which INSTRUCTION_FILE paths are kept relative to
+        # Agent and workflow blocks that set INSTRUCTION, as (keyword, name)
+        self._instructed: set = set()
+        self._include_stack: List[str] = []  # Files being parsed, outermost first
+        self.max_workers = max_workers
+        self.allow_server_alias = allow_server_alias  # Whether the deprecated SERVER spelling is accepted
+        # Launch settings of servers declared by name alone, such as BUILTIN_SERVERS
+        self.server_registry = BUILTIN_SERVERS if server_registry is None else server_registry
+        # Whether IMPORT_MCP servers and MCP_SERVER blocks of the same name replace each other
+        self.allow_override = allow_override
+        self._imported_servers: Dict[str, tuple] = {}  # Server name -> (file, line) of the IMPORT_MCP it came from
+        self.cancel = cancel  # Set it to stop reading INCLUDE targets ahead
+        self.max_line_size = max_line_size  # Longest physical line accepted, in characters; None for no limit
+        self._agent_stage_closed = False  # Whether a stage named agent was followed by a later one
+        self._shell_forms: Dict[str, int] = {}  # Line of the agent stage's CMD or ENTRYPOINT, when in shell form
+        self._included: Dict[str, str] = {}  # INCLUDE targets read ahead, by normalized path
+        # Where each server and each agent or workflow name was declared, as (keyword, file, line, body)
+        self._declarations: Dict[tuple, tuple] = {}
+        # The block the last instruction closed without an END, as (context, name, instruction, line)
+        self._closed_context: Optional[tuple] = None
+        self._bodies = 0  # Files parsed so far, counting a file included twice as two
+        self._body = 0  # Which of those the current instruction belongs to
         self.current_context = None
         self.current_item = None
+        self.current_line = None
+        self.current_text = None
+        self.current_raw = None  # Physical lines of the current instruction, including heredoc bodies
+        self.current_heredocs: List[str] = []  # Bodies of the heredocs the current instruction opens
+        self.current_columns: List[int] = []  # Column each part of the current instruction starts at
+        self.current_indent = 0  # Indentation of the current instruction's first line
+        self.current_end_line = None  # Last physical line of the current instruction
+        self.current_comments: List[str] = []  # Comment lines between the previous instruction and this one
+        self.statements: List[Statement] = []  # The parsed file's instructions in source order
+        self._open_statement: Optional[Statement] = None  # Declaration whose block is open
+        self.diagnostics: List[Diagnostic] = []
+        # Which list syntax ("array" or "plain") each list sub-instruction used, per item
+        self._list_forms: Dict[tuple, str] = {}
+        # Where each list sub-instruction was last set, as (line number, logical line text)
+        self._list_positions: Dict[tuple, tuple] = {}
 
     def parse_file(self, filepath: str) -> AgentfileConfig:
         """Parse an Agentfile and return the configuration."""
         with open(filepath, 'r', encoding='utf-8') as f:
             content = f.read()
+        self.config.source_name = os.path.basename(filepath)
+        self.config.source_path = filepath
+        self.base_dir = include_base(self.source_url or filepath)
+        self._include_stack = [self.source_url or os.path.normpath(filepath)]
         return self.parse_content(content)
 
+    def parse_string(self, content: str, source_name: str = "", base_dir: str = "") -> AgentfileConfig:
+        """Parse Agentfile content with a fresh parser that has this one's options.
+
+        base_dir is the directory INCLUDE and ENV_FILE paths are relative to.
+        """
+        parser = self._fresh()
+        parser.config.source_name = source_name
+        parser.base_dir = base_dir
+        return parser.parse_content(content)
+
+    def parse_bytes(self, data: bytes, source_name: str = "", base_dir: str = "") -> AgentfileConfig:
+        """Parse UTF-8 encoded Agentfile content, as parse_string does."""
+        return self.parse_string(data.decode("utf-8"), source_name, base_dir)
+
+    def _fresh(self) -> "AgentfileParser":
+        """Return a parser with this one's options and none of its state."""
+        return AgentfileParser(
+            resolver=self.resolver,
+            build_args=self.build_args,
+            env_lookup=self.env_lookup,
+            max_workers=self.max_workers,
+            cancel=self.cancel,
+            allow_server_alias=self.allow_server_alias,
+            max_line_size=self.max_line_size,
+            default_base_image=self.default_base_image,
+            default_framework=self.default_framework,
+            default_cmd=self.default_cmd,
+            strict=self.strict,
+            external_servers=self.config.external_servers,
+            route_to_workflows=self.config.route_to_workflows,
+            validate=self.validate,
+            server_registry=self.server_registry,
+            allow_override=self.allow_override,
+            source_url=self.source_url,
+            insecure_http=self.insecure_http,
+            fetch=self.fetch,
+            overrides=self.overrides,
+        )
+
     def parse_content(self, content: str) -> AgentfileConfig:
         """Parse Agentfile content and return the configuration."""
         lines = content.split('\n')
+        self.config.source_sha256 = hashlib.sha256(content.encode("utf-8")).hexdigest()
+        body_start = self._parse_directives(lines)
+        self._declarations = {}
+        self._imported_servers = {}
+        self._root_dir = self.base_dir
+        self._included = resolve_includes(content, self.base_dir, self.resolver, self.max_workers, self.cancel)
+        self._parse_body(lines, body_start)
+        if self.overrides:
+            self._parse_overrides()
+
+        self._apply_global_env()
+        self._apply_server_registry()
+        self._apply_serve()
+        self._apply_default_cmd()
+        self._classify_servers()
+        self._check_server_portability()
+        if self.validate:
+            self._check_findings()
+        self.config.expand_prompts()
+        self._check_framework_capabilities()
+        self._check_cmd_mode()
+        self._check_shell_forms()
+        if self.strict:
+            self._raise_first_warning(lines)
+        return self.config
 
-        # Pre-process lines to handle multi-line continuations with backslash
-        processed_lines = []
+    def _raise_first_warning(self, lines: List[str]):
+        """Fail on the first warning, for strict parsers."""
+        for diagnostic in self.diagnostics:
+            if diagnostic.severity == SEVERITY_WARNING:
+                source = lines[diagnostic.line - 1].strip() if diagnostic.line else None
+                raise AgentfileError(
+                    f"{diagnostic.message} [{diagnostic.code}]",
+                    file=self._current_file(),
+                    line=diagnostic.line,
+                    column=diagnostic.column,
+                    instruction=source.split(None, 1)[0].upper() if source else None,
+                    source=source,
+                )
+
+    def _parse_body(self, lines: List[str], body_start: int):
+        """Parse the instructions that follow the parser directives."""
+        outer_body = self._body
+        self._bodies += 1
+        self._body = self._bodies
+        try:
+            self._parse_instructions(lines, body_start)
+        finally:
+            self._body = outer_body
+
+    def _parse_instructions(self, lines: List[str], body_start: int):
+        """Parse each logical line, wrapping errors with the line they were found on."""
+        previous_end = body_start
+        for line_num, line, raw, heredocs, end_line in self._logical_lines(lines, body_start):
+            self.current_comments = [
+                text.strip() for text in lines[previous_end : line_num - 1] if text.strip().startswith("#")
+            ]
+            self.current_end_line = end_line
+            previous_end = end_line
+            self.current_line = line_num
+            self.current_text = line
+            self.current_raw = raw
+            self.current_heredocs = heredocs
+            self.current_columns = []
+            self.current_indent = len(lines[line_num - 1]) - len(lines[line_num - 1].lstrip())
+            try:
+                self._parse_line(line)
+            except AgentfileError as e:
+                if e.context:  # Raised in an included file, which already placed it
+                    e.include_context(f"Error parsing line {line_num}: {line}\n")
+                raise
+            except Exception as e:
+                raise self._error(str(e), 0) from e
+
+    def _current_file(self) -> str:
+        """Return the path of the file being parsed."""
+        return self._include_stack[-1] if self._include_stack else self.config.source_name
+
+    def _column(self, index: int) -> Optional[int]:
+        """Return the 1-based column of a part of the current instruction, counting its indentation."""
+        if index >= len(self.current_columns):
+            return None
+        return self.current_columns[index] + self.current_indent
+
+    def _error(self, message: str, index: int) -> AgentfileError:
+        """Build an error pointing at a part of the current instruction."""
+        line = self.current_text or ""
+        return AgentfileError(
+            message,
+            file=self._current_file(),
+            line=self.current_line,
+            column=self._column(index),
+            instruction=line.split(None, 1)[0].upper() if line else None,
+            source=line,
+        )
+
+    def logical_lines(self, content: str) -> List[tuple]:
+        """Split Agentfile content into (start line number, logical line) pairs without parsing them.
+
+        Continuations are joined, and heredoc bodies and triple-quoted values stay inside
+        the instruction that opens them.
+        """
+        lines = content.split('\n')
+        return [(entry[0], entry[1]) for entry in self._logical_lines(lines, self._parse_directives(lines))]
+
+    def _logical_lines(self, lines: List[str], body_start: int) -> List[tuple]:
+        """Return (line number, logical line, raw text, heredoc bodies, last line number) for the instructions
+        after the directives."""
+        escape = self.config.escape_char
+
+        # Pre-process lines to handle multi-line continuations and heredocs
+        processed_lines = []  # (line number, logical line, raw text, heredoc bodies, last line number)
         current_line = ""
+        raw_lines: List[str] = []
         continued_start_line_num = None
-
-        for line_num, line in enumerate(lines, 1):
+        body = list(enumerate(lines[body_start:], body_start + 1))
+        for line_num, line in body:
+            if self.max_line_size is not None and len(line) > self.max_line_size:
+                raise AgentfileError(
+                    f"Line {line_num} is {len(line)} characters long, over the limit of {self.max_line_size}",
+                    file=self._current_file(),
+                    line=line_num,
+                    instruction=line.split(None, 1)[0].upper() if line.strip() else None,
+                    source=line.strip()[:LONG_LINE_PREVIEW] + "...",
+                )
+
+        index = 0
+        while index < len(body):
+            line_num, line = body[index]
+            index += 1
+
+            # A line inside a quoted value left open is part of the value as written
+            if current_line.endswith("\n"):
+                raw_lines.append(line)
+                current_line += line
+                if self._open_quote(current_line):
+                    current_line += "\n"
+                    continue
+                current_line = current_line.rstrip()
+                if current_line.endswith(escape):
+                    current_line = f"{current_line[:-1].rstrip()} "
+                    continue
+                line = ""
             line = line.rstrip()  # Remove trailing whitespace but keep leading
 
             # Skip empty lines and comments if not part of a continuation
             if not current_line and (not line or line.lstrip().startswith('#')):
+                directive = PARSER_DIRECTIVE_PATTERN.match(line.strip())
+                if directive and directive.group(1).lower() in PARSER_DIRECTIVES:
+                    raise AgentfileError(
+                        f"Parser directive '{directive.group(1).lower()}' must appear at the top of the Agentfile, "
+                        "before any comment or instruction",
+                        file=self._current_file(),
+                        line=line_num,
+                        column=line.find("#") + 1,
+                        source=line.strip(),
+                    )
+                continue
+
+            # Like Docker, comment lines inside a continued instruction are dropped
+            if current_line and line.lstrip().startswith('#'):
+                continue
+
+            # Keep the physical lines so passthrough instructions can be re-emitted verbatim
+            if line:
+                raw_lines.append(line if current_line else line.lstrip())
+            if not current_line:
+                continued_start_line_num = line_num
+
+            # An Agentman value still inside quotes carries on, newline included, whatever the line ends with
+            if line and self._open_quote(current_line + line):
+                current_line += line + "\n"
                 continue
 
             # Check for line continuation
-            if line.endswith('\\'):
+            if line.endswith(escape):
                 # Remove the backslash and add to current line with a space
-                if not current_line:
-                    # This is the start of a new continued line, so record the starting line number
-                    continued_start_line_num = line_num
                 current_line += f"{line[:-1].rstrip()} "
-            else:
-                # Complete the line
-                current_line += line
-                if current_line.strip():  # Only add non-empty lines
-                    # Use the real start line number for continued instructions
-                    if continued_start_line_num is not None:
-                        processed_lines.append((continued_start_line_num, current_line.strip()))
-                        continued_start_line_num = None
-                    else:
-                        processed_lines.append((line_num, current_line.strip()))
-                current_line = ""
+                continue
+
+            # Complete the line, using the real start line number for continued instructions
+            current_line = (current_line + line).strip()
+            start_line_num = continued_start_line_num
+            # A triple-quoted value keeps reading physical lines, newlines included, until it closes
+            if self._opens_triple_quote(current_line):
+                opening_line = current_line
+                while current_line.count(TRIPLE_QUOTE) % 2:
+                    if index >= len(body):
+                        raise AgentfileError(
+                            f"Unterminated triple-quoted string starting on line {start_line_num}",
+                            file=self._current_file(),
+                            line=start_line_num,
+                            column=opening_line.rfind(TRIPLE_QUOTE) + 1,
+                            instruction=opening_line.split(None, 1)[0].upper(),
+                            source=opening_line,
+                        )
+                    body_line = body[index][1]
+                    index += 1
+                    raw_lines.append(body_line)
+                    current_line += "\n" + body_line
+                current_line = current_line.rstrip()
+            # Heredoc bodies belong to the instruction and are never parsed as instructions themselves
+            heredocs = []
+            for delimiter, strip_tabs in self._heredoc_delimiters(current_line):
+                heredoc_lines = []
+                while True:
+                    if index >= len(body):
+                        raise AgentfileError(
+                            f"Unterminated heredoc, missing closing {delimiter}",
+                            file=self._current_file(),
+                            line=start_line_num,
+                            column=current_line.find("<<") + 1,
+                            instruction=current_line.split(None, 1)[0].upper(),
+                            source=current_line,
+                        )
+                    body_line = body[index][1]
+                    index += 1
+                    raw_lines.append(body_line)
+                    body_line = body_line.lstrip("\t") if strip_tabs else body_line
+                    if body_line == delimiter:
+                        break
+                    heredoc_lines.append(body_line)
+                heredocs.append("\n".join(heredoc_lines))
+            if current_line:  # Only add non-empty lines
+                processed_lines.append(
+                    (start_line_num, current_line, "\n".join(raw_lines), heredocs, body[index - 1][0])
+                )
+            current_line = ""
+            raw_lines = []
+            continued_start_line_num = None
+
+        if current_line.endswith("\n"):
+            opening_line = current_line.split("\n", 1)[0]
+            raise AgentfileError(
+                f"Unterminated quoted string starting on line {continued_start_line_num}",
+                file=self._current_file(),
+                line=continued_start_line_num,
+                column=self._open_quote(opening_line)[1] + 1,
+                instruction=opening_line.split(None, 1)[0].upper(),
+                source=opening_line.strip(),
+            )
 
         # Handle any remaining line (shouldn't happen with proper syntax)
         if current_line.strip():
-            # Use the real start line number for continued instructions if present
-            if continued_start_line_num is not None:
-                processed_lines.append((continued_start_line_num, current_line.strip()))
-            else:
-                processed_lines.append((len(lines), current_line.strip()))
+            processed_lines.append(
+                (continued_start_line_num or len(lines), current_line.strip(), "\n".join(raw_lines), [], len(lines))
+            )
 
-        # Parse each processed line
-        for line_num, line in processed_lines:
-            try:
-                self._parse_line(line)
-            except Exception as e:
-                raise ValueError(f"Error parsing line {line_num}: {line}\n{str(e)}") from e
+        return processed_lines
+
+    def _heredoc_delimiters(self, line: str) -> List[tuple]:
+        """Return the (delimiter, strips leading tabs) pairs of the heredocs a line opens."""
+        instruction, _, rest = line.partition(" ")
+        if instruction.upper() not in HEREDOC_INSTRUCTIONS:
+            return []
+        if instruction.upper() in ["INSTRUCTION", "PROMPT"]:
+            # Only a heredoc that replaces the whole text counts, so prose mentioning << is left alone
+            if instruction.upper() == "PROMPT":
+                rest = rest.strip().partition(" ")[2]
+            match = HEREDOC_PATTERN.fullmatch(rest.strip())
+            return [(match.group(3), match.group(1) == "-")] if match else []
+        return [(match.group(3), match.group(1) == "-") for match in HEREDOC_PATTERN.finditer(line)]
+
+    def _open_quote(self, line: str) -> Optional[tuple]:
+        """Return the quote character and index of a single or double quote an Agentman line leaves open."""
+        instruction = line.split(None, 1)[0].upper() if line.strip() else ""
+        if instruction not in AGENTMAN_INSTRUCTIONS + SUB_INSTRUCTIONS:
+            return None
+        if '"' not in line and "'" not in line:
+            return None
+        escape = self.config.escape_char
+        quote = None
+        i = 0
+        while i < len(line):
+            if quote is not None:
+                i = self._quote_stop(line, i, quote[0], escape)
+                if i == len(line):
+                    break
+            char = line[i]
+            if quote is None and line.startswith(TRIPLE_QUOTE, i):
+                # Triple-quoted values have their own rule for spanning lines
+                end = line.find(TRIPLE_QUOTE, i + 3)
+                if end == -1:
+                    return None
+                i = end + 3
+                while line.startswith('"', i):  # The value may end in a quote, as in """say "hi""""
+                    i += 1
+                continue
+            if quote is None and char == "#" and (i == 0 or line[i - 1].isspace()):
+                return None
+            if char == escape:  # An escaped quote, inside a quoted value or out of one, neither opens nor closes
+                i += 2
+                continue
+            if quote is None and self._quote_opens(line, i):
+                quote = (char, i)
+            elif quote is not None and char == quote[0]:
+                quote = None
+            i += 1
+        return quote
+
+    @staticmethod
+    def _quote_stop(line: str, index: int, quote_char: str, escape: Optional[str]) -> int:
+        """Return the index of the next quote_char or escape at or after index, or the end of the line."""
+        stops = re.escape(quote_char) + (re.escape(escape) if escape else "")
+        match = re.compile(f"[{stops}]").search(line, index)
+        return match.start() if match else len(line)
+
+    @staticmethod
+    def _quote_opens(line: str, index: int) -> bool:
+        """Whether the character at index opens a quoted value; a quote inside a word, as in don't, is literal."""
+        return line[index] in ['"', "'"] and (index == 0 or not line[index - 1].isalnum())
+
+    def _opens_triple_quote(self, line: str) -> bool:
+        """Whether a line leaves a triple-quoted value open at its end."""
+        instruction = line.partition(" ")[0].upper()
+        return instruction not in SHELL_INSTRUCTIONS and line.count(TRIPLE_QUOTE) % 2 == 1
+
+    def _instruction_text(self, parts: List[str]) -> str:
+        """Return the text of an INSTRUCTION sub-instruction, taking a heredoc body verbatim."""
+        if len(parts) < 2:
+            raise ValueError("INSTRUCTION requires instruction text")
+        if self.current_heredocs:
+            return self.current_heredocs[0]
+        return self._unquote(' '.join(parts[1:]))
+
+    def _set_instruction(self, entity, text: str):
+        """Set the INSTRUCTION of the open agent or workflow block, which cannot also have an INSTRUCTION_FILE."""
+        if entity.instruction_file:
+            raise self._error(f"{self._block_title()} sets both INSTRUCTION_FILE and INSTRUCTION; keep one", 0)
+        entity.instruction = text
+        self._instructed.add((CONTEXT_KEYWORDS[self.current_context], entity.name))
+
+    def _handle_instruction_file(self, entity, parts: List[str]):
+        """Handle INSTRUCTION_FILE path, reading the instruction of the open agent or workflow block from a file.
+
+        The path is relative to the file the instruction is in, and is kept relative to the Agentfile.
+        """
+        if len(parts) != 2:
+            raise ValueError("INSTRUCTION_FILE requires exactly one file path")
+        if (CONTEXT_KEYWORDS[self.current_context], entity.name) in self._instructed:
+            raise self._error(f"{self._block_title()} sets both INSTRUCTION and INSTRUCTION_FILE; keep one", 0)
+        path = self._local_path("INSTRUCTION_FILE", self._unquote(parts[1]), 1)
+        try:
+            content = self.resolver(path)
+        except OSError as e:
+            raise self._error(f"Cannot read INSTRUCTION_FILE {path}: {e.strerror or e}", 1) from e
+        if not content.strip():
+            raise self._error(f"INSTRUCTION_FILE {path} is empty", 1)
+        entity.instruction = content.rstrip("\n")
+        entity.instruction_file = os.path.relpath(path, self._root_dir or os.curdir)
+
+    def _block_title(self) -> str:
+        """Return the declaration of the open block, such as AGENT coder."""
+        return f"{CONTEXT_KEYWORDS[self.current_context]} {self.current_item}"
+
+    def _parse_directives(self, lines: List[str]) -> int:
+        """Read parser directives from the top of the file and return the index of the first other line."""
+        index = 0
+        while index < len(lines):
+            line = lines[index].strip()
+            directive = PARSER_DIRECTIVE_PATTERN.match(line)
+            if not line and not self.config.directives:
+                index += 1
+                continue
+            if not directive or directive.group(1).lower() not in PARSER_DIRECTIVES:
+                break
 
-        return self.config
+            name, value = directive.group(1).lower(), directive.group(2)
+            if name in self.config.directives:
+                raise AgentfileError(
+                    f"Duplicate parser directive '{name}'", file=self._current_file(), line=index + 1, source=line
+                )
+            if name == "escape" and value not in ESCAPE_CHARS:
+                raise AgentfileError(
+                    f"Invalid escape character '{value}'. Supported: {', '.join(ESCAPE_CHARS)}",
+                    file=self._current_file(),
+                    line=index + 1,
+                    column=line.rfind(value) + 1,
+                    source=line,
+                )
+            self.config.directives[name] = value
+            index += 1
+        return index
+
+    def _classify_servers(self):
+        """Extract and validate the npx/uvx packages servers launch."""
+        for server in self.config.servers.values():
+            server.package = classify_server_command(server.command, server.args)
+            if server.package is None or server.package.version is None:
+                continue
+
+            pattern = NPM_VERSION_PATTERN if server.package.installer == "npm" else PYTHON_VERSION_PATTERN
+            if not pattern.match(server.package.version):
+                raise ValueError(
+                    f"Invalid version pin '{server.package.version}' for package {server.package.name} "
+                    f"of server {server.name}: expected an exact version like 1.2.3"
+                )
+
+    def _check_findings(self):
+        """Raise the first error config.validate() finds and record its warnings as diagnostics."""
+        findings = self.config.validate()
+        for finding in findings:
+            if finding.severity == SEVERITY_ERROR:
+                raise self._finding_error(finding)
+        self.diagnostics.extend(
+            Diagnostic(finding.severity, finding.code, finding.message, finding.line) for finding in findings
+        )
+
+    def _finding_error(self, finding: Finding) -> AgentfileError:
+        """Turn an error finding into a parse error at the declaration its path names."""
+        kind, _, rest = finding.path.partition(".")
+        name = re.split(r"[.\[]", rest, maxsplit=1)[0]
+        keyword = CONTEXT_KEYWORDS.get(kind[:-1])
+        line, column, source = finding.line, None, f"{keyword} {name}" if keyword else None
+        if finding.code == "undefined-server":
+            # Point at the server's name on the SERVERS line rather than at the AGENT declaration
+            server = self.config.agents[name].servers[int(finding.path.rsplit("[", 1)[1][:-1])]
+            line, text = self._list_positions.get(("agent", name, "SERVERS"), (line, ""))
+            column, source = text.find(server) + 1 or None, text or source
+        return AgentfileError(finding.message, file=self.config.source_name, line=line, column=column, source=source)
+
+    def _check_framework_capabilities(self):
+        """Reject settings the chosen framework cannot act on, instead of generating dead configuration.
+
+        Settings it can leave out without changing what the agent may do are reported as warnings.
+        """
+        serve = self.config.serve
+        if serve and SERVE_PROTOCOLS[serve.protocol] != self.config.framework:
+            supported = next(name for name, framework in SERVE_PROTOCOLS.items() if framework == self.config.framework)
+            raise AgentfileError(
+                f"FRAMEWORK {self.config.framework} cannot SERVE {serve.protocol}; use SERVE {supported}",
+                file=self.config.source_name,
+                line=serve.line,
+                source=f"SERVE {serve.protocol}",
+            )
+        if serve and serve.protocol == "mcp" and serve.endpoint != SERVE_DEFAULT_PATHS["mcp"]:
+            raise AgentfileError(
+                f"fast-agent serves MCP on {SERVE_DEFAULT_PATHS['mcp']}, so SERVE cannot use {serve.path}",
+                file=self.config.source_name,
+                line=serve.line,
+                source=f"SERVE {serve.protocol}",
+            )
+        if self.config.framework != "agno":
+            return
+        for agent in self.config.agents.values():
+            if agent.tools:
+                self.diagnostics.append(
+                    Diagnostic(
+                        SEVERITY_WARNING,
+                        "unsupported-tools",
+                        f"Agent {agent.name} sets TOOLS, but FRAMEWORK agno maps SERVERS to its own toolkits "
+                        "and cannot filter their tools; the agent gets every tool of each toolkit",
+                        agent.line,
+                    )
+                )
+            if agent.resources:
+                self.diagnostics.append(
+                    Diagnostic(
+                        SEVERITY_WARNING,
+                        "unsupported-resources",
+                        f"Agent {agent.name} sets RESOURCES, but FRAMEWORK agno does not read MCP resources; "
+                        "the agent starts without them",
+                        agent.line,
+                    )
+                )
+        # An ORCHESTRATOR or a PARALLEL becomes the team; a PARALLEL's FAN_IN agent leads it
+        teams = [("ORCHESTRATOR", item, item.agents, "coordinates") for item in self.config.orchestrators.values()]
+        for parallel in self.config.parallels.values():
+            members = parallel.fan_out + ([parallel.fan_in] if parallel.fan_in else [])
+            teams.append(("PARALLEL", parallel, members, "runs"))
+        if len(teams) > 1:
+            (first_keyword, first, *_), (second_keyword, second, *_) = teams[:2]
+            second_title = second.name if second_keyword == first_keyword else f"{second_keyword} {second.name}"
+            raise AgentfileError(
+                f"FRAMEWORK agno runs one team, but {first_keyword} {first.name} and {second_title} "
+                "are both declared; keep one",
+                file=self.config.source_name,
+                line=second.line,
+                source=f"{second_keyword} {second.name}",
+            )
+        for keyword, team, members, verb in teams:
+            workflows = [name for name in members if name not in self.config.agents]
+            if workflows:
+                raise AgentfileError(
+                    f"{keyword} {team.name} {verb} {', '.join(workflows)}, but FRAMEWORK agno "
+                    "can only make AGENTs team members",
+                    file=self.config.source_name,
+                    line=team.line,
+                    source=f"{keyword} {team.name}",
+                )
+        if self.config.human_input_mode == "webhook":
+            lines = [statement.line for statement in self.statements if statement.keyword == "HUMAN_INPUT_MODE"]
+            raise AgentfileError(
+                "HUMAN_INPUT_MODE webhook needs fast-agent's human input handler, which FRAMEWORK agno does not have",
+                file=self.config.source_name,
+                line=lines[-1] if lines else None,
+            )
+        for server in self.config.servers.values():
+            if server.elicitation_mode:
+                raise AgentfileError(
+                    f"Server {server.name} sets ELICITATION_MODE, but FRAMEWORK agno does not support MCP elicitation",
+                    file=self.config.source_name,
+                    line=server.line,
+                    source=f"MCP_SERVER {server.name}",
+                )
+
+    def _check_cmd_mode(self):
+        """Report a CMD that silently replaces the generated agent."""
+        if not self.config.custom_cmd:
+            if self.config.cmd_mode == "append":
+                raise ValueError("CMD_MODE append needs a CMD that runs something other than agent.py")
+            return
+        config = self.config
+        if config.cmd_mode or not config.entities():
+            return
+
+        cmd_lines = [inst.line for inst in config.agent_instructions if inst.instruction == "CMD"]
+        self.diagnostics.append(
+            Diagnostic(
+                SEVERITY_WARNING,
+                "cmd-conflict",
+                f"CMD {json.dumps(config.cmd)} replaces the generated agent, so agent.py never runs. "
+                "Remove the CMD, add CMD_MODE override to keep only it, or CMD_MODE append to run both",
+                cmd_lines[-1] if cmd_lines else None,
+            )
+        )
+
+    def _check_shell_forms(self):
+        """Warn when ENTRYPOINT and CMD are both in shell form, which Docker runs differently from the output."""
+        if not {"CMD", "ENTRYPOINT"} <= set(self._shell_forms):
+            return
+        self.diagnostics.append(
+            Diagnostic(
+                SEVERITY_WARNING,
+                "shell-form-entrypoint",
+                "ENTRYPOINT and CMD are both in shell form, under which Docker ignores the CMD. The Dockerfile "
+                "writes both in exec form, so the CMD is passed to the ENTRYPOINT as arguments; write them as "
+                "JSON arrays to make that explicit",
+                self._shell_forms["ENTRYPOINT"],
+            )
+        )
+
+    def _check_server_portability(self):
+        """Check that server commands can run inside the built container."""
+        for server in self.config.servers.values():
+            if server.uses_docker and not server.allow_docker:
+                raise AgentfileError(
+                    f"Server {server.name} is launched with docker, but the agent container has no docker CLI "
+                    "or socket. Add ALLOW_DOCKER true to install the docker CLI and mount "
+                    "/var/run/docker.sock when running the image",
+                    file=self.config.source_name,
+                    line=server.line,
+                    instruction=server.keyword,
+                    source=f"{server.keyword} {server.name}",
+                )
+
+            windows_paths = [arg for arg in server.args if WINDOWS_PATH_PATTERN.match(arg)]
+            if windows_paths:
+                line, text = self._list_positions.get(("server", server.name, "ARGS"), (None, ""))
+                column = text.find(windows_paths[0]) + 1 or None
+                self.diagnostics.append(
+                    Diagnostic(
+                        SEVERITY_WARNING,
+                        "windows-path",
+                        f"Server {server.name} uses Windows paths ({', '.join(windows_paths)}) that do not exist "
+                        "inside the Linux container",
+                        line,
+                        column,
+                    )
+                )
 
     def _parse_line(self, line: str):
         """Parse a single line of the Agentfile."""
         # Split by whitespace but handle quoted strings
-        parts = self._split_respecting_quotes(line)
+        instruction = line.split(None, 1)[0].upper() if line.strip() else ""
+        agentman = instruction in AGENTMAN_INSTRUCTIONS + SUB_INSTRUCTIONS or (
+            instruction == "ENV" and self.current_context == "server"
+        )
+        # Trailing comments are Agentman syntax; Dockerfile instructions keep Docker's meaning of #
+        parts, self.current_columns, end = self._tokenize(line, strip_comments=agentman, unescape=agentman)
         if not parts:
             return
 
-        instruction = parts[0].upper()
+        if agentman:
+            parts = parts[:1] + [self._expand_placeholders(part) for part in parts[1:]]
 
+        block = (self.current_context, self.current_item)
+        if instruction == "END":
+            self._handle_end(parts)
+        else:
+            self._parse_instruction(instruction, parts)
+        self._record_statement(instruction, line, end, block)
+
+    def _parse_instruction(self, instruction: str, parts: List[str]):
+        """Dispatch an instruction other than END, noting which block it closed without an END."""
+        closed = self._closed_context
+        if self.current_context is None and closed and closed[0] in CONTEXT_SENSITIVE_INSTRUCTIONS.get(instruction, []):
+            context, name, closed_by, closed_line = closed
+            self._warn(
+                "implicit-end",
+                f"{instruction} is top-level because {closed_by} on line {closed_line} closed the "
+                f"{CONTEXT_KEYWORDS[context]} {name} block; add END after the block to make that explicit, "
+                f"or move {instruction} into it",
+            )
+            self._closed_context = None
+
+        context = self.current_context
+        self._dispatch_instruction(instruction, parts)
+        if self.current_context is not None:
+            self._closed_context = None
+        elif context is not None:
+            self._closed_context = (context, self.current_item, instruction, self.current_line)
+
+    def _record_statement(self, instruction: str, line: str, end: int, block: tuple):
+        """Add the current instruction to the statements of the file being parsed, under its block if it has one.
+
+        end is where the instruction's arguments stop, before any trailing comment, and block is
+        the (context, item) that was open before the instruction.
+        """
+        if self._body != 1:  # Included files have statements of their own
+            return
+        starts = [column - 1 for column in self.current_columns] + [end]
+        comment = line[end:].strip()
+        statement = Statement(
+            keyword=instruction,
+            args=[line[start:stop].rstrip() for start, stop in zip(starts[1:], starts[2:])],
+            line=self.current_line,
+            end_line=self.current_end_line,
+            column=self.current_indent + 1,
+            text=line,
+            raw=self.current_raw,
+            heredocs=list(self.current_heredocs),
+            comment=comment or None,
+            comments=self.current_comments,
+        )
+        if instruction in DECLARATION_KEYWORDS and self.current_context is not None:
+            self.statements.append(statement)
+            self._open_statement = statement
+        elif self._open_statement is not None and (
+            instruction == "END" or (block[0] is not None and block == (self.current_context, self.current_item))
+        ):
+            self._open_statement.children.append(statement)
+            if instruction == "END":
+                self._open_statement = None
+        else:
+            self.statements.append(statement)
+            self._open_statement = None
+
+    def _dispatch_instruction(self, instruction: str, parts: List[str]):
+        """Hand an instruction to its handler."""
         # Agentman-specific instructions (not Docker)
         if instruction == "MODEL":
             # Check if we're in a context that should handle MODEL as sub-instruction
-            if self.current_context in ["agent"]:
+            if self.current_context in CONTEXT_SENSITIVE_INSTRUCTIONS["MODEL"]:
                 self._handle_sub_instruction(instruction, parts)
             else:
                 self._handle_model(parts)
+        elif instruction in MODEL_SETTINGS:
+            if self.current_context in CONTEXT_SENSITIVE_INSTRUCTIONS[instruction]:
+                self._handle_sub_instruction(instruction, parts)
+            else:
+                value = self._parse_model_setting(instruction, parts)
+                setattr(self.config, f"default_{MODEL_SETTINGS[instruction]}", value)
+                self.current_context = None
+        elif instruction == "INCLUDE":
+            self._handle_include(parts)
+        elif instruction == "ENV_FILE":
+            self._handle_env_file(parts)
+        elif instruction == "IMPORT_MCP":
+            self._handle_import_mcp(parts)
+        elif instruction == "PROMPT":
+            self._handle_prompt(parts)
         elif instruction == "FRAMEWORK":
             self._handle_framework(parts)
+        elif instruction == "SHUTDOWN_GRACE":
+            self._handle_shutdown_grace(parts)
+        elif instruction == "HUMAN_INPUT_MODE":
+            self._handle_human_input_mode(parts)
+        elif instruction == "SERVE":
+            self._handle_serve(parts)
+        elif instruction == "CMD_MODE":
+            self._handle_cmd_mode(parts)
         elif instruction in ["SERVER", "MCP_SERVER"]:
             self._handle_server(parts)
         elif instruction == "AGENT":
@@ -321,6 +2079,8 @@ class AgentfileParser:
             self._handle_router(parts)
         elif instruction == "CHAIN":
             self._handle_chain(parts)
+        elif instruction == "PARALLEL":
+            self._handle_parallel(parts)
         elif instruction == "ORCHESTRATOR":
             self._handle_orchestrator(parts)
         elif instruction == "SECRET":
@@ -332,10 +2092,21 @@ class AgentfileParser:
         elif instruction == "EXPOSE":
             self._handle_expose(parts)
             self._handle_dockerfile_instruction(instruction, parts)
-        elif instruction == "CMD":
-            self._handle_cmd(parts)
-            # Store the CMD instruction with the correctly parsed args
-            dockerfile_instruction = DockerfileInstruction(instruction="CMD", args=self.config.cmd)
+        elif instruction == "VOLUME":
+            self._handle_volume(parts)
+            self._handle_dockerfile_instruction(instruction, parts)
+        elif instruction == "HEALTHCHECK":
+            self._handle_healthcheck(parts)
+            self._handle_dockerfile_instruction(instruction, parts)
+        elif instruction == "LABEL":
+            self._handle_label(parts)
+            self._handle_dockerfile_instruction(instruction, parts)
+        elif instruction in ["CMD", "ENTRYPOINT"]:
+            args = self._handle_cmd(parts) if instruction == "CMD" else self._handle_entrypoint(parts)
+            # Store the instruction with the correctly parsed args, so it is written in exec form
+            dockerfile_instruction = DockerfileInstruction(
+                instruction=instruction, args=args, line=self.current_line, stage=self._stage
+            )
             self.config.dockerfile_instructions.append(dockerfile_instruction)
         elif instruction == "RUN":
             self._handle_dockerfile_instruction(instruction, parts)
@@ -345,15 +2116,11 @@ class AgentfileParser:
             "ARG",
             "ADD",
             "COPY",
-            "ENTRYPOINT",
-            "HEALTHCHECK",
-            "LABEL",
             "MAINTAINER",
             "ONBUILD",
             "SHELL",
             "STOPSIGNAL",
             "USER",
-            "VOLUME",
             "WORKDIR",
             # BuildKit instructions
             "MOUNT",
@@ -361,24 +2128,7 @@ class AgentfileParser:
         ]:
             self._handle_dockerfile_instruction(instruction, parts)
         # Sub-instructions for contexts
-        elif instruction in [
-            "COMMAND",
-            "ARGS",
-            "INSTRUCTION",
-            "SERVERS",
-            "AGENTS",
-            "SEQUENCE",
-            "TRANSPORT",
-            "URL",
-            "USE_HISTORY",
-            "HUMAN_INPUT",
-            "PLAN_TYPE",
-            "PLAN_ITERATIONS",
-            "CUMULATIVE",
-            "API_KEY",
-            "BASE_URL",
-            "DEFAULT",
-        ]:
+        elif instruction in SUB_INSTRUCTIONS:
             self._handle_sub_instruction(instruction, parts)
         # Handle ENV - could be Dockerfile instruction or sub-instruction
         elif instruction == "ENV":
@@ -390,23 +2140,155 @@ class AgentfileParser:
                 self._handle_dockerfile_instruction(instruction, parts)
         else:
             # Unknown instruction - treat as potential Dockerfile instruction
-            # for forward compatibility
+            # for forward compatibility, but inside a block it is more likely a misspelt sub-instruction
+            if self.current_context in CONTEXT_SUB_INSTRUCTIONS:
+                self._warn(
+                    "unknown-sub-instruction",
+                    f"{self._unsupported_message(instruction)}; it is passed to the Dockerfile and closes the block",
+                )
             self._handle_dockerfile_instruction(instruction, parts)
 
+    def _expand_placeholders(self, part: str) -> str:
+        """Replace build arg and environment placeholders in a value; single-quoted values are left as written.
+
+        A bare ${NAME} is only a placeholder when NAME was passed as a build arg or declared
+        by an earlier ARG, so references to runtime environment variables pass through unchanged.
+        """
+        if part.startswith("'"):
+            return part
+
+        def replace(match):
+            env_name, name, default = match.groups()
+            if env_name is not None:
+                return self._lookup_env(env_name, match.group(0))
+            if name is None:
+                return "$"
+            if default is None and name not in self.build_args and name not in self.declared_args:
+                return match.group(0)
+            value = self.build_args.get(name, self.declared_args.get(name))
+            # As in Docker, :- also replaces a value that is set but empty
+            if not value and default is not None:
+                value = default
+            if value is None:
+                raise ValueError(
+                    f"Build arg {name} has no value; pass --build-arg {name}=<value> or declare ARG {name}=<default>"
+                )
+            return value
+
+        return PLACEHOLDER_PATTERN.sub(replace, part)
+
+    def _lookup_env(self, name: str, placeholder: str) -> str:
+        """Return the value of an ${env:NAME} placeholder, or leave it as written when lookups are off."""
+        if self.env_lookup is None:
+            self.diagnostics.append(
+                Diagnostic(
+                    SEVERITY_WARNING,
+                    "env-lookup-disabled",
+                    f"{placeholder} is kept as written; enable environment lookups (--env-lookup) to read {name}",
+                    self.current_line,
+                )
+            )
+            return placeholder
+        value = self.env_lookup(name)
+        if value is None:
+            raise ValueError(f"Environment variable {name} is not set, but {placeholder} reads it")
+        return value
+
     def _split_respecting_quotes(self, line: str) -> List[str]:
         """Split line by whitespace but respect quoted strings."""
+        return self._split_with_columns(line)[0]
+
+    def _split_with_columns(self, line: str, strip_comments: bool = False, unescape: bool = False) -> tuple:
+        """Split line like _split_respecting_quotes, also returning the 1-based column each part starts at."""
+        parts, columns, _ = self._tokenize(line, strip_comments, unescape)
+        return parts, columns
+
+    def _tokenize(self, line: str, strip_comments: bool = False, unescape: bool = False) -> tuple:
+        """Return the parts of a line, the 1-based column each starts at, and the index where they end.
+
+        With strip_comments, an unquoted # that starts a part ends the line, so trailing
+        comments are dropped while a # inside quotes or inside a word is kept.
+
+        With unescape, the escape character inside single or double quotes introduces one of the
+        QUOTED_ESCAPES, which the part holds unescaped. JSON arrays keep their escapes for the JSON
+        decoder, and triple-quoted values stay raw. A quote left open is then an error at the column
+        of the opening quote, instead of a part that runs to the end of the line.
+        """
+        if '"' not in line and "'" not in line:
+            # Without quotes the parts are just the words, which keeps very long lines fast
+            words = list(WORD_PATTERN.finditer(line))
+            end = len(line)
+            if strip_comments:
+                comment = next((word for word in words if word.group().startswith("#")), None)
+                if comment is not None:
+                    end = comment.start()
+                    words = words[: words.index(comment)]
+            return [word.group() for word in words], [word.start() + 1 for word in words], end
+
         parts = []
+        columns = []
         current = ""
         in_quotes = False
         quote_char = None
+        quote_start = 0
+        in_array = False
+        escape = self.config.escape_char
+        parts_end = len(line)
 
         i = 0
         while i < len(line):
+            if in_quotes:
+                # Copy everything up to the next character that closes the quote or starts an escape
+                stop = self._quote_stop(line, i, quote_char, escape if unescape else None)
+                current += line[i:stop]
+                i = stop
+                if i == len(line):
+                    break
             char = line[i]
 
-            if not in_quotes and char in ['"', "'"]:
+            # A triple-quoted value is one part, whatever quotes or whitespace it contains
+            end = line.find(TRIPLE_QUOTE, i + 3) if not in_quotes and line.startswith(TRIPLE_QUOTE, i) else -1
+            if end != -1:
+                if not current:
+                    columns.append(i + 1)
+                end += 3
+                while line.startswith('"', end):  # The value may end in a quote, as in """say "hi""""
+                    end += 1
+                current += line[i:end]
+                i = end
+                continue
+
+            if strip_comments and not current and char == "#":
+                parts_end = i
+                break
+            if not current and not char.isspace():
+                columns.append(i + 1)
+            if not in_quotes and char == escape and line[i + 1 : i + 2] in ['"', "'"]:
+                # An escaped quote outside a quoted value is a literal quote, as in return \"done\"
+                current += line[i + 1] if unescape else line[i : i + 2]
+                i += 2
+                continue
+            if unescape and in_quotes and char == escape:
+                sequence = line[i + 1 : i + 2]
+                if in_array:
+                    current += char + sequence
+                elif sequence == escape or sequence in QUOTED_ESCAPES:
+                    current += QUOTED_ESCAPES.get(sequence, escape)
+                else:
+                    raise ValueError(
+                        f"Invalid escape sequence {escape}{sequence} in a quoted value; "
+                        f"write {escape}{escape} for a literal {escape}"
+                    )
+                i += 2
+                continue
+            if unescape and not in_quotes and char == "[" and not current and len(parts) == 1:
+                in_array = True
+            elif not in_quotes and char == "]":
+                in_array = False
+            if not in_quotes and self._quote_opens(line, i):
                 in_quotes = True
                 quote_char = char
+                quote_start = i
                 current += char
             elif in_quotes and char == quote_char:
                 in_quotes = False
@@ -420,22 +2302,131 @@ class AgentfileParser:
                 current += char
             i += 1
 
+        if in_quotes and unescape:
+            column = quote_start + 1 + self.current_indent
+            raise AgentfileError(
+                f"Unterminated quoted string starting at column {column}",
+                file=self._current_file(),
+                line=self.current_line,
+                column=column,
+                instruction=line.split(None, 1)[0].upper(),
+                source=line,
+            )
         if current:
             parts.append(current)
 
-        return parts
+        return parts, columns, parts_end
+
+    def _parse_exec_form(self, text: str) -> List[str]:
+        """Parse a JSON-array value like CMD ["python", "agent.py"].
+
+        Falls back to a tolerant split on commas outside quotes when the text
+        is not strict JSON, such as single-quoted elements. An array that not
+        even the tolerant split can read, with a quote left open or an empty
+        element, is an error showing the text.
+        """
+        try:
+            value = json.loads(text)
+            if isinstance(value, list) and all(isinstance(item, str) for item in value):
+                return value
+        except json.JSONDecodeError:
+            pass
+
+        items = []
+        current = ""
+        quote_char = None
+        for char in text.strip()[1:-1]:
+            if quote_char is None and char in ['"', "'"]:
+                quote_char = char
+            elif quote_char is not None and char == quote_char:
+                quote_char = None
+            elif quote_char is None and char == ",":
+                items.append(current.strip())
+                current = ""
+                continue
+            current += char
+        if current.strip() or items:
+            items.append(current.strip())
+        if quote_char is not None or "" in items:
+            raise self._error(f"Malformed JSON array: {text}", 1)
+        return [self._unquote(item) for item in items]
+
+    def _parse_list(self, instruction: str, parts: List[str], comma_separated: bool = False) -> List[str]:
+        """Parse a list sub-instruction in either plain or JSON-array form.
+
+        Name lists set comma_separated so that `SERVERS fetch, github` and
+        `SERVERS fetch github` are equivalent; empty elements are dropped.
+        """
+        remainder = ' '.join(parts[1:])
+        if parts[1].startswith('[') and not parts[-1].endswith(']'):
+            raise self._error(f"Malformed JSON array for {instruction}, missing the closing ]: {remainder}", 1)
+        if parts[1].startswith('['):
+            form = "array"
+            values = self._parse_exec_form(remainder)
+        else:
+            form = "plain"
+            values = [self._unquote(part) for part in parts[1:]]
+            if comma_separated:
+                values = [name.strip() for value in values for name in value.split(',') if name.strip()]
+
+        key = (self.current_context, self.current_item, instruction)
+        previous_form = self._list_forms.get(key)
+        if previous_form is not None and previous_form != form:
+            self.diagnostics.append(
+                Diagnostic(
+                    SEVERITY_INFO,
+                    "mixed-list-form",
+                    f"{instruction} for {self.current_context} {self.current_item} mixes array and plain forms; "
+                    "the last one wins",
+                    self.current_line,
+                )
+            )
+        self._list_forms[key] = form
+        self._list_positions[key] = (self.current_line, self.current_text)
+        return values
 
     def _unquote(self, s: str) -> str:
         """Remove quotes from a string if present."""
+        if len(s) >= 6 and s.startswith(TRIPLE_QUOTE) and s.endswith(TRIPLE_QUOTE):
+            return s[3:-3]
         if len(s) >= 2 and s[0] == s[-1] and s[0] in ['"', "'"]:
             return s[1:-1]
         return s
 
+    @property
+    def _stage(self) -> int:
+        """Return the index of the build stage being parsed."""
+        return max(len(self.config.stages) - 1, 0)
+
     def _handle_from(self, parts: List[str]):
-        """Handle FROM instruction."""
-        if len(parts) < 2:
+        """Handle FROM instruction, which starts a build stage: FROM [--platform=...] image [AS name]."""
+        args = [part for part in parts[1:] if not part.startswith("--")]
+        if not args:
             raise ValueError("FROM requires a base image")
-        self.config.base_image = self._unquote(parts[1])
+        if len(args) not in [1, 3] or (len(args) == 3 and args[1].upper() != "AS"):
+            raise self._error("FROM takes an image and an optional AS name, as in FROM node:20 AS builder", 1)
+        name = args[2].lower() if len(args) == 3 else None
+        stage = BuildStage(image=self._unquote(args[0]), name=name, line=self.current_line)
+        for index, existing in enumerate(self.config.stages):
+            if stage.name and existing.name == stage.name:
+                raise self._error(
+                    f"Build stage {stage.name} is already declared on line {existing.line}", len(parts) - 1
+                )
+            if existing.name == AGENT_STAGE and index == len(self.config.stages) - 1:
+                self._agent_stage_closed = True
+        if self.config.stages and not self._agent_stage_closed:
+            # The previous stage only builds artifacts, so its CMD, EXPOSE and ENV do not describe the agent image
+            self.config.cmd = list(DEFAULT_CMD)
+            self.config.expose_ports = []
+            self.config.volumes = []
+            self.config.healthcheck = None
+            self.config.entrypoint = []
+            self.config.labels = {}
+            self._shell_forms = {}
+            self.config.image_env = {}
+        self.config.stages.append(stage)
+        if not self._agent_stage_closed:
+            self.config.base_image = stage.image
         self.current_context = None
 
     def _handle_model(self, parts: List[str]):
@@ -445,60 +2436,520 @@ class AgentfileParser:
         self.config.default_model = self._unquote(parts[1])
         self.current_context = None
 
+    def _parse_model_setting(self, instruction: str, parts: List[str]) -> Union[float, int, List[str]]:
+        """Return the value of one of the MODEL_SETTINGS instructions."""
+        if instruction == "STOP":
+            if len(parts) < 2:
+                raise ValueError("STOP requires at least one stop sequence")
+            sequences = self._parse_list(instruction, parts)
+            if not all(sequences):
+                raise self._error("STOP sequences cannot be empty", 1)
+            return sequences
+        if instruction in MODEL_SETTING_RANGES:
+            return self._parse_number_in_range(instruction, parts, *MODEL_SETTING_RANGES[instruction])
+        if instruction == "REQUEST_TIMEOUT":
+            return self._parse_timeout(instruction, parts)
+        if instruction == "MAX_RETRIES":
+            return self._parse_retries(parts)
+        return self._parse_positive_int(instruction, parts)
+
+    def _parse_auth(self, parts: List[str]) -> ServerAuth:
+        """Parse AUTH bearer $SECRET or AUTH basic user:$SECRET."""
+        if len(parts) != 3:
+            raise ValueError("AUTH requires a type and credentials: AUTH bearer $SECRET or AUTH basic user:$SECRET")
+        auth_type = self._unquote(parts[1]).lower()
+        if auth_type not in AUTH_TYPES:
+            raise self._error(f"Invalid AUTH type: {auth_type}. Supported: {', '.join(AUTH_TYPES)}", 1)
+        credentials = self._unquote(parts[2])
+        user = None
+        if auth_type == "basic":
+            user, sep, credentials = credentials.partition(":")
+            if not sep or not user:
+                raise self._error("AUTH basic requires user:$SECRET", 2)
+        secret = env_reference(credentials)
+        if not secret:
+            raise self._error(
+                f"AUTH {auth_type} takes a $SECRET reference, not the credentials themselves; "
+                "declare them with SECRET and write $NAME",
+                2,
+            )
+        return ServerAuth(type=auth_type, secret=secret, user=user)
+
+    def _parse_timeout(self, instruction: str, parts: List[str]) -> int:
+        """Return the seconds of a timeout instruction, a duration of at least one second."""
+        if len(parts) < 2:
+            raise ValueError(f"{instruction} requires a duration such as 120s")
+        seconds = parse_duration(self._unquote(parts[1]))
+        if seconds < 1:
+            raise self._error(f"{instruction} must be at least 1s, got {self._unquote(parts[1])}", 1)
+        return seconds
+
+    def _parse_retries(self, parts: List[str]) -> int:
+        """Return the value of a MAX_RETRIES instruction, from 0 to MAX_RETRIES_LIMIT."""
+        if len(parts) < 2:
+            raise ValueError("MAX_RETRIES requires a number of retries")
+        value = self._unquote(parts[1])
+        try:
+            retries = int(value)
+        except ValueError as exc:
+            raise self._error(f"Invalid number for MAX_RETRIES: {value}", 1) from exc
+        if not 0 <= retries <= MAX_RETRIES_LIMIT:
+            raise self._error(f"MAX_RETRIES must be between 0 and {MAX_RETRIES_LIMIT}, got {retries}", 1)
+        return retries
+
+    def _parse_positive_int(self, instruction: str, parts: List[str]) -> int:
+        """Return the value of an instruction that takes a whole number of at least 1."""
+        if len(parts) < 2:
+            raise ValueError(f"{instruction} requires a whole number")
+        value = self._unquote(parts[1])
+        try:
+            number = int(value)
+        except ValueError as exc:
+            raise self._error(f"Invalid number for {instruction}: {value}", 1) from exc
+        if number < 1:
+            raise self._error(f"{instruction} must be at least 1, got {number}", 1)
+        return number
+
+    def _parse_number_in_range(self, instruction: str, parts: List[str], low: float, high: float) -> float:
+        """Return the value of an instruction that takes a number from low to high."""
+        if len(parts) < 2:
+            raise ValueError(f"{instruction} requires a number between {low:g} and {high:g}")
+        value = self._unquote(parts[1])
+        try:
+            number = float(value)
+        except ValueError as exc:
+            raise self._error(f"Invalid number for {instruction}: {value}", 1) from exc
+        if not low <= number <= high:
+            raise self._error(f"{instruction} must be between {low:g} and {high:g}, got {value}", 1)
+        return number
+
     def _handle_framework(self, parts: List[str]):
         """Handle FRAMEWORK instruction."""
         if len(parts) < 2:
             raise ValueError("FRAMEWORK requires a framework name")
         framework = self._unquote(parts[1]).lower()
-        if framework not in ["fast-agent", "agno"]:
-            raise ValueError(f"Unsupported framework: {framework}. Supported: fast-agent, agno")
+        if framework not in FRAMEWORKS:
+            raise self._error(f"Unsupported framework: {framework}. Supported: {', '.join(FRAMEWORKS)}", 1)
         self.config.framework = framework
         self.current_context = None
 
+    def _handle_human_input_mode(self, parts: List[str]):
+        """Handle HUMAN_INPUT_MODE console, webhook URL or disabled."""
+        if len(parts) < 2:
+            raise ValueError(f"HUMAN_INPUT_MODE requires a mode. Supported: {', '.join(HUMAN_INPUT_MODES)}")
+        mode = self._unquote(parts[1]).lower()
+        if mode not in HUMAN_INPUT_MODES:
+            raise self._error(f"Unsupported HUMAN_INPUT_MODE: {mode}. Supported: {', '.join(HUMAN_INPUT_MODES)}", 1)
+        if mode == "webhook":
+            if len(parts) != 3:
+                raise ValueError("HUMAN_INPUT_MODE webhook requires the URL to post questions to")
+            url = self._unquote(parts[2])
+            if not BASE_URL_PATTERN.match(url):
+                raise self._error(f"Invalid HUMAN_INPUT_MODE webhook URL: {url}. Use an http(s):// URL", 2)
+            self.config.human_input_webhook = url
+        elif len(parts) > 2:
+            raise self._error(f"HUMAN_INPUT_MODE {mode} takes no URL", 2)
+        else:
+            self.config.human_input_webhook = None
+        self.config.human_input_mode = mode
+        self.current_context = None
+
+    def _handle_serve(self, parts: List[str]):
+        """Handle SERVE protocol port [path]."""
+        protocols = ", ".join(SERVE_PROTOCOLS)
+        if len(parts) not in [3, 4]:
+            raise ValueError(f"SERVE requires a protocol and a port, such as SERVE mcp 8080. Protocols: {protocols}")
+        protocol = self._unquote(parts[1]).lower()
+        if protocol not in SERVE_PROTOCOLS:
+            raise self._error(f"Unsupported SERVE protocol: {protocol}. Supported: {protocols}", 1)
+        try:
+            port = int(self._unquote(parts[2]))
+        except ValueError as exc:
+            raise self._error(f"Invalid port number: {parts[2]}", 2) from exc
+        if not 0 < port <= MAX_PORT:
+            raise self._error(f"SERVE {port} is not a port number between 1 and {MAX_PORT}", 2)
+        path = self._unquote(parts[3]) if len(parts) == 4 else None
+        if path is not None and not path.startswith("/"):
+            raise self._error(f"SERVE path must start with /, got {path}", 3)
+        self.config.serve = Serve(protocol=protocol, port=port, path=path, line=self.current_line)
+        self.current_context = None
+
+    def _handle_shutdown_grace(self, parts: List[str]):
+        """Handle SHUTDOWN_GRACE instruction."""
+        if len(parts) < 2:
+            raise ValueError("SHUTDOWN_GRACE requires a duration such as 20s")
+        self.config.shutdown_grace = parse_duration(self._unquote(parts[1]))
+        self.current_context = None
+
+    def _handle_cmd_mode(self, parts: List[str]):
+        """Handle CMD_MODE instruction."""
+        if len(parts) < 2:
+            raise ValueError(f"CMD_MODE requires a mode. Supported: {', '.join(CMD_MODES)}")
+        mode = self._unquote(parts[1]).lower()
+        if mode not in CMD_MODES:
+            raise self._error(f"Unsupported CMD_MODE: {mode}. Supported: {', '.join(CMD_MODES)}", 1)
+        self.config.cmd_mode = mode
+        self.current_context = None
+
+    def _handle_end(self, parts: List[str]):
+        """Handle END instruction by closing the open block, so what follows is top-level."""
+        if len(parts) > 1:
+            raise self._error("END takes no arguments", 1)
+        if self.current_context is None:
+            raise ValueError(
+                "END without an open MCP_SERVER, AGENT, ROUTER, CHAIN, PARALLEL, ORCHESTRATOR or SECRET block"
+            )
+        self.current_context = None
+        self.current_item = None
+        self._closed_context = None
+
+    def _parse_overrides(self):
+        """Parse the override lines after the Agentfile, so they replace what it declares as an INCLUDE would."""
+        self.current_context = None
+        self._include_stack.append(OVERRIDES_SOURCE)
+        try:
+            self._parse_body(list(self.overrides), 0)
+        except AgentfileError as e:
+            e.include_context(f"In {OVERRIDES_SOURCE}: ")
+            raise
+        finally:
+            self._include_stack.pop()
+        self.current_context = None
+
+    def _origin(self) -> str:
+        """Return the file being parsed as render names it, relative to the Agentfile's directory when local."""
+        current = self._current_file()
+        if self._body == 1:
+            return self.config.source_name
+        if current == OVERRIDES_SOURCE or URL_SCHEME_PATTERN.match(current) or URL_SCHEME_PATTERN.match(self._root_dir):
+            return current
+        return os.path.relpath(current, self._root_dir or os.curdir)
+
+    def _local_path(self, keyword: str, target: str, index: int) -> str:
+        """Return the path a file argument names, relative to the file the instruction is in, which must be local."""
+        if URL_SCHEME_PATTERN.match(target):
+            raise self._error(f"{keyword} {target} is a URL; {keyword} reads local files only", index)
+        if URL_SCHEME_PATTERN.match(self.base_dir):
+            raise self._error(
+                f"{keyword} {target} is relative to the fetched file {self.base_dir}; {keyword} reads local files only",
+                index,
+            )
+        return os.path.normpath(os.path.join(self.base_dir, target))
+
+    def _handle_include(self, parts: List[str]):
+        """Handle INCLUDE instruction by parsing the referenced file in place.
+
+        Definitions in the included file override earlier ones with the same name,
+        and later ones override them in turn. The target is a path or an HTTPS URL; relative targets
+        of a fetched file are fetched from next to it. A sha256:<digest> after the target pins its content.
+        """
+        if len(parts) not in [2, 3] or (len(parts) == 3 and not parts[2].lower().startswith("sha256:")):
+            raise ValueError("INCLUDE requires one file path or URL, optionally followed by sha256:<digest>")
+        target = self._unquote(parts[1])
+        if URL_SCHEME_PATTERN.match(target) and not is_remote(target):
+            raise self._error(f"INCLUDE {target} is not a file path or an HTTPS URL; other URL schemes are not read", 1)
+        path = include_path(self.base_dir, target)
+        if path in self._include_stack:
+            cycle = self._include_stack[self._include_stack.index(path) :] + [path]
+            raise self._error(f"Cyclic INCLUDE: {' -> '.join(cycle)}", 1)
+        sha256 = parts[2][len("sha256:") :] if len(parts) == 3 else None
+        try:
+            if is_remote(path):
+                fetched = fetch_agentfile(path, sha256, self.insecure_http, fetch=self.fetch)
+                content = fetched.read_text(encoding="utf-8")
+            else:
+                content = self._included[path] if path in self._included else self.resolver(path)
+                verify_digest(content.encode("utf-8"), sha256, path)
+        except OSError as e:
+            raise self._error(f"Cannot read included file {path}: {e.strerror or e}", 1) from e
+        except ValueError as e:
+            raise self._error(str(e), len(parts) - 1) from e
+
+        include_line = self.current_line
+        first_diagnostic = len(self.diagnostics)
+        saved = (self.base_dir, self.current_text, self.current_raw, self.current_heredocs)
+        self.base_dir = include_base(path)
+        self._include_stack.append(path)
+        self.current_context = None
+        try:
+            self._parse_body(content.split('\n'), 0)
+        except AgentfileError as e:
+            e.include_context(f"In {path}: ")
+            raise
+        finally:
+            self._include_stack.pop()
+            self.base_dir, self.current_text, self.current_raw, self.current_heredocs = saved
+            self.current_line = include_line
+        # Point warnings from the included file at the INCLUDE line, naming where they came from
+        for diagnostic in self.diagnostics[first_diagnostic:]:
+            if diagnostic.line is not None:
+                diagnostic.message = f"{path}:{diagnostic.line}: {diagnostic.message}"
+            diagnostic.line, diagnostic.column = include_line, None
+        self.current_context = None
+
+    def _handle_env_file(self, parts: List[str]):
+        """Handle ENV_FILE [--optional] path, loading a dotenv file into the server or every server.
+
+        Inside an MCP_SERVER block the variables go to that server, elsewhere to every server; values
+        the server sets itself win. The path is relative to the file the instruction is in.
+        """
+        optional = "--optional" in parts[1:]
+        paths = [part for part in parts[1:] if part != "--optional"]
+        if len(paths) != 1 or paths[0].startswith("--"):
+            raise ValueError("ENV_FILE requires exactly one file path, optionally after --optional")
+        path = self._local_path("ENV_FILE", self._unquote(paths[0]), parts.index(paths[0]))
+        try:
+            content = self.resolver(path)
+        except OSError as e:
+            if optional:
+                return
+            raise self._error(f"Cannot read ENV_FILE {path}: {e.strerror or e}", parts.index(paths[0])) from e
+        try:
+            variables = parse_dotenv(content)
+        except ValueError as e:
+            raise self._error(f"{path}: {e}", parts.index(paths[0])) from e
+
+        if self.current_context == "server":
+            self.config.servers[self.current_item].env.update(variables)
+        else:
+            self.config.env.update(variables)
+            self.current_context = None
+
+    def _handle_import_mcp(self, parts: List[str]):
+        """Handle IMPORT_MCP path, adding the MCP servers of a Claude Desktop config or an mcp.json file.
+
+        The path is relative to the file the instruction is in. A server whose name is already declared
+        is an error unless the parser allows overrides, in which case the later definition wins. The
+        inputs of an mcp.json become SECRETs, unless already declared.
+        """
+        if len(parts) != 2:
+            raise ValueError("IMPORT_MCP requires exactly one file path")
+        path = self._local_path("IMPORT_MCP", self._unquote(parts[1]), 1)
+        try:
+            data = json.loads(self.resolver(path))
+            if isinstance(data, dict) and "servers" in data:
+                servers, secrets = import_mcp_json(data, path)
+            else:
+                servers, secrets = import_claude_config(data, path), []
+        except OSError as e:
+            raise self._error(f"Cannot read IMPORT_MCP file {path}: {e.strerror or e}", 1) from e
+        except json.JSONDecodeError as e:
+            raise self._error(f"{path}: invalid JSON: {e}", 1) from e
+        except ValueError as e:
+            raise self._error(str(e), 1) from e
+
+        for name, server in servers.items():
+            existing = self.config.servers.get(name)
+            if existing is not None and not self.allow_override:
+                raise self._error(
+                    f"IMPORT_MCP {path} defines server {name}, which is already declared on line {existing.line}; "
+                    "rename one or pass --allow-override",
+                    1,
+                )
+            server.line = self.current_line
+            self.config.servers[name] = server
+            self._imported_servers[name] = (path, self.current_line)
+        declared = self.config.secret_names()
+        for secret in secrets:
+            if secret.name not in declared:
+                secret.line = self.current_line
+                self.config.secrets.append(secret)
+        self.current_context = None
+
+    def _handle_prompt(self, parts: List[str]):
+        """Handle PROMPT name text, declaring a text agents and workflows can use in their INSTRUCTION."""
+        if len(parts) < 2:
+            raise ValueError("PROMPT requires a prompt name")
+        name = self._unquote(parts[1])
+        if not PROMPT_NAME_PATTERN.match(name):
+            raise self._error(f"Invalid PROMPT name {name}; use letters, digits, _ and -", 1)
+        text = self.current_heredocs[0] if self.current_heredocs else self._unquote(' '.join(parts[2:]))
+        if not text.strip():
+            raise self._error(f"PROMPT {name} requires text, on the same line or as a heredoc", 1)
+        self._declare("prompt", "PROMPT", name)
+        self.config.prompts[name] = text
+        self.current_context = None
+
+    def _apply_server_registry(self):
+        """Fill in the launch settings of servers declared with no COMMAND or URL from the server registry.
+
+        Whatever the block or a top-level ENV_FILE sets wins; the variables the server reads are otherwise
+        passed as ${NAME} references.
+        """
+        for server in self.config.servers.values():
+            entry = self.server_registry.get(server.name)
+            if entry is None or server.command or server.url or server.transport != "stdio":
+                continue
+            server.transport = entry.get("transport", server.transport)
+            server.command = entry.get("command")
+            server.url = entry.get("url")
+            server.args = server.args or list(entry.get("args", []))
+            server.env = {**{name: f"${{{name}}}" for name in entry.get("env", [])}, **server.env}
+
+    def _apply_serve(self):
+        """Expose the SERVE port."""
+        serve = self.config.serve
+        if serve and serve.port not in self.config.expose_ports:
+            self.config.expose_ports.append(serve.port)
+
+    def _apply_default_cmd(self):
+        """Give an Agentfile without a CMD the parser's default one, or else the one its framework and SERVE need."""
+        if any(inst.instruction == "CMD" for inst in self.config.agent_instructions):
+            return
+        self.config.cmd = list(self.default_cmd) if self.default_cmd else self.config.generated_cmd()
+
+    def _apply_global_env(self):
+        """Give every MCP server the top-level ENV_FILE variables it does not set itself."""
+        for server in self.config.servers.values():
+            server.env = {**self.config.env, **server.env}
+
     def _handle_server(self, parts: List[str]):
         """Handle SERVER instruction."""
         if len(parts) < 2:
             raise ValueError("SERVER requires a server name")
         name = self._unquote(parts[1])
-        self.config.servers[name] = MCPServer(name=name)
+        keyword = parts[0].upper()
+        if keyword == "SERVER":
+            if not self.allow_server_alias:
+                raise self._error(f"SERVER is not allowed; declare MCP_SERVER {name} or run `agentman migrate`", 0)
+            self._warn(
+                "server-alias",
+                f"SERVER {name} uses the deprecated SERVER keyword; declare MCP_SERVER {name} "
+                "or run `agentman migrate --write`",
+            )
+        if name in self._imported_servers and not self.allow_override:
+            path, line = self._imported_servers[name]
+            raise self._error(
+                f"MCP_SERVER {name} is already imported from {path} by IMPORT_MCP on line {line}; "
+                "rename one or pass --allow-override",
+                1,
+            )
+        self._declare("server", "MCP_SERVER", name)
+        server = MCPServer(name=name, line=self.current_line, keyword=keyword)
+        self.config.servers[name] = server
         self.current_context = "server"
         self.current_item = name
+        attributes = parts[2:]
+        shorthand = SERVER_SHORTHAND_PATTERN.match(self._unquote(attributes[0])) if attributes else None
+        if shorthand:
+            self._apply_server_shorthand(server, shorthand.group(1).lower(), shorthand.group(2))
+            attributes = attributes[1:]
+        self._apply_inline_attributes(attributes)
+
+    def _apply_server_shorthand(self, server: MCPServer, scheme: str, target: str):
+        """Expand npx:package, uvx:package, sse:url or http:url on a server declaration.
+
+        Inline attributes and sub-instructions that follow override what it sets.
+        """
+        schemes = list(SERVER_SHORTHAND_LAUNCHERS) + SERVER_SHORTHAND_TRANSPORTS
+        if scheme not in schemes:
+            raise self._error(f"Unknown server shorthand {scheme}:. Supported: {', '.join(schemes)}", 2)
+        if not target:
+            raise self._error(f"Server shorthand {scheme}: requires a package or URL after the colon", 2)
+        if scheme in SERVER_SHORTHAND_LAUNCHERS:
+            server.transport = "stdio"
+            server.command = scheme
+            server.args = SERVER_SHORTHAND_LAUNCHERS[scheme] + [target]
+        else:
+            server.transport = scheme
+            server.url = target
 
     def _handle_agent(self, parts: List[str]):
         """Handle AGENT instruction."""
         if len(parts) < 2:
             raise ValueError("AGENT requires an agent name")
         name = self._unquote(parts[1])
-        self.config.agents[name] = Agent(name=name)
+        self._declare("entity", "AGENT", name)
+        self.config.agents[name] = Agent(name=name, line=self.current_line)
         self.current_context = "agent"
         self.current_item = name
+        self._apply_inline_attributes(parts[2:])
 
     def _handle_router(self, parts: List[str]):
         """Handle ROUTER instruction."""
         if len(parts) < 2:
             raise ValueError("ROUTER requires a router name")
         name = self._unquote(parts[1])
-        self.config.routers[name] = Router(name=name)
+        self._declare("entity", "ROUTER", name)
+        self.config.routers[name] = Router(name=name, line=self.current_line)
         self.current_context = "router"
         self.current_item = name
+        self._apply_inline_attributes(parts[2:])
 
     def _handle_chain(self, parts: List[str]):
         """Handle CHAIN instruction."""
         if len(parts) < 2:
             raise ValueError("CHAIN requires a chain name")
         name = self._unquote(parts[1])
-        self.config.chains[name] = Chain(name=name)
+        self._declare("entity", "CHAIN", name)
+        self.config.chains[name] = Chain(name=name, line=self.current_line)
         self.current_context = "chain"
         self.current_item = name
+        self._apply_inline_attributes(parts[2:])
+
+    def _handle_parallel(self, parts: List[str]):
+        """Handle PARALLEL instruction."""
+        if len(parts) < 2:
+            raise ValueError("PARALLEL requires a parallel name")
+        name = self._unquote(parts[1])
+        self._declare("entity", "PARALLEL", name)
+        self.config.parallels[name] = Parallel(name=name, line=self.current_line)
+        self.current_context = "parallel"
+        self.current_item = name
+        self._apply_inline_attributes(parts[2:])
 
     def _handle_orchestrator(self, parts: List[str]):
         """Handle ORCHESTRATOR instruction."""
         if len(parts) < 2:
             raise ValueError("ORCHESTRATOR requires an orchestrator name")
         name = self._unquote(parts[1])
-        self.config.orchestrators[name] = Orchestrator(name=name)
+        self._declare("entity", "ORCHESTRATOR", name)
+        self.config.orchestrators[name] = Orchestrator(name=name, line=self.current_line)
         self.current_context = "orchestrator"
         self.current_item = name
+        self._apply_inline_attributes(parts[2:])
+
+    def _declare(self, namespace: str, keyword: str, name: str):
+        """Record a declaration, rejecting a name declared twice in one file or shared by two kinds.
+
+        Agents and workflows share one namespace, since SEQUENCE and AGENTS can name either.
+        Redeclaring a name of the same kind in another file, such as after an INCLUDE, overrides it.
+        """
+        current_file = self._current_file()
+        previous = self._declarations.get((namespace, name))
+        if previous is not None:
+            previous_keyword, previous_file, previous_line, previous_body = previous
+            where = f"line {previous_line}"
+            if previous_file != current_file:
+                where = f"{previous_file} {where}"
+            if previous_keyword != keyword:
+                raise self._error(f"{keyword} {name} reuses the name of {previous_keyword} {name} on {where}", 1)
+            if previous_body == self._body:
+                raise self._error(f"{keyword} {name} is already declared on {where}", 1)
+        self._declarations[(namespace, name)] = (keyword, current_file, self.current_line, self._body)
+        self.config.declared_in[(keyword, name)] = self._origin()
+        self._instructed.discard((keyword, name))
+
+    def _apply_inline_attributes(self, attributes: List[str]):
+        """Apply key=value attributes from a declaration line through the sub-instruction handlers.
+
+        Sub-instruction lines that follow the declaration override these values.
+        """
+        valid = INLINE_ATTRIBUTES[self.current_context]
+        for attribute in attributes:
+            key, sep, value = attribute.partition('=')
+            if not sep or key.lower() not in valid:
+                raise ValueError(
+                    f"Unknown {self.current_context} attribute '{key}'. Valid attributes: {', '.join(valid)}"
+                )
+            if not value:
+                raise ValueError(f"Attribute '{key}' requires a value")
+            sub_instruction = key.upper()
+            if sub_instruction == "ARGS" and not value.startswith('['):
+                values = self._split_respecting_quotes(self._unquote(value))
+            else:
+                values = [value]
+            self._handle_sub_instruction(sub_instruction, [sub_instruction] + values)
 
     def _handle_secret(self, parts: List[str]):
         """Handle SECRET instruction.
@@ -506,18 +2957,30 @@ class AgentfileParser:
         Supports multiple formats:
         - SECRET ANTHROPIC_API_KEY (simple reference)
         - SECRET ANTHROPIC_API_KEY <<real_api_key>> (inline value)
+        - SECRET OPENAI_API_KEY=<<key>> ANTHROPIC_API_KEY=<<key>> (inline values, like ENV)
         - SECRET openai (context for multiple values)
         """
         if len(parts) < 2:
             raise ValueError("SECRET requires a secret name")
 
+        # NAME=value pairs, split on the first = so that values such as base64 keep theirs
+        if "=" in parts[1]:
+            for index, pair in enumerate(parts[1:], 1):
+                name, sep, value = pair.partition("=")
+                if not sep or not name:
+                    raise self._error(
+                        f"SECRET {pair} is not NAME=value; write every secret on the line that way", index
+                    )
+                self._add_secret_value(name, self._unquote(value), index)
+            self.current_context = None
+            return
+
         secret_name = self._unquote(parts[1])
 
         # Check if it's an inline value: SECRET KEY value
         if len(parts) >= 3:
             value = ' '.join(parts[2:])  # Join all remaining parts as the value
-            secret = SecretValue(name=secret_name, value=self._unquote(value))
-            self.config.secrets.append(secret)
+            self._add_secret_value(secret_name, self._unquote(value), 2)
             self.current_context = None
         # Check if it's a context (no value, will be populated with sub-instructions)
         elif len(parts) == 2:
@@ -539,13 +3002,31 @@ class AgentfileParser:
                 # Create a new secret context - this will be used if subsequent
                 # lines contain key-value pairs. If no key-value pairs follow,
                 # it will be treated as a simple reference
-                secret = SecretContext(name=secret_name)
+                secret = SecretContext(name=secret_name, line=self.current_line)
                 self.config.secrets.append(secret)
                 self.current_context = "secret"
                 self.current_item = secret_name
         else:
             raise ValueError("Invalid SECRET format. Use: SECRET NAME or SECRET NAME value")
 
+    def _add_secret_value(self, name: str, value: str, index: int):
+        """Record a SECRET with an inline value, the value starting at part index."""
+        # References and values read from the parsing environment were not typed into the file
+        if not env_reference(value) and "${env:" not in self.current_text:
+            self._warn(
+                "secret-inline-value",
+                f"SECRET {name} has an inline value, which is written into the generated files; "
+                f"declare SECRET {name} alone and pass the value at run time",
+                index,
+            )
+        # A later inline value replaces an earlier one, such as one from an INCLUDE
+        self.config.secrets = [
+            existing
+            for existing in self.config.secrets
+            if not (isinstance(existing, SecretValue) and existing.name == name)
+        ]
+        self.config.secrets.append(SecretValue(name=name, value=value))
+
     def _handle_secret_sub_instruction(self, instruction: str, parts: List[str]):
         """Handle sub-instructions for SECRET context (key-value pairs)."""
         if not self.current_item:
@@ -575,51 +3056,210 @@ class AgentfileParser:
             raise ValueError("EXPOSE requires a port number")
         try:
             port = int(parts[1])
-            if port not in self.config.expose_ports:
-                self.config.expose_ports.append(port)
         except ValueError as exc:
-            raise ValueError(f"Invalid port number: {parts[1]}") from exc
+            raise self._error(f"Invalid port number: {parts[1]}", 1) from exc
+        if not 0 < port <= MAX_PORT:
+            raise self._error(f"EXPOSE {port} is not a port number between 1 and {MAX_PORT}", 1)
+        if port in self.config.expose_ports:
+            self._warn("expose-duplicate", f"Port {port} is already exposed", 1)
+        elif not self._agent_stage_closed:
+            self.config.expose_ports.append(port)
         self.current_context = None
 
-    def _handle_cmd(self, parts: List[str]):
-        """Handle CMD instruction."""
+    def _handle_volume(self, parts: List[str]):
+        """Handle VOLUME instruction, in the JSON array form or as space-separated paths."""
         if len(parts) < 2:
-            raise ValueError("CMD requires at least one argument")
-        # Handle both array format and simple format
+            raise ValueError("VOLUME requires at least one path")
+        if parts[1].startswith('['):
+            paths = self._parse_exec_form(' '.join(parts[1:]))
+        else:
+            paths = [self._unquote(part) for part in parts[1:]]
+        for path in paths:
+            if path in self.config.volumes:
+                self._warn("volume-duplicate", f"Volume {path} is already declared", 1)
+            elif not self._agent_stage_closed:
+                self.config.volumes.append(path)
+
+    def _handle_label(self, parts: List[str]):
+        """Handle LABEL key=value ..., keeping each value as written so it is emitted unchanged."""
+        if len(parts) < 2:
+            raise ValueError("LABEL requires at least one key=value pair")
+        labels = {}
+        for index, part in enumerate(parts[1:], 1):
+            key, has_value, value = part.partition("=")
+            key = self._unquote(key)
+            if not has_value or not key:
+                raise self._error(f"LABEL needs key=value pairs, got {part}", index)
+            labels[key] = value
+        if not self._agent_stage_closed:
+            self.config.labels.update(labels)
+
+    def _handle_healthcheck(self, parts: List[str]):
+        """Handle HEALTHCHECK [--flag=value ...] CMD command, or HEALTHCHECK NONE."""
+        usage = "HEALTHCHECK takes NONE, or optional flags followed by CMD and a command"
+        healthcheck = Healthcheck(line=self.current_line)
+        if len(parts) != 2 or parts[1].upper() != "NONE":
+            index = 1
+            while index < len(parts) and parts[index].startswith("--"):
+                name, has_value, value = parts[index][2:].partition("=")
+                if name not in HEALTHCHECK_FLAGS:
+                    raise self._error(
+                        f"Unknown HEALTHCHECK flag '--{name}'. Valid flags: {', '.join(HEALTHCHECK_FLAGS)}", index
+                    )
+                if not has_value:
+                    raise self._error(f"HEALTHCHECK --{name} needs a value, as in --{name}=3", index)
+                if name == "retries":
+                    if not value.isdigit():
+                        raise self._error(f"HEALTHCHECK --retries must be a whole number, got {value}", index)
+                    healthcheck.retries = int(value)
+                else:
+                    setattr(healthcheck, name.replace("-", "_"), parse_duration(value))
+                index += 1
+            if index >= len(parts) - 1 or parts[index].upper() != "CMD":
+                raise self._error(usage, min(index, len(parts) - 1))
+            command = parts[index + 1 :]
+            if command[0].startswith("["):
+                healthcheck.command = self._parse_exec_form(" ".join(command))
+            else:
+                healthcheck.command, healthcheck.shell = [" ".join(command)], True
+        if not self._agent_stage_closed:
+            self.config.healthcheck = healthcheck
+
+    def _command_args(self, instruction: str, parts: List[str]) -> List[str]:
+        """Return the arguments of a CMD or ENTRYPOINT, in the array format or the simple format."""
+        if len(parts) < 2:
+            raise ValueError(f"{instruction} requires at least one argument")
         if parts[1].startswith('[') and parts[-1].endswith(']'):
             # Array format: CMD ["python", "agent.py"]
-            cmd_str = ' '.join(parts[1:])
-            # Simple JSON-like parsing
-            cmd_str = cmd_str.strip('[]')
-            self.config.cmd = [self._unquote(item.strip()) for item in cmd_str.split(',')]
-        else:
-            # Simple format: CMD python agent.py
-            self.config.cmd = [self._unquote(part) for part in parts[1:]]
+            return self._parse_exec_form(' '.join(parts[1:]))
+        # Simple format: CMD python agent.py
+        if not self._agent_stage_closed:
+            self._shell_forms[instruction] = self.current_line
+        return [self._unquote(part) for part in parts[1:]]
+
+    def _handle_cmd(self, parts: List[str]) -> List[str]:
+        """Handle CMD instruction, returning its arguments."""
+        cmd = self._command_args("CMD", parts)
+        # A CMD in a stage after the agent stage belongs to that stage's image
+        if not self._agent_stage_closed:
+            self.config.cmd = cmd
+        self.current_context = None
+        return cmd
+
+    def _handle_entrypoint(self, parts: List[str]) -> List[str]:
+        """Handle ENTRYPOINT instruction, returning its arguments."""
+        entrypoint = self._command_args("ENTRYPOINT", parts)
+        if not self._agent_stage_closed:
+            self.config.entrypoint = entrypoint
         self.current_context = None
+        return entrypoint
 
     def _handle_dockerfile_instruction(self, instruction: str, parts: List[str]):
         """Handle any generic Dockerfile instruction."""
         if len(parts) < 2:
             raise ValueError(f"{instruction} requires arguments")
 
-        # Special handling for ENV instruction to support KEY=VALUE format
-        if instruction == "ENV":
-            # Handle both KEY VALUE and KEY=VALUE formats for Dockerfile ENV
-            args = parts[1:]
-            if len(args) == 1 and '=' in args[0]:
-                # KEY=VALUE format - keep as single argument for Dockerfile
-                dockerfile_args = args
-            else:
-                # KEY VALUE format or multiple args - keep as is
-                dockerfile_args = args
-        else:
-            dockerfile_args = parts[1:]
+        # ENV is passed through verbatim and also recorded for the other outputs
+        if instruction == "ENV" and not self._agent_stage_closed:
+            self._record_image_env(parts[1:])
+        if instruction == "ARG":
+            for arg in parts[1:]:
+                name, has_default, default = arg.partition("=")
+                self.declared_args[name] = self._unquote(default) if has_default else None
+        dockerfile_args = parts[1:]
+
+        flags = []
+        raw = self.current_raw
+        if instruction == "ENV" and TRIPLE_QUOTE in self.current_text:
+            dockerfile_args = self._docker_env_args(parts[1:])
+            raw = None
+        if instruction in FILE_FLAGS:
+            flags, dockerfile_args = self._split_file_flags(instruction, dockerfile_args)
+            # Docker only accepts flags before the sources, so flags written later are moved to the front
+            if parts[1 : len(flags) + 1] != flags:
+                raw = None
 
         # Store all instructions for ordered generation
-        dockerfile_instruction = DockerfileInstruction(instruction=instruction, args=dockerfile_args)
+        dockerfile_instruction = DockerfileInstruction(
+            instruction=instruction,
+            args=dockerfile_args,
+            line=self.current_line,
+            flags=flags,
+            raw=raw,
+            stage=self._stage,
+        )
         self.config.dockerfile_instructions.append(dockerfile_instruction)
         self.current_context = None
 
+    def _parse_env_pairs(self, args: List[str]) -> List[tuple]:
+        """Parse ENV arguments in the KEY=VALUE ... form or the legacy KEY value form."""
+        if '=' not in args[0]:
+            if len(args) < 2:
+                raise ValueError("ENV requires KEY VALUE or KEY=VALUE")
+            return [(self._unquote(args[0]), self._unquote(' '.join(args[1:])))]
+
+        pairs = []
+        for arg in args:
+            if '=' not in arg:
+                raise ValueError(f"ENV with several variables needs KEY=VALUE pairs, got '{arg}'")
+            key, value = arg.split('=', 1)
+            pairs.append((self._unquote(key), self._unquote(value)))
+        return pairs
+
+    def _docker_env_args(self, args: List[str]) -> List[str]:
+        """Rewrite ENV arguments with triple-quoted values into the double-quoted KEY=VALUE form Docker reads."""
+        docker_args = []
+        for key, value in self._parse_env_pairs(args):
+            if "\n" in value:
+                raise ValueError(f"ENV {key} cannot span lines in the Dockerfile; use a single-line value")
+            escaped = value.replace("\\", "\\\\").replace('"', '\\"')
+            docker_args.append(f'{key}="{escaped}"')
+        return docker_args
+
+    def _check_env_pair(self, key: str, value: str):
+        """Warn about an ENV variable that was probably not written the way it reads."""
+        if not ENV_NAME_PATTERN.match(key):
+            self._warn("env-malformed", f"ENV name '{key}' is not a valid variable name", 1)
+        elif value.startswith("="):
+            self._warn("env-malformed", f"ENV {key} value starts with '='; write ENV {key}={value[1:].strip()}", 1)
+
+    def _warn(self, code: str, message: str, index: int = 0):
+        """Record a warning pointing at a part of the current instruction."""
+        self.diagnostics.append(Diagnostic(SEVERITY_WARNING, code, message, self.current_line, self._column(index)))
+
+    def _record_image_env(self, args: List[str]):
+        """Add top-level ENV variables to the image environment, warning about redefinitions."""
+        for key, value in self._parse_env_pairs(args):
+            self._check_env_pair(key, value)
+            previous = self.config.image_env.get(key)
+            if previous is not None and previous != value:
+                self.diagnostics.append(
+                    Diagnostic(
+                        SEVERITY_WARNING,
+                        "env-redefined",
+                        f"ENV {key} is redefined from '{previous}' to '{value}'; the last value wins",
+                        self.current_line,
+                    )
+                )
+            self.config.image_env[key] = value
+
+    def _split_file_flags(self, instruction: str, args: List[str]) -> tuple:
+        """Separate and validate the --flags of an ADD or COPY instruction."""
+        flags = [arg for arg in args if arg.startswith("--")]
+        paths = [arg for arg in args if not arg.startswith("--")]
+        valid = FILE_FLAGS[instruction]
+
+        for flag in flags:
+            name, _, value = flag[2:].partition('=')
+            if name not in valid:
+                raise ValueError(f"Unknown {instruction} flag '--{name}'. Valid flags: {', '.join(valid)}")
+            if name == "checksum" and not CHECKSUM_PATTERN.match(value):
+                raise ValueError(f"Invalid checksum '{value}'; expected sha256:<64 hex digits>")
+
+        if len(paths) < 2:
+            raise ValueError(f"{instruction} requires at least one source and a destination")
+        return flags, paths
+
     def _handle_sub_instruction(self, instruction: str, parts: List[str]):
         """Handle sub-instructions that modify the current context item."""
         if not self.current_context:
@@ -641,11 +3281,18 @@ class AgentfileParser:
             self._handle_router_sub_instruction(instruction, parts)
         elif self.current_context == "chain":
             self._handle_chain_sub_instruction(instruction, parts)
+        elif self.current_context == "parallel":
+            self._handle_parallel_sub_instruction(instruction, parts)
         elif self.current_context == "orchestrator":
             self._handle_orchestrator_sub_instruction(instruction, parts)
         elif self.current_context == "secret":
             self._handle_secret_sub_instruction(instruction, parts)
 
+    def _unsupported_message(self, instruction: str) -> str:
+        """Describe an instruction the open block does not accept, listing the ones it does."""
+        valid = ", ".join(CONTEXT_SUB_INSTRUCTIONS[self.current_context])
+        return f"{self._block_title()} does not support {instruction}; valid: {valid}"
+
     def _handle_server_sub_instruction(self, instruction: str, parts: List[str]):
         """Handle sub-instructions for SERVER context."""
         server = self.config.servers[self.current_item]
@@ -657,56 +3304,115 @@ class AgentfileParser:
         elif instruction == "ARGS":
             if len(parts) < 2:
                 raise ValueError("ARGS requires at least one argument")
-            server.args = [self._unquote(part) for part in parts[1:]]
+            server.args = self._parse_list(instruction, parts)
         elif instruction == "TRANSPORT":
             if len(parts) < 2:
                 raise ValueError("TRANSPORT requires a transport type")
             transport = self._unquote(parts[1])
-            if transport not in ["stdio", "sse", "http"]:
-                raise ValueError(f"Invalid transport type: {transport}")
+            if transport not in TRANSPORTS:
+                raise self._error(f"Invalid transport type: {transport}. Supported: {', '.join(TRANSPORTS)}", 1)
             server.transport = transport
         elif instruction == "URL":
             if len(parts) < 2:
                 raise ValueError("URL requires a URL")
             server.url = self._unquote(parts[1])
+        elif instruction == "CWD":
+            if len(parts) < 2:
+                raise ValueError("CWD requires a directory")
+            cwd = self._unquote(' '.join(parts[1:]))
+            if not cwd.startswith("/"):
+                raise self._error(f"CWD must be an absolute path in the image, got {cwd}", 1)
+            server.cwd = cwd
+        elif instruction == "ALLOW_DOCKER":
+            if len(parts) < 2:
+                raise ValueError("ALLOW_DOCKER requires true/false")
+            server.allow_docker = self._unquote(parts[1]).lower() in ['true', '1', 'yes']
+        elif instruction == "ELICITATION_MODE":
+            if len(parts) < 2:
+                raise ValueError(f"ELICITATION_MODE requires a mode. Supported: {', '.join(ELICITATION_MODES)}")
+            mode = self._unquote(parts[1]).lower()
+            if mode not in ELICITATION_MODES:
+                raise self._error(f"Invalid ELICITATION_MODE: {mode}. Supported: {', '.join(ELICITATION_MODES)}", 1)
+            server.elicitation_mode = mode
+        elif instruction == "HEADER":
+            if len(parts) < 3:
+                raise ValueError('HEADER requires a name and a value, such as HEADER Authorization "Bearer ${TOKEN}"')
+            name = self._unquote(parts[1])
+            if not HEADER_NAME_PATTERN.match(name):
+                raise self._error(f"Invalid HEADER name: {name}", 1)
+            server.headers[name] = self._unquote(' '.join(parts[2:]))
+        elif instruction == "AUTH":
+            server.auth = self._parse_auth(parts)
+        elif instruction == "STARTUP_TIMEOUT":
+            server.startup_timeout = self._parse_timeout(instruction, parts)
+        elif instruction == "READ_TIMEOUT":
+            server.read_timeout = self._parse_timeout(instruction, parts)
+        elif instruction == "REQUEST_TIMEOUT":
+            server.request_timeout = self._parse_timeout(instruction, parts)
+        elif instruction == "MAX_RETRIES":
+            server.max_retries = self._parse_retries(parts)
         elif instruction == "ENV":
             if len(parts) < 2:
                 raise ValueError("ENV requires KEY VALUE or KEY=VALUE")
-
-            if len(parts) == 2:
-                # Handle KEY=VALUE format
-                env_part = parts[1]
-                if '=' in env_part:
-                    key, value = env_part.split('=', 1)  # Split only on first =
-                    key = self._unquote(key)
-                    value = self._unquote(value)
-                    server.env[key] = value
-                else:
-                    raise ValueError("ENV requires KEY VALUE or KEY=VALUE")
-            elif len(parts) >= 3:
-                # Handle KEY VALUE format
-                key = self._unquote(parts[1])
-                value = self._unquote(' '.join(parts[2:]))  # Join remaining parts as value
+            # Same forms as the Dockerfile ENV: KEY=VALUE pairs, or the legacy KEY value
+            for key, value in self._parse_env_pairs(parts[1:]):
+                self._check_env_pair(key, value)
                 server.env[key] = value
-            else:
-                raise ValueError("ENV requires KEY VALUE or KEY=VALUE")
+        else:
+            raise self._error(self._unsupported_message(instruction), 0)
 
     def _handle_agent_sub_instruction(self, instruction: str, parts: List[str]):
         """Handle sub-instructions for AGENT context."""
         agent = self.config.agents[self.current_item]
 
         if instruction == "INSTRUCTION":
-            if len(parts) < 2:
-                raise ValueError("INSTRUCTION requires instruction text")
-            agent.instruction = self._unquote(' '.join(parts[1:]))
+            self._set_instruction(agent, self._instruction_text(parts))
+        elif instruction == "INSTRUCTION_FILE":
+            self._handle_instruction_file(agent, parts)
         elif instruction == "SERVERS":
             if len(parts) < 2:
                 raise ValueError("SERVERS requires at least one server name")
-            agent.servers = [self._unquote(part) for part in parts[1:]]
+            agent.servers = self._parse_list(instruction, parts, comma_separated=True)
+        elif instruction == "TOOLS":
+            if len(parts) < 2:
+                raise ValueError("TOOLS requires at least one server.tool name")
+            tools = self._parse_list(instruction, parts, comma_separated=True)
+            for tool in tools:
+                if not TOOL_PATTERN.match(tool):
+                    raise self._error(
+                        f"Invalid TOOLS entry: {tool}. Use server.tool, such as filesystem.read_file or filesystem.*",
+                        1,
+                    )
+            agent.tools = tools
+        elif instruction == "RESOURCES":
+            if len(parts) < 2:
+                raise ValueError("RESOURCES requires at least one resource URI")
+            resources = self._parse_list(instruction, parts)
+            for uri in resources:
+                match = RESOURCE_URI_PATTERN.match(uri)
+                if not match:
+                    raise self._error(f"Invalid RESOURCES URI: {uri}. Use a URI such as file:///app/docs/spec.md", 1)
+                if match.group(1).lower() not in KNOWN_RESOURCE_SCHEMES:
+                    self._warn(
+                        "unknown-resource-scheme",
+                        f"Resource {uri} uses the {match.group(1)} scheme, which is not file or http(s); "
+                        "it only loads if one of the agent's SERVERS serves it",
+                        1,
+                    )
+            agent.resources = resources
         elif instruction == "MODEL":
             if len(parts) < 2:
                 raise ValueError("MODEL requires a model name")
             agent.model = self._unquote(parts[1])
+        elif instruction in MODEL_SETTINGS:
+            setattr(agent, MODEL_SETTINGS[instruction], self._parse_model_setting(instruction, parts))
+        elif instruction == "BASE_URL":
+            if len(parts) < 2:
+                raise ValueError("BASE_URL requires a URL or $SECRET reference")
+            base_url = self._unquote(parts[1])
+            if not (BASE_URL_PATTERN.match(base_url) or env_reference(base_url)):
+                raise self._error(f"Invalid BASE_URL: {base_url}. Use an http(s):// URL or a $SECRET reference", 1)
+            agent.base_url = base_url
         elif instruction == "USE_HISTORY":
             if len(parts) < 2:
                 raise ValueError("USE_HISTORY requires true/false")
@@ -719,6 +3425,8 @@ class AgentfileParser:
             if len(parts) < 2:
                 raise ValueError("DEFAULT requires true/false")
             agent.default = self._unquote(parts[1]).lower() in ['true', '1', 'yes']
+        else:
+            raise self._error(self._unsupported_message(instruction), 0)
 
     def _handle_router_sub_instruction(self, instruction: str, parts: List[str]):
         """Handle sub-instructions for ROUTER context."""
@@ -727,19 +3435,26 @@ class AgentfileParser:
         if instruction == "AGENTS":
             if len(parts) < 2:
                 raise ValueError("AGENTS requires at least one agent name")
-            router.agents = [self._unquote(part) for part in parts[1:]]
+            router.agents = self._parse_list(instruction, parts, comma_separated=True)
         elif instruction == "MODEL":
-            if len(parts) < 2:
+            # An empty model would silently fall back to the default model, so reject it
+            if len(parts) < 2 or not self._unquote(parts[1]):
                 raise ValueError("MODEL requires a model name")
             router.model = self._unquote(parts[1])
         elif instruction == "INSTRUCTION":
+            self._set_instruction(router, self._instruction_text(parts))
+        elif instruction == "INSTRUCTION_FILE":
+            self._handle_instruction_file(router, parts)
+        elif instruction == "HUMAN_INPUT":
             if len(parts) < 2:
-                raise ValueError("INSTRUCTION requires instruction text")
-            router.instruction = self._unquote(' '.join(parts[1:]))
+                raise ValueError("HUMAN_INPUT requires true/false")
+            router.human_input = self._unquote(parts[1]).lower() in ['true', '1', 'yes']
         elif instruction == "DEFAULT":
             if len(parts) < 2:
                 raise ValueError("DEFAULT requires true/false")
             router.default = self._unquote(parts[1]).lower() in ['true', '1', 'yes']
+        else:
+            raise self._error(self._unsupported_message(instruction), 0)
 
     def _handle_chain_sub_instruction(self, instruction: str, parts: List[str]):
         """Handle sub-instructions for CHAIN context."""
@@ -748,11 +3463,18 @@ class AgentfileParser:
         if instruction == "SEQUENCE":
             if len(parts) < 2:
                 raise ValueError("SEQUENCE requires at least one agent name")
-            chain.sequence = [self._unquote(part) for part in parts[1:]]
+            chain.sequence = self._parse_list(instruction, parts, comma_separated=True)
+        elif instruction == "MODEL":
+            if len(parts) < 2 or not self._unquote(parts[1]):
+                raise ValueError("MODEL requires a model name")
+            chain.model = self._unquote(parts[1])
         elif instruction == "INSTRUCTION":
-            if len(parts) < 2:
+            instruction_text = self._instruction_text(parts)
+            if not instruction_text:
                 raise ValueError("INSTRUCTION requires instruction text")
-            chain.instruction = self._unquote(' '.join(parts[1:]))
+            self._set_instruction(chain, instruction_text)
+        elif instruction == "INSTRUCTION_FILE":
+            self._handle_instruction_file(chain, parts)
         elif instruction == "CUMULATIVE":
             if len(parts) < 2:
                 raise ValueError("CUMULATIVE requires true/false")
@@ -765,6 +3487,37 @@ class AgentfileParser:
             if len(parts) < 2:
                 raise ValueError("DEFAULT requires true/false")
             chain.default = self._unquote(parts[1]).lower() in ['true', '1', 'yes']
+        else:
+            raise self._error(self._unsupported_message(instruction), 0)
+
+    def _handle_parallel_sub_instruction(self, instruction: str, parts: List[str]):
+        """Handle sub-instructions for PARALLEL context."""
+        parallel = self.config.parallels[self.current_item]
+
+        if instruction == "FAN_OUT":
+            if len(parts) < 2:
+                raise ValueError("FAN_OUT requires at least one agent name")
+            parallel.fan_out = self._parse_list(instruction, parts, comma_separated=True)
+        elif instruction == "FAN_IN":
+            if len(parts) < 2:
+                raise ValueError("FAN_IN requires the name of the agent that combines the answers")
+            fan_in = self._parse_list(instruction, parts, comma_separated=True)
+            if len(fan_in) != 1:
+                raise self._error(f"FAN_IN names one agent to combine the answers, got {', '.join(fan_in)}", 1)
+            parallel.fan_in = fan_in[0]
+        elif instruction == "INSTRUCTION":
+            instruction_text = self._instruction_text(parts)
+            if not instruction_text:
+                raise ValueError("INSTRUCTION requires instruction text")
+            self._set_instruction(parallel, instruction_text)
+        elif instruction == "INSTRUCTION_FILE":
+            self._handle_instruction_file(parallel, parts)
+        elif instruction == "DEFAULT":
+            if len(parts) < 2:
+                raise ValueError("DEFAULT requires true/false")
+            parallel.default = self._unquote(parts[1]).lower() in ['true', '1', 'yes']
+        else:
+            raise self._error(self._unsupported_message(instruction), 0)
 
     def _handle_orchestrator_sub_instruction(self, instruction: str, parts: List[str]):
         """Handle sub-instructions for ORCHESTRATOR context."""
@@ -773,29 +3526,35 @@ class AgentfileParser:
         if instruction == "AGENTS":
             if len(parts) < 2:
                 raise ValueError("AGENTS requires at least one agent name")
-            orchestrator.agents = [self._unquote(part) for part in parts[1:]]
+            orchestrator.agents = self._parse_list(instruction, parts, comma_separated=True)
         elif instruction == "MODEL":
-            if len(parts) < 2:
+            if len(parts) < 2 or not self._unquote(parts[1]):
                 raise ValueError("MODEL requires a model name")
             orchestrator.model = self._unquote(parts[1])
         elif instruction == "INSTRUCTION":
-            if len(parts) < 2:
+            instruction_text = self._instruction_text(parts)
+            if not instruction_text:
                 raise ValueError("INSTRUCTION requires instruction text")
-            orchestrator.instruction = self._unquote(' '.join(parts[1:]))
+            self._set_instruction(orchestrator, instruction_text)
+        elif instruction == "INSTRUCTION_FILE":
+            self._handle_instruction_file(orchestrator, parts)
         elif instruction == "PLAN_TYPE":
             if len(parts) < 2:
                 raise ValueError("PLAN_TYPE requires a plan type")
             plan_type = self._unquote(parts[1])
             if plan_type not in ["full", "iterative"]:
-                raise ValueError(f"Invalid plan type: {plan_type}")
+                raise self._error(f"Invalid plan type: {plan_type}", 1)
             orchestrator.plan_type = plan_type
         elif instruction == "PLAN_ITERATIONS":
             if len(parts) < 2:
                 raise ValueError("PLAN_ITERATIONS requires a number")
             try:
-                orchestrator.plan_iterations = int(parts[1])
+                plan_iterations = int(self._unquote(parts[1]))
             except ValueError as exc:
-                raise ValueError(f"Invalid number for PLAN_ITERATIONS: {parts[1]}") from exc
+                raise self._error(f"Invalid number for PLAN_ITERATIONS: {parts[1]}", 1) from exc
+            if plan_iterations < 1:
+                raise self._error(f"PLAN_ITERATIONS must be at least 1, got {plan_iterations}", 1)
+            orchestrator.plan_iterations = plan_iterations
         elif instruction == "HUMAN_INPUT":
             if len(parts) < 2:
                 raise ValueError("HUMAN_INPUT requires true/false")
@@ -804,3 +3563,225 @@ class AgentfileParser:
             if len(parts) < 2:
                 raise ValueError("DEFAULT requires true/false")
             orchestrator.default = self._unquote(parts[1]).lower() in ['true', '1', 'yes']
+        else:
+            raise self._error(self._unsupported_message(instruction), 0)
+
+
+def import_claude_config(data: Any, source: str = "claude_desktop_config.json") -> Dict[str, MCPServer]:
+    """Return the servers of a Claude Desktop config, {"mcpServers": {name: {command, args, env}}}, by name.
+
+    source names the file in errors.
+    """
+    entries = data.get("mcpServers") if isinstance(data, dict) else None
+    if not isinstance(entries, dict):
+        raise ValueError(f"{source}: expected a JSON object with an mcpServers object")
+    servers = {}
+    for name, entry in entries.items():
+        if not isinstance(entry, dict):
+            raise ValueError(f"{source}: server {name} must be an object")
+        command, args, env = entry.get("command"), entry.get("args", []), entry.get("env", {})
+        if not isinstance(command, str) or not command:
+            raise ValueError(f"{source}: server {name} needs a command")
+        if not isinstance(args, list) or not all(isinstance(arg, str) for arg in args):
+            raise ValueError(f"{source}: args of server {name} must be a list of strings")
+        if not isinstance(env, dict) or not all(isinstance(value, str) for value in env.values()):
+            raise ValueError(f"{source}: env of server {name} must map names to strings")
+        servers[name] = MCPServer(name=name, command=command, args=list(args), env=dict(env))
+    return servers
+
+
+def import_mcp_json(data: Any, source: str = "mcp.json") -> tuple:
+    """Return the servers of a VS Code or Cursor mcp.json by name, and a SECRET for each of its inputs.
+
+    The file has {"inputs": [{"id": ...}], "servers": {name: {type, command, args, env, url, headers}}}.
+    ${input:id} and ${env:NAME} in its values become ${NAME} references, where an input's NAME is its
+    id in upper case with other characters turned into underscores. source names the file in errors.
+    """
+    entries = data.get("servers") if isinstance(data, dict) else None
+    inputs = data.get("inputs", []) if isinstance(data, dict) else None
+    if not isinstance(entries, dict) or not isinstance(inputs, list):
+        raise ValueError(f"{source}: expected a JSON object with a servers object and an optional inputs list")
+    names = {}
+    for entry in inputs:
+        if not isinstance(entry, dict) or not isinstance(entry.get("id"), str) or not entry["id"]:
+            raise ValueError(f"{source}: every input needs an id")
+        name = re.sub(r"[^A-Za-z0-9]", "_", entry["id"]).upper()
+        names[entry["id"]] = name if ENV_NAME_PATTERN.match(name) else f"_{name}"
+
+    def substitute(value: str, where: str) -> str:
+        def replace(match) -> str:
+            kind, key = match.groups()
+            if kind == "input" and key not in names:
+                raise ValueError(f"{source}: {where} uses ${{input:{key}}}, which is not in inputs")
+            return f"${{{names[key] if kind == 'input' else key}}}"
+
+        return MCP_JSON_VARIABLE_PATTERN.sub(replace, value)
+
+    servers = {}
+    for name, entry in entries.items():
+        if not isinstance(entry, dict):
+            raise ValueError(f"{source}: server {name} must be an object")
+        transport = entry.get("type", "stdio" if "command" in entry else "http")
+        if transport not in TRANSPORTS:
+            raise ValueError(
+                f"{source}: server {name} has unsupported type {transport}. Supported: {', '.join(TRANSPORTS)}"
+            )
+        args, env, headers = entry.get("args", []), entry.get("env", {}), entry.get("headers", {})
+        for field_name in ["command", "url"]:
+            if not isinstance(entry.get(field_name, ""), str):
+                raise ValueError(f"{source}: {field_name} of server {name} must be a string")
+        if not isinstance(args, list) or not all(isinstance(arg, str) for arg in args):
+            raise ValueError(f"{source}: args of server {name} must be a list of strings")
+        for field_name, mapping in [("env", env), ("headers", headers)]:
+            if not isinstance(mapping, dict) or not all(isinstance(value, str) for value in mapping.values()):
+                raise ValueError(f"{source}: {field_name} of server {name} must map names to strings")
+        where = f"server {name}"
+        servers[name] = MCPServer(
+            name=name,
+            transport=transport,
+            command=substitute(entry["command"], where) if entry.get("command") else None,
+            args=[substitute(arg, where) for arg in args],
+            url=substitute(entry["url"], where) if entry.get("url") else None,
+            env={key: substitute(value, where) for key, value in env.items()},
+            headers={key: substitute(value, where) for key, value in headers.items()},
+        )
+    return servers, [SecretContext(name=name) for name in names.values()]
+
+
+def parse_dotenv(content: str) -> Dict[str, str]:
+    """Parse a dotenv file: NAME=value lines, optionally quoted or prefixed with export, and # comments.
+
+    Double-quoted values may span lines and take backslash escapes; single-quoted values are literal.
+    An unquoted value ends at a # that follows whitespace.
+    """
+    variables: Dict[str, str] = {}
+    lines = content.splitlines()
+    index = 0
+    while index < len(lines):
+        line_num, line = index + 1, lines[index].strip()
+        index += 1
+        if not line or line.startswith("#"):
+            continue
+        match = DOTENV_LINE_PATTERN.match(line)
+        if not match:
+            raise ValueError(f"line {line_num} is not NAME=value")
+        name, value = match.groups()
+        quote = value[:1]
+        if quote in ['"', "'"]:
+            # Read on until the closing quote, which may be on a later line
+            text = value[1:]
+            while _closing_quote(text, quote) is None:
+                if index >= len(lines):
+                    raise ValueError(f"line {line_num}: unterminated {quote} value for {name}")
+                text += "\n" + lines[index]
+                index += 1
+            end = _closing_quote(text, quote)
+            rest = text[end + 1 :].strip()
+            if rest and not rest.startswith("#"):
+                raise ValueError(f"line {line_num}: unexpected text after the quoted value of {name}")
+            value = text[:end]
+            if quote == '"':
+                value = re.sub(r"\\(.)", lambda m: DOTENV_ESCAPES.get(m.group(1), m.group(0)), value)
+        else:
+            value = re.split(r"\s+#", value, maxsplit=1)[0].strip()
+        variables[name] = value
+    return variables
+
+
+def _closing_quote(text: str, quote: str) -> Optional[int]:
+    """Return the index of the quote that closes a dotenv value, skipping escaped double quotes."""
+    index = 0
+    while index < len(text):
+        if quote == '"' and text[index] == "\\":
+            index += 2
+            continue
+        if text[index] == quote:
+            return index
+        index += 1
+    return None
+
+
+def include_path(base_dir: str, target: str) -> str:
+    """Return where an INCLUDE target is: a URL as given or joined to a URL base, else a normalized path."""
+    if URL_SCHEME_PATTERN.match(target):
+        return target
+    if URL_SCHEME_PATTERN.match(base_dir):
+        return urllib.parse.urljoin(base_dir, target)
+    return os.path.normpath(os.path.join(base_dir, target))
+
+
+def include_base(location: str) -> str:
+    """Return what the INCLUDE targets of the file at a path or URL are relative to."""
+    return urllib.parse.urljoin(location, ".") if URL_SCHEME_PATTERN.match(location) else os.path.dirname(location)
+
+
+def _include_targets(content: str, base_dir: str) -> List[str]:
+    """Return the normalized local paths a file INCLUDEs, skipping URLs and paths that use placeholders.
+
+    URL targets are fetched as they are included.
+    """
+    if URL_SCHEME_PATTERN.match(base_dir):
+        return []
+    try:
+        instructions = AgentfileParser().logical_lines(content)
+    except ValueError:
+        return []  # Parsing reports the problem with its line
+    targets = []
+    for _, line in instructions:
+        match = INCLUDE_PATTERN.match(line)
+        if match and "$" not in match.group(1):
+            path = match.group(1)
+            if len(path) >= 2 and path[0] == path[-1] and path[0] in ['"', "'"]:
+                path = path[1:-1]
+            if not URL_SCHEME_PATTERN.match(path):
+                targets.append(os.path.normpath(os.path.join(base_dir, path)))
+    return targets
+
+
+def resolve_includes(
+    content: str,
+    base_dir: str,
+    resolver: Callable[[str], str],
+    max_workers: int = DEFAULT_INCLUDE_WORKERS,
+    cancel: Optional[threading.Event] = None,
+) -> Dict[str, str]:
+    """Read every file reachable through INCLUDE concurrently and return their contents by path.
+
+    Files are read breadth first, one level of includes at a time. Read errors are collected
+    and reported together, sorted by path, so the outcome does not depend on thread timing.
+    Content without INCLUDE never starts a thread.
+    """
+    pending = sorted(set(_include_targets(content, base_dir)))
+    if not pending:
+        return {}
+
+    contents: Dict[str, str] = {}
+    errors: Dict[str, str] = {}
+    seen = set(pending)
+    with ThreadPoolExecutor(max_workers=max(1, max_workers)) as executor:
+        while pending:
+            if cancel is not None and cancel.is_set():
+                raise ValueError("Reading INCLUDE files was cancelled")
+            futures = {path: executor.submit(_resolve_one, resolver, path, cancel) for path in pending}
+            discovered = set()
+            for path in pending:
+                try:
+                    contents[path] = futures[path].result()
+                except OSError as e:
+                    errors[path] = str(e.strerror or e)
+                    continue
+                discovered.update(_include_targets(contents[path], include_base(path)))
+            pending = sorted(discovered - seen)
+            seen.update(pending)
+
+    if errors:
+        details = "\n".join(f"  {path}: {errors[path]}" for path in sorted(errors))
+        raise ValueError(f"Cannot read included files:\n{details}")
+    return contents
+
+
+def _resolve_one(resolver: Callable[[str], str], path: str, cancel: Optional[threading.Event]) -> str:
+    """Read one INCLUDE target unless the read-ahead was cancelled before it started."""
+    if cancel is not None and cancel.is_set():
+        raise OSError(f"cancelled before {path} was read")
+    return resolver(path)
@@ -0,0 +1,311 @@
+"""Serialization of parsed configurations back into canonical Agentfile text."""
+
+import json
+from typing import List, Optional
+
+from agentman.agentfile_parser import (
+    DEFAULT_BASE_IMAGE,
+    DEFAULT_SHUTDOWN_GRACE,
+    HEREDOC_PATTERN,
+    MODEL_SETTINGS,
+    PLACEHOLDER_PATTERN,
+    TRIPLE_QUOTE,
+    AgentfileConfig,
+    MCPServer,
+    SecretContext,
+    SecretValue,
+)
+
+# Characters a double- or single-quoted value writes with the escape character in front
+QUOTED_ESCAPE_NAMES = {"\t": "t"}
+
+
+def _needs_array_form(values: List[str]) -> bool:
+    """Whether a list would not survive the plain whitespace-separated form."""
+    return any(not value or value[0] in "[\"'#" or any(c.isspace() or c in "\"'" for c in value) for value in values)
+
+
+def _list_line(instruction: str, values: List[str]) -> str:
+    """Render a list sub-instruction, falling back to the JSON array form when needed."""
+    if _needs_array_form(values):
+        return f"{instruction} {json.dumps(values)}"
+    return f"{instruction} {' '.join(values)}"
+
+
+def _setting_line(instruction: str, value) -> str:
+    """Render a model setting: a number, a timeout in seconds, or a list of stop sequences."""
+    if isinstance(value, list):
+        return _list_line(instruction, value)
+    return f"{instruction} {value}s" if instruction.endswith("_TIMEOUT") else f"{instruction} {value!r}"
+
+
+def _needs_quotes(text: str, escape: str) -> bool:
+    """Whether text would not read back unchanged as the unquoted rest of an Agentman line.
+
+    The parser joins the words of such a value with single spaces, opens a quoted value at a
+    quote that starts a word, ends the line at a # that starts one, expands placeholders and
+    continues the line at a trailing escape character.
+    """
+    if not text or text != text.strip() or "  " in text or any(c.isspace() and c != " " for c in text):
+        return True
+    if PLACEHOLDER_PATTERN.search(text) or text.endswith(escape) or TRIPLE_QUOTE in text:
+        return True
+    return any(word[0] in "\"'#" for word in text.split(" "))
+
+
+def _quote(text: str, escape: str) -> str:
+    """Quote text for an Agentman value, single-quoted when double quotes would expand its placeholders."""
+    quote = "'" if PLACEHOLDER_PATTERN.search(text) else '"'
+    escaped = text.replace(escape, escape * 2).replace(quote, escape + quote)
+    for char, name in QUOTED_ESCAPE_NAMES.items():
+        escaped = escaped.replace(char, escape + name)
+    return f"{quote}{escaped}{quote}"
+
+
+def _word(text: str, escape: str) -> str:
+    """Render a single-word value, quoted when it contains whitespace or would not read back unchanged."""
+    return _quote(text, escape) if " " in text or _needs_quotes(text, escape) else text
+
+
+def _instruction_line(entity, escape: str) -> str:
+    """Render the INSTRUCTION_FILE of an agent or workflow, or its text as a heredoc when it would not fit one line."""
+    if entity.instruction_file:
+        return f"INSTRUCTION_FILE {_word(entity.instruction_file, escape)}"
+    return _text_line("INSTRUCTION", entity.instruction, escape)
+
+
+def _text_line(keyword: str, text: str, escape: str) -> str:
+    """Render a keyword followed by text, as a heredoc when the text would not fit one line."""
+    if "\n" not in text and text == text.strip() and not HEREDOC_PATTERN.fullmatch(text):
+        return f"{keyword} {_value(text, escape)}"
+    delimiter = "EOF"
+    while delimiter in text.split("\n"):
+        delimiter += "_"
+    return f"{keyword} <<{delimiter}\n{text}\n{delimiter}"
+
+
+def _value(text: str, escape: str) -> str:
+    """Render a value that runs to the end of the line, triple-quoted when it spans lines."""
+    if "\n" in text:
+        return f"{TRIPLE_QUOTE}{text}{TRIPLE_QUOTE}"
+    return _quote(text, escape) if _needs_quotes(text, escape) else text
+
+
+def _server_lines(server: MCPServer, escape: str) -> List[str]:
+    """Render an MCP_SERVER block."""
+    lines = [f"MCP_SERVER {server.name}"]
+    if server.command:
+        lines.append(f"COMMAND {_word(server.command, escape)}")
+    if server.args:
+        lines.append(_list_line("ARGS", server.args))
+    if server.transport != "stdio":
+        lines.append(f"TRANSPORT {server.transport}")
+    if server.url:
+        lines.append(f"URL {_word(server.url, escape)}")
+    if server.cwd:
+        lines.append(f"CWD {_word(server.cwd, escape)}")
+    if server.allow_docker:
+        lines.append("ALLOW_DOCKER true")
+    if server.elicitation_mode:
+        lines.append(f"ELICITATION_MODE {server.elicitation_mode}")
+    for name, value in server.headers.items():
+        lines.append(f"HEADER {name} {_value(value, escape)}")
+    if server.auth:
+        user = f"{server.auth.user}:" if server.auth.user else ""
+        lines.append(f"AUTH {server.auth.type} {user}${server.auth.secret}")
+    if server.startup_timeout is not None:
+        lines.append(_setting_line("STARTUP_TIMEOUT", server.startup_timeout))
+    if server.read_timeout is not None:
+        lines.append(_setting_line("READ_TIMEOUT", server.read_timeout))
+    if server.request_timeout is not None:
+        lines.append(_setting_line("REQUEST_TIMEOUT", server.request_timeout))
+    if server.max_retries is not None:
+        lines.append(_setting_line("MAX_RETRIES", server.max_retries))
+    for key, value in server.env.items():
+        lines.append(f"ENV {key} {_value(value, escape)}")
+    return lines
+
+
+def _image_lines(config: AgentfileConfig) -> tuple:
+    """Return the FROM line, and the other image lines, for settings no passthrough instruction sets.
+
+    A parsed configuration has its Dockerfile instructions; one built in code may only have the settings.
+    """
+    written = {instruction.instruction for instruction in config.dockerfile_instructions}
+    exposed = [inst.args[0] for inst in config.dockerfile_instructions if inst.instruction == "EXPOSE"]
+    if config.serve:
+        # SERVE exposes its port when parsed again
+        exposed.append(str(config.serve.port))
+    head = []
+    if "FROM" not in written and config.base_image != DEFAULT_BASE_IMAGE:
+        head.append(f"FROM {config.base_image}")
+    tail = []
+    if "ENV" not in written:
+        for key, value in config.image_env.items():
+            if not value or any(c.isspace() or c in "\"'\\" for c in value):
+                value = '"' + value.replace("\\", "\\\\").replace('"', '\\"') + '"'
+            tail.append(f"ENV {key}={value}")
+    tail.extend(f"EXPOSE {port}" for port in config.expose_ports if str(port) not in exposed)
+    if "VOLUME" not in written and config.volumes:
+        tail.append(f"VOLUME {json.dumps(config.volumes)}")
+    if "LABEL" not in written and config.labels:
+        tail.append("LABEL " + " ".join(f"{key}={value}" for key, value in config.labels.items()))
+    if "HEALTHCHECK" not in written and config.healthcheck:
+        tail.append(config.healthcheck.to_dockerfile_line())
+    if "ENTRYPOINT" not in written and config.entrypoint:
+        tail.append(f"ENTRYPOINT {json.dumps(config.entrypoint)}")
+    if "CMD" not in written and config.cmd != config.generated_cmd():
+        tail.append(f"CMD {json.dumps(config.cmd)}")
+    return head, tail
+
+
+def write_agentfile(config: AgentfileConfig, source: Optional[str] = None) -> str:
+    """Write a configuration as a canonical Agentfile that parses back to the same configuration.
+
+    Parser directives come first, then the Dockerfile instructions in their
+    original order, the global settings, secrets, prompts, MCP servers, agents and
+    workflows. With a source, each prompt, server, agent and workflow is preceded by a
+    comment naming the file it was declared in.
+    """
+
+    def origin(keyword: str, name: str) -> List[str]:
+        declared_in = config.declared_in.get((keyword, name))
+        return [f"# from {declared_in}"] if source and declared_in else []
+
+    lines = config.directive_lines()
+    if source:
+        lines.append(f"# Rendered from {source}")
+    if lines:
+        lines.append("")
+
+    head, tail = _image_lines(config)
+    dockerfile_lines = head + [instruction.passthrough_text() for instruction in config.dockerfile_instructions] + tail
+    if dockerfile_lines:
+        lines.extend(dockerfile_lines + [""])
+
+    if config.framework != "fast-agent":
+        lines.append(f"FRAMEWORK {config.framework}")
+    escape = config.escape_char
+    if config.default_model:
+        lines.append(f"MODEL {_word(config.default_model, escape)}")
+    for keyword, attribute in MODEL_SETTINGS.items():
+        value = getattr(config, f"default_{attribute}")
+        if value is not None:
+            lines.append(_setting_line(keyword, value))
+    if config.shutdown_grace != DEFAULT_SHUTDOWN_GRACE:
+        lines.append(f"SHUTDOWN_GRACE {config.shutdown_grace}s")
+    if config.human_input_mode != "console":
+        webhook = f" {_word(config.human_input_webhook, escape)}" if config.human_input_webhook else ""
+        lines.append(f"HUMAN_INPUT_MODE {config.human_input_mode}{webhook}")
+    if config.serve:
+        path = f" {config.serve.path}" if config.serve.path else ""
+        lines.append(f"SERVE {config.serve.protocol} {config.serve.port}{path}")
+    if config.cmd_mode:
+        lines.append(f"CMD_MODE {config.cmd_mode}")
+
+    for secret in config.secrets:
+        if isinstance(secret, str):
+            lines.append(f"SECRET {secret}")
+        elif isinstance(secret, SecretValue):
+            lines.append(f"SECRET {secret.name} {_value(secret.value, escape)}")
+        elif isinstance(secret, SecretContext):
+            lines.append(f"SECRET {secret.name}")
+            lines.extend(f"{key} {_value(value, escape)}" for key, value in secret.values.items())
+    if lines and lines[-1]:
+        lines.append("")
+
+    for name, text in config.prompts.items():
+        lines.extend(origin("PROMPT", name) + [_text_line(f"PROMPT {name}", text, escape), ""])
+
+    blocks = [origin("MCP_SERVER", server.name) + _server_lines(server, escape) for server in config.servers.values()]
+
+    for agent in config.agents.values():
+        block = origin("AGENT", agent.name) + [f"AGENT {agent.name}", _instruction_line(agent, escape)]
+        if agent.servers:
+            block.append(_list_line("SERVERS", agent.servers))
+        if agent.tools:
+            block.append(_list_line("TOOLS", agent.tools))
+        if agent.resources:
+            block.append(_list_line("RESOURCES", agent.resources))
+        if agent.model:
+            block.append(f"MODEL {_word(agent.model, escape)}")
+        for keyword, attribute in MODEL_SETTINGS.items():
+            value = getattr(agent, attribute)
+            if value is not None:
+                block.append(_setting_line(keyword, value))
+        if agent.base_url:
+            block.append(f"BASE_URL {_word(agent.base_url, escape)}")
+        if not agent.use_history:
+            block.append("USE_HISTORY false")
+        if agent.human_input:
+            block.append("HUMAN_INPUT true")
+        if agent.default:
+            block.append("DEFAULT true")
+        blocks.append(block)
+
+    for router in config.routers.values():
+        block = origin("ROUTER", router.name) + [f"ROUTER {router.name}"]
+        if router.agents:
+            block.append(_list_line("AGENTS", router.agents))
+        if router.model:
+            block.append(f"MODEL {_word(router.model, escape)}")
+        if router.instruction:
+            block.append(_instruction_line(router, escape))
+        if router.human_input:
+            block.append("HUMAN_INPUT true")
+        if router.default:
+            block.append("DEFAULT true")
+        blocks.append(block)
+
+    for chain in config.chains.values():
+        block = origin("CHAIN", chain.name) + [f"CHAIN {chain.name}"]
+        if chain.sequence:
+            block.append(_list_line("SEQUENCE", chain.sequence))
+        if chain.model:
+            block.append(f"MODEL {_word(chain.model, escape)}")
+        if chain.instruction:
+            block.append(_instruction_line(chain, escape))
+        if chain.cumulative:
+            block.append("CUMULATIVE true")
+        if not chain.continue_with_final:
+            block.append("CONTINUE_WITH_FINAL false")
+        if chain.default:
+            block.append("DEFAULT true")
+        blocks.append(block)
+
+    for parallel in config.parallels.values():
+        block = origin("PARALLEL", parallel.name) + [f"PARALLEL {parallel.name}"]
+        if parallel.fan_out:
+            block.append(_list_line("FAN_OUT", parallel.fan_out))
+        if parallel.fan_in:
+            block.append(f"FAN_IN {_word(parallel.fan_in, escape)}")
+        if parallel.instruction:
+            block.append(_instruction_line(parallel, escape))
+        if parallel.default:
+            block.append("DEFAULT true")
+        blocks.append(block)
+
+    for orchestrator in config.orchestrators.values():
+        block = origin("ORCHESTRATOR", orchestrator.name) + [f"ORCHESTRATOR {orchestrator.name}"]
+        if orchestrator.agents:
+            block.append(_list_line("AGENTS", orchestrator.agents))
+        if orchestrator.model:
+            block.append(f"MODEL {_word(orchestrator.model, escape)}")
+        if orchestrator.instruction:
+            block.append(_instruction_line(orchestrator, escape))
+        if orchestrator.plan_type != "full":
+            block.append(f"PLAN_TYPE {orchestrator.plan_type}")
+        if orchestrator.plan_iterations is not None:
+            block.append(f"PLAN_ITERATIONS {orchestrator.plan_iterations}")
+        if orchestrator.human_input:
+            block.append("HUMAN_INPUT true")
+        if orchestrator.default:
+            block.append("DEFAULT true")
+        blocks.append(block)
+
+    for block in blocks:
+        lines.extend(block + [""])
+
+    while lines and not lines[-1]:
+        lines.pop()
+    return "\n".join(lines) + "\n"
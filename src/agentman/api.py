@@ -0,0 +1,78 @@
+"""Public API for reading Agentfiles and generating their Dockerfile from other tools.
+
+The names in __all__ are the supported surface; the other modules may change between releases.
+"""
+
+from agentman.agent_builder import AgentBuilder
+from agentman.agentfile_parser import (
+    Agent,
+    AgentfileConfig,
+    AgentfileError,
+    AgentfileParser,
+    BuildStage,
+    Chain,
+    DockerfileInstruction,
+    Healthcheck,
+    MCPServer,
+    Orchestrator,
+    Parallel,
+    Router,
+    SecretContext,
+    SecretValue,
+    Serve,
+    Statement,
+    import_claude_config,
+    import_mcp_json,
+    parse,
+    parse_ast,
+)
+from agentman.agentfile_writer import write_agentfile
+from agentman.diagnostics import Diagnostic, Finding
+from agentman.formatter import format_agentfile
+from agentman.lint import Rule, lint_config
+from agentman.manifest import build_manifest
+
+__all__ = [
+    "Agent",
+    "AgentfileConfig",
+    "AgentfileError",
+    "AgentfileParser",
+    "BuildStage",
+    "Chain",
+    "Diagnostic",
+    "DockerfileInstruction",
+    "Finding",
+    "Healthcheck",
+    "MCPServer",
+    "Orchestrator",
+    "Parallel",
+    "Router",
+    "Rule",
+    "SecretContext",
+    "SecretValue",
+    "Serve",
+    "Statement",
+    "build_manifest",
+    "format_agentfile",
+    "generate_dockerfile",
+    "import_claude_config",
+    "import_mcp_json",
+    "lint_config",
+    "parse",
+    "parse_ast",
+    "parse_file",
+    "write_agentfile",
+]
+
+
+def parse_file(path: str, **options) -> AgentfileConfig:
+    """Parse the Agentfile at path; options are those of AgentfileParser."""
+    return AgentfileParser(**options).parse_file(path)
+
+
+def generate_dockerfile(config: AgentfileConfig, source_dir: str = ".", **options) -> str:
+    """Return the Dockerfile agentman build writes for a configuration, without writing any file.
+
+    source_dir is where prompt.txt is looked for; options are those of AgentBuilder.
+    """
+    return AgentBuilder(config, source_dir=source_dir, **options).dockerfile_content()
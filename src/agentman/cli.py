@@ -2,12 +2,30 @@
 
 import argparse
 import errno
+import json
+import os
+import shlex
 import subprocess
 import sys
 from pathlib import Path
 
-from agentman.agent_builder import build_from_agentfile
+from agentman.agent_builder import AgentBuilder, build_from_agentfile
+from agentman.agentfile_parser import DEFAULT_BASE_IMAGE, DEFAULT_FRAMEWORK, FRAMEWORKS, AgentfileParser
+from agentman.agentfile_writer import write_agentfile
 from agentman.common import perror
+from agentman.diagnostics import count_warnings, format_diagnostics
+from agentman.doctor import STATUS_FAIL, run_checks, run_host_checks
+from agentman.dryrun import dry_run
+from agentman.environment import collect_environment
+from agentman.formatter import format_statements
+from agentman.lint import RULES, lint_config
+from agentman.lockfile import generate_lock, lockfile_path, write_lock
+from agentman.manifest import MANIFEST_LABEL, describe_manifest, manifest_from_labels
+from agentman.migrate import migrate_content
+from agentman.registry import fetch_image_labels
+from agentman.remote import fetch_agentfile, is_remote
+from agentman.server_registry import load_server_registry
+from agentman.stats import Stats
 from agentman.version import print_version
 
 
@@ -38,6 +56,142 @@ def resolve_context_path(path):
     return context_path
 
 
+def resolve_agentfile_path(args, context_path):
+    """Locate the Agentfile, fetching it first when -f names an HTTPS URL."""
+    if is_remote(args.file):
+        try:
+            return fetch_agentfile(args.file, sha256=args.sha256, insecure_http=args.insecure_http)
+        except (IOError, ValueError) as e:
+            perror(str(e))
+            sys.exit(1)
+
+    agentfile_path = context_path / args.file
+    if not agentfile_path.exists():
+        perror(f"Agentfile not found: {agentfile_path}")
+        sys.exit(1)
+    return agentfile_path
+
+
+def remote_options(parser):
+    """Add the options for Agentfiles fetched from a URL."""
+    parser.add_argument("--sha256", help="Expected sha256 digest of an Agentfile given as a URL with -f")
+    parser.add_argument("--insecure-http", action="store_true", help="Allow fetching the Agentfile over plain HTTP")
+
+
+def parser_options(parser):
+    """Add the options that fill placeholders in Agentfile values and restrict the accepted syntax."""
+    parser.add_argument(
+        "--build-arg",
+        action="append",
+        default=[],
+        metavar="NAME=VALUE",
+        help="Set a build arg for ${NAME} placeholders in Agentfile values and in docker build",
+    )
+    parser.add_argument(
+        "--env-lookup",
+        action="store_true",
+        help="Replace ${env:NAME} placeholders with values from the current environment",
+    )
+    parser.add_argument(
+        "--no-server-alias",
+        action="store_true",
+        help="Reject the deprecated SERVER keyword instead of warning about it",
+    )
+    parser.add_argument(
+        "--default-base-image",
+        default=DEFAULT_BASE_IMAGE,
+        help="Base image for Agentfiles without a FROM (default: %(default)s)",
+    )
+    parser.add_argument(
+        "--default-framework",
+        default=DEFAULT_FRAMEWORK,
+        choices=FRAMEWORKS,
+        help="Framework for Agentfiles without a FRAMEWORK (default: %(default)s)",
+    )
+    parser.add_argument(
+        "--default-cmd",
+        type=shlex.split,
+        help="Command for Agentfiles without a CMD, as a shell-quoted string "
+        "(default: the one that starts the generated agent, such as python agent.py)",
+    )
+    parser.add_argument("--strict", action="store_true", help="Stop at the first parser warning, as an error")
+    parser.add_argument(
+        "--external-server",
+        action="append",
+        default=[],
+        metavar="NAME",
+        help="Let agents use a server the base image provides instead of an MCP_SERVER block",
+    )
+    parser.add_argument(
+        "--route-to-workflows",
+        action="store_true",
+        help="Let ROUTER AGENTS name chains, orchestrators and other routers as well as agents",
+    )
+    parser.add_argument(
+        "--server-registry",
+        metavar="PATH",
+        help="JSON file of servers an MCP_SERVER block may name without COMMAND or URL, added to the built-in ones",
+    )
+    parser.add_argument(
+        "--allow-override",
+        action="store_true",
+        help="Let IMPORT_MCP servers and MCP_SERVER blocks of the same name replace each other, the later one winning",
+    )
+
+
+def parse_build_args(values):
+    """Turn NAME=VALUE strings into a dict; a bare NAME takes its value from the environment, as docker does."""
+    build_args = {}
+    for value in values:
+        name, has_value, arg_value = value.partition("=")
+        if not has_value:
+            if name not in os.environ:
+                continue
+            arg_value = os.environ[name]
+        build_args[name] = arg_value
+    return build_args
+
+
+def env_lookup(args):
+    """Return the ${env:NAME} lookup the options ask for, or None to keep the build hermetic."""
+    return os.environ.get if args.env_lookup else None
+
+
+def server_registry(args):
+    """Return the server registry the options ask for, exiting when its file cannot be read."""
+    try:
+        return load_server_registry(args.server_registry)
+    except (OSError, ValueError) as e:
+        perror(f"Failed to load server registry: {e}")
+        sys.exit(1)
+
+
+def include_options(args) -> dict:
+    """Return the parser options for fetching the INCLUDEs of an Agentfile given as a URL with -f."""
+    file = getattr(args, "file", None)
+    if not file or not is_remote(file):
+        return {}
+    return {"source_url": file, "insecure_http": getattr(args, "insecure_http", False)}
+
+
+def agentfile_parser(args):
+    """Create a parser configured by the parser options."""
+    return AgentfileParser(
+        **include_options(args),
+        build_args=parse_build_args(args.build_arg),
+        env_lookup=env_lookup(args),
+        allow_server_alias=not args.no_server_alias,
+        default_base_image=args.default_base_image,
+        default_framework=args.default_framework,
+        default_cmd=args.default_cmd,
+        strict=args.strict,
+        external_servers=args.external_server,
+        route_to_workflows=args.route_to_workflows,
+        server_registry=server_registry(args),
+        allow_override=args.allow_override,
+    )
+
+
 def safe_subprocess_run(cmd_args, check=True):
     """Safely run subprocess with validated arguments."""
     # Ensure all arguments are strings and properly escaped
@@ -123,11 +277,7 @@ def build_cli(args):
     context_path = resolve_context_path(args.path)
 
     # Construct the Agentfile path relative to context
-    agentfile_path = context_path / args.file
-
-    if not agentfile_path.exists():
-        perror(f"Agentfile not found: {agentfile_path}")
-        sys.exit(1)
+    agentfile_path = resolve_agentfile_path(args, context_path)
 
     # Determine output directory relative to context
     if args.output:
@@ -135,12 +285,43 @@ def build_cli(args):
     else:
         output_dir = context_path / "agent"
 
+    stats = Stats()
+    build_args = parse_build_args(args.build_arg)
     try:
-        build_from_agentfile(str(agentfile_path), str(output_dir))
+        build_from_agentfile(
+            str(agentfile_path),
+            str(output_dir),
+            source_dir=str(context_path),
+            base_image=args.base_image,
+            base_image_stage=args.base_image_stage,
+            offline=args.offline,
+            frozen_lock=args.frozen_lock,
+            combined_config=args.combined_config,
+            no_color=args.no_color,
+            annotate=args.annotate,
+            stats=stats,
+            run_hints_tag=args.tag if args.print_run_hints else None,
+            prune=args.prune_unused,
+            fail_on_warn=args.fail_on_warn,
+            verify_configs=not args.no_verify_configs,
+            inline_instructions=args.inline_instructions,
+            copy_config=args.copy_config,
+            runtime_secrets=args.runtime_secrets,
+            rootless=args.rootless,
+            parser=agentfile_parser(args),
+        )
+
+        if args.stats:
+            perror(stats.render_table())
+        if args.stats_json:
+            perror(json.dumps(stats.to_dict()))
 
         if args.build_docker:
             print("\n🐳 Building Docker image...")
-            docker_cmd = ["docker", "build", "-t", args.tag, str(output_dir)]
+            docker_cmd = ["docker", "build", "-t", args.tag]
+            for name, value in build_args.items():
+                docker_cmd.extend(["--build-arg", f"{name}={value}"])
+            docker_cmd.append(str(output_dir))
             safe_subprocess_run(docker_cmd, check=True)
             print(f"✅ Docker image built: {args.tag}")
 
@@ -158,9 +339,73 @@ def build_parser(subparsers):
     parser.add_argument(
         "--build-docker", action="store_true", help="Also build the Docker image after generating files"
     )
+    parser.add_argument("--base-image", help="Override the base image declared by FROM in the Agentfile")
+    parser.add_argument(
+        "--base-image-stage",
+        help="Name or index of the build stage whose FROM --base-image replaces (default: the agent stage)",
+    )
+    parser.add_argument(
+        "--offline",
+        action="store_true",
+        help="Vendor pip/npm packages into the output directory so the image builds without network access",
+    )
+    parser.add_argument(
+        "--frozen-lock", action="store_true", help="Fail when the Agentfile.lock is missing or lacks a package"
+    )
+    parser.add_argument(
+        "--combined-config",
+        action="store_true",
+        help="Write a single framework config file that includes the secrets instead of separate files",
+    )
+    parser.add_argument(
+        "--copy-config",
+        action="store_true",
+        help="Copy fastagent.config.yaml from the build context instead of writing it into the Dockerfile",
+    )
+    parser.add_argument(
+        "--runtime-secrets",
+        action="store_true",
+        help="Write fastagent.secrets.yaml from environment variables when the container starts, not into the image",
+    )
+    parser.add_argument(
+        "--rootless",
+        action="store_true",
+        help="Run the container as an unprivileged agent user unless the Agentfile sets USER",
+    )
+    parser.add_argument("--no-color", action="store_true", help="Print diagnostics as plain one-line messages")
+    parser.add_argument("--fail-on-warn", action="store_true", help="Fail the build when the parser reports warnings")
+    parser.add_argument(
+        "--annotate",
+        action="store_true",
+        help="Prefix generated blocks with comments naming the Agentfile line that produced them",
+    )
+    parser.add_argument(
+        "--no-verify-configs",
+        action="store_true",
+        help="Skip checking the generated config files during docker build, for images without Python",
+    )
+    parser.add_argument(
+        "--inline-instructions",
+        action="store_true",
+        help="Embed INSTRUCTION_FILE contents in the generated agent instead of copying the files into the image",
+    )
+    parser.add_argument("--stats", action="store_true", help="Print parse and generation metrics to stderr")
+    parser.add_argument("--stats-json", action="store_true", help="Print parse and generation metrics as JSON")
+    parser.add_argument(
+        "--print-run-hints",
+        action="store_true",
+        help="Print the docker build and docker run commands the generated image needs",
+    )
+    parser.add_argument(
+        "--prune-unused",
+        action="store_true",
+        help="Leave out agents, workflows and servers the DEFAULT entity never reaches",
+    )
     parser.add_argument("path", nargs="?", default=".", help="Build context (directory or URL)")
     parser.usage = "agentman build [OPTIONS] PATH | URL | -"
     runtime_options(parser, "build")
+    remote_options(parser)
+    parser_options(parser)
     parser.set_defaults(func=build_cli)
 
 
@@ -172,11 +417,7 @@ def run_cli(args):
         context_path = resolve_context_path(args.path)
 
         # Construct the Agentfile path relative to context
-        agentfile_path = context_path / args.file
-
-        if not agentfile_path.exists():
-            perror(f"Agentfile not found: {agentfile_path}")
-            sys.exit(1)
+        agentfile_path = resolve_agentfile_path(args, context_path)
 
         # Determine output directory relative to context
         if args.output:
@@ -186,7 +427,14 @@ def run_cli(args):
 
         try:
             print("🔨 Building agent files...")
-            build_from_agentfile(str(agentfile_path), str(output_dir))
+            build_from_agentfile(
+                str(agentfile_path),
+                str(output_dir),
+                source_dir=str(context_path),
+                base_image=args.base_image,
+                base_image_stage=args.base_image_stage,
+                parser=AgentfileParser(**include_options(args)),
+            )
 
             print("\n🐳 Building Docker image...")
             docker_cmd = ["docker", "build", "-t", args.tag, str(output_dir)]
@@ -278,6 +526,13 @@ def run_parser(subparsers):
         help="Build from Agentfile and then run " "(default is to run existing image)",
     )
     parser.add_argument("--path", default=".", help="Build context (directory or URL) " "when building from Agentfile")
+    parser.add_argument(
+        "--base-image", help="Override the base image declared by FROM (when building from Agentfile)"
+    )
+    parser.add_argument(
+        "--base-image-stage",
+        help="Name or index of the build stage whose FROM --base-image replaces (default: the agent stage)",
+    )
     parser.add_argument("-i", "--interactive", action="store_true", help="Run container interactively")
     parser.add_argument(
         "--rm", dest="remove", action="store_true", help="Automatically remove the container when it exits"
@@ -291,9 +546,416 @@ def run_parser(subparsers):
     parser.add_argument("-v", "--volume", action="append", help="Bind mount volumes (can be used multiple times)")
     parser.add_argument("command", nargs="*", help="Command to run in the container (overrides default)")
     runtime_options(parser, "run")
+    remote_options(parser)
     parser.set_defaults(func=run_cli)
 
 
+def secrets_cli(args):
+    """Print the environment variables an Agentfile requires as JSON."""
+    context_path = resolve_context_path(args.path)
+    agentfile_path = context_path / args.file
+
+    if not agentfile_path.exists():
+        perror(f"Agentfile not found: {agentfile_path}")
+        sys.exit(1)
+
+    config = AgentfileParser().parse_file(str(agentfile_path))
+    entries = [entry.to_dict() for entry in collect_environment(config)]
+    print(json.dumps(entries, indent=2))
+
+
+def secrets_parser(subparsers):
+    """Configure the secrets subcommand parser."""
+    parser = subparsers.add_parser("secrets", help="List the secrets and environment variables an agent reads")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument("path", nargs="?", default=".", help="Build context (directory or URL)")
+    parser.set_defaults(func=secrets_cli)
+
+
+def lock_cli(args):
+    """Resolve package versions and write the lockfile next to the Agentfile."""
+    context_path = resolve_context_path(args.path)
+    agentfile_path = context_path / args.file
+
+    if not agentfile_path.exists():
+        perror(f"Agentfile not found: {agentfile_path}")
+        sys.exit(1)
+
+    config = AgentfileParser().parse_file(str(agentfile_path))
+    builder = AgentBuilder(config, source_dir=str(context_path))
+    lock = generate_lock(builder.get_packages())
+
+    path = lockfile_path(str(agentfile_path))
+    write_lock(path, lock)
+    print(f"🔒 Locked {len(lock['packages'])} packages in {path}")
+
+
+def lock_parser(subparsers):
+    """Configure the lock subcommand parser."""
+    parser = subparsers.add_parser("lock", help="Pin MCP server and Python packages in an Agentfile.lock")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument("path", nargs="?", default=".", help="Build context (directory or URL)")
+    parser.set_defaults(func=lock_cli)
+
+
+def override_lines(args) -> list:
+    """Return the Agentfile lines --profile and --set apply after the Agentfile, the profile's first."""
+    lines = []
+    if args.profile:
+        # The profile file sits next to the Agentfile, which INCLUDE paths are relative to, even for a URL
+        lines.append(f'INCLUDE "{Path(args.file).name}.{args.profile}"')
+    for setting in args.set:
+        keyword, sep, value = setting.partition("=")
+        if not sep or not keyword.strip():
+            raise ValueError(f"--set {setting} is not INSTRUCTION=VALUE, such as MODEL=openai/gpt-4o")
+        lines.append(f"{keyword.strip()} {value}")
+    return lines
+
+
+def render_cli(args):
+    """Print the fully resolved Agentfile that generation consumes."""
+    context_path = resolve_context_path(args.path)
+    agentfile_path = resolve_agentfile_path(args, context_path)
+
+    try:
+        parser = agentfile_parser(args)
+        parser.overrides = override_lines(args)
+        config = parser.parse_file(str(agentfile_path))
+    except ValueError as e:
+        perror(f"Failed to parse {agentfile_path}: {e}")
+        sys.exit(1)
+
+    if args.base_image:
+        try:
+            config.override_base_image(args.base_image, args.base_image_stage)
+        except ValueError as e:
+            perror(str(e))
+            sys.exit(1)
+    print(write_agentfile(config, source=args.file), end="")
+
+
+def render_parser(subparsers):
+    """Configure the render subcommand parser."""
+    parser = subparsers.add_parser("render", help="Print the resolved, canonical Agentfile")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument(
+        "--profile",
+        help="Apply the Agentfile lines in <Agentfile>.<PROFILE>, such as Agentfile.dev, after the Agentfile",
+    )
+    parser.add_argument(
+        "--set",
+        action="append",
+        default=[],
+        metavar="INSTRUCTION=VALUE",
+        help="Apply a top-level instruction after the Agentfile and profile, such as MODEL=openai/gpt-4o",
+    )
+    parser.add_argument("--base-image", help="Override the base image declared by FROM in the Agentfile")
+    parser.add_argument(
+        "--base-image-stage",
+        help="Name or index of the build stage whose FROM --base-image replaces (default: the agent stage)",
+    )
+    parser.add_argument("path", nargs="?", default=".", help="Build context (directory or URL)")
+    remote_options(parser)
+    parser_options(parser)
+    parser.set_defaults(func=render_cli)
+
+
+def migrate_cli(args):
+    """Rewrite deprecated Agentfile syntax into the current form."""
+    context_path = resolve_context_path(args.path)
+    agentfile_path = context_path / args.file
+    if not agentfile_path.exists():
+        perror(f"Agentfile not found: {agentfile_path}")
+        sys.exit(1)
+
+    try:
+        result = migrate_content(agentfile_path.read_text(encoding='utf-8'))
+    except ValueError as e:
+        perror(f"Failed to migrate {agentfile_path}: {e}")
+        sys.exit(1)
+
+    if args.write:
+        agentfile_path.write_text(result.text, encoding='utf-8')
+    else:
+        print(result.text, end="")
+
+    for rule, count in result.applied.items():
+        perror(f"✅ {rule}: rewrote {count} instruction(s)")
+    if not result.applied:
+        perror(f"✅ {agentfile_path.name} already uses the current syntax")
+    for note in result.manual:
+        perror(f"⚠️  {note}")
+
+
+def migrate_parser(subparsers):
+    """Configure the migrate subcommand parser."""
+    parser = subparsers.add_parser("migrate", help="Rewrite deprecated Agentfile syntax into the current form")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument("--write", action="store_true", help="Rewrite the Agentfile in place instead of printing it")
+    parser.add_argument("path", nargs="?", default=".", help="Build context containing the Agentfile")
+    parser.set_defaults(func=migrate_cli)
+
+
+def fmt_cli(args):
+    """Rewrite an Agentfile in canonical form, or check that it already is."""
+    context_path = resolve_context_path(args.path)
+    agentfile_path = context_path / args.file
+    if not agentfile_path.exists():
+        perror(f"Agentfile not found: {agentfile_path}")
+        sys.exit(1)
+
+    source = agentfile_path.read_text(encoding='utf-8')
+    parser = agentfile_parser(args)
+    try:
+        config = parser.parse_file(str(agentfile_path))
+    except ValueError as e:
+        perror(f"Failed to parse {agentfile_path}: {e}")
+        sys.exit(1)
+    formatted = format_statements(parser.statements, source, config.directive_lines(), args.indent)
+
+    if args.check:
+        if formatted != source:
+            perror(f"{agentfile_path.name} is not formatted; run `agentman fmt -w`")
+            sys.exit(1)
+    elif args.write:
+        if formatted != source:
+            agentfile_path.write_text(formatted, encoding='utf-8')
+            perror(f"✅ Formatted {agentfile_path.name}")
+    else:
+        print(formatted, end="")
+
+
+def fmt_parser(subparsers):
+    """Configure the fmt subcommand parser."""
+    parser = subparsers.add_parser("fmt", help="Rewrite an Agentfile in canonical form")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument(
+        "-w", "--write", action="store_true", help="Rewrite the Agentfile in place instead of printing it"
+    )
+    parser.add_argument(
+        "--check", action="store_true", help="Print nothing and exit with an error when the Agentfile would change"
+    )
+    parser.add_argument(
+        "--indent", type=int, default=0, help="Spaces to indent the sub-instructions of a block by (default: 0)"
+    )
+    parser.add_argument("path", nargs="?", default=".", help="Build context containing the Agentfile")
+    parser_options(parser)
+    parser.set_defaults(func=fmt_cli)
+
+
+def lint_cli(args):
+    """Run static checks over an Agentfile."""
+    if args.list_rules:
+        for rule in RULES:
+            print(f"{rule.id:<28} {rule.description}")
+        return
+
+    context_path = resolve_context_path(args.path)
+    agentfile_path = resolve_agentfile_path(args, context_path)
+
+    # --disable takes rule IDs or the code of any parser warning, repeated or comma-separated
+    disabled = {code.strip() for value in args.disable for code in value.split(",") if code.strip()}
+    parser = agentfile_parser(args)
+    config = parser.parse_file(str(agentfile_path))
+    diagnostics = [
+        diagnostic
+        for diagnostic in parser.diagnostics + lint_config(config, disabled)
+        if diagnostic.code not in disabled
+    ]
+    if args.format == "json":
+        print(json.dumps([diagnostic.to_dict() for diagnostic in diagnostics], indent=2))
+    elif diagnostics:
+        source = agentfile_path.read_text(encoding='utf-8')
+        perror(format_diagnostics(diagnostics, source, agentfile_path.name, sys.stderr, args.no_color))
+
+    if any(diagnostic.severity == "error" for diagnostic in diagnostics):
+        sys.exit(1)
+    if args.fail_on_warn and count_warnings(diagnostics):
+        sys.exit(1)
+
+
+def lint_parser(subparsers):
+    """Configure the lint subcommand parser."""
+    parser = subparsers.add_parser("lint", help="Run static checks over an Agentfile")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument("--no-color", action="store_true", help="Print diagnostics as plain one-line messages")
+    parser.add_argument("--fail-on-warn", action="store_true", help="Exit with an error when there are warnings")
+    parser.add_argument(
+        "--format", choices=["text", "json"], default="text", help="Print diagnostics as text or as a JSON list"
+    )
+    parser.add_argument(
+        "--disable",
+        action="append",
+        default=[],
+        metavar="RULE",
+        help="Skip a rule or parser warning by ID; repeat or separate IDs with commas",
+    )
+    parser.add_argument("--list-rules", action="store_true", help="List the lint rules and exit")
+    parser.add_argument("path", nargs="?", default=".", help="Build context (directory or URL)")
+    remote_options(parser)
+    parser_options(parser)
+    parser.set_defaults(func=lint_cli)
+
+
+def validate_cli(args):
+    """Run the semantic checks over an Agentfile and report every finding instead of stopping at the first."""
+    context_path = resolve_context_path(args.path)
+    agentfile_path = resolve_agentfile_path(args, context_path)
+
+    parser = agentfile_parser(args)
+    parser.validate = False
+    findings = parser.parse_file(str(agentfile_path)).validate()
+    if args.format == "json":
+        print(json.dumps([finding.to_dict() for finding in findings], indent=2))
+    elif findings:
+        for finding in findings:
+            location = f"{agentfile_path.name}:{finding.line}" if finding.line else agentfile_path.name
+            perror(f"{location}: {finding}")
+    else:
+        perror(f"✅ {agentfile_path.name} is valid")
+
+    # The exit code follows the most severe finding: 2 for errors, 1 for warnings
+    severities = {finding.severity for finding in findings}
+    if "error" in severities:
+        sys.exit(2)
+    if "warning" in severities:
+        sys.exit(1)
+
+
+def validate_parser(subparsers):
+    """Configure the validate subcommand parser."""
+    parser = subparsers.add_parser("validate", help="Check that an Agentfile's references and settings fit together")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument(
+        "--format", choices=["text", "json"], default="text", help="Print findings as text or as a JSON list"
+    )
+    parser.add_argument("path", nargs="?", default=".", help="Build context (directory or URL)")
+    remote_options(parser)
+    parser_options(parser)
+    parser.set_defaults(func=validate_cli)
+
+
+def doctor_cli(args):
+    """Check that the local environment can build and run agent images."""
+    config = None
+    if args.path is not None:
+        agentfile_path = resolve_context_path(args.path) / args.file
+        if not agentfile_path.exists():
+            perror(f"Agentfile not found: {agentfile_path}")
+            sys.exit(1)
+        try:
+            config = AgentfileParser().parse_file(str(agentfile_path))
+        except ValueError as e:
+            perror(f"Failed to parse {agentfile_path}: {e}")
+            sys.exit(1)
+
+    checks = run_checks(config)
+    for check in checks:
+        print(check)
+
+    failed = [check for check in checks if check.status == STATUS_FAIL]
+    if failed:
+        print(f"\n{len(failed)} check(s) failed")
+        sys.exit(1)
+    print("\nAll required checks passed")
+
+
+def doctor_parser(subparsers):
+    """Configure the doctor subcommand parser."""
+    parser = subparsers.add_parser("doctor", help="Check the local environment for building agent images")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument(
+        "path", nargs="?", default=None, help="Build context whose Agentfile features should be checked"
+    )
+    parser.set_defaults(func=doctor_cli)
+
+
+def dryrun_cli(args):
+    """Run an agent with the host Python before spending time on an image build."""
+    context_path = resolve_context_path(args.path)
+    agentfile_path = context_path / args.file
+    if not agentfile_path.exists():
+        perror(f"Agentfile not found: {agentfile_path}")
+        sys.exit(1)
+    try:
+        config = agentfile_parser(args).parse_file(str(agentfile_path))
+    except ValueError as e:
+        perror(f"Failed to parse {agentfile_path}: {e}")
+        sys.exit(1)
+
+    failed = [check for check in run_host_checks(config) if check.status == STATUS_FAIL]
+    if failed:
+        for check in failed:
+            perror(str(check))
+        perror(f"\n{len(failed)} check(s) failed; fix them before a dry run")
+        sys.exit(1)
+
+    try:
+        code = dry_run(config, str(context_path), agent=args.agent, prompt=args.prompt, keep=args.keep)
+    except ValueError as e:
+        perror(f"Dry run failed: {e}")
+        sys.exit(1)
+    sys.exit(code)
+
+
+def dryrun_parser(subparsers):
+    """Configure the dryrun subcommand parser."""
+    parser = subparsers.add_parser("dryrun", help="Run an agent with the host Python instead of an image")
+    parser.add_argument("-f", "--file", default="Agentfile", help="Name of the Agentfile")
+    parser.add_argument("--agent", help="Agent or workflow to run instead of the default one")
+    parser.add_argument("--prompt", help="Send this prompt and exit instead of starting an interactive session")
+    parser.add_argument("--keep", action="store_true", help="Keep the temporary directory with the generated files")
+    parser.add_argument("path", nargs="?", default=".", help="Build context containing the Agentfile")
+    parser_options(parser)
+    parser.set_defaults(func=dryrun_cli)
+
+
+def local_image_labels(image: str):
+    """Return the labels of an image the local docker daemon has, or None when docker or the image is missing."""
+    inspect_cmd = ["docker", "image", "inspect", "--format", "{{json .Config.Labels}}", image]
+    try:
+        result = subprocess.run(inspect_cmd, check=True, capture_output=True, text=True)
+    except (FileNotFoundError, subprocess.CalledProcessError):
+        return None
+    return json.loads(result.stdout or "null") or {}
+
+
+def inspect_cli(args):
+    """Show the Agentfile configuration embedded in a built image."""
+    labels = None if args.registry else local_image_labels(args.image)
+    if labels is None:
+        # Images that were never pulled are read from their registry: the manifest and config blob, no layers
+        try:
+            labels = fetch_image_labels(args.image)
+        except (OSError, ValueError, LookupError) as e:
+            perror(f"Unable to inspect image {args.image}: {e}")
+            sys.exit(1)
+
+    manifest = manifest_from_labels(labels)
+    if manifest is None:
+        perror(f"Image {args.image} has no {MANIFEST_LABEL} label; it was not built by this version of agentman")
+        sys.exit(1)
+
+    if args.json:
+        print(json.dumps(manifest, indent=2))
+    else:
+        print(f"Image:         {args.image}")
+        print(describe_manifest(manifest))
+
+
+def inspect_parser(subparsers):
+    """Configure the inspect subcommand parser."""
+    parser = subparsers.add_parser("inspect", help="Show the agent configuration embedded in a built image")
+    parser.add_argument("--json", action="store_true", help="Print the embedded manifest as JSON")
+    parser.add_argument(
+        "--registry",
+        action="store_true",
+        help="Read the image from its registry even when the local docker daemon has it",
+    )
+    parser.add_argument("image", help="Image reference to inspect")
+    parser.set_defaults(func=inspect_cli)
+
+
 def version_parser(subparsers):
     """Configure the version subcommand parser."""
     parser = subparsers.add_parser("version", help="Show the Agentman version information")
@@ -318,6 +980,16 @@ def configure_subcommands(parser):
     subparsers.required = False
     build_parser(subparsers)
     run_parser(subparsers)
+    secrets_parser(subparsers)
+    lock_parser(subparsers)
+    lint_parser(subparsers)
+    validate_parser(subparsers)
+    migrate_parser(subparsers)
+    fmt_parser(subparsers)
+    render_parser(subparsers)
+    inspect_parser(subparsers)
+    doctor_parser(subparsers)
+    dryrun_parser(subparsers)
     help_parser(subparsers)
     version_parser(subparsers)
 
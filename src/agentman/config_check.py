@@ -0,0 +1,100 @@
+"""Build-time check that the generated configuration files load and have the expected shape."""
+
+import json
+from typing import Dict, List
+
+from agentman.manifest import MANIFEST_FILENAME
+
+CONFIG_CHECK_FILENAME = "check_configs.py"
+
+# Per generated file: how to load it, the top-level keys it must have, and the dotted paths
+# that must be mappings when present ("*" matches every key of a mapping)
+CONFIG_SCHEMAS = {
+    "fastagent.config.yaml": {
+        "format": "yaml",
+        "required": ["default_model"],
+        "mappings": ["logger", "mcp", "mcp.servers", "mcp.servers.*"],
+    },
+    "fastagent.secrets.yaml": {"format": "yaml", "required": [], "mappings": ["mcp", "mcp.servers", "mcp.servers.*"]},
+    ".env": {"format": "dotenv", "required": [], "mappings": []},
+    MANIFEST_FILENAME: {
+        "format": "json",
+        "required": ["schema_version", "framework", "servers", "agents"],
+        "mappings": ["servers", "servers.*", "agents", "agents.*"],
+    },
+}
+
+
+def config_check_schemas(files: List[str]) -> Dict[str, dict]:
+    """Return the schemas of the generated files that have one."""
+    return {name: CONFIG_SCHEMAS[name] for name in files if name in CONFIG_SCHEMAS}
+
+
+def build_config_check_script(files: List[str]) -> str:
+    """Render a script that loads each generated config file and names the first one that is malformed."""
+    schemas = config_check_schemas(files)
+    return f'''"""Fail the image build when a generated configuration file is malformed."""
+
+import json
+import re
+import sys
+
+SCHEMAS = {json.dumps(schemas, indent=4)}
+ENV_LINE = re.compile(r"^[A-Za-z_][A-Za-z0-9_]*=")
+
+
+def load(name, schema):
+    with open(name, encoding="utf-8") as f:
+        text = f.read()
+    if schema["format"] == "json":
+        return json.loads(text)
+    if schema["format"] == "yaml":
+        import yaml
+
+        return yaml.safe_load(text) or {{}}
+    for number, line in enumerate(text.splitlines(), 1):
+        if line.strip() and not line.lstrip().startswith("#") and not ENV_LINE.match(line):
+            raise ValueError(f"line {{number}} is not NAME=value")
+    return {{}}
+
+
+def values_at(data, path):
+    values = [("", data)]
+    for key in path.split("."):
+        found = []
+        for prefix, value in values:
+            if not isinstance(value, dict):
+                continue
+            keys = list(value) if key == "*" else [key] if key in value else []
+            found.extend((f"{{prefix}}{{name}}.", value[name]) for name in keys)
+        values = found
+    return [(prefix[:-1], value) for prefix, value in values]
+
+
+def check(name, schema):
+    data = load(name, schema)
+    if not isinstance(data, dict):
+        raise ValueError(f"expected a mapping at the top level, got {{type(data).__name__}}")
+    missing = [key for key in schema["required"] if key not in data]
+    if missing:
+        raise ValueError(f"missing {{', '.join(missing)}}")
+    for path in schema["mappings"]:
+        for key, value in values_at(data, path):
+            if not isinstance(value, dict):
+                raise ValueError(f"{{key}} should be a mapping, got {{type(value).__name__}}")
+
+
+def main() -> int:
+    for name, schema in SCHEMAS.items():
+        try:
+            check(name, schema)
+        except Exception as e:
+            print(f"{{name}}: {{e}}", file=sys.stderr)
+            return 1
+    print(f"Checked {{len(SCHEMAS)}} configuration file(s)")
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
+'''
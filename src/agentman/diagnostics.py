@@ -0,0 +1,101 @@
+"""Diagnostics reported while checking Agentfiles."""
+
+import os
+from dataclasses import asdict, dataclass
+from typing import Dict, List, Optional, TextIO
+
+SEVERITY_ERROR = "error"
+SEVERITY_WARNING = "warning"
+SEVERITY_INFO = "info"
+
+SEVERITY_COLORS = {SEVERITY_ERROR: "\033[31m", SEVERITY_WARNING: "\033[33m", SEVERITY_INFO: "\033[36m"}
+BOLD = "\033[1m"
+RESET = "\033[0m"
+
+
+@dataclass
+class Diagnostic:
+    """Represents a single finding about an Agentfile."""
+
+    severity: str
+    code: str
+    message: str
+    line: Optional[int] = None
+    column: Optional[int] = None  # 1-based column of the offending token
+
+    def to_dict(self) -> Dict[str, object]:
+        """Convert to a JSON-serializable dictionary."""
+        return asdict(self)
+
+    def __str__(self) -> str:
+        location = f"line {self.line}: " if self.line is not None else ""
+        return f"{location}{self.severity}: {self.message} [{self.code}]"
+
+
+@dataclass
+class Finding:
+    """Represents a semantic problem with a configuration, located by a path rather than a source line."""
+
+    severity: str
+    code: str
+    path: str  # What the finding is about, such as agents.coder.servers[1]
+    message: str
+    line: Optional[int] = None  # Declaration line of what the path names, when the configuration came from a file
+
+    def to_dict(self) -> Dict[str, object]:
+        """Convert to a JSON-serializable dictionary."""
+        return asdict(self)
+
+    def __str__(self) -> str:
+        return f"{self.path}: {self.severity}: {self.message} [{self.code}]"
+
+
+def count_warnings(diagnostics: List[Diagnostic]) -> int:
+    """Return how many diagnostics are warnings, the findings --fail-on-warn turns into failures."""
+    return sum(1 for diagnostic in diagnostics if diagnostic.severity == SEVERITY_WARNING)
+
+
+def use_color(stream: TextIO, no_color: bool = False) -> bool:
+    """Decide whether diagnostics written to a stream should be rendered with color and snippets."""
+    if no_color or "NO_COLOR" in os.environ:
+        return False
+    return hasattr(stream, "isatty") and stream.isatty()
+
+
+def render_diagnostic(diagnostic: Diagnostic, source_lines: List[str], filename: str, color: bool = False) -> str:
+    """Render a diagnostic with the offending source line and a caret under the bad token."""
+
+    def paint(text: str, *codes: str) -> str:
+        return "".join(codes) + text + RESET if color else text
+
+    location = filename
+    if diagnostic.line is not None:
+        location += f":{diagnostic.line}"
+        if diagnostic.column is not None:
+            location += f":{diagnostic.column}"
+    severity = paint(diagnostic.severity, BOLD, SEVERITY_COLORS.get(diagnostic.severity, ""))
+    lines = [f"{paint(location, BOLD)}: {severity}: {diagnostic.message} [{diagnostic.code}]"]
+
+    if diagnostic.line is None or not 0 < diagnostic.line <= len(source_lines):
+        return "\n".join(lines)
+
+    source = source_lines[diagnostic.line - 1].rstrip()
+    gutter = " " * len(str(diagnostic.line))
+    lines.append(f" {diagnostic.line} | {source}")
+    if diagnostic.column is not None and diagnostic.column <= len(source):
+        start = diagnostic.column - 1
+        token = source[start:].split(maxsplit=1)
+        width = len(token[0]) if token else 1
+        caret = paint("^" * width, SEVERITY_COLORS.get(diagnostic.severity, ""))
+        lines.append(f" {gutter} | {' ' * start}{caret}")
+    return "\n".join(lines)
+
+
+def format_diagnostics(
+    diagnostics: List[Diagnostic], source: str, filename: str, stream: TextIO, no_color: bool = False
+) -> str:
+    """Format diagnostics for a stream, with snippets on terminals and one line each otherwise."""
+    if not use_color(stream, no_color):
+        return "\n".join(f"{filename}: {diagnostic}" for diagnostic in diagnostics)
+    source_lines = source.split("\n")
+    return "\n\n".join(render_diagnostic(diagnostic, source_lines, filename, color=True) for diagnostic in diagnostics)
@@ -0,0 +1,204 @@
+"""Environment checks for building and running agent images."""
+
+import importlib.util
+import json
+import shutil
+import subprocess
+import sys
+import urllib.error
+import urllib.request
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Callable, List, Optional
+
+from agentman.agentfile_parser import DOCKER_SOCKET_MOUNT, AgentfileConfig
+
+STATUS_PASS = "pass"
+STATUS_WARN = "warn"
+STATUS_FAIL = "fail"
+
+STATUS_ICONS = {STATUS_PASS: "✅", STATUS_WARN: "⚠️ ", STATUS_FAIL: "❌"}
+
+DEFAULT_REGISTRY = "docker.io"
+REGISTRY_ENDPOINTS = {"docker.io": "registry-1.docker.io"}
+PROBE_TIMEOUT = 10
+
+# The Python generated agents need, and the module each framework is imported as
+MIN_PYTHON = (3, 10)
+FRAMEWORK_MODULES = {"fast-agent": ("mcp_agent", "fast-agent-mcp"), "agno": ("agno", "agno")}
+
+
+@dataclass
+class Check:
+    """Represents the result of a single environment check."""
+
+    name: str
+    status: str
+    message: str
+    hint: Optional[str] = None
+
+    def __str__(self) -> str:
+        line = f"{STATUS_ICONS[self.status]} {self.name}: {self.message}"
+        if self.hint and self.status != STATUS_PASS:
+            line += f"\n   → {self.hint}"
+        return line
+
+
+def probe_url(url: str) -> int:
+    """Return the HTTP status of a URL, raising OSError when it is unreachable."""
+    request = urllib.request.Request(url, method="GET")
+    try:
+        with urllib.request.urlopen(request, timeout=PROBE_TIMEOUT) as response:  # nosec - registry endpoints
+            return response.status
+    except urllib.error.HTTPError as e:
+        return e.code
+
+
+def image_registry(image: str) -> str:
+    """Return the registry host an image reference is pulled from."""
+    first, _, rest = image.partition("/")
+    if rest and ("." in first or ":" in first or first == "localhost"):
+        return first
+    return DEFAULT_REGISTRY
+
+
+def check_command(name: str, purpose: str, which: Callable = shutil.which, required: bool = True) -> Check:
+    """Check that a command is available on PATH."""
+    path = which(name)
+    if path:
+        return Check(name, STATUS_PASS, f"found at {path}")
+    return Check(
+        name,
+        STATUS_FAIL if required else STATUS_WARN,
+        f"{name} not found on PATH",
+        f"Install {name}; it is needed to {purpose}",
+    )
+
+
+def check_docker_daemon(runner: Callable = subprocess.run) -> Check:
+    """Check that the docker daemon answers API requests."""
+    try:
+        result = runner(
+            ["docker", "version", "--format", "{{json .Server}}"], check=True, capture_output=True, text=True
+        )
+        server = json.loads(result.stdout or "null") or {}
+    except (subprocess.CalledProcessError, FileNotFoundError, ValueError):
+        return Check(
+            "docker daemon",
+            STATUS_FAIL,
+            "the docker daemon is not reachable",
+            "Start Docker and make sure your user can access the docker socket",
+        )
+    return Check(
+        "docker daemon",
+        STATUS_PASS,
+        f"Docker Engine {server.get('Version', 'unknown')} (API {server.get('ApiVersion', 'unknown')})",
+    )
+
+
+def check_buildx(runner: Callable = subprocess.run, required: bool = False) -> Check:
+    """Check that BuildKit is available through docker buildx."""
+    try:
+        result = runner(["docker", "buildx", "version"], check=True, capture_output=True, text=True)
+    except (subprocess.CalledProcessError, FileNotFoundError):
+        return Check(
+            "buildx",
+            STATUS_FAIL if required else STATUS_WARN,
+            "docker buildx is not available",
+            "Install the buildx plugin, or build with --copy-config; BuildKit runs heredocs and the syntax directive",
+        )
+    return Check("buildx", STATUS_PASS, result.stdout.strip() or "available")
+
+
+def check_registry(image: str, probe: Callable[[str], int] = probe_url) -> Check:
+    """Check that the registry serving an image is reachable."""
+    registry = image_registry(image)
+    url = f"https://{REGISTRY_ENDPOINTS.get(registry, registry)}/v2/"
+    try:
+        status = probe(url)
+    except OSError as e:
+        return Check(
+            "registry",
+            STATUS_WARN,
+            f"{registry} is not reachable: {e}",
+            "Check your network or proxy settings, or build with --offline",
+        )
+    # The registry API answers 401 to anonymous clients, which still proves it is reachable
+    if status in [200, 401]:
+        return Check("registry", STATUS_PASS, f"{registry} is reachable")
+    return Check("registry", STATUS_WARN, f"{registry} answered HTTP {status}", f"Run `docker login {registry}`")
+
+
+def check_python(version_info=sys.version_info) -> Check:
+    """Check that Python is new enough to run generated agents on the host."""
+    version = ".".join(str(part) for part in version_info[:3])
+    if tuple(version_info[:2]) >= MIN_PYTHON:
+        return Check("python", STATUS_PASS, f"Python {version}")
+    return Check(
+        "python",
+        STATUS_FAIL,
+        f"Python {version} is too old",
+        f"Use Python {'.'.join(str(part) for part in MIN_PYTHON)} or newer",
+    )
+
+
+def check_framework_package(framework: str, find_spec: Callable = importlib.util.find_spec) -> Check:
+    """Check that the framework a generated agent imports is installed on the host."""
+    module, package = FRAMEWORK_MODULES[framework]
+    if find_spec(module) is not None:
+        return Check(framework, STATUS_PASS, f"{module} is importable")
+    return Check(framework, STATUS_FAIL, f"{module} is not importable", f"Run `pip install {package}`")
+
+
+def check_docker_socket(socket_path: Path) -> Check:
+    """Check that the docker socket docker-launched servers need can be mounted."""
+    if socket_path.exists():
+        return Check("docker socket", STATUS_PASS, f"{socket_path} is present")
+    return Check(
+        "docker socket",
+        STATUS_FAIL,
+        f"{socket_path} does not exist",
+        f"Servers with ALLOW_DOCKER need the image run with -v {DOCKER_SOCKET_MOUNT}",
+    )
+
+
+def run_checks(
+    config: Optional[AgentfileConfig] = None,
+    runner: Callable = subprocess.run,
+    which: Callable = shutil.which,
+    probe: Callable[[str], int] = probe_url,
+    socket_path: Path = Path(DOCKER_SOCKET_MOUNT.split(":", 1)[0]),
+) -> List[Check]:
+    """Run the generic checks, plus those for the features an Agentfile uses."""
+    docker = check_command("docker", "build and run agent images", which)
+    checks = [docker]
+    if docker.status == STATUS_PASS:
+        checks.append(check_docker_daemon(runner))
+        # The syntax directive and the heredoc that writes the fast-agent config both need BuildKit
+        heredoc = config is not None and ("syntax" in config.directives or config.framework == "fast-agent")
+        checks.append(check_buildx(runner, required=heredoc))
+
+    checks.append(check_registry(config.base_image if config else AgentfileConfig().base_image, probe))
+    if config is None:
+        return checks
+
+    if any(server.uses_docker for server in config.servers.values()):
+        checks.append(check_docker_socket(socket_path))
+    if any(server.package and server.package.installer == "npm" for server in config.servers.values()):
+        checks.append(check_command("npm", "vendor npx packages with `agentman build --offline`", which, False))
+    checks.append(check_command("pip", "vendor Python packages with `agentman build --offline`", which, False))
+    return checks
+
+
+def run_host_checks(
+    config: AgentfileConfig,
+    which: Callable = shutil.which,
+    find_spec: Callable = importlib.util.find_spec,
+    version_info=sys.version_info,
+) -> List[Check]:
+    """Run the checks for running a generated agent on the host instead of in an image."""
+    checks = [check_python(version_info), check_framework_package(config.framework, find_spec)]
+    for server in config.servers.values():
+        if server.transport == "stdio" and server.command:
+            checks.append(check_command(server.command, f"start the {server.name} MCP server", which))
+    return checks
@@ -0,0 +1,51 @@
+"""Running a generated agent with the host Python instead of building an image."""
+
+import shutil
+import subprocess
+import sys
+import tempfile
+from pathlib import Path
+from typing import Callable, Optional
+
+from agentman.agent_builder import AgentBuilder
+from agentman.agentfile_parser import AgentfileConfig
+from agentman.prune import all_entities, prune_unused
+
+DRYRUN_PREFIX = "agentman-dryrun-"
+
+
+def select_entity(config: AgentfileConfig, name: str):
+    """Make one agent or workflow the DEFAULT and drop everything it does not reach."""
+    entities = all_entities(config)
+    if name not in entities:
+        raise ValueError(f"Unknown agent or workflow '{name}'. Available: {', '.join(entities) or 'none'}")
+    for entity_name, entity in entities.items():
+        entity.default = entity_name == name
+    prune_unused(config)
+
+
+def dry_run(
+    config: AgentfileConfig,
+    source_dir: str = ".",
+    agent: Optional[str] = None,
+    prompt: Optional[str] = None,
+    keep: bool = False,
+    runner: Callable = subprocess.run,
+) -> int:
+    """Generate the agent into a temporary directory, run it there and return its exit code.
+
+    Without a prompt the agent starts its interactive session, as it would in the container.
+    """
+    if agent:
+        select_entity(config, agent)
+
+    work_dir = Path(tempfile.mkdtemp(prefix=DRYRUN_PREFIX))
+    try:
+        AgentBuilder(config, str(work_dir), source_dir, prompt=prompt).build_all()
+        print(f"🧪 Running {agent or 'the agent'} from {work_dir} with {sys.executable}", flush=True)
+        return runner([sys.executable, "agent.py"], cwd=work_dir, check=False).returncode
+    finally:
+        if keep:
+            print(f"📁 Kept generated files in {work_dir}")
+        else:
+            shutil.rmtree(work_dir, ignore_errors=True)
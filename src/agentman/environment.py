@@ -0,0 +1,183 @@
+"""Environment variable discovery for generated agents."""
+
+from dataclasses import asdict, dataclass
+from typing import Dict, List, Optional
+
+from agentman.agentfile_parser import AgentfileConfig, SecretContext, SecretValue, env_reference
+
+# Environment variable names that fast-agent reads for each model provider
+PROVIDER_API_KEYS = {
+    "anthropic": "ANTHROPIC_API_KEY",
+    "openai": "OPENAI_API_KEY",
+    "azure": "AZURE_OPENAI_API_KEY",
+    "google": "GOOGLE_API_KEY",
+    "deepseek": "DEEPSEEK_API_KEY",
+    "openrouter": "OPENROUTER_API_KEY",
+    "groq": "GROQ_API_KEY",
+    "generic": "GENERIC_API_KEY",
+}
+
+# Runtime settings that can be tuned without rebuilding the image
+RUNTIME_TUNABLES = {
+    "fast-agent": [
+        ("LOGGER__LEVEL", "Log level for the fast-agent runtime (debug, info, warning, error)"),
+        ("OTEL__ENABLED", "Set to true to export OpenTelemetry traces"),
+        ("OTEL__OTLP_ENDPOINT", "OTLP endpoint that receives traces when OTEL__ENABLED is true"),
+    ],
+    "agno": [
+        ("AGNO_DEBUG", "Set to true to enable Agno debug logging"),
+        ("AGNO_MONITOR", "Set to true to send agent sessions to agno.com monitoring"),
+    ],
+}
+
+
+@dataclass
+class EnvVar:
+    """Represents an environment variable the generated agent reads."""
+
+    name: str
+    kind: str  # "secret", "provider", "image" or "runtime"
+    required: bool
+    description: str
+
+    def to_dict(self) -> Dict[str, object]:
+        """Convert to a JSON-serializable dictionary."""
+        return asdict(self)
+
+
+def model_provider(model: str) -> Optional[str]:
+    """Return the provider prefix of a model string, if any."""
+    model_lower = model.lower()
+    for separator in ("/", "."):
+        if separator in model_lower:
+            provider = model_lower.split(separator, 1)[0]
+            if provider.isidentifier():
+                return provider
+    if "claude" in model_lower or model_lower in ["haiku", "sonnet", "opus"]:
+        return "anthropic"
+    if "gpt" in model_lower or model_lower.startswith("o1") or model_lower.startswith("o3"):
+        return "openai"
+    return None
+
+
+def _declared_model_usages(config: AgentfileConfig) -> List[tuple]:
+    """Return (model, usage description) pairs in declaration order."""
+    usages = []
+    if config.default_model:
+        usages.append((config.default_model, f"default MODEL {config.default_model}"))
+    for agent in config.agents.values():
+        if agent.model:
+            usages.append((agent.model, f"MODEL {agent.model} of agent '{agent.name}'"))
+    for router in config.routers.values():
+        if router.model:
+            usages.append((router.model, f"MODEL {router.model} of router '{router.name}'"))
+    for chain in config.chains.values():
+        if chain.model:
+            usages.append((chain.model, f"MODEL {chain.model} of chain '{chain.name}'"))
+    for orchestrator in config.orchestrators.values():
+        if orchestrator.model:
+            usages.append((orchestrator.model, f"MODEL {orchestrator.model} of orchestrator '{orchestrator.name}'"))
+    return usages
+
+
+def _secret_usage(config: AgentfileConfig, name: str) -> str:
+    """Describe where a secret is referenced in the Agentfile."""
+    servers = [server.name for server in config.servers.values() if name in server.env]
+    if servers:
+        return "Declared by SECRET, used by MCP server " + ", ".join(f"'{s}'" for s in servers)
+    return "Declared by SECRET"
+
+
+def collect_environment(config: AgentfileConfig) -> List[EnvVar]:
+    """Collect every environment variable the generated agent may need.
+
+    The result never contains secret values. fast-agent images keep inline
+    values out of the secrets file, so their variables stay required; agno
+    writes them to .env, which makes the variable an optional override.
+    """
+    entries: List[EnvVar] = []
+    seen = set()
+
+    def add(entry: EnvVar):
+        if entry.name not in seen:
+            seen.add(entry.name)
+            entries.append(entry)
+
+    inline_default = config.framework != "fast-agent"
+    inline_usage = " with an inline default" if inline_default else "; its inline value is not put in the image"
+    for secret in config.secrets:
+        if isinstance(secret, str):
+            add(EnvVar(secret, "secret", True, _secret_usage(config, secret)))
+        elif isinstance(secret, SecretValue):
+            add(
+                EnvVar(
+                    secret.name,
+                    "secret",
+                    not inline_default,
+                    _secret_usage(config, secret.name) + inline_usage,
+                )
+            )
+        elif isinstance(secret, SecretContext):
+            if not secret.values:
+                add(EnvVar(secret.name, "secret", True, _secret_usage(config, secret.name)))
+            for key in secret.values:
+                add(
+                    EnvVar(
+                        f"{secret.name.upper()}_{key}",
+                        "secret",
+                        not inline_default,
+                        f"Key {key} of SECRET {secret.name}{inline_usage}",
+                    )
+                )
+
+    for model, usage in _declared_model_usages(config):
+        provider = model_provider(model)
+        if not provider:
+            continue
+        if provider in PROVIDER_API_KEYS:
+            add(EnvVar(PROVIDER_API_KEYS[provider], "provider", True, f"API key implied by {usage}"))
+        else:
+            provider_upper = provider.upper()
+            add(EnvVar(f"{provider_upper}_API_KEY", "provider", True, f"API key implied by {usage}"))
+            add(EnvVar(f"{provider_upper}_BASE_URL", "provider", False, f"Base URL implied by {usage}"))
+
+    for agent in config.agents.values():
+        name = env_reference(agent.base_url)
+        if name:
+            add(EnvVar(name, "secret", True, f"BASE_URL of agent '{agent.name}'"))
+
+    for name in config.image_env:
+        add(EnvVar(name, "image", False, "Set by ENV in the image; override at runtime if needed"))
+
+    for name, description in RUNTIME_TUNABLES.get(config.framework, []):
+        add(EnvVar(name, "runtime", False, description))
+
+    return entries
+
+
+def render_env_example(entries: List[EnvVar]) -> str:
+    """Render collected environment variables as a .env.example file."""
+    lines = [
+        "# Environment variables read by the generated agent",
+        "# Copy to .env and fill in the values; required variables are uncommented",
+    ]
+
+    sections = [
+        ("secret", "Secrets"),
+        ("provider", "Model provider credentials"),
+        ("image", "Image environment"),
+        ("runtime", "Runtime settings"),
+    ]
+    for kind, title in sections:
+        section_entries = [entry for entry in entries if entry.kind == kind]
+        if not section_entries:
+            continue
+        lines.extend(["", f"# {title}"])
+        for entry in section_entries:
+            lines.append(f"# {entry.description}")
+            if entry.required:
+                lines.append(f"{entry.name}=")
+            else:
+                lines.append(f"# {entry.name}=")
+
+    return "\n".join(lines) + "\n"
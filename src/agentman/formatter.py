@@ -0,0 +1,93 @@
+"""Canonical formatting of Agentfiles from their parsed statements.
+
+Formatting upper-cases keywords, indents the sub-instructions of each block the
+same way, puts blank lines around blocks and keeps each comment with the
+statement that follows it. Arguments are kept as written, quotes included, and
+Dockerfile instructions keep their continuation lines and heredoc bodies, since
+their spacing may matter to the shell.
+"""
+
+from typing import List, Optional
+
+from agentman.agentfile_parser import (
+    AGENTMAN_INSTRUCTIONS,
+    CONTEXT_KEYWORDS,
+    SUB_INSTRUCTIONS,
+    AgentfileParser,
+    Statement,
+)
+
+# Declarations that always stand apart as a block; SECRET lines are often a list of names
+BLOCK_KEYWORDS = ["SERVER"] + [keyword for keyword in CONTEXT_KEYWORDS.values() if keyword != "SECRET"]
+
+
+def format_agentfile(content: str, indent: int = 0, **options) -> str:
+    """Return content in canonical form; options are those of AgentfileParser."""
+    parser = AgentfileParser(**options)
+    config = parser.parse_content(content)
+    return format_statements(parser.statements, content, config.directive_lines(), indent)
+
+
+def format_statements(statements: List[Statement], content: str, directives: List[str], indent: int = 0) -> str:
+    """Render parsed statements in canonical form, using content for the blank lines and comments around them."""
+    source_lines = content.split("\n")
+    lines = list(directives)
+    if lines:
+        lines.append("")
+
+    previous: Optional[Statement] = None
+    for statement in statements:
+        if previous is not None and (
+            _is_block(statement) or _is_block(previous) or _blank_between(source_lines, previous, statement)
+        ):
+            lines.append("")
+        lines.extend(_statement_lines(statement, "", statement.keyword in AGENTMAN_INSTRUCTIONS + SUB_INSTRUCTIONS))
+        for child in statement.children:
+            lines.extend(_statement_lines(child, "" if child.keyword == "END" else " " * indent, True))
+        previous = statement
+
+    # Comments after the last statement have nothing to precede, so they stay at the end
+    end = _last_line(previous) if previous is not None else 0
+    trailing = [
+        line.strip() for line in source_lines[end:] if line.strip().startswith("#") and line.strip() not in directives
+    ]
+    if trailing:
+        lines.extend([""] + trailing if lines else trailing)
+
+    while lines and not lines[-1]:
+        lines.pop()
+    return "\n".join(lines) + "\n"
+
+
+def _is_block(statement: Statement) -> bool:
+    """Whether a statement opens a block that blank lines set apart."""
+    return bool(statement.children) or statement.keyword in BLOCK_KEYWORDS
+
+
+def _last_line(statement: Statement) -> int:
+    """Return the last physical line of a statement and its block."""
+    return statement.children[-1].end_line if statement.children else statement.end_line
+
+
+def _blank_between(source_lines: List[str], previous: Statement, statement: Statement) -> bool:
+    """Whether the source separates two statements with a blank line."""
+    return any(not line.strip() for line in source_lines[_last_line(previous) : statement.line - 1])
+
+
+def _statement_lines(statement: Statement, prefix: str, agentman: bool) -> List[str]:
+    """Render one statement, after the comments that precede it.
+
+    Agentman instructions, which include every sub-instruction, are rebuilt from their arguments.
+    """
+    lines = [prefix + comment for comment in statement.comments]
+    if statement.heredocs or not agentman:
+        # Passthrough text as written, apart from the keyword's case
+        raw = statement.raw or statement.text
+        lines.append(prefix + statement.keyword + raw[len(statement.keyword) :])
+        return lines
+
+    text = " ".join([statement.keyword] + statement.args)
+    if statement.comment:
+        text += f"  {statement.comment}"
+    lines.append(prefix + text)
+    return lines
@@ -1,10 +1,37 @@
 """Agno framework implementation for AgentMan."""
 
-from typing import List
+import json
+from typing import List, Optional
+
+from agentman.agentfile_parser import env_reference, instruction_code
 
 from .base import BaseFramework
 
 
+def _with_model_settings(model_code: str, settings: dict) -> str:
+    """Add model settings as keyword arguments to the model constructor in model_code."""
+    if not settings:
+        return model_code
+    settings = {("timeout" if key == "request_timeout" else key): value for key, value in settings.items()}
+    if model_code.startswith("model=Claude("):
+        if "stop" in settings:
+            settings["stop_sequences"] = settings.pop("stop")
+    elif "top_k" in settings:
+        # The OpenAI API has no top_k; compatible servers that support it read it from the request body
+        settings["extra_body"] = {"top_k": settings.pop("top_k")}
+    if "\n" in model_code:
+        # Multi-line constructors end with an indented "),"
+        body, end = model_code.rsplit("\n", 1)
+        return body + "".join(f"\n        {key}={json.dumps(value)}," for key, value in settings.items()) + "\n" + end
+    arguments = "".join(f", {key}={json.dumps(value)}" for key, value in settings.items())
+    return model_code[: -len("),")] + arguments + "),"
+
+
+def _env_value(value: str) -> str:
+    """Quote a multi-line value the way python-dotenv reads it back."""
+    return json.dumps(value) if "\n" in value else value
+
+
 class AgnoFramework(BaseFramework):
     """Framework implementation for Agno."""
 
@@ -13,14 +40,23 @@ class AgnoFramework(BaseFramework):
         lines = []
 
         # Determine if we need advanced features
-        has_multiple_agents = len(self.config.agents) > 1
+        # An ORCHESTRATOR or a PARALLEL becomes the team, so it runs even a single agent
+        orchestrator = next(iter(self.config.orchestrators.values()), None)
+        parallel = next(iter(self.config.parallels.values()), None)
+        team = orchestrator or parallel
+        use_team = len(self.config.agents) > 1 or team is not None
         has_servers = bool(self.config.servers)
 
         # Enhanced imports based on features needed
         imports = [
             "import os",
+            "import signal",
             "from agno.agent import Agent",
         ]
+        if self.config.serve:
+            imports.extend(["import uvicorn", "from fastapi import FastAPI", "from pydantic import BaseModel"])
+        if self.instruction_files():
+            imports.append("from pathlib import Path")
 
         # Add dotenv import for loading .env files
         imports.append("from dotenv import load_dotenv")
@@ -82,7 +118,7 @@ class AgnoFramework(BaseFramework):
             imports.append(tool_import)
 
         # Team imports if multiple agents
-        if has_multiple_agents:
+        if use_team:
             imports.append("from agno.team.team import Team")
 
         # Advanced feature imports (always include for better examples)
@@ -98,29 +134,56 @@ class AgnoFramework(BaseFramework):
 
         lines.extend(imports + [""])
 
+        # Graceful shutdown: SystemExit unwinds the running turn and closes its tools. A SERVE app leaves
+        # the signals to uvicorn, which gives the requests in flight SHUTDOWN_GRACE seconds instead
+        lines.extend(self.shutdown_grace_lines())
+        lines.extend(["", ""])
+        if not self.config.serve:
+            lines.extend([
+                "def install_shutdown_handlers() -> None:",
+                '    """Exit SHUTDOWN_GRACE seconds after the first SIGTERM or SIGINT, or at once on the second."""',
+                "",
+                "    def stop(signum, frame):",
+                "        raise SystemExit(0)",
+                "",
+                "    def shutdown(signum, frame):",
+                "        if SHUTDOWN_GRACE <= 0:",
+                "            stop(signum, frame)",
+                '        print(f"Shutting down, allowing {SHUTDOWN_GRACE}s for the current turn", flush=True)',
+                "        for stop_signum in (signal.SIGALRM, signal.SIGTERM, signal.SIGINT):",
+                "            signal.signal(stop_signum, stop)",
+                "        signal.alarm(SHUTDOWN_GRACE)",
+                "",
+                "    for signum in (signal.SIGTERM, signal.SIGINT):",
+                "        signal.signal(signum, shutdown)",
+                "",
+                "",
+            ])
+
         # Generate agents with enhanced capabilities
         agent_vars = []
         for agent in self.config.agents.values():
             agent_var = f"{agent.name.lower().replace('-', '_')}_agent"
             agent_vars.append((agent_var, agent))
 
+            lines.extend(self.source_comment(agent.line, f"AGENT {agent.name}"))
             lines.extend([
                 f"# Agent: {agent.name}",
                 f"{agent_var} = Agent(",
                 f'    name="{agent.name}",',
-                f'    instructions="""{agent.instruction}""",',
+                f'    instructions={instruction_code(agent, self.inline_instructions)},',
             ])
 
             # Add role if we have multiple agents
-            if has_multiple_agents:
+            if use_team:
                 role = f"Handle {agent.name.lower().replace('-', ' ')} requests"
                 lines.append(f'    role="{role}",')
 
             # Add model
             model = agent.model or self.config.default_model
             if model:
-                model_code = self._generate_model_code(model)
-                lines.append(f'    {model_code}')
+                model_code = self._generate_model_code(model, agent.base_url)
+                lines.append(f'    {_with_model_settings(model_code, self.config.model_settings_for(agent.name))}')
 
             # Enhanced tools based on servers
             tools = []
@@ -166,34 +229,57 @@ class AgnoFramework(BaseFramework):
             ])
 
         # Team creation for multi-agent scenarios
-        if has_multiple_agents:
-            team_name = "AgentTeam"
+        if use_team:
             lines.extend([
                 "# Multi-Agent Team",
-                f"{team_name.lower()} = Team(",
-                f'    name="{team_name}",',
-                "    mode='coordinate',  # or 'sequential' for ordered execution",
+                "agentteam = Team(",
+                f'    name="{team.name if team else "AgentTeam"}",',
             ])
+            if parallel:
+                lines.append("    mode='collaborate',  # every member answers, the leader combines the answers")
+            else:
+                lines.append("    mode='coordinate',  # or 'sequential' for ordered execution")
+
+            # A PARALLEL's FAN_IN agent leads the team with its model and instruction
+            fan_in = self.config.agents[parallel.fan_in] if parallel and parallel.fan_in else None
 
-            # Use the first agent's model for team coordination
-            if agent_vars:
+            # The orchestrator's MODEL coordinates, else the FAN_IN agent's, else the first agent's
+            if orchestrator and orchestrator.model:
+                lines.append(f'    {self._generate_model_code(orchestrator.model)}')
+            elif fan_in:
+                lines.append(f'    {self._generate_model_code(self.config.model_for(fan_in.name))}')
+            elif agent_vars:
                 first_model = agent_vars[0][1].model or self.config.default_model
                 model_code = self._generate_model_code(first_model)
                 lines.append(f'    {model_code}')
 
-            # Add all agents as team members
-            member_vars = [var for var, _ in agent_vars]
+            # The orchestrator's AGENTS or the FAN_OUT are the members, else every agent
+            agent_var_names = {agent.name: var for var, agent in agent_vars}
+            if orchestrator:
+                member_names = orchestrator.agents
+            elif parallel:
+                member_names = parallel.fan_out
+            else:
+                member_names = list(agent_var_names)
+            member_vars = [agent_var_names[name] for name in member_names]
             members_str = ", ".join(member_vars)
             lines.append(f'    members=[{members_str}],')
 
+            lines.append("    tools=[ReasoningTools(add_instructions=True)],")
+            if team and team.instruction:
+                lines.append(f"    instructions={instruction_code(team, self.inline_instructions)},")
+            elif fan_in and fan_in.instruction:
+                lines.append(f"    instructions={instruction_code(fan_in, self.inline_instructions)},")
+            else:
+                lines.extend([
+                    "    instructions=[",
+                    "        'Collaborate to provide comprehensive responses',",
+                    "        'Consider multiple perspectives and expertise areas',",
+                    "        'Present findings in a structured, easy-to-follow format',",
+                    "        'Only output the final consolidated response',",
+                    "    ],",
+                ])
             lines.extend([
-                "    tools=[ReasoningTools(add_instructions=True)],",
-                "    instructions=[",
-                "        'Collaborate to provide comprehensive responses',",
-                "        'Consider multiple perspectives and expertise areas',",
-                "        'Present findings in a structured, easy-to-follow format',",
-                "        'Only output the final consolidated response',",
-                "    ],",
                 "    markdown=True,",
                 "    show_members_responses=True,",
                 "    enable_agentic_context=True,",
@@ -204,7 +290,10 @@ class AgnoFramework(BaseFramework):
             ])
 
         # Main function and execution logic
-        lines.extend(self._generate_main_function(has_multiple_agents, agent_vars))
+        if self.config.serve:
+            lines.extend(self._generate_server_app(use_team, agent_vars))
+        else:
+            lines.extend(self._generate_main_function(use_team, agent_vars))
 
         lines.extend([
             "",
@@ -214,23 +303,31 @@ class AgnoFramework(BaseFramework):
 
         return "\n".join(lines)
 
-    def _generate_model_code(self, model: str) -> str:
+    def _generate_model_code(self, model: str, base_url: Optional[str] = None) -> str:
         """Generate the appropriate model instantiation code for Agno framework."""
         if not model:
             return 'model=Claude(id="anthropic/claude-3-sonnet-20241022"),'
 
         model_lower = model.lower()
+        # A per-agent BASE_URL replaces the provider-wide environment variable
+        base_url_code = None
+        if base_url:
+            name = env_reference(base_url)
+            base_url_code = f'os.getenv("{name}")' if name else f'"{base_url}"'
 
         # Anthropic models
         if "anthropic" in model_lower or "claude" in model_lower:
+            if base_url_code:
+                return f'model=Claude(id="{model}", client_params={{"base_url": {base_url_code}}}),'
             return f'model=Claude(id="{model}"),'
 
         # OpenAI models
         elif "openai" in model_lower or "gpt" in model_lower:
+            default_base_url = 'os.getenv("OPENAI_BASE_URL")'
             model_code = 'model=OpenAILike(\n'
             model_code += f'        id="{model}",\n'
             model_code += '        api_key=os.getenv("OPENAI_API_KEY"),\n'
-            model_code += '        base_url=os.getenv("OPENAI_BASE_URL"),\n'
+            model_code += f'        base_url={base_url_code or default_base_url},\n'
             model_code += '    ),'
             return model_code
 
@@ -243,7 +340,8 @@ class AgnoFramework(BaseFramework):
             model_code = 'model=OpenAILike(\n'
             model_code += f'        id="{model}",\n'
             model_code += f'        api_key=os.getenv("{provider_upper}_API_KEY"),\n'
-            model_code += f'        base_url=os.getenv("{provider_upper}_BASE_URL"),\n'
+            default_base_url = f'os.getenv("{provider_upper}_BASE_URL")'
+            model_code += f'        base_url={base_url_code or default_base_url},\n'
             model_code += '    ),'
             return model_code
 
@@ -256,6 +354,8 @@ class AgnoFramework(BaseFramework):
                 for secret in self.config.secrets
             )
 
+            if base_url_code:
+                return f'model=OpenAILike(id="{model}", base_url={base_url_code}),'
             if has_openai_config:
                 # Use OpenAI environment variables for custom models
                 model_code = 'model=OpenAILike(\n'
@@ -267,9 +367,44 @@ class AgnoFramework(BaseFramework):
             else:
                 return f'model=OpenAILike(id="{model}"),'
 
-    def _generate_main_function(self, has_multiple_agents: bool, agent_vars: list) -> List[str]:
+    def _generate_server_app(self, use_team: bool, agent_vars: list) -> List[str]:
+        """Generate the FastAPI app SERVE http runs: a POST route answering messages, and a health check."""
+        serve = self.config.serve
+        runner = "agentteam" if use_team else next((var for var, _ in agent_vars), None)
+        lines = [
+            "class RunRequest(BaseModel):",
+            "    message: str",
+            "",
+            "",
+            "app = FastAPI()",
+            "",
+            "",
+            f'@app.post("{serve.endpoint}")',
+            "async def run(request: RunRequest) -> dict:",
+        ]
+        if runner:
+            lines.extend([
+                f"    response = await {runner}.arun(request.message)",
+                '    return {"response": response.content}',
+            ])
+        else:
+            lines.append('    return {"response": "No agents defined"}')
+        lines.extend([
+            "",
+            "",
+            '@app.get("/health")',
+            "async def health() -> dict:",
+            '    return {"status": "ok"}',
+            "",
+            "",
+            "def main() -> None:",
+            f'    uvicorn.run(app, host="0.0.0.0", port={serve.port}, timeout_graceful_shutdown=SHUTDOWN_GRACE)',
+        ])
+        return lines
+
+    def _generate_main_function(self, use_team: bool, agent_vars: list) -> List[str]:
         """Generate the main function and execution logic."""
-        lines = ["def main() -> None:"]
+        lines = ["def main() -> None:", "    install_shutdown_handlers()"]
 
         # Handle prompt file loading
         if self.has_prompt_file:
@@ -283,7 +418,7 @@ class AgnoFramework(BaseFramework):
             ])
 
         # Enhanced execution logic
-        if has_multiple_agents:
+        if use_team:
             # Use team for multi-agent scenarios
             team_name = "AgentTeam"
             if self.has_prompt_file:
@@ -456,6 +591,10 @@ class AgnoFramework(BaseFramework):
             "tantivy",     # For hybrid search
         ])
 
+        # SERVE http runs the agents behind a FastAPI app
+        if self.config.serve:
+            requirements.extend(["fastapi", "uvicorn"])
+
         # Multi-agent scenarios get additional dependencies
         if len(self.config.agents) > 1:
             requirements.extend([
@@ -498,12 +637,12 @@ class AgnoFramework(BaseFramework):
                     env_lines.append(f"# {secret}=your-value-here")
             elif hasattr(secret, 'value'):
                 # SecretValue with inline value
-                env_lines.append(f"{secret.name}={secret.value}")
+                env_lines.append(f"{secret.name}={_env_value(secret.value)}")
             elif hasattr(secret, 'values'):
                 # SecretContext with multiple key-value pairs
                 env_lines.append(f"# {secret.name.upper()} configuration")
                 for key, value in secret.values.items():
-                    env_lines.append(f"{secret.name.upper()}_{key}={value}")
+                    env_lines.append(f"{secret.name.upper()}_{key}={_env_value(value)}")
 
         env_file = self.output_dir / ".env"
         with open(env_file, 'w', encoding='utf-8') as f:
@@ -512,3 +651,8 @@ class AgnoFramework(BaseFramework):
     def get_dockerfile_config_lines(self) -> List[str]:
         """Get Agno-specific Dockerfile configuration lines."""
         return ["COPY .env ."]
+
+    def get_config_files(self) -> List[str]:
+        """Get the names of the generated configuration files."""
+        # Agno already uses a single .env file, so combined mode changes nothing
+        return [".env"]
@@ -10,11 +10,29 @@ from agentman.agentfile_parser import AgentfileConfig
 class BaseFramework(ABC):
     """Base class for framework implementations."""
 
-    def __init__(self, config: AgentfileConfig, output_dir: Path, source_dir: Path):
+    def __init__(
+        self,
+        config: AgentfileConfig,
+        output_dir: Path,
+        source_dir: Path,
+        combined_config: bool = False,
+        annotate: bool = False,
+    ):
         self.config = config
         self.output_dir = output_dir
         self.source_dir = source_dir
+        self.combined_config = combined_config
+        self.annotate = annotate
         self.has_prompt_file = (source_dir / "prompt.txt").exists()
+        self.inline_instructions = False  # Whether INSTRUCTION_FILE contents are embedded instead of copied
+        self.embed_config = False  # Whether the config file is written into the Dockerfile instead of copied
+        self.runtime_secrets = False  # Whether an entrypoint writes the secrets file from the environment at start
+
+    def instruction_files(self) -> list:
+        """Return the agents and workflows whose INSTRUCTION_FILE is copied into the image and read from there."""
+        if self.inline_instructions:
+            return []
+        return [entity for entity in self.config.entities().values() if entity.instruction_file]
 
     @abstractmethod
     def build_agent_content(self) -> str:
@@ -36,25 +54,40 @@ class BaseFramework(ABC):
         """Get framework-specific Dockerfile configuration lines."""
         pass
 
-    def get_custom_model_providers(self) -> set:
-        """Extract custom model providers from all models used."""
-        providers = set()
+    @abstractmethod
+    def get_config_files(self) -> List[str]:
+        """Get the names of the generated configuration files."""
+        pass
+
+    def source_comment(self, line, label: str) -> List[str]:
+        """Return an annotation naming the Agentfile line behind a block, when annotating."""
+        return self.config.source_comment(line, label) if self.annotate else []
 
-        # Check default model
-        if self.config.default_model and "/" in self.config.default_model:
-            provider = self.config.default_model.split("/")[0]
-            # Skip official providers that don't need custom base URLs
-            if provider.lower() not in ["openai", "anthropic"]:
-                providers.add(provider)
+    def server_source_comments(self) -> List[str]:
+        """Return annotations for the MCP servers written into a config file."""
+        lines = []
+        for server in self.config.servers.values():
+            lines.extend(self.source_comment(server.line, f"MCP_SERVER {server.name}"))
+        return lines
 
-        # Check agent models
-        for agent in self.config.agents.values():
-            if agent.model and "/" in agent.model:
-                provider = agent.model.split("/")[0]
-                # Skip official providers that don't need custom base URLs
-                if provider.lower() not in ["openai", "anthropic"]:
-                    providers.add(provider)
+    def shutdown_grace_lines(self) -> List[str]:
+        """Return the SHUTDOWN_GRACE constant the generated signal handlers read."""
+        return [
+            "# Seconds an in-flight agent turn may keep running after SIGTERM or SIGINT",
+            f"SHUTDOWN_GRACE = {self.config.shutdown_grace}",
+        ]
 
+    def get_custom_model_providers(self) -> List[str]:
+        """Extract custom model providers from all models used, in the order they are first declared."""
+        models = [self.config.default_model] + [self.config.model_for(name) for name in self.config.agents]
+        providers = []
+        for model in models:
+            if not model or "/" not in model:
+                continue
+            provider = model.split("/")[0]
+            # Skip official providers that don't need custom base URLs
+            if provider.lower() not in ["openai", "anthropic"] and provider not in providers:
+                providers.append(provider)
         return providers
 
     def _ensure_output_dir(self):
@@ -1,70 +1,166 @@
 """Fast-Agent framework implementation for AgentMan."""
 
+import json
 from typing import List
 import yaml
 
+from agentman.agentfile_parser import env_reference
+from agentman.environment import model_provider
+from agentman.secrets_entrypoint import SECRETS_ENTRYPOINT_FILENAME
+
 from .base import BaseFramework
 
 
+# Well-known secret names, mapped to the provider section and key of fastagent.secrets.yaml they fill
+PROVIDER_SECRETS = {
+    "OPENAI_API_KEY": ("openai", "api_key"),
+    "OPENAI_BASE_URL": ("openai", "base_url"),
+    "ANTHROPIC_API_KEY": ("anthropic", "api_key"),
+    "AZURE_OPENAI_API_KEY": ("azure", "api_key"),
+    "ALIYUN_API_KEY": ("aliyun", "api_key"),
+    "DEEPSEEK_API_KEY": ("deepseek", "api_key"),
+    "GOOGLE_API_KEY": ("google", "api_key"),
+    "OPENROUTER_API_KEY": ("openrouter", "api_key"),
+    "GENERIC_API_KEY": ("generic", "api_key"),
+    "GENERIC_BASE_URL": ("generic", "base_url"),
+}
+# Placeholders a declared but unset provider secret gets in the generated file
+PROVIDER_PLACEHOLDERS = {"azure": "<your-azure-api-key-here>", "aliyun": "<your-aliyun-api-key-here>"}
+
+# RequestParams fields whose names differ from the model settings they hold
+REQUEST_PARAM_NAMES = {"max_tokens": "maxTokens", "stop": "stopSequences"}
+
+
 class FastAgentFramework(BaseFramework):
     """Framework implementation for Fast-Agent."""
 
     def build_agent_content(self) -> str:
         """Build the Python agent file content for Fast-Agent framework."""
         lines = []
+        request_params = {name: self.request_params(name) for name in self.config.agents}
 
         # Imports
         lines.extend([
             "import asyncio",
+            "import signal",
             "from mcp_agent.core.fastagent import FastAgent",
+        ])
+        if any(request_params.values()):
+            lines.append("from mcp_agent.core.request_params import RequestParams")
+        if self.instruction_files():
+            lines.append("from pathlib import Path")
+        if self.config.human_input_mode == "webhook":
+            lines.extend([
+                "import json",
+                "import urllib.request",
+                "from mcp_agent.human_input.types import HumanInputRequest, HumanInputResponse",
+            ])
+        lines.extend(self.basic_auth_lines())
+        lines.extend([
             "",
             "# Create the application",
             'fast = FastAgent("Generated by Agentman")',
             "",
         ])
+        lines.extend(self.human_input_lines())
+
+        # Graceful shutdown: cancelling main() leaves fast.run(), which stops the stdio MCP servers
+        lines.extend(self.shutdown_grace_lines())
+        lines.extend([
+            "",
+            "",
+            "def install_shutdown_handlers(task: asyncio.Task) -> None:",
+            '    """Cancel the agent SHUTDOWN_GRACE seconds after the first signal, or at once on the second."""',
+            "    loop = asyncio.get_running_loop()",
+            "    stopping = []",
+            "",
+            "    def shutdown() -> None:",
+            "        if stopping:",
+            "            task.cancel()",
+            "            return",
+            "        stopping.append(True)",
+            '        print(f"Shutting down, allowing {SHUTDOWN_GRACE}s for the current turn", flush=True)',
+            "        loop.call_later(SHUTDOWN_GRACE, task.cancel)",
+            "",
+            "    for signum in (signal.SIGTERM, signal.SIGINT):",
+            "        loop.add_signal_handler(signum, shutdown)",
+            "",
+            "",
+        ])
 
         # Agent definitions
         for agent in self.config.agents.values():
-            lines.append(agent.to_decorator_string(self.config.default_model))
+            lines.extend(self.source_comment(agent.line, f"AGENT {agent.name}"))
+            lines.append(
+                agent.to_decorator_string(
+                    self.config.model_for(agent.name), request_params[agent.name], self.inline_instructions
+                )
+            )
 
         # Router definitions
         for router in self.config.routers.values():
-            lines.append(router.to_decorator_string(self.config.default_model))
+            lines.extend(self.source_comment(router.line, f"ROUTER {router.name}"))
+            lines.append(router.to_decorator_string(self.config.model_for(router.name), self.inline_instructions))
 
         # Chain definitions
         for chain in self.config.chains.values():
-            lines.append(chain.to_decorator_string())
+            lines.extend(self.source_comment(chain.line, f"CHAIN {chain.name}"))
+            lines.append(chain.to_decorator_string(self.inline_instructions))
+
+        # Parallel definitions
+        for parallel in self.config.parallels.values():
+            lines.extend(self.source_comment(parallel.line, f"PARALLEL {parallel.name}"))
+            lines.append(parallel.to_decorator_string(self.inline_instructions))
 
         # Orchestrator definitions
         for orchestrator in self.config.orchestrators.values():
-            lines.append(orchestrator.to_decorator_string(self.config.default_model))
+            lines.extend(self.source_comment(orchestrator.line, f"ORCHESTRATOR {orchestrator.name}"))
+            lines.append(
+                orchestrator.to_decorator_string(self.config.model_for(orchestrator.name), self.inline_instructions)
+            )
 
-        # Main function
+        # Main function; under SERVE the CMD's --server arguments make fast.run() serve the agents instead
+        if self.config.serve:
+            port = self.config.serve.port
+            lines.append(f"# SERVE mcp: started with --server, fast.run() serves the agents on port {port}")
         lines.extend([
             "async def main() -> None:",
-            "    async with fast.run() as agent:",
+            "    install_shutdown_handlers(asyncio.current_task())",
+            "    try:",
+            "        async with fast.run() as agent:",
         ])
 
+        # RESOURCES: give each agent its resources before the conversation starts
+        resources = {name: agent.resources for name, agent in self.config.agents.items() if agent.resources}
+        if resources:
+            lines.extend([
+                f"            for name, uris in {json.dumps(resources)}.items():",
+                "                for uri in uris:",
+                '                    await agent[name].with_resource("Keep this resource in mind.", uri)',
+            ])
+
         # Check if prompt.txt exists and add prompt loading
         if self.has_prompt_file:
             lines.extend([
-                "        # Check if prompt.txt exists and load its content",
-                "        import os",
-                "        prompt_file = 'prompt.txt'",
-                "        if os.path.exists(prompt_file):",
-                "            with open(prompt_file, 'r', encoding='utf-8') as f:",
-                "                prompt_content = f.read().strip()",
-                "            if prompt_content:",
-                "                await agent(prompt_content)",
+                "            # Check if prompt.txt exists and load its content",
+                "            import os",
+                "            prompt_file = 'prompt.txt'",
+                "            if os.path.exists(prompt_file):",
+                "                with open(prompt_file, 'r', encoding='utf-8') as f:",
+                "                    prompt_content = f.read().strip()",
+                "                if prompt_content:",
+                "                    await agent(prompt_content)",
+                "                else:",
+                "                    await agent()",
                 "            else:",
                 "                await agent()",
-                "        else:",
-                "            await agent()",
             ])
         else:
-            lines.extend(["        await agent()"])
+            lines.extend(["            await agent()"])
 
         lines.extend([
+            "    except asyncio.CancelledError:",
+            '        print("Agent stopped", flush=True)',
             "",
             "",
             'if __name__ == "__main__":',
@@ -73,6 +169,75 @@ class FastAgentFramework(BaseFramework):
 
         return "\n".join(lines)
 
+    def basic_auth_lines(self) -> List[str]:
+        """Return code that builds the basic Authorization headers fastagent.config.yaml reads from the environment.
+
+        fast-agent only substitutes variables into the config, so it cannot base64-encode user:password itself.
+        """
+        servers = [server for server in self.config.servers.values() if server.auth and server.auth.type == "basic"]
+        if not servers:
+            return []
+        lines = [
+            "import base64",
+            "import os",
+            "",
+            "# AUTH basic: encode the credentials before FastAgent reads its config",
+        ]
+        for server in servers:
+            credentials = f"{server.auth.user}:{{os.environ.get({server.auth.secret!r}, '')}}"
+            encoded = f'base64.b64encode(f"{credentials}".encode()).decode()'
+            lines.append(f'os.environ["{server.auth_env_name}"] = "Basic " + {encoded}')
+        return lines
+
+    def human_input_lines(self) -> List[str]:
+        """Return code that replaces fast-agent's console human input handler for HUMAN_INPUT_MODE.
+
+        FastAgent has no public setting for the handler, so the code sets the one its app passes to agents.
+        """
+        if self.config.human_input_mode == "disabled":
+            return [
+                "# HUMAN_INPUT_MODE disabled: agents cannot ask for input",
+                "fast.app._human_input_callback = None",
+                "",
+            ]
+        if self.config.human_input_mode != "webhook":
+            return []
+        return [
+            f"HUMAN_INPUT_WEBHOOK = {json.dumps(self.config.human_input_webhook)}",
+            "",
+            "",
+            "async def webhook_input_callback(request: HumanInputRequest) -> HumanInputResponse:",
+            '    """POST the question to HUMAN_INPUT_WEBHOOK and answer with the "response" of its JSON reply."""',
+            "    question = {",
+            '        "request_id": request.request_id,',
+            '        "prompt": request.prompt,',
+            '        "description": request.description,',
+            '        "metadata": request.metadata,',
+            "    }",
+            "    http_request = urllib.request.Request(",
+            "        HUMAN_INPUT_WEBHOOK,",
+            "        data=json.dumps(question).encode(),",
+            '        headers={"Content-Type": "application/json"},',
+            "    )",
+            "",
+            "    def post() -> dict:",
+            "        with urllib.request.urlopen(http_request, timeout=request.timeout_seconds) as reply:",
+            "            return json.load(reply)",
+            "",
+            "    reply = await asyncio.to_thread(post)",
+            '    return HumanInputResponse(request_id=request.request_id, response=str(reply.get("response", "")))',
+            "",
+            "",
+            "# HUMAN_INPUT_MODE webhook: questions go to the webhook instead of the console",
+            "fast.app._human_input_callback = webhook_input_callback",
+            "",
+        ]
+
+    def request_params(self, name: str) -> dict:
+        """Return the RequestParams fields for an agent's model settings."""
+        settings = self.config.model_settings_for(name)
+        return {REQUEST_PARAM_NAMES.get(key, key): value for key, value in settings.items()}
+
     def get_requirements(self) -> List[str]:
         """Get requirements for Fast-Agent framework."""
         requirements = [
@@ -98,11 +263,37 @@ class FastAgentFramework(BaseFramework):
     def generate_config_files(self) -> None:
         """Generate Fast-Agent specific configuration files."""
         self._ensure_output_dir()
-        self._generate_config_yaml()
-        self._generate_secrets_yaml()
+        if self.combined_config:
+            self._generate_combined_config_yaml()
+        else:
+            self._generate_config_yaml()
+            if not self.runtime_secrets:
+                self._generate_secrets_yaml()
+
+    def _generate_combined_config_yaml(self):
+        """Generate a single fastagent.config.yaml that also carries the secrets."""
+        config_data = _merge_config(self._build_config_data(), self._build_secrets_data())
+
+        config_file = self.output_dir / "fastagent.config.yaml"
+        with open(config_file, 'w', encoding='utf-8') as f:
+            f.write("# FastAgent Configuration (combined with secrets)\n")
+            f.write("# WARNING: Keep this file secure and never commit to version control\n\n")
+            f.writelines(f"{line}\n" for line in self.server_source_comments())
+            yaml.dump(config_data, f, default_flow_style=False, sort_keys=False)
 
     def _generate_config_yaml(self):
         """Generate the fastagent.config.yaml file."""
+        config_file = self.output_dir / "fastagent.config.yaml"
+        with open(config_file, 'w', encoding='utf-8') as f:
+            f.write(self.config_yaml_text())
+
+    def config_yaml_text(self) -> str:
+        """Return the text of fastagent.config.yaml, without the secrets."""
+        comments = "".join(f"{line}\n" for line in self.server_source_comments())
+        return comments + yaml.dump(self._build_config_data(), default_flow_style=False, sort_keys=False)
+
+    def _build_config_data(self) -> dict:
+        """Build the fastagent.config.yaml content."""
         config_data = {
             "default_model": self.config.default_model or "haiku",
             "logger": {
@@ -114,132 +305,154 @@ class FastAgentFramework(BaseFramework):
             },
         }
 
+        for provider, base_url in self._provider_base_urls().items():
+            config_data[provider] = {"base_url": base_url}
+
         if self.config.servers:
             config_data["mcp"] = {
                 "servers": {name: server.to_config_dict() for name, server in self.config.servers.items()}
             }
 
-        config_file = self.output_dir / "fastagent.config.yaml"
-        with open(config_file, 'w', encoding='utf-8') as f:
-            yaml.dump(config_data, f, default_flow_style=False, sort_keys=False)
+        return config_data
+
+    def _provider_base_urls(self) -> dict:
+        """Map agent BASE_URL overrides onto fast-agent's per-provider base_url settings."""
+        base_urls = {}
+        for agent in self.config.agents.values():
+            model = self.config.model_for(agent.name) or "haiku"
+            provider = model_provider(model) or "generic"
+            name = env_reference(agent.base_url)
+            base_url = f"${{{name}}}" if name else agent.base_url
+            base_urls.setdefault(provider, {})[agent.name] = base_url
+
+        overrides = {}
+        for provider, agent_urls in base_urls.items():
+            urls = set(agent_urls.values())
+            if urls == {None}:
+                continue
+            if len(urls) > 1:
+                raise ValueError(
+                    f"fast-agent reads one base_url per provider, but the {provider} agents "
+                    f"{', '.join(sorted(agent_urls))} use different BASE_URL values; give them the same BASE_URL, "
+                    "use a different provider for the override, or use FRAMEWORK agno"
+                )
+            overrides[provider] = urls.pop()
+        return overrides
 
     def _generate_secrets_yaml(self):
         """Generate the fastagent.secrets.yaml template file."""
+        secrets_data = self._build_secrets_data()
+
+        secrets_file = self.output_dir / "fastagent.secrets.yaml"
+        with open(secrets_file, 'w', encoding='utf-8') as f:
+            f.write("# FastAgent Secrets Configuration\n")
+            f.write("# Inline SECRET values are not written here; fast-agent fills each ${NAME} from the environment\n")
+            f.write("# WARNING: Keep this file secure and never commit to version control\n\n")
+            yaml.dump(secrets_data, f, default_flow_style=False, sort_keys=False)
+
+    def _build_secrets_data(self, runtime: bool = False) -> dict:
+        """Build the fastagent.secrets.yaml content.
+
+        The file is copied into the image, so inline SECRET values are never written to it: they become
+        ${NAME} references fast-agent fills from the environment. With runtime, every value is instead the
+        name of the environment variable the entrypoint fills it from.
+        """
         secrets_data = {}
         mcp_servers_env = {}
 
         # Process secrets based on their type
         for secret in self.config.secrets:
-            if isinstance(secret, str):
-                # Simple secret reference
-                self._process_simple_secret(secret, secrets_data, mcp_servers_env)
+            if isinstance(secret, str) or (hasattr(secret, 'values') and not secret.values):
+                # Simple secret reference; a bare SECRET NAME parses as a context with no values
+                name = getattr(secret, 'name', secret)
+                self._place_secret(name, name if runtime else None, secrets_data, mcp_servers_env)
             elif hasattr(secret, 'value'):
                 # SecretValue with inline value
-                self._process_secret_value(secret, secrets_data, mcp_servers_env)
+                value = secret.name if runtime else f"${{{secret.name}}}"
+                self._place_secret(secret.name, value, secrets_data, mcp_servers_env)
             elif hasattr(secret, 'values'):
                 # SecretContext with multiple key-value pairs
-                self._process_secret_context(secret, secrets_data)
+                self._process_secret_context(secret, secrets_data, runtime)
 
         # Add MCP servers environment if any
         if mcp_servers_env:
             secrets_data["mcp"] = {"servers": mcp_servers_env}
 
-        secrets_file = self.output_dir / "fastagent.secrets.yaml"
-        with open(secrets_file, 'w', encoding='utf-8') as f:
-            f.write("# FastAgent Secrets Configuration\n")
-            f.write("# WARNING: Keep this file secure and never commit to version control\n\n")
-            f.write(
-                "# Alternatively set OPENAI_API_KEY and ANTHROPIC_API_KEY "
-                "environment variables. Config file takes precedence.\n\n"
-            )
-            yaml.dump(secrets_data, f, default_flow_style=False, sort_keys=False)
-
-    def _process_simple_secret(self, secret: str, secrets_data: dict, mcp_servers_env: dict):
-        """Process a simple secret reference."""
-        if secret == "OPENAI_API_KEY":
-            if "openai" not in secrets_data:
-                secrets_data["openai"] = {}
-            secrets_data["openai"]["api_key"] = "<your-api-key-here>"
-        elif secret == "ANTHROPIC_API_KEY":
-            if "anthropic" not in secrets_data:
-                secrets_data["anthropic"] = {}
-            secrets_data["anthropic"]["api_key"] = "<your-api-key-here>"
-        elif secret == "AZURE_OPENAI_API_KEY":
-            if "azure" not in secrets_data:
-                secrets_data["azure"] = {}
-            secrets_data["azure"]["api_key"] = "<your-azure-api-key-here>"
-        elif secret == "ALIYUN_API_KEY":
-            if "aliyun" not in secrets_data:
-                secrets_data["aliyun"] = {}
-            secrets_data["aliyun"]["api_key"] = "<your-aliyun-api-key-here>"
-        else:
-            # Handle server-specific environment variables
-            server_found = False
-            for server_name, server in self.config.servers.items():
-                if hasattr(server, 'env') and server.env and secret in server.env:
-                    if server_name not in mcp_servers_env:
-                        mcp_servers_env[server_name] = {"env": {}}
-                    mcp_servers_env[server_name]["env"][secret] = f"<your_{secret.lower()}_here>"
-                    server_found = True
-                    break
-
-            if not server_found:
-                # Generic environment variable
-                if "environment" not in mcp_servers_env:
-                    mcp_servers_env["environment"] = {"env": {}}
-                mcp_servers_env["environment"]["env"][secret] = f"<your_{secret.lower()}_here>"
-
-    def _process_secret_value(self, secret, secrets_data: dict, mcp_servers_env: dict):
-        """Process a secret with an inline value."""
-        secret_name = secret.name
-        secret_value = secret.value
-
-        if secret_name == "OPENAI_API_KEY":
-            if "openai" not in secrets_data:
-                secrets_data["openai"] = {}
-            secrets_data["openai"]["api_key"] = secret_value
-        elif secret_name == "ANTHROPIC_API_KEY":
-            if "anthropic" not in secrets_data:
-                secrets_data["anthropic"] = {}
-            secrets_data["anthropic"]["api_key"] = secret_value
-        elif secret_name == "AZURE_OPENAI_API_KEY":
-            if "azure" not in secrets_data:
-                secrets_data["azure"] = {}
-            secrets_data["azure"]["api_key"] = secret_value
-        elif secret_name == "ALIYUN_API_KEY":
-            if "aliyun" not in secrets_data:
-                secrets_data["aliyun"] = {}
-            secrets_data["aliyun"]["api_key"] = secret_value
-        else:
-            # Handle server-specific environment variables
-            server_found = False
-            for server_name, server in self.config.servers.items():
-                if hasattr(server, 'env') and server.env and secret_name in server.env:
-                    if server_name not in mcp_servers_env:
-                        mcp_servers_env[server_name] = {"env": {}}
-                    mcp_servers_env[server_name]["env"][secret_name] = secret_value
-                    server_found = True
-                    break
-
-            if not server_found:
-                # Generic environment variable
-                if "environment" not in mcp_servers_env:
-                    mcp_servers_env["environment"] = {"env": {}}
-                mcp_servers_env["environment"]["env"][secret_name] = secret_value
-
-    def _process_secret_context(self, secret, secrets_data: dict):
+        # The secrets file is layered over the config file, so drop base_urls an agent BASE_URL overrides
+        for provider in self._provider_base_urls():
+            secrets_data.get(provider, {}).pop("base_url", None)
+
+        return secrets_data
+
+    def secrets_layout(self) -> dict:
+        """Return the fastagent.secrets.yaml structure with environment variable names in place of values."""
+        return self._build_secrets_data(runtime=True)
+
+    def _place_secret(self, name: str, value, secrets_data: dict, mcp_servers_env: dict):
+        """Put a secret where fast-agent reads it, with a placeholder when value is None.
+
+        Well-known provider names become provider settings; others go to the env of the server that
+        uses them, or to the environment passthrough section.
+        """
+        if name in PROVIDER_SECRETS:
+            provider, key = PROVIDER_SECRETS[name]
+            placeholder = PROVIDER_PLACEHOLDERS.get(provider, f"<your-{key.replace('_', '-')}-here>")
+            secrets_data.setdefault(provider, {})[key] = placeholder if value is None else value
+            return
+
+        # Handle server-specific environment variables
+        section = "environment"
+        for server_name, server in self.config.servers.items():
+            if server.env and name in server.env:
+                section = server_name
+                break
+        placeholder = f"<your_{name.lower()}_here>"
+        mcp_servers_env.setdefault(section, {"env": {}})["env"][name] = placeholder if value is None else value
+
+    def _process_secret_context(self, secret, secrets_data: dict, runtime: bool = False):
         """Process a secret context with multiple key-value pairs."""
         context_name = secret.name.lower()
 
         if context_name not in secrets_data:
             secrets_data[context_name] = {}
 
-        for key, value in secret.values.items():
-            secrets_data[context_name][key.lower()] = value
+        for key in secret.values:
+            name = f"{secret.name.upper()}_{key}"
+            secrets_data[context_name][key.lower()] = name if runtime else f"${{{name}}}"
 
     def get_dockerfile_config_lines(self) -> List[str]:
         """Get Fast-Agent specific Dockerfile configuration lines."""
-        return [
-            "COPY fastagent.config.yaml .",
-            "COPY fastagent.secrets.yaml .",
-        ]
+        if self.combined_config:
+            return ["COPY fastagent.config.yaml ."]
+        config_lines = self._embedded_config_lines() if self.embed_config else ["COPY fastagent.config.yaml ."]
+        if self.runtime_secrets:
+            return config_lines + [f"COPY {SECRETS_ENTRYPOINT_FILENAME} ."]
+        return config_lines + ["COPY fastagent.secrets.yaml ."]
+
+    def _embedded_config_lines(self) -> List[str]:
+        """Return a heredoc COPY that writes fastagent.config.yaml from the Dockerfile itself.
+
+        The quoted delimiter keeps Docker from expanding the ${NAME} references fast-agent fills in.
+        """
+        text = self.config_yaml_text()
+        delimiter = "FASTAGENT_CONFIG"
+        while delimiter in text.splitlines():
+            delimiter += "_END"
+        return [f"COPY <<'{delimiter}' fastagent.config.yaml", *text.splitlines(), delimiter]
+
+    def get_config_files(self) -> List[str]:
+        """Get the names of the generated configuration files."""
+        if self.combined_config or self.runtime_secrets:
+            return ["fastagent.config.yaml"]
+        return ["fastagent.config.yaml", "fastagent.secrets.yaml"]
+
+
+def _merge_config(base: dict, overlay: dict) -> dict:
+    """Deep-merge overlay into base the way fast-agent layers the secrets file over the config file."""
+    merged = dict(base)
+    for key, value in overlay.items():
+        if isinstance(value, dict) and isinstance(merged.get(key), dict):
+            merged[key] = _merge_config(merged[key], value)
+        else:
+            merged[key] = value
+    return merged
@@ -0,0 +1,204 @@
+"""Static checks for Agentfile configurations.
+
+Each check is a Rule: an ID, which is also the code of the diagnostics it
+reports, and a function from a parsed configuration to its findings. Rules run
+over the configuration rather than the text, so they work the same on
+configurations built in code.
+"""
+
+import urllib.parse
+from dataclasses import dataclass
+from typing import Callable, Iterable, List, Optional
+
+from agentman.agentfile_parser import AgentfileConfig, SecretContext, env_reference
+from agentman.diagnostics import SEVERITY_WARNING, Diagnostic
+from agentman.environment import model_provider
+
+# Public API hosts and the model provider they serve
+PROVIDER_HOSTS = {
+    "api.openai.com": "openai",
+    "api.anthropic.com": "anthropic",
+    "generativelanguage.googleapis.com": "google",
+    "api.deepseek.com": "deepseek",
+    "openrouter.ai": "openrouter",
+    "api.groq.com": "groq",
+}
+
+# Characters past which an INSTRUCTION is reported as long
+MAX_INSTRUCTION_LENGTH = 4000
+
+
+@dataclass(frozen=True)
+class Rule:
+    """A lint check and the ID its findings are reported, and disabled, under."""
+
+    id: str
+    description: str
+    check: Callable[[AgentfileConfig], List[Diagnostic]]
+
+
+def check_unpinned_packages(config: AgentfileConfig) -> List[Diagnostic]:
+    """Flag npx/uvx servers whose package version floats."""
+    diagnostics = []
+    for server in config.servers.values():
+        if server.package and server.package.version is None:
+            diagnostics.append(
+                Diagnostic(
+                    SEVERITY_WARNING,
+                    "unpinned-package",
+                    f"Server {server.name} launches {server.package.name} without a version; "
+                    f"pin it as {server.package.name}@<version> or run `agentman lock`",
+                    server.line,
+                )
+            )
+    return diagnostics
+
+
+def check_unverified_downloads(config: AgentfileConfig) -> List[Diagnostic]:
+    """Flag ADD instructions that download URLs without a --checksum."""
+    diagnostics = []
+    for instruction in config.dockerfile_instructions:
+        if instruction.instruction != "ADD" or instruction.flag("checksum") is not None:
+            continue
+        urls = [source for source in instruction.sources if source.startswith(("http://", "https://"))]
+        if urls:
+            diagnostics.append(
+                Diagnostic(
+                    SEVERITY_WARNING,
+                    "add-without-checksum",
+                    f"ADD downloads {', '.join(urls)} without verifying it; add --checksum=sha256:<digest>",
+                    instruction.line,
+                )
+            )
+    return diagnostics
+
+
+def _base_url_provider(base_url: str) -> Optional[str]:
+    """Guess the provider a BASE_URL points at, from a known host or a <PROVIDER>_BASE_URL reference."""
+    name = env_reference(base_url)
+    if name:
+        return name[: -len("_BASE_URL")].lower() if name.endswith("_BASE_URL") else None
+    return PROVIDER_HOSTS.get(urllib.parse.urlparse(base_url).hostname or "")
+
+
+def check_base_url_provider(config: AgentfileConfig) -> List[Diagnostic]:
+    """Flag agents whose BASE_URL belongs to a different provider than their MODEL."""
+    diagnostics = []
+    for agent in config.agents.values():
+        model = config.model_for(agent.name)
+        if not agent.base_url or not model:
+            continue
+        url_provider = _base_url_provider(agent.base_url)
+        provider = model_provider(model)
+        if url_provider and provider and url_provider != provider:
+            diagnostics.append(
+                Diagnostic(
+                    SEVERITY_WARNING,
+                    "base-url-provider-mismatch",
+                    f"Agent {agent.name} uses a {url_provider} BASE_URL with the {provider} model {model}",
+                    agent.line,
+                )
+            )
+    return diagnostics
+
+
+def check_inline_secret_contexts(config: AgentfileConfig) -> List[Diagnostic]:
+    """Flag values typed into a SECRET block; the parser already reports the one-line SECRET NAME value form."""
+    diagnostics = []
+    for secret in config.secrets:
+        if not isinstance(secret, SecretContext):
+            continue
+        keys = [key for key, value in secret.values.items() if not env_reference(value)]
+        if keys:
+            diagnostics.append(
+                Diagnostic(
+                    SEVERITY_WARNING,
+                    "secret-inline-value",
+                    f"SECRET {secret.name} sets {', '.join(keys)} inline, which is written into the generated files; "
+                    "use $VARIABLE references and pass the values at run time",
+                    secret.line,
+                )
+            )
+    return diagnostics
+
+
+def check_expose_without_listener(config: AgentfileConfig) -> List[Diagnostic]:
+    """Flag EXPOSE when the container only runs the generated agent, which reads stdin and listens on no port."""
+    if not config.expose_ports or config.resolved_cmd_mode != "generated":
+        return []
+    lines = [inst.line for inst in config.agent_instructions if inst.instruction == "EXPOSE"]
+    ports = ", ".join(str(port) for port in config.expose_ports)
+    return [
+        Diagnostic(
+            SEVERITY_WARNING,
+            "expose-without-listener",
+            f"EXPOSE {ports} has nothing listening: the generated agent does not serve on a port; "
+            "set a CMD that starts a server or remove the EXPOSE",
+            lines[0] if lines else None,
+        )
+    ]
+
+
+def check_single_step_chains(config: AgentfileConfig) -> List[Diagnostic]:
+    """Flag chains that run a single step, which adds a workflow without changing what runs."""
+    return [
+        Diagnostic(
+            SEVERITY_WARNING,
+            "single-step-chain",
+            f"Chain {chain.name} only runs {chain.sequence[0]}; use {chain.sequence[0]} directly or add steps",
+            chain.line,
+        )
+        for chain in config.chains.values()
+        if len(chain.sequence) == 1
+    ]
+
+
+def check_instruction_length(config: AgentfileConfig) -> List[Diagnostic]:
+    """Flag INSTRUCTIONs longer than MAX_INSTRUCTION_LENGTH characters."""
+    entities = [
+        ("Agent", config.agents),
+        ("Router", config.routers),
+        ("Chain", config.chains),
+        ("Parallel", config.parallels),
+        ("Orchestrator", config.orchestrators),
+    ]
+    diagnostics = []
+    for kind, items in entities:
+        for item in items.values():
+            if item.instruction and len(item.instruction) > MAX_INSTRUCTION_LENGTH:
+                diagnostics.append(
+                    Diagnostic(
+                        SEVERITY_WARNING,
+                        "long-instruction",
+                        f"{kind} {item.name} has a {len(item.instruction)}-character INSTRUCTION, over "
+                        f"{MAX_INSTRUCTION_LENGTH}; every request sends it, so keep it to what the model needs",
+                        item.line,
+                    )
+                )
+    return diagnostics
+
+
+RULES = [
+    Rule("unpinned-package", "npx/uvx server packages without a version", check_unpinned_packages),
+    Rule("add-without-checksum", "ADD downloads without --checksum", check_unverified_downloads),
+    Rule("base-url-provider-mismatch", "BASE_URL of another provider than MODEL", check_base_url_provider),
+    Rule("secret-inline-value", "values typed into a SECRET block", check_inline_secret_contexts),
+    Rule("expose-without-listener", "EXPOSE while only the generated agent runs", check_expose_without_listener),
+    Rule("single-step-chain", "chains with a single step", check_single_step_chains),
+    Rule("long-instruction", f"INSTRUCTIONs over {MAX_INSTRUCTION_LENGTH} characters", check_instruction_length),
+]
+
+
+def lint_config(
+    config: AgentfileConfig, disabled: Iterable[str] = (), rules: Optional[List[Rule]] = None
+) -> List[Diagnostic]:
+    """Run lint rules over a parsed configuration.
+
+    Rules default to RULES; pass rules to add checks of your own. Rules whose ID is in disabled are skipped.
+    """
+    disabled = set(disabled)
+    diagnostics = []
+    for rule in RULES if rules is None else rules:
+        if rule.id not in disabled:
+            diagnostics.extend(rule.check(config))
+    return diagnostics
@@ -0,0 +1,127 @@
+"""Lockfile support pinning the packages an agent image installs."""
+
+import json
+import re
+import urllib.parse
+import urllib.request
+from pathlib import Path
+from typing import Callable, Dict, List, Optional
+
+from agentman.agentfile_parser import AgentfileConfig, split_package_spec
+
+LOCKFILE_VERSION = 1
+REGISTRY_TIMEOUT = 30
+
+REGISTRY_URLS = {
+    "npm": "https://registry.npmjs.org/{name}/latest",
+    "pip": "https://pypi.org/pypi/{name}/json",
+    "uv": "https://pypi.org/pypi/{name}/json",
+}
+
+
+def lockfile_path(agentfile_path: str) -> Path:
+    """Return the lockfile path that belongs to an Agentfile."""
+    path = Path(agentfile_path)
+    return path.with_name(f"{path.name}.lock")
+
+
+def package_name(installer: str, spec: str) -> str:
+    """Strip version constraints and extras from a package spec."""
+    if installer in ["npm", "uv"]:
+        return split_package_spec(installer, spec)[0]
+    return re.split(r"[\[<>=!~; ]", spec, maxsplit=1)[0]
+
+
+def fetch_json(url: str) -> dict:
+    """Fetch and decode a JSON document from a package registry."""
+    with urllib.request.urlopen(url, timeout=REGISTRY_TIMEOUT) as response:  # nosec - fixed registry URLs
+        return json.load(response)
+
+
+def resolve_version(installer: str, name: str, fetch: Callable[[str], dict] = fetch_json) -> str:
+    """Query the package registry for the latest version of a package."""
+    url = REGISTRY_URLS[installer].format(name=urllib.parse.quote(name, safe="@"))
+    try:
+        data = fetch(url)
+    except (OSError, ValueError) as e:
+        raise IOError(f"Failed to resolve {installer} package {name}: {e}") from e
+    if installer == "npm":
+        return data["version"]
+    return data["info"]["version"]
+
+
+def generate_lock(packages: Dict[str, List[str]], fetch: Callable[[str], dict] = fetch_json) -> dict:
+    """Resolve every package to an exact version."""
+    locked = {}
+    for installer, specs in packages.items():
+        for spec in specs:
+            name = package_name(installer, spec)
+            locked[f"{installer}:{name}"] = {"name": name, "version": resolve_version(installer, name, fetch)}
+    return {"lockfile_version": LOCKFILE_VERSION, "packages": dict(sorted(locked.items()))}
+
+
+def write_lock(path: Path, lock: dict):
+    """Write a lockfile to disk."""
+    with open(path, 'w', encoding='utf-8') as f:
+        json.dump(lock, f, indent=2)
+        f.write("\n")
+
+
+def read_lock(path: Path) -> Optional[dict]:
+    """Read a lockfile, returning None when it does not exist."""
+    if not path.exists():
+        return None
+    with open(path, 'r', encoding='utf-8') as f:
+        lock = json.load(f)
+    if lock.get("lockfile_version") != LOCKFILE_VERSION:
+        raise ValueError(f"Unsupported lockfile version in {path}: {lock.get('lockfile_version')}")
+    return lock
+
+
+def _pinned_spec(installer: str, name: str, version: str) -> str:
+    """Return a package spec pinned to an exact version."""
+    if installer in ["npm", "uv"]:
+        # npx and uvx both accept the name@version form
+        return f"{name}@{version}"
+    return f"{name}=={version}"
+
+
+class LockApplier:
+    """Pins package specs to the versions recorded in a lockfile."""
+
+    def __init__(self, lock: dict, frozen: bool = False):
+        self.packages = lock.get("packages", {})
+        self.frozen = frozen
+        self.missing: List[str] = []
+
+    def locked_version(self, installer: str, spec: str) -> Optional[str]:
+        """Return the locked version of a package, recording it when the lock lacks an entry."""
+        name = package_name(installer, spec)
+        entry = self.packages.get(f"{installer}:{name}")
+        if entry is None:
+            if self.frozen:
+                raise ValueError(f"{installer} package {name} is missing from the lockfile; run `agentman lock`")
+            if spec not in self.missing:
+                self.missing.append(spec)
+            return None
+        return entry["version"]
+
+    def pin(self, installer: str, spec: str) -> str:
+        """Pin a single package spec to its locked version."""
+        version = self.locked_version(installer, spec)
+        if version is None:
+            return spec
+        return _pinned_spec(installer, package_name(installer, spec), version)
+
+    def apply_to_servers(self, config: AgentfileConfig):
+        """Pin the npx/uvx package of every MCP server; explicit pins in ARGS win."""
+        for server in config.servers.values():
+            if server.package is None or server.package.version is not None:
+                continue
+            version = self.locked_version(server.package.installer, server.package.name)
+            if version is not None:
+                server.pin_package(version)
+
+    def apply_to_requirements(self, requirements: List[str]) -> List[str]:
+        """Pin Python requirements."""
+        return [self.pin("pip", requirement) for requirement in requirements]
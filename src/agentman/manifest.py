@@ -0,0 +1,177 @@
+"""Build manifest describing the configuration baked into an agent image."""
+
+import json
+from dataclasses import asdict
+from typing import Any, Dict, List, Optional
+
+from agentman.agentfile_parser import DOCKER_SOCKET_MOUNT, AgentfileConfig, Healthcheck, Orchestrator, Serve
+from agentman.run_hints import build_run_hints
+from agentman.version import version
+
+MANIFEST_SCHEMA_VERSION = 1
+MANIFEST_FILENAME = "agentman.json"
+MANIFEST_LABEL = "agentman.config"
+
+
+def _orchestrator_data(orchestrator: Orchestrator) -> Dict[str, Any]:
+    """Describe an orchestrator, leaving out settings that fall back to framework defaults."""
+    data = {"agents": orchestrator.agents, "plan_type": orchestrator.plan_type, "default": orchestrator.default}
+    if orchestrator.plan_iterations is not None:
+        data["plan_iterations"] = orchestrator.plan_iterations
+    if orchestrator.instruction:
+        data["instruction"] = orchestrator.instruction
+    return data
+
+
+def _serve_data(serve: Optional[Serve]) -> Optional[Dict[str, Any]]:
+    """Describe how SERVE runs the agents, or None when they run interactively."""
+    if serve is None:
+        return None
+    return {"protocol": serve.protocol, "port": serve.port, "path": serve.endpoint}
+
+
+def _healthcheck_data(healthcheck: Optional[Healthcheck]) -> Optional[Dict[str, Any]]:
+    """Describe the HEALTHCHECK the image runs, without its Agentfile line."""
+    if healthcheck is None:
+        return None
+    data = asdict(healthcheck)
+    del data["line"]
+    return data
+
+
+def build_manifest(config: AgentfileConfig, has_prompt_file: bool = False) -> Dict[str, Any]:
+    """Build the manifest for a parsed Agentfile.
+
+    The manifest never contains secret values or environment values.
+    """
+    servers = {}
+    for name, server in config.servers.items():
+        server_data = server.to_config_dict()
+        if "env" in server_data:
+            server_data["env"] = sorted(server_data["env"].keys())
+        if "headers" in server_data:
+            server_data["headers"] = sorted(server_data["headers"].keys())
+        servers[name] = server_data
+
+    agents = {}
+    for name, agent in config.agents.items():
+        agents[name] = {
+            "model": config.model_for(name),
+            "servers": agent.servers,
+            "use_history": agent.use_history,
+            "human_input": agent.human_input,
+            "default": agent.default,
+        }
+        if agent.tools:
+            agents[name]["tools"] = agent.tools
+        if agent.resources:
+            agents[name]["resources"] = agent.resources
+
+    return {
+        "schema_version": MANIFEST_SCHEMA_VERSION,
+        "agentman_version": version(),
+        "framework": config.framework,
+        "base_image": config.base_image,
+        "agentfile_base_image": config.agentfile_base_image,
+        "default_model": config.default_model,
+        "servers": servers,
+        "agents": agents,
+        "routers": {
+            name: {"agents": r.agents, "human_input": r.human_input, "default": r.default}
+            for name, r in config.routers.items()
+        },
+        "chains": {name: {"sequence": c.sequence, "default": c.default} for name, c in config.chains.items()},
+        "parallels": {
+            name: {"fan_out": p.fan_out, "fan_in": p.fan_in, "default": p.default}
+            for name, p in config.parallels.items()
+        },
+        "orchestrators": {name: _orchestrator_data(o) for name, o in config.orchestrators.items()},
+        "secrets": config.secret_names(),
+        "env": list(config.image_env),
+        "expose_ports": config.expose_ports,
+        "volumes": config.volumes,
+        "serve": _serve_data(config.serve),
+        "healthcheck": _healthcheck_data(config.image_healthcheck()),
+        "mounts": [DOCKER_SOCKET_MOUNT] if any(s.uses_docker for s in config.servers.values()) else [],
+        "entrypoint": config.entrypoint,
+        "cmd": config.cmd,
+        "cmd_mode": config.resolved_cmd_mode,
+        "shutdown_grace_seconds": config.shutdown_grace,
+        "run_hints": build_run_hints(config, has_prompt_file).to_dict(),
+    }
+
+
+def label_value(text: str, escape: str = "\\") -> str:
+    """Encode text as a double-quoted Dockerfile LABEL value that Docker reads back unchanged."""
+    # Escape the characters the Dockerfile word parser treats specially
+    escaped = text.replace(escape, escape * 2).replace('"', f'{escape}"').replace("$", f"{escape}$")
+    return f'"{escaped}"'
+
+
+def manifest_label_value(manifest: Dict[str, Any], escape: str = "\\") -> str:
+    """Encode a manifest as a double-quoted Dockerfile LABEL value."""
+    return label_value(json.dumps(manifest, separators=(",", ":"), sort_keys=True), escape)
+
+
+def manifest_from_labels(labels: Optional[Dict[str, str]]) -> Optional[Dict[str, Any]]:
+    """Extract the manifest from image labels, if present."""
+    if not labels or MANIFEST_LABEL not in labels:
+        return None
+    return json.loads(labels[MANIFEST_LABEL])
+
+
+def describe_manifest(manifest: Dict[str, Any]) -> str:
+    """Render a manifest as a human-readable summary."""
+    lines = [
+        f"Framework:     {manifest.get('framework')}",
+        f"Base image:    {manifest.get('base_image')}",
+        f"Default model: {manifest.get('default_model') or '-'}",
+        f"Built with:    agentman {manifest.get('agentman_version')}",
+    ]
+    if manifest.get("agentfile_base_image"):
+        lines.insert(2, f"               (overridden, Agentfile declares {manifest['agentfile_base_image']})")
+
+    servers = manifest.get("servers", {})
+    if servers:
+        lines.append("MCP servers:")
+        for name, server in servers.items():
+            target = server.get("url") or " ".join([server.get("command", "")] + server.get("args", []))
+            lines.append(f"  - {name} ({server.get('transport')}): {target.strip()}")
+
+    agents = manifest.get("agents", {})
+    if agents:
+        lines.append("Agents:")
+        for name, agent in agents.items():
+            suffix = " [default]" if agent.get("default") else ""
+            lines.append(f"  - {name}: model={agent.get('model') or '-'}{suffix}")
+
+    for section, key in [("Routers", "agents"), ("Chains", "sequence"), ("Orchestrators", "agents")]:
+        items = manifest.get(section.lower(), {})
+        if items:
+            lines.append(f"{section}:")
+            for name, item in items.items():
+                suffix = " [default]" if item.get("default") else ""
+                lines.append(f"  - {name}: {', '.join(item.get(key, []))}{suffix}")
+
+    if manifest.get("secrets"):
+        lines.append("Secrets:       " + ", ".join(manifest["secrets"]))
+    if manifest.get("env"):
+        lines.append("Environment:   " + ", ".join(manifest["env"]))
+    if manifest.get("mounts"):
+        lines.append("Mounts:        " + ", ".join(manifest["mounts"]))
+    if manifest.get("expose_ports"):
+        lines.append("Ports:         " + ", ".join(str(p) for p in manifest["expose_ports"]))
+    if manifest.get("volumes"):
+        lines.append("Volumes:       " + ", ".join(manifest["volumes"]))
+    serve = manifest.get("serve")
+    if serve:
+        lines.append(f"Serves:        {serve['protocol']} on port {serve['port']}, path {serve['path']}")
+    cmd_mode = manifest.get("cmd_mode")
+    suffix = f" ({cmd_mode})" if cmd_mode and cmd_mode != "generated" else ""
+    if manifest.get("entrypoint"):
+        lines.append(f"Entrypoint:    {json.dumps(manifest['entrypoint'])}")
+    lines.append(f"Command:       {json.dumps(manifest.get('cmd'))}{suffix}")
+    if manifest.get("shutdown_grace_seconds") is not None:
+        lines.append(f"Shutdown:      {manifest['shutdown_grace_seconds']}s grace after SIGTERM")
+
+    return "\n".join(lines)
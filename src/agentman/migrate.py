@@ -0,0 +1,128 @@
+"""Mechanical rewrites of older Agentfile syntax into the current canonical form.
+
+Each migration is keyed by the rule ID of the construct it replaces. Migrations
+edit the physical lines of the instructions they match, so comments, blank
+lines and everything else in the file are kept as written.
+"""
+
+import re
+from dataclasses import dataclass, field
+from typing import Callable, Dict, List, Tuple
+
+from agentman.agentfile_parser import AgentfileParser
+
+# Flat SECRET names the frameworks read as a provider's api_key, mapped to the provider group
+PROVIDER_KEY_SECRETS = {"OPENAI_API_KEY": "openai", "ANTHROPIC_API_KEY": "anthropic", "ALIYUN_API_KEY": "aliyun"}
+TRUE_VALUES = ["1", "yes"]  # Accepted by DEFAULT besides the canonical true
+
+SERVER_PATTERN = re.compile(r"^(\s*)SERVER(\s)", re.IGNORECASE)
+FLAT_SECRET_PATTERN = re.compile(r"^(\s*)SECRET\s+(\S+)\s+(.+)$", re.IGNORECASE)
+DEFAULT_PATTERN = re.compile(r"^(\s*)DEFAULT(\s+)(\S+)(\s*(?:#.*)?)$", re.IGNORECASE)
+DECLARATION_PATTERN = re.compile(r"^(AGENT|ROUTER|CHAIN|ORCHESTRATOR)\s+(\S+)", re.IGNORECASE)
+
+# A migration takes the physical lines and the (line number, logical line) instructions, and returns
+# the new lines, how many instructions it rewrote and notes about what it could not rewrite
+MigrationFunc = Callable[[List[str], List[tuple]], Tuple[List[str], int, List[str]]]
+
+
+@dataclass
+class Migration:
+    """A rewrite from one deprecated construct to its current syntax."""
+
+    rule: str
+    description: str
+    apply: MigrationFunc
+
+
+@dataclass
+class MigrationResult:
+    """The migrated text, how many instructions each rule rewrote, and what needs a manual fix."""
+
+    text: str
+    applied: Dict[str, int] = field(default_factory=dict)
+    manual: List[str] = field(default_factory=list)
+
+
+def migrate_server_alias(lines: List[str], instructions: List[tuple]) -> Tuple[List[str], int, List[str]]:
+    """Rename SERVER blocks to MCP_SERVER."""
+    lines = list(lines)
+    rewritten = 0
+    for line_num, logical in instructions:
+        if logical.split(None, 1)[0].upper() == "SERVER":
+            lines[line_num - 1] = SERVER_PATTERN.sub(r"\1MCP_SERVER\2", lines[line_num - 1], count=1)
+            rewritten += 1
+    return lines, rewritten, []
+
+
+def migrate_flat_provider_secret(lines: List[str], instructions: List[tuple]) -> Tuple[List[str], int, List[str]]:
+    """Move inline provider API keys such as SECRET OPENAI_API_KEY sk-... into provider groups."""
+    lines = list(lines)
+    rewritten = 0
+    notes = []
+    # From the bottom up, so inserting a line does not shift the ones still to rewrite
+    for line_num, logical in reversed(instructions):
+        match = FLAT_SECRET_PATTERN.match(logical)
+        if not match or match.group(2).upper() not in PROVIDER_KEY_SECRETS:
+            continue
+        physical = FLAT_SECRET_PATTERN.match(lines[line_num - 1])
+        if not physical or physical.group(3) != match.group(3):
+            notes.append(f"line {line_num}: move SECRET {match.group(2)} into a provider group by hand")
+            continue
+        indent, provider = physical.group(1), PROVIDER_KEY_SECRETS[match.group(2).upper()]
+        lines[line_num - 1 : line_num] = [f"{indent}SECRET {provider}", f"{indent}API_KEY {physical.group(3)}"]
+        rewritten += 1
+    return lines, rewritten, notes
+
+
+def migrate_default_flag(lines: List[str], instructions: List[tuple]) -> Tuple[List[str], int, List[str]]:
+    """Write DEFAULT flags as true, and report files with more than one default entity."""
+    lines = list(lines)
+    rewritten = 0
+    defaults = []
+    entity = None
+    for line_num, logical in instructions:
+        declaration = DECLARATION_PATTERN.match(logical)
+        if declaration:
+            entity = declaration.group(2)
+            continue
+        match = DEFAULT_PATTERN.match(lines[line_num - 1])
+        if not match:
+            continue
+        value = match.group(3).lower()
+        if value in TRUE_VALUES:
+            lines[line_num - 1] = f"{match.group(1)}DEFAULT{match.group(2)}true{match.group(4)}"
+            rewritten += 1
+        if value in TRUE_VALUES + ["true"]:
+            defaults.append(entity)
+    notes = []
+    if len(defaults) > 1:
+        notes.append(f"several entities are DEFAULT ({', '.join(defaults)}); keep DEFAULT true on only one")
+    return lines, rewritten, notes
+
+
+MIGRATIONS = [
+    Migration("server-alias", "SERVER is now MCP_SERVER", migrate_server_alias),
+    Migration(
+        "flat-provider-secret", "Provider API keys belong in SECRET <provider> groups", migrate_flat_provider_secret
+    ),
+    Migration("default-flag", "DEFAULT takes true, on a single entity", migrate_default_flag),
+]
+
+
+def migrate_content(content: str) -> MigrationResult:
+    """Apply every migration in turn and return the rewritten text."""
+    # Parse the original first, so a file that is already broken is reported instead of rewritten
+    AgentfileParser().parse_content(content)
+
+    result = MigrationResult(text=content)
+    for migration in MIGRATIONS:
+        instructions = AgentfileParser().logical_lines(result.text)
+        lines, rewritten, notes = migration.apply(result.text.split("\n"), instructions)
+        if rewritten:
+            result.applied[migration.rule] = rewritten
+        result.manual.extend(f"[{migration.rule}] {note}" for note in notes)
+        result.text = "\n".join(lines)
+
+    # Every rewrite must still parse
+    AgentfileParser().parse_content(result.text)
+    return result
@@ -0,0 +1,50 @@
+"""Removal of the agents, workflows and servers the default entity never reaches."""
+
+from typing import List, Set
+
+from agentman.agentfile_parser import AgentfileConfig
+
+ENTITY_KINDS = ["agents", "routers", "chains", "parallels", "orchestrators"]
+
+
+def all_entities(config: AgentfileConfig) -> dict:
+    """Return every agent and workflow by name."""
+    return config.entities()
+
+
+def reachable_entities(config: AgentfileConfig) -> Set[str]:
+    """Return the names reachable from the DEFAULT entities, or every name when none is marked DEFAULT.
+
+    Without a DEFAULT the generated agent lets the user pick any agent, so all of them stay reachable.
+    """
+    entities = all_entities(config)
+    graph = config.workflow_graph()
+    pending = [name for name, entity in entities.items() if entity.default] or list(entities)
+    reached = set()
+    while pending:
+        name = pending.pop()
+        if name in reached or name not in graph:
+            continue
+        reached.add(name)
+        pending.extend(graph[name])
+    return reached
+
+
+def prune_unused(config: AgentfileConfig) -> List[str]:
+    """Drop unreachable agents, workflows and servers from the configuration and describe what went.
+
+    Run this after parsing, so unused definitions are still validated.
+    """
+    reached = reachable_entities(config)
+    pruned = []
+    for kind in ENTITY_KINDS:
+        entities = getattr(config, kind)
+        for name in [name for name in entities if name not in reached]:
+            del entities[name]
+            pruned.append(f"{kind[:-1]} {name}")
+
+    used_servers = {server for agent in config.agents.values() for server in agent.servers}
+    for name in [name for name in config.servers if name not in used_servers]:
+        del config.servers[name]
+        pruned.append(f"server {name}")
+    return pruned
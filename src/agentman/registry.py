@@ -0,0 +1,184 @@
+"""Reading image labels from a container registry without pulling the image."""
+
+import hashlib
+import json
+import os
+import re
+import urllib.error
+import urllib.parse
+import urllib.request
+from pathlib import Path
+from typing import Callable, Dict, Optional, Tuple
+
+from agentman.remote import FETCH_TIMEOUT
+
+DOCKER_HUB = "registry-1.docker.io"
+# Key Docker Hub credentials are stored under in ~/.docker/config.json
+DOCKER_HUB_AUTH_KEY = "https://index.docker.io/v1/"
+MAX_REGISTRY_DOCUMENT_SIZE = 4 * 1024 * 1024  # 4 MiB
+
+IMAGE_INDEX_TYPES = [
+    "application/vnd.oci.image.index.v1+json",
+    "application/vnd.docker.distribution.manifest.list.v2+json",
+]
+IMAGE_MANIFEST_TYPES = [
+    "application/vnd.oci.image.manifest.v1+json",
+    "application/vnd.docker.distribution.manifest.v2+json",
+]
+
+# A transport sends (method, url, headers) and returns (status, response headers, body)
+Transport = Callable[[str, str, Dict[str, str]], Tuple[int, Dict[str, str], bytes]]
+
+
+def http_transport(method: str, url: str, headers: Dict[str, str]) -> Tuple[int, Dict[str, str], bytes]:
+    """Send a request with urllib, returning error statuses instead of raising."""
+    request = urllib.request.Request(url, headers=headers, method=method)
+    try:
+        with urllib.request.urlopen(request, timeout=FETCH_TIMEOUT) as response:  # nosec - https URLs built here
+            return response.status, dict(response.headers), response.read(MAX_REGISTRY_DOCUMENT_SIZE + 1)
+    except urllib.error.HTTPError as e:
+        return e.code, dict(e.headers), b""
+
+
+def parse_image_reference(reference: str) -> Tuple[str, str, str]:
+    """Split an image reference into registry host, repository and tag or digest, with Docker's defaults."""
+    name, _, digest = reference.partition("@")
+    registry = DOCKER_HUB
+    first, sep, rest = name.partition("/")
+    if sep and ("." in first or ":" in first or first == "localhost"):
+        registry, name = first, rest
+    elif not sep:
+        name = f"library/{name}"
+    tag = "latest"
+    if ":" in name.rsplit("/", 1)[-1]:
+        name, tag = name.rsplit(":", 1)
+    if not name:
+        raise ValueError(f"Invalid image reference: {reference}")
+    return registry, name, digest or tag
+
+
+def docker_credentials(registry: str, config_path: Optional[Path] = None) -> Optional[str]:
+    """Return the base64 user:password docker login stored for registry, if any.
+
+    Credentials kept by a credential helper are not read.
+    """
+    config_path = config_path or Path(os.environ.get("DOCKER_CONFIG") or Path.home() / ".docker") / "config.json"
+    try:
+        auths = json.loads(config_path.read_text(encoding="utf-8")).get("auths", {})
+    except (OSError, ValueError):
+        return None
+    key = DOCKER_HUB_AUTH_KEY if registry == DOCKER_HUB else registry
+    for name in [key, f"https://{key}"]:
+        if auths.get(name, {}).get("auth"):
+            return auths[name]["auth"]
+    return None
+
+
+def header_value(headers: Dict[str, str], name: str) -> str:
+    """Return a response header regardless of its case, or an empty string."""
+    return next((value for key, value in headers.items() if key.lower() == name.lower()), "")
+
+
+def parse_challenge(header: str) -> Tuple[str, Dict[str, str]]:
+    """Split a WWW-Authenticate header into its scheme and parameters."""
+    scheme, _, params = header.strip().partition(" ")
+    return scheme.lower(), dict(re.findall(r'(\w+)="([^"]*)"', params))
+
+
+class RegistryClient:
+    """Reads manifests and blobs of one repository, authenticating the way docker does."""
+
+    def __init__(self, registry: str, repository: str, transport: Transport = http_transport, credentials=None):
+        self.registry = registry
+        self.repository = repository
+        self.transport = transport
+        self.credentials = credentials  # base64 user:password, as docker login stores it
+        scheme = "http" if registry.split(":")[0] in ["localhost", "127.0.0.1"] else "https"
+        self.base_url = f"{scheme}://{registry}/v2/{repository}"
+        self.authorization: Optional[str] = None
+
+    def request(self, method: str, path: str, accept: Optional[list] = None) -> Tuple[Dict[str, str], bytes]:
+        """Send a request for path, answering one authentication challenge, and return headers and body."""
+        url = f"{self.base_url}/{path}"
+        headers = {"Accept": ", ".join(accept)} if accept else {}
+        status, response_headers, body = self.transport(method, url, self._headers(headers))
+        if status == 401:
+            self.authorization = self._authorize(header_value(response_headers, "WWW-Authenticate"))
+            status, response_headers, body = self.transport(method, url, self._headers(headers))
+        if status == 401:
+            raise PermissionError(f"{self.registry} refused access to {self.repository}; run docker login")
+        if status == 404:
+            raise LookupError(f"{self.registry}/{self.repository} has no {path}")
+        if status >= 400:
+            raise IOError(f"{method} {url} failed with status {status}")
+        if len(body) > MAX_REGISTRY_DOCUMENT_SIZE:
+            raise ValueError(f"{url} is larger than the {MAX_REGISTRY_DOCUMENT_SIZE} byte limit")
+        return response_headers, body
+
+    def _headers(self, headers: Dict[str, str]) -> Dict[str, str]:
+        return {**headers, "Authorization": self.authorization} if self.authorization else headers
+
+    def _authorize(self, challenge: str) -> str:
+        """Return the Authorization header a Basic or Bearer challenge asks for."""
+        scheme, params = parse_challenge(challenge)
+        if scheme == "basic" and self.credentials:
+            return f"Basic {self.credentials}"
+        if scheme != "bearer" or "realm" not in params:
+            raise PermissionError(f"{self.registry} asks for authentication agentman cannot provide: {challenge}")
+        query = {key: params[key] for key in ["service", "scope"] if key in params}
+        query.setdefault("scope", f"repository:{self.repository}:pull")
+        headers = {"Authorization": f"Basic {self.credentials}"} if self.credentials else {}
+        status, _, body = self.transport("GET", f"{params['realm']}?{urllib.parse.urlencode(query)}", headers)
+        if status >= 400:
+            raise PermissionError(f"{params['realm']} refused a token for {self.repository} (status {status})")
+        token = json.loads(body)
+        return f"Bearer {token.get('token') or token.get('access_token')}"
+
+    def document(self, kind: str, digest: str, accept: Optional[list] = None) -> dict:
+        """Fetch a manifest or blob by digest and check its content matches the digest."""
+        _, body = self.request("GET", f"{kind}/{digest}", accept)
+        algorithm, _, expected = digest.partition(":")
+        if algorithm != "sha256" or hashlib.sha256(body).hexdigest() != expected:
+            raise ValueError(f"{self.registry}/{self.repository} returned content that does not match {digest}")
+        return json.loads(body)
+
+
+def fetch_image_labels(
+    reference: str, transport: Transport = http_transport, credentials: Optional[str] = None
+) -> Dict[str, str]:
+    """Return the labels of an image in a registry from its manifest and config blob, without pulling layers.
+
+    Multi-platform images are read for linux/amd64, or their first platform when that is missing.
+    """
+    registry, repository, tag_or_digest = parse_image_reference(reference)
+    if credentials is None:
+        credentials = docker_credentials(registry)
+    client = RegistryClient(registry, repository, transport, credentials)
+    accept = IMAGE_INDEX_TYPES + IMAGE_MANIFEST_TYPES
+
+    digest = tag_or_digest
+    if ":" not in tag_or_digest:
+        headers, _ = client.request("HEAD", f"manifests/{tag_or_digest}", accept)
+        digest = header_value(headers, "Docker-Content-Digest")
+        if not digest:
+            raise IOError(f"{registry} did not report the digest of {reference}")
+    manifest = client.document("manifests", digest, accept)
+
+    if manifest.get("mediaType") in IMAGE_INDEX_TYPES or "manifests" in manifest:
+        entries = manifest.get("manifests") or []
+        if not entries:
+            raise ValueError(f"{reference} is an image index with no images")
+        chosen = next(
+            (
+                entry
+                for entry in entries
+                if entry.get("platform", {}).get("os") == "linux"
+                and entry.get("platform", {}).get("architecture") == "amd64"
+            ),
+            entries[0],
+        )
+        manifest = client.document("manifests", chosen["digest"], IMAGE_MANIFEST_TYPES)
+
+    config = client.document("blobs", manifest["config"]["digest"])
+    return config.get("config", {}).get("Labels") or {}
+
@@ -0,0 +1,90 @@
+"""Fetching Agentfiles published at HTTPS URLs."""
+
+import hashlib
+import os
+import urllib.parse
+import urllib.request
+from pathlib import Path
+from typing import Callable, Optional
+
+FETCH_TIMEOUT = 30
+MAX_AGENTFILE_SIZE = 1024 * 1024  # 1 MiB
+
+
+def is_remote(location: str) -> bool:
+    """Whether an Agentfile location is a URL rather than a local path."""
+    return urllib.parse.urlparse(location).scheme in ["http", "https"]
+
+
+def cache_dir() -> Path:
+    """Return the directory fetched Agentfiles are cached in."""
+    base = os.environ.get("XDG_CACHE_HOME") or Path.home() / ".cache"
+    return Path(base) / "agentman" / "agentfiles"
+
+
+def fetch_url(url: str, limit: int = MAX_AGENTFILE_SIZE) -> bytes:
+    """Download a URL, refusing bodies larger than the limit."""
+    with urllib.request.urlopen(url, timeout=FETCH_TIMEOUT) as response:  # nosec - scheme checked by caller
+        data = response.read(limit + 1)
+    if len(data) > limit:
+        raise ValueError(f"{url} is larger than the {limit} byte limit")
+    return data
+
+
+def _normalize_digest(digest: str) -> str:
+    """Accept both bare hex digests and the sha256:<hex> form."""
+    digest = digest.strip().lower()
+    if digest.startswith("sha256:"):
+        digest = digest[len("sha256:") :]
+    if len(digest) != 64 or any(c not in "0123456789abcdef" for c in digest):
+        raise ValueError(f"Invalid sha256 digest: {digest}")
+    return digest
+
+
+def verify_digest(data: bytes, sha256: Optional[str], source: str) -> str:
+    """Return the sha256 digest of data, failing when it differs from the expected one."""
+    digest = hashlib.sha256(data).hexdigest()
+    expected = _normalize_digest(sha256) if sha256 else None
+    if expected and digest != expected:
+        raise ValueError(f"Checksum mismatch for {source}: expected sha256:{expected}, got sha256:{digest}")
+    return digest
+
+
+def fetch_agentfile(
+    url: str,
+    sha256: Optional[str] = None,
+    insecure_http: bool = False,
+    cache: Optional[Path] = None,
+    fetch: Callable[[str], bytes] = fetch_url,
+) -> Path:
+    """Fetch a remote Agentfile into the cache and return the cached path.
+
+    Content is cached under its digest, so a pinned --sha256 is served from
+    the cache without touching the network.
+    """
+    scheme = urllib.parse.urlparse(url).scheme
+    if scheme == "http" and not insecure_http:
+        raise ValueError(f"Refusing to fetch {url} over plain HTTP; use HTTPS or pass --insecure-http")
+    if scheme not in ["http", "https"]:
+        raise ValueError(f"Unsupported Agentfile URL: {url}")
+
+    cache = cache or cache_dir()
+    name = Path(urllib.parse.urlparse(url).path).name or "Agentfile"
+    expected = _normalize_digest(sha256) if sha256 else None
+
+    if expected:
+        cached = cache / expected / name
+        if cached.exists():
+            return cached
+
+    try:
+        data = fetch(url)
+    except OSError as e:
+        raise IOError(f"Failed to fetch {url}: {e}") from e
+
+    digest = verify_digest(data, expected, url)
+
+    path = cache / digest / name
+    path.parent.mkdir(parents=True, exist_ok=True)
+    path.write_bytes(data)
+    return path
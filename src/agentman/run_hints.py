@@ -0,0 +1,105 @@
+"""Copy-pasteable docker build and run commands derived from a parsed Agentfile."""
+
+import re
+import shlex
+from dataclasses import asdict, dataclass, field
+from typing import Any, Dict, List
+
+from agentman.agentfile_parser import DEFAULT_SHUTDOWN_GRACE, DOCKER_SOCKET_MOUNT, AgentfileConfig
+from agentman.environment import collect_environment
+
+# A RUN --mount=type=secret flag; its id defaults to the file name of the target
+SECRET_MOUNT_PATTERN = re.compile(r"^--mount=(?=.*\btype=secret\b)(.*)$")
+
+
+@dataclass
+class RunHints:
+    """The docker flags a built agent image needs, without the image name or build context."""
+
+    build_flags: List[str] = field(default_factory=list)
+    run_flags: List[str] = field(default_factory=list)
+
+    def build_command(self, tag: str, context: str) -> List[str]:
+        """Return the docker build command line."""
+        return ["docker", "build"] + self.build_flags + ["-t", tag, context]
+
+    def run_command(self, tag: str) -> List[str]:
+        """Return the docker run command line."""
+        return ["docker", "run"] + self.run_flags + [tag]
+
+    def to_dict(self) -> Dict[str, Any]:
+        """Convert to a JSON-serializable dictionary."""
+        return asdict(self)
+
+    def render(self, tag: str, context: str) -> str:
+        """Render the commands for pasting into a shell."""
+        return "\n".join(
+            [
+                "# Build the image",
+                shlex.join(self.build_command(tag, context)),
+                "# Run the agent",
+                shlex.join(self.run_command(tag)),
+            ]
+        )
+
+
+def _build_secret_ids(config: AgentfileConfig) -> List[str]:
+    """Return the ids of the build secrets RUN --mount=type=secret instructions read."""
+    ids = []
+    for instruction in config.dockerfile_instructions:
+        if instruction.instruction != "RUN":
+            continue
+        for arg in instruction.args:
+            match = SECRET_MOUNT_PATTERN.match(arg)
+            if not match:
+                continue
+            options = dict(option.partition("=")[::2] for option in match.group(1).split(","))
+            target = options.get("target") or options.get("dst") or ""
+            secret_id = options.get("id") or target.rsplit("/", 1)[-1]
+            if secret_id and secret_id not in ids:
+                ids.append(secret_id)
+    return ids
+
+
+def is_interactive(config: AgentfileConfig, has_prompt_file: bool = False) -> bool:
+    """Whether the agent reads from a terminal: human input, or fast-agent's console without prompt.txt.
+
+    ELICITATION_MODE forms also needs one, even with prompt.txt, since the forms are filled in there.
+    Human input only does with HUMAN_INPUT_MODE console. An agent run by SERVE answers requests instead.
+    """
+    if config.serve:
+        return False
+    human_input = any(getattr(entity, "human_input", False) for entity in config.entities().values())
+    if human_input and config.human_input_mode == "console":
+        return True
+    if any(server.elicitation_mode == "forms" for server in config.servers.values()):
+        return True
+    return config.framework == "fast-agent" and not has_prompt_file
+
+
+def build_run_hints(config: AgentfileConfig, has_prompt_file: bool = False) -> RunHints:
+    """Derive the docker build and run flags an Agentfile needs."""
+    hints = RunHints()
+
+    for secret_id in _build_secret_ids(config):
+        hints.build_flags.extend(["--secret", f"id={secret_id},env={secret_id}"])
+    for instruction in config.dockerfile_instructions:
+        if instruction.instruction == "ARG" and instruction.args and "=" not in instruction.args[0]:
+            hints.build_flags.extend(["--build-arg", instruction.args[0]])
+
+    hints.run_flags.extend(["--rm", "--add-host", "host.docker.internal:host-gateway"])
+    if is_interactive(config, has_prompt_file):
+        hints.run_flags.append("-it")
+    for entry in collect_environment(config):
+        if entry.required:
+            hints.run_flags.extend(["-e", entry.name])
+    for port in config.expose_ports:
+        hints.run_flags.extend(["-p", f"{port}:{port}"])
+    for path in config.volumes:
+        hints.run_flags.extend(["-v", f"{path.strip('/').replace('/', '-') or 'root'}:{path}"])
+    if any(server.uses_docker for server in config.servers.values()):
+        hints.run_flags.extend(["-v", DOCKER_SOCKET_MOUNT])
+    # docker stop sends SIGKILL after its own timeout, which would cut SHUTDOWN_GRACE short
+    if config.shutdown_grace > DEFAULT_SHUTDOWN_GRACE:
+        hints.run_flags.extend(["--stop-timeout", str(config.shutdown_grace)])
+    return hints
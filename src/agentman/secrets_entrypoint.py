@@ -0,0 +1,47 @@
+"""Entrypoint that writes fastagent.secrets.yaml from the container's environment when it starts."""
+
+import json
+
+SECRETS_ENTRYPOINT_FILENAME = "write_secrets.py"
+
+
+def build_secrets_entrypoint(layout: dict, secrets_file: str = "fastagent.secrets.yaml") -> str:
+    """Render a script that fills layout from the environment, writes it to secrets_file and runs the CMD.
+
+    layout has the structure of the secrets file with environment variable names in place of values;
+    settings whose variable is unset are left out.
+    """
+    return f'''"""Write {secrets_file} from the environment, then run the image CMD."""
+
+import os
+import sys
+
+import yaml
+
+LAYOUT = {json.dumps(layout, indent=4)}
+SECRETS_FILE = {json.dumps(secrets_file)}
+
+
+def fill(node: dict) -> dict:
+    filled = {{}}
+    for key, value in node.items():
+        if isinstance(value, dict):
+            value = fill(value)
+            if value:
+                filled[key] = value
+        elif value in os.environ:
+            filled[key] = os.environ[value]
+    return filled
+
+
+def main() -> None:
+    fd = os.open(SECRETS_FILE, os.O_WRONLY | os.O_CREAT | os.O_TRUNC, 0o600)
+    with os.fdopen(fd, "w", encoding="utf-8") as f:
+        yaml.safe_dump(fill(LAYOUT), f, default_flow_style=False, sort_keys=False)
+    if len(sys.argv) > 1:
+        os.execvp(sys.argv[1], sys.argv[1:])
+
+
+if __name__ == "__main__":
+    main()
+'''
@@ -0,0 +1,57 @@
+"""Well-known MCP servers an Agentfile can declare by name alone."""
+
+import json
+from typing import Dict, Optional
+
+# MCP_SERVER blocks with no COMMAND or URL take their launch settings from here. "env" lists the
+# variables the server reads, which it gets as ${NAME} references for SECRET or ENV_FILE to fill
+BUILTIN_SERVERS = {
+    "fetch": {"command": "uvx", "args": ["mcp-server-fetch"]},
+    "filesystem": {"command": "npx", "args": ["-y", "@modelcontextprotocol/server-filesystem", "/app"]},
+    "github": {
+        "command": "npx",
+        "args": ["-y", "@modelcontextprotocol/server-github"],
+        "env": ["GITHUB_PERSONAL_ACCESS_TOKEN"],
+    },
+    "memory": {"command": "npx", "args": ["-y", "@modelcontextprotocol/server-memory"]},
+    "brave-search": {
+        "command": "npx",
+        "args": ["-y", "@modelcontextprotocol/server-brave-search"],
+        "env": ["BRAVE_API_KEY"],
+    },
+}
+
+# Keys a registry entry may set, with the type each must have
+ENTRY_FIELDS = {"command": str, "args": list, "env": list, "transport": str, "url": str}
+
+
+def validate_registry(registry, source: str) -> Dict[str, dict]:
+    """Check the shape of a registry mapping server names to entries, naming source in errors."""
+    if not isinstance(registry, dict):
+        raise ValueError(f"{source}: the server registry must be a JSON object of server names to entries")
+    for name, entry in registry.items():
+        if not isinstance(entry, dict):
+            raise ValueError(f"{source}: entry {name} must be an object")
+        for key, value in entry.items():
+            if key not in ENTRY_FIELDS:
+                raise ValueError(
+                    f"{source}: entry {name} has unknown key {key}. Valid keys: {', '.join(ENTRY_FIELDS)}"
+                )
+            if not isinstance(value, ENTRY_FIELDS[key]):
+                raise ValueError(f"{source}: {key} of entry {name} must be a {ENTRY_FIELDS[key].__name__}")
+        if not entry.get("command") and not entry.get("url"):
+            raise ValueError(f"{source}: entry {name} needs a command or a url")
+    return registry
+
+
+def load_server_registry(path: Optional[str] = None) -> Dict[str, dict]:
+    """Return the built-in registry, with the entries of a JSON file at path added or replacing them by name."""
+    registry = dict(BUILTIN_SERVERS)
+    if path:
+        try:
+            with open(path, encoding="utf-8") as f:
+                entries = json.load(f)
+        except json.JSONDecodeError as e:
+            raise ValueError(f"{path}: invalid JSON in the server registry: {e}") from e
+        registry.update(validate_registry(entries, path))
+    return registry
@@ -0,0 +1,74 @@
+"""Metrics collected while parsing an Agentfile and generating its output."""
+
+import time
+from contextlib import contextmanager
+from dataclasses import asdict, dataclass, field
+from pathlib import Path
+from typing import Dict
+
+from agentman.agentfile_parser import AgentfileConfig
+
+# Dockerfile instructions that add a filesystem layer to the image
+LAYER_INSTRUCTIONS = ["RUN", "COPY", "ADD"]
+
+
+@dataclass
+class Stats:
+    """Counts and phase timings for a single build."""
+
+    agents: int = 0
+    servers: int = 0
+    workflows: int = 0
+    secrets: int = 0
+    files: int = 0
+    layers: int = 0
+    output_bytes: int = 0
+    warnings: int = 0
+    timings: Dict[str, float] = field(default_factory=dict)  # Seconds spent per phase
+
+    @contextmanager
+    def phase(self, name: str):
+        """Time a phase; repeated phases with the same name accumulate."""
+        start = time.perf_counter()
+        try:
+            yield
+        finally:
+            self.timings[name] = self.timings.get(name, 0.0) + time.perf_counter() - start
+
+    def record_config(self, config: AgentfileConfig):
+        """Count the declarations in a parsed configuration."""
+        self.agents = len(config.agents)
+        self.servers = len(config.servers)
+        self.workflows = len(config.entities()) - len(config.agents)
+        self.secrets = len(config.secrets)
+
+    def record_output(self, output_dir: Path):
+        """Count the generated files, their size and the image layers the Dockerfile creates."""
+        files = [path for path in Path(output_dir).rglob("*") if path.is_file()]
+        self.files = len(files)
+        self.output_bytes = sum(path.stat().st_size for path in files)
+
+        dockerfile = Path(output_dir) / "Dockerfile"
+        if dockerfile.exists():
+            lines = dockerfile.read_text(encoding='utf-8').split("\n")
+            self.layers = sum(1 for line in lines if line.split(" ", 1)[0] in LAYER_INSTRUCTIONS)
+
+    def to_dict(self) -> Dict[str, object]:
+        """Convert to a JSON-serializable dictionary."""
+        return asdict(self)
+
+    def render_table(self) -> str:
+        """Render the stats as a human-readable table."""
+        rows = [
+            ("Agents", self.agents),
+            ("MCP servers", self.servers),
+            ("Workflows", self.workflows),
+            ("Secrets", self.secrets),
+            ("Generated files", self.files),
+            ("Image layers", self.layers),
+            ("Output bytes", self.output_bytes),
+            ("Warnings", self.warnings),
+        ]
+        rows.extend((f"{name.capitalize()} time", f"{seconds * 1000:.1f} ms") for name, seconds in self.timings.items())
+        width = max(len(name) for name, _ in rows)
+        return "\n".join(f"{name.ljust(width)}  {value}" for name, value in rows)
@@ -0,0 +1,56 @@
+"""Process supervisor that runs an Agentfile CMD alongside the generated agent."""
+
+import json
+from typing import List
+
+SUPERVISOR_FILENAME = "supervise.py"
+
+
+def build_supervisor_script(commands: List[List[str]], shutdown_grace: int) -> str:
+    """Render a script that runs every command, forwards signals and stops them all when one exits."""
+    return f'''"""Run the Agentfile CMD next to the generated agent and stop both together."""
+
+import signal
+import subprocess
+import sys
+import time
+
+COMMANDS = {json.dumps(commands)}
+SHUTDOWN_GRACE = {shutdown_grace}
+
+
+def main() -> int:
+    processes = [subprocess.Popen(command) for command in COMMANDS]
+    stopping = []
+
+    def forward(signum, frame):
+        stopping.append(signum)
+        for process in processes:
+            if process.poll() is None:
+                process.send_signal(signum)
+
+    for signum in (signal.SIGTERM, signal.SIGINT):
+        signal.signal(signum, forward)
+
+    while all(process.poll() is None for process in processes):
+        time.sleep(0.2)
+    code = next(process.returncode for process in processes if process.returncode is not None)
+
+    # One command exited on its own, so ask the others to stop too
+    if not stopping:
+        for process in processes:
+            if process.poll() is None:
+                process.terminate()
+
+    deadline = time.monotonic() + SHUTDOWN_GRACE
+    for process in processes:
+        try:
+            process.wait(timeout=max(deadline - time.monotonic(), 0))
+        except subprocess.TimeoutExpired:
+            process.kill()
+    return 0 if stopping else code
+
+
+if __name__ == "__main__":
+    sys.exit(main())
+'''
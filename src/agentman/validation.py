@@ -0,0 +1,533 @@
+"""Semantic checks of an Agentfile configuration, independent of how it was built.
+
+Parsing runs these checks and raises the first error they find; configurations
+built in code can run them with AgentfileConfig.validate(). Each finding names
+what it is about with a path such as agents.coder.servers[1].
+"""
+
+import difflib
+import re
+from typing import TYPE_CHECKING, Dict, List, Optional
+
+from agentman.diagnostics import SEVERITY_ERROR, SEVERITY_WARNING, Finding
+
+if TYPE_CHECKING:
+    from agentman.agentfile_parser import AgentfileConfig
+
+MAX_PORT = 65535
+# A ${NAME} reference in a header value, which fast-agent reads from the environment
+HEADER_REFERENCE_PATTERN = re.compile(r"\$\{([A-Za-z_][A-Za-z0-9_]*)\}")
+# A URL reaching a server inside the agent's own container, with the port it names
+LOCAL_URL_PATTERN = re.compile(r"^[a-z]+://(?:localhost|127\.0\.0\.1|0\.0\.0\.0)(?::(\d+))?(?:/|$)", re.IGNORECASE)
+
+
+def find_cycle(graph: Dict[str, List[str]]) -> Optional[List[str]]:
+    """Return the first cycle in a graph as the path around it, starting and ending at the same name."""
+    done = set()
+    for start in graph:
+        if start in done:
+            continue
+        path = [start]
+        pending = [iter(graph[start])]
+        while pending:
+            child = next(pending[-1], None)
+            if child is None:
+                done.add(path.pop())
+                pending.pop()
+            elif child in path:
+                return path[path.index(child) :] + [child]
+            elif child in graph and child not in done:
+                path.append(child)
+                pending.append(iter(graph[child]))
+    return None
+
+
+def _suggestion(name: str, candidates: List[str]) -> str:
+    """Return a "did you mean" hint for the candidate closest to a name, or nothing."""
+    matches = difflib.get_close_matches(name, candidates, n=1)
+    return f"; did you mean {matches[0]}?" if matches else ""
+
+
+def check_transports(config: "AgentfileConfig") -> List[Finding]:
+    """Require a URL for every server reached over the network and a COMMAND for every other one.
+
+    Also warn when a server sets both, or a CWD its transport does not use.
+    """
+    findings = []
+    for server in config.servers.values():
+        path = f"servers.{server.name}"
+        if server.command and server.url:
+            used = "COMMAND" if server.transport == "stdio" else "URL"
+            findings.append(
+                Finding(
+                    SEVERITY_WARNING,
+                    "server-command-and-url",
+                    path,
+                    f"Server {server.name} sets both COMMAND and URL; TRANSPORT {server.transport} "
+                    f"only uses the {used}",
+                    server.line,
+                )
+            )
+        if server.transport != "stdio" and not server.url:
+            findings.append(
+                Finding(
+                    SEVERITY_ERROR,
+                    "missing-url",
+                    f"{path}.url",
+                    f"Server {server.name} uses TRANSPORT {server.transport}, which needs a URL",
+                    server.line,
+                )
+            )
+        if server.transport == "stdio" and not server.command:
+            if server.url or server.args:
+                message = (
+                    f"Server {server.name} uses TRANSPORT stdio, which needs a COMMAND to start it; "
+                    "set COMMAND, or URL with TRANSPORT sse or http for a remote server"
+                )
+            else:
+                message = (
+                    f"Server {server.name} is not in the server registry and sets no COMMAND or URL; "
+                    "supply COMMAND, or URL with TRANSPORT sse or http for a remote server"
+                )
+            findings.append(Finding(SEVERITY_ERROR, "missing-command", f"{path}.command", message, server.line))
+        if server.cwd and server.transport != "stdio":
+            findings.append(
+                Finding(
+                    SEVERITY_WARNING,
+                    "server-cwd-ignored",
+                    f"{path}.cwd",
+                    f"Server {server.name} sets CWD, but TRANSPORT {server.transport} starts no process; "
+                    "it will be ignored",
+                    server.line,
+                )
+            )
+    return findings
+
+
+def check_headers(config: "AgentfileConfig") -> List[Finding]:
+    """Keep HEADER to servers reached over the network, and secret values out of the headers baked into the image.
+
+    Warn about a ${NAME} reference no SECRET provides, since the container would send it empty.
+    """
+    secrets = config.secret_names()
+    secret_values = {name: value for name, value in config.secret_values().items() if value}
+    findings = []
+    for server in config.servers.values():
+        path = f"servers.{server.name}.headers"
+        if server.headers and server.transport == "stdio":
+            findings.append(
+                Finding(
+                    SEVERITY_ERROR,
+                    "stdio-headers",
+                    path,
+                    f"Server {server.name} sets HEADER, but TRANSPORT stdio sends no HTTP requests; "
+                    "use TRANSPORT sse or http",
+                    server.line,
+                )
+            )
+        for header, value in server.headers.items():
+            for name, secret in secret_values.items():
+                if secret in value:
+                    findings.append(
+                        Finding(
+                            SEVERITY_ERROR,
+                            "plaintext-header-secret",
+                            f"{path}.{header}",
+                            f"Header {header} of server {server.name} contains the value of SECRET {name}, "
+                            f"which would be written into the image; write ${{{name}}} instead",
+                            server.line,
+                        )
+                    )
+            for name in HEADER_REFERENCE_PATTERN.findall(value):
+                if name not in secrets:
+                    findings.append(
+                        Finding(
+                            SEVERITY_WARNING,
+                            "undeclared-header-reference",
+                            f"{path}.{header}",
+                            f"Header {header} of server {server.name} reads ${{{name}}}, but no SECRET provides "
+                            f"{name}; declare SECRET {name}",
+                            server.line,
+                        )
+                    )
+    return findings
+
+
+def check_server_auth(config: "AgentfileConfig") -> List[Finding]:
+    """Require AUTH to read a declared SECRET, on a server reached over the network with no Authorization HEADER."""
+    secrets = config.secret_names()
+    findings = []
+    for server in config.servers.values():
+        if not server.auth:
+            continue
+        path = f"servers.{server.name}.auth"
+        if server.auth.secret not in secrets:
+            message = (
+                f"Server {server.name} authenticates with SECRET {server.auth.secret}, which is not declared"
+                f"{_suggestion(server.auth.secret, secrets)}"
+            )
+            findings.append(Finding(SEVERITY_ERROR, "undeclared-auth-secret", path, message, server.line))
+        if server.transport == "stdio":
+            message = f"Server {server.name} sets AUTH, but TRANSPORT stdio sends no HTTP requests"
+            findings.append(Finding(SEVERITY_ERROR, "stdio-auth", path, message, server.line))
+        if any(header.lower() == "authorization" for header in server.headers):
+            message = f"Server {server.name} sets both AUTH and HEADER Authorization; keep one"
+            findings.append(Finding(SEVERITY_ERROR, "auth-header-conflict", path, message, server.line))
+    return findings
+
+
+def check_server_references(config: "AgentfileConfig") -> List[Finding]:
+    """Require every server an agent uses to be defined, or provided by the base image."""
+    findings = []
+    for agent, name in config.undefined_servers():
+        hint = _suggestion(name, list(config.servers)) or f"; add an MCP_SERVER {name} block."
+        findings.append(
+            Finding(
+                SEVERITY_ERROR,
+                "undefined-server",
+                f"agents.{agent.name}.servers[{agent.servers.index(name)}]",
+                f"Agent {agent.name} uses server {name}, which is not defined{hint} "
+                f"If the base image provides it, pass --external-server {name}",
+                agent.line,
+            )
+        )
+    return findings
+
+
+def check_agent_tools(config: "AgentfileConfig") -> List[Finding]:
+    """Require every TOOLS entry of an agent to name one of the agent's SERVERS."""
+    findings = []
+    for agent in config.agents.values():
+        for index, tool in enumerate(agent.tools):
+            server = tool.split(".", 1)[0]
+            if server in agent.servers:
+                continue
+            findings.append(
+                Finding(
+                    SEVERITY_ERROR,
+                    "tool-server-not-used",
+                    f"agents.{agent.name}.tools[{index}]",
+                    f"Agent {agent.name} lists tool {tool}, but {server} is not in its SERVERS"
+                    f"{_suggestion(server, agent.servers)}",
+                    agent.line,
+                )
+            )
+    return findings
+
+
+def check_agent_resources(config: "AgentfileConfig") -> List[Finding]:
+    """Require an agent with RESOURCES to have SERVERS, since MCP servers are what serve resources."""
+    return [
+        Finding(
+            SEVERITY_ERROR,
+            "resources-without-servers",
+            f"agents.{agent.name}.resources",
+            f"Agent {agent.name} lists RESOURCES but no SERVERS to read them from",
+            agent.line,
+        )
+        for agent in config.agents.values()
+        if agent.resources and not agent.servers
+    ]
+
+
+def check_prompt_references(config: "AgentfileConfig") -> List[Finding]:
+    """Require every prompt an INSTRUCTION uses, as @name or {{prompt:name}}, to be declared by PROMPT."""
+    findings = []
+    for name, entity in config.entities().items():
+        keyword = config.entity_keyword(name)
+        for prompt in config.prompt_references(entity.instruction or ""):
+            if prompt not in config.prompts:
+                findings.append(
+                    Finding(
+                        SEVERITY_ERROR,
+                        "undefined-prompt",
+                        f"{keyword.lower()}s.{name}.instruction",
+                        f"{keyword} {name} uses PROMPT {prompt}, which is not defined"
+                        f"{_suggestion(prompt, list(config.prompts))}",
+                        entity.line,
+                    )
+                )
+    return findings
+
+
+def check_human_input(config: "AgentfileConfig") -> List[Finding]:
+    """Reject HUMAN_INPUT true on an agent or workflow when HUMAN_INPUT_MODE disabled leaves no one to ask."""
+    if config.human_input_mode != "disabled":
+        return []
+    findings = []
+    for name, entity in config.entities().items():
+        if getattr(entity, "human_input", False):
+            keyword = config.entity_keyword(name)
+            findings.append(
+                Finding(
+                    SEVERITY_ERROR,
+                    "human-input-disabled",
+                    f"{keyword.lower()}s.{name}.human_input",
+                    f"{keyword} {name} sets HUMAN_INPUT true, but HUMAN_INPUT_MODE disabled gives it no one to ask; "
+                    "remove one of them",
+                    entity.line,
+                )
+            )
+    return findings
+
+
+def check_router_targets(config: "AgentfileConfig") -> List[Finding]:
+    """Require every router to route to defined agents, and warn when it has only one to pick from."""
+    findings = []
+    for router in config.routers.values():
+        path = f"routers.{router.name}.agents"
+        if not router.agents:
+            message = f"Router {router.name} has no AGENTS to route to"
+            findings.append(Finding(SEVERITY_ERROR, "no-routes", path, message, router.line))
+        for index, name in enumerate(router.agents):
+            keyword = config.entity_keyword(name)
+            if keyword is None:
+                message = f"Router {router.name} routes to {name}, which is not defined"
+                message += _suggestion(name, list(config.agents))
+            elif name == router.name:
+                message = f"Router {router.name} routes to itself"
+            elif keyword != "AGENT" and not config.route_to_workflows:
+                message = (
+                    f"Router {router.name} routes to {keyword} {name}, which is not an AGENT; "
+                    "pass --route-to-workflows to allow workflows as routes"
+                )
+            else:
+                continue
+            findings.append(Finding(SEVERITY_ERROR, "invalid-route", f"{path}[{index}]", message, router.line))
+        if len(router.agents) == 1:
+            findings.append(
+                Finding(
+                    SEVERITY_WARNING,
+                    "single-route",
+                    path,
+                    f"Router {router.name} only routes to {router.agents[0]}, so it has nothing to choose; "
+                    f"use {router.agents[0]} directly or add AGENTS",
+                    router.line,
+                )
+            )
+    return findings
+
+
+def check_orchestrator_agents(config: "AgentfileConfig") -> List[Finding]:
+    """Require every orchestrator to coordinate defined agents or workflows other than itself."""
+    entities = list(config.entities())
+    findings = []
+    for orchestrator in config.orchestrators.values():
+        path = f"orchestrators.{orchestrator.name}.agents"
+        if not orchestrator.agents:
+            message = f"Orchestrator {orchestrator.name} has no AGENTS to coordinate"
+            findings.append(Finding(SEVERITY_ERROR, "no-orchestrator-agents", path, message, orchestrator.line))
+        for index, name in enumerate(orchestrator.agents):
+            if name == orchestrator.name:
+                message = f"Orchestrator {orchestrator.name} coordinates itself"
+            elif name not in entities:
+                message = f"Orchestrator {orchestrator.name} coordinates {name}, which is not defined"
+                message += _suggestion(name, entities)
+            else:
+                continue
+            findings.append(
+                Finding(SEVERITY_ERROR, "invalid-orchestrator-agent", f"{path}[{index}]", message, orchestrator.line)
+            )
+    return findings
+
+
+def check_parallel_targets(config: "AgentfileConfig") -> List[Finding]:
+    """Require every parallel to fan out to defined agents or workflows, and fan in to one, other than itself."""
+    entities = list(config.entities())
+    findings = []
+    for parallel in config.parallels.values():
+        path = f"parallels.{parallel.name}"
+        if not parallel.fan_out:
+            message = f"Parallel {parallel.name} has no FAN_OUT agents to send the request to"
+            findings.append(Finding(SEVERITY_ERROR, "empty-fan-out", f"{path}.fan_out", message, parallel.line))
+        targets = [(f"{path}.fan_out[{index}]", "fans out to", name) for index, name in enumerate(parallel.fan_out)]
+        if parallel.fan_in:
+            targets.append((f"{path}.fan_in", "fans in to", parallel.fan_in))
+        for target_path, verb, name in targets:
+            if name == parallel.name:
+                message = f"Parallel {parallel.name} {verb} itself"
+            elif name not in entities:
+                message = f"Parallel {parallel.name} {verb} {name}, which is not defined{_suggestion(name, entities)}"
+            else:
+                continue
+            findings.append(Finding(SEVERITY_ERROR, "invalid-parallel-target", target_path, message, parallel.line))
+    return findings
+
+
+def check_chain_steps(config: "AgentfileConfig") -> List[Finding]:
+    """Require every chain to have steps, and every step to be defined."""
+    entities = list(config.entities())
+    findings = []
+    for chain in config.chains.values():
+        path = f"chains.{chain.name}.sequence"
+        if not chain.sequence:
+            message = f"Chain {chain.name} has no SEQUENCE of steps"
+            findings.append(Finding(SEVERITY_ERROR, "empty-sequence", path, message, chain.line))
+        for index, name in enumerate(chain.sequence):
+            if name not in entities:
+                findings.append(
+                    Finding(
+                        SEVERITY_ERROR,
+                        "undefined-step",
+                        f"{path}[{index}]",
+                        f"Chain {chain.name} runs {name}, which is not defined{_suggestion(name, entities)}",
+                        chain.line,
+                    )
+                )
+    return findings
+
+
+def check_chain_models(config: "AgentfileConfig") -> List[Finding]:
+    """Warn when a step with no MODEL of its own runs in chains that set different models.
+
+    The step runs with the model of the first of those chains.
+    """
+    findings = []
+    for name, entity in config.entities().items():
+        if getattr(entity, "model", None):
+            continue
+        chains = [chain for chain in config.chains.values() if chain.model and name in chain.sequence]
+        if len({chain.model for chain in chains}) < 2:
+            continue
+        first, other = chains[0], next(chain for chain in chains if chain.model != chains[0].model)
+        findings.append(
+            Finding(
+                SEVERITY_WARNING,
+                "chain-model-conflict",
+                f"chains.{other.name}.model",
+                f"{name} runs in CHAIN {first.name} with MODEL {first.model} and in CHAIN {other.name} "
+                f"with MODEL {other.model}; it uses {first.model} in both, so give {name} its own MODEL",
+                other.line,
+            )
+        )
+    return findings
+
+
+def check_cycles(config: "AgentfileConfig") -> List[Finding]:
+    """Reject a workflow that hands work back to itself, directly or through other workflows."""
+    cycle = find_cycle(config.workflow_graph())
+    if not cycle:
+        return []
+    keyword = config.entity_keyword(cycle[0])
+    return [
+        Finding(
+            SEVERITY_ERROR,
+            "workflow-cycle",
+            f"{keyword.lower()}s.{cycle[0]}",
+            f"{keyword} {cycle[0]} includes itself: {' -> '.join(cycle)}",
+            config.entities()[cycle[0]].line,
+        )
+    ]
+
+
+def check_default_entity(config: "AgentfileConfig") -> List[Finding]:
+    """Allow DEFAULT on one agent or workflow at most, and say which one starts when it is on none."""
+    entities = config.entities()
+    defaults = [item for item in entities.values() if item.default]
+    if len(defaults) > 1:
+        listed = ", ".join(f"{config.entity_keyword(item.name)} {item.name} (line {item.line})" for item in defaults)
+        second = defaults[1]
+        return [
+            Finding(
+                SEVERITY_ERROR,
+                "multiple-defaults",
+                f"{config.entity_keyword(second.name).lower()}s.{second.name}.default",
+                f"DEFAULT is set on more than one agent or workflow: {listed}; keep one",
+                second.line,
+            )
+        ]
+    if defaults or len(entities) < 2 or config.framework != "fast-agent":
+        return []
+
+    # Entities no workflow hands work to are the ones a user would expect to start
+    used = {name for children in config.workflow_graph().values() for name in children}
+    top_level = [name for name in entities if name not in used]
+    fallback = config.fallback_entity()
+    if top_level == [fallback]:
+        return []
+    keyword = config.entity_keyword(fallback)
+    return [
+        Finding(
+            SEVERITY_WARNING,
+            "implicit-default",
+            f"{keyword.lower()}s.{fallback}",
+            f"No agent or workflow is marked DEFAULT, so the generated agent starts {keyword} {fallback}, "
+            f"the first {keyword.lower()} declared; add DEFAULT true to the one it should start",
+            entities[fallback].line,
+        )
+    ]
+
+
+def check_ports(config: "AgentfileConfig") -> List[Finding]:
+    """Require exposed ports to be port numbers."""
+    return [
+        Finding(
+            SEVERITY_ERROR,
+            "invalid-port",
+            f"expose_ports[{index}]",
+            f"EXPOSE {port} is not a port number between 1 and {MAX_PORT}",
+        )
+        for index, port in enumerate(config.expose_ports)
+        if not 0 < port <= MAX_PORT
+    ]
+
+
+def check_serve(config: "AgentfileConfig") -> List[Finding]:
+    """Require something for SERVE to serve, on a port no server inside the container already listens on."""
+    serve = config.serve
+    if serve is None:
+        return []
+    findings = []
+    if not config.entities():
+        findings.append(
+            Finding(
+                SEVERITY_ERROR,
+                "nothing-to-serve",
+                "serve",
+                f"SERVE {serve.protocol} {serve.port} has no AGENT or workflow to serve",
+                serve.line,
+            )
+        )
+    for server in config.servers.values():
+        match = LOCAL_URL_PATTERN.match(server.url or "")
+        if match and match.group(1) and int(match.group(1)) == serve.port:
+            findings.append(
+                Finding(
+                    SEVERITY_ERROR,
+                    "serve-port-in-use",
+                    "serve.port",
+                    f"SERVE {serve.protocol} {serve.port} uses the port server {server.name} listens on "
+                    f"inside the container ({server.url}); pick another port",
+                    serve.line,
+                )
+            )
+    return findings
+
+
+CHECKS = [
+    check_transports,
+    check_headers,
+    check_server_auth,
+    check_server_references,
+    check_agent_tools,
+    check_agent_resources,
+    check_prompt_references,
+    check_human_input,
+    check_router_targets,
+    check_orchestrator_agents,
+    check_parallel_targets,
+    check_chain_steps,
+    check_chain_models,
+    check_cycles,
+    check_default_entity,
+    check_ports,
+    check_serve,
+]
+
+
+def validate_config(config: "AgentfileConfig") -> List[Finding]:
+    """Run every semantic check over a configuration, in order."""
+    findings = []
+    for check in CHECKS:
+        findings.extend(check(config))
+    return findings
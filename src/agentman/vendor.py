@@ -0,0 +1,176 @@
+"""Dependency vendoring for offline (air-gapped) image builds."""
+
+import hashlib
+import json
+import posixpath
+import subprocess
+from pathlib import Path
+from typing import Callable, Dict, List
+
+from agentman.agentfile_parser import AgentfileConfig
+
+VENDOR_DIRNAME = "vendor"
+VENDOR_MANIFEST = "manifest.json"
+
+# Commands that reach the network when a Dockerfile instruction runs
+NETWORK_COMMANDS = ["pip install", "npm install", "npx ", "uvx ", "curl ", "wget ", "apt-get install", "apk add"]
+
+
+def collect_packages(config: AgentfileConfig, requirements: List[str]) -> Dict[str, List[str]]:
+    """Collect the packages the image needs, grouped by installer."""
+    packages = {"pip": sorted(set(requirements)), "npm": [], "uv": []}
+
+    for server in config.servers.values():
+        if server.package and server.package.spec not in packages[server.package.installer]:
+            packages[server.package.installer].append(server.package.spec)
+
+    return packages
+
+
+def check_offline_compatible(config: AgentfileConfig):
+    """Reject Dockerfile instructions and MCP servers that need network access at build time."""
+    for server in config.servers.values():
+        if server.uses_docker:
+            raise ValueError(
+                f"MCP server {server.name} is launched through docker, whose CLI is copied from the docker:cli "
+                "image at build time, so it cannot be used with --offline"
+            )
+    for instruction in config.dockerfile_instructions:
+        args = " ".join(instruction.args)
+        if instruction.instruction == "ADD" and any(
+            source.startswith(("http://", "https://")) for source in instruction.sources
+        ):
+            raise ValueError(
+                f"ADD {args} downloads at build time and cannot be used with --offline; "
+                "download the file ahead of time and COPY it instead"
+            )
+        if instruction.instruction == "RUN" and any(command in f"{args} " for command in NETWORK_COMMANDS):
+            raise ValueError(
+                f"RUN {args} needs network access and cannot be used with --offline; "
+                "declare the dependency through an MCP server or requirements so it is vendored"
+            )
+
+
+def _sha256(path: Path) -> str:
+    """Return the sha256 digest of a file."""
+    digest = hashlib.sha256()
+    with open(path, 'rb') as f:
+        for chunk in iter(lambda: f.read(65536), b""):
+            digest.update(chunk)
+    return digest.hexdigest()
+
+
+def _is_vendored(entry: dict, vendor_dir: Path) -> bool:
+    """Check that every file recorded for a package is present and unchanged."""
+    for relative_path, digest in entry.get("files", {}).items():
+        path = vendor_dir / relative_path
+        if not path.exists() or _sha256(path) != digest:
+            return False
+    return True
+
+
+def _snapshot(directory: Path) -> Dict[str, Path]:
+    """Return the files below a directory keyed by their relative path."""
+    if not directory.exists():
+        return {}
+    return {str(path.relative_to(directory.parent)): path for path in directory.rglob("*") if path.is_file()}
+
+
+def _run(runner: Callable, cmd: List[str], installer: str, package: str):
+    """Run a download command, reporting a failure as an IOError."""
+    try:
+        runner(cmd, check=True)
+    except (subprocess.CalledProcessError, FileNotFoundError) as e:
+        raise IOError(f"Failed to vendor {installer} package {package}: {e}") from e
+
+
+def _vendor_npm(
+    names: List[str], destination: Path, vendor_dir: Path, manifest: Dict[str, dict], runner: Callable, command
+):
+    """Install every npm package with a single npm install, recorded as one manifest entry."""
+    entry = manifest.get("npm", {})
+    if entry.get("packages") == sorted(names) and _is_vendored(entry, vendor_dir):
+        return
+    for key in [key for key in manifest if key == "npm" or key.startswith("npm:")]:
+        del manifest[key]
+    if not names:
+        return
+
+    destination.mkdir(parents=True, exist_ok=True)
+    _run(runner, command(names, destination), "npm", " ".join(names))
+    files = {path: _sha256(abs_path) for path, abs_path in _snapshot(destination).items()}
+    manifest["npm"] = {"installer": "npm", "packages": sorted(names), "files": files}
+
+
+def vendor_packages(
+    packages: Dict[str, List[str]],
+    vendor_dir: Path,
+    runner: Callable = subprocess.run,
+) -> Dict[str, dict]:
+    """Download packages into the vendor directory.
+
+    Packages already recorded in the vendor manifest with matching content
+    digests are skipped, so re-vendoring only fetches what changed. npm
+    packages are installed together, so a change to any of them reinstalls all.
+    """
+    vendor_dir.mkdir(parents=True, exist_ok=True)
+    manifest_path = vendor_dir / VENDOR_MANIFEST
+    manifest = {}
+    if manifest_path.exists():
+        with open(manifest_path, 'r', encoding='utf-8') as f:
+            manifest = json.load(f)
+
+    commands = {
+        "pip": lambda pkgs, dest: ["pip", "download", "--dest", str(dest)] + pkgs,
+        "uv": lambda pkgs, dest: ["pip", "download", "--dest", str(dest)] + pkgs,
+        "npm": lambda pkgs, dest: ["npm", "install", "--prefix", str(dest), "--no-save"] + pkgs,
+    }
+    destinations = {"pip": vendor_dir / "pip", "uv": vendor_dir / "pip", "npm": vendor_dir / "npm"}
+
+    for installer, names in packages.items():
+        if installer == "npm":
+            # npm prunes what a --no-save install did not ask for, so every spec goes into one install
+            _vendor_npm(names, destinations[installer], vendor_dir, manifest, runner, commands[installer])
+            continue
+        for package in names:
+            key = f"{installer}:{package}"
+            if key in manifest and _is_vendored(manifest[key], vendor_dir):
+                continue
+
+            destination = destinations[installer]
+            destination.mkdir(parents=True, exist_ok=True)
+            before = _snapshot(destination)
+            _run(runner, commands[installer]([package], destination), installer, package)
+
+            files = {path: _sha256(abs_path) for path, abs_path in _snapshot(destination).items() if path not in before}
+            manifest[key] = {"installer": installer, "package": package, "files": files}
+
+    with open(manifest_path, 'w', encoding='utf-8') as f:
+        json.dump(manifest, f, indent=2, sort_keys=True)
+        f.write("\n")
+
+    return manifest
+
+
+def offline_dockerfile_lines(packages: Dict[str, List[str]], workdir: str = "/app") -> List[str]:
+    """Dockerfile lines that install vendored packages without network access.
+
+    Vendored npm packages are copied into workdir, the image's working directory.
+    """
+    vendor_path = f"/opt/agentman/{VENDOR_DIRNAME}"
+    lines = [
+        "# Install vendored dependencies (offline build)",
+        f"COPY {VENDOR_DIRNAME}/ {vendor_path}/",
+        f"RUN pip install --no-cache-dir --no-index --find-links {vendor_path}/pip -r requirements.txt",
+    ]
+    if packages.get("uv"):
+        lines.append(f"ENV UV_OFFLINE=1 UV_FIND_LINKS={vendor_path}/pip")
+    if packages.get("npm"):
+        lines.extend(
+            [
+                f"RUN mkdir -p {workdir} && cp -r {vendor_path}/npm/. {posixpath.join(workdir, '')}",
+                "ENV NPM_CONFIG_OFFLINE=true",
+            ]
+        )
+    lines.append("")
+    return lines
@@ -10,16 +10,19 @@ Tests cover all aspects of the AgentBuilder including:
 - Integration with AgentfileConfig
 """
 
+import json
 import pytest
 import tempfile
 import os
+import subprocess
 import yaml
 from pathlib import Path
 from unittest.mock import patch, mock_open
 
-from agentman.agent_builder import AgentBuilder, build_from_agentfile
+from agentman.agent_builder import AgentBuilder, build_from_agentfile, build_timestamp
 from agentman.agentfile_parser import (
     AgentfileConfig,
+    AgentfileParser,
     MCPServer,
     Agent,
     Router,
@@ -289,6 +292,53 @@ class TestAgentBuilder:
             assert "COPY agent.py" in content
             assert "RUN pip install" in content
 
+    def test_generate_dockerfile_base_image_override(self):
+        """Test a --base-image override replaces the single FROM line."""
+        parser = AgentfileParser()
+        config = parser.parse_content("FROM python:3.11-slim\nRUN echo hi\n")
+        config.override_base_image("python:3.11-alpine")
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir)
+            builder._generate_dockerfile()
+
+            with open(Path(temp_dir) / "Dockerfile", 'r') as f:
+                content = f.read()
+
+            from_lines = [line for line in content.splitlines() if line.startswith("FROM ")]
+            assert from_lines == ["FROM python:3.11-alpine"]
+            assert "# Base image overridden by --base-image (Agentfile: python:3.11-slim)" in content
+            assert config.dockerfile_instructions[0].args == ["python:3.11-alpine"]
+
+    def test_base_image_override_stage(self):
+        """Test --base-image-stage picks the stage whose FROM is replaced, by name or index."""
+        content = "FROM golang:1.22 AS tools\nRUN go build ./...\nFROM python:3.11-slim\nAGENT helper\n"
+        for stage in ["tools", "0"]:
+            config = AgentfileParser().parse_content(content)
+            config.override_base_image("golang:1.22-alpine", stage)
+
+            dockerfile = AgentBuilder(config, ".").dockerfile_content()
+            from_lines = [line for line in dockerfile.splitlines() if "FROM " in line]
+            assert from_lines == ["FROM golang:1.22-alpine AS tools", "FROM python:3.11-slim"]
+            assert (config.base_image, config.agentfile_base_image) == ("python:3.11-slim", None)
+
+        config = AgentfileParser().parse_content(content)
+        with pytest.raises(ValueError, match="No build stage builder; the stages are tools, 1"):
+            config.override_base_image("golang:1.22-alpine", "builder")
+
+    def test_base_image_override_keeps_digest_pin(self):
+        """Test a FROM pinned by digest is only replaced by another pinned image."""
+        pinned = "python:3.11-slim@sha256:" + "a" * 64
+        config = AgentfileParser().parse_content(f"FROM {pinned}\nAGENT helper\n")
+
+        with pytest.raises(ValueError, match="would drop the pin"):
+            config.override_base_image("python:3.11-alpine")
+        assert config.base_image == pinned
+
+        override = "registry.local/python:3.11-alpine@sha256:" + "b" * 64
+        config.override_base_image(override)
+        assert f"FROM {override}" in AgentBuilder(config, ".").dockerfile_content()
+
     def test_generate_requirements_txt_basic(self):
         """Test basic requirements.txt generation."""
         with tempfile.TemporaryDirectory() as temp_dir:
@@ -534,3 +584,414 @@ class TestAgentBuilderEdgeCases:
 
 if __name__ == "__main__":
     pytest.main([__file__])
+
+
+
+ANNOTATED_AGENTFILE = """FROM yeahdongcn/agentman-base:latest
+MODEL anthropic/claude-3-sonnet-20241022
+
+MCP_SERVER fetch
+COMMAND uvx
+ARGS mcp-server-fetch
+
+AGENT researcher
+INSTRUCTION Research things
+SERVERS fetch
+
+CHAIN pipeline
+SEQUENCE researcher
+
+RUN apt-get update
+CMD ["python", "agent.py"]
+"""
+
+
+class TestAnnotate:
+    """Test suite for annotated generation."""
+
+    def _generate(self, temp_dir, annotate):
+        config = AgentfileParser().parse_content(ANNOTATED_AGENTFILE)
+        AgentBuilder(config, temp_dir, annotate=annotate).build_all()
+        return {
+            name: (Path(temp_dir) / name).read_text(encoding='utf-8')
+            for name in ["Dockerfile", "agent.py", "fastagent.config.yaml"]
+        }
+
+    def test_annotate_only_adds_source_comments(self):
+        """Test --annotate adds source comments without changing any other output."""
+        with tempfile.TemporaryDirectory() as plain_dir, tempfile.TemporaryDirectory() as annotated_dir:
+            with patch.dict(os.environ, {"SOURCE_DATE_EPOCH": "1700000000"}):
+                plain = self._generate(plain_dir, annotate=False)
+                annotated = self._generate(annotated_dir, annotate=True)
+
+        annotations = {}
+        for name, content in annotated.items():
+            lines = content.split("\n")
+            annotations[name] = [line for line in lines if line.startswith("# Agentfile:")]
+            assert "\n".join(line for line in lines if not line.startswith("# Agentfile:")) == plain[name]
+
+        assert annotations == {
+            # The heredoc that writes fastagent.config.yaml carries its annotations into the Dockerfile
+            "Dockerfile": [
+                "# Agentfile:1 FROM",
+                "# Agentfile:15 RUN",
+                "# Agentfile:4 MCP_SERVER fetch",
+                "# Agentfile:16 CMD",
+            ],
+            "agent.py": ["# Agentfile:8 AGENT researcher", "# Agentfile:12 CHAIN pipeline"],
+            "fastagent.config.yaml": ["# Agentfile:4 MCP_SERVER fetch"],
+        }
+
+
+class TestLabels:
+    """Test suite for the image labels."""
+
+    def test_one_label_instruction_with_generated_labels(self):
+        """Test Agentfile and generated labels share one LABEL instruction, the Agentfile's winning."""
+        content = (
+            "MODEL openai/gpt-4o\n"
+            'LABEL maintainer="Jane Doe" agentman.framework=custom\n'
+            "RUN true\n"
+            "LABEL org.example.tier=gold\n"
+            "AGENT helper\n"
+        )
+        config = AgentfileParser().parse_content(content)
+
+        with patch.dict(os.environ, {"SOURCE_DATE_EPOCH": "1700000000"}):
+            dockerfile = AgentBuilder(config, ".").dockerfile_content()
+
+        assert dockerfile.count("LABEL ") == 1
+        label = dockerfile[dockerfile.index("LABEL ") :].split("\n\n", 1)[0]
+        pairs = [line.strip().rstrip(" \\") for line in label.split("\n")]
+        assert pairs[0].startswith("LABEL agentman.config=")
+        assert pairs[1:] == [
+            'org.opencontainers.image.created="2023-11-14T22:13:20Z"',
+            "agentman.framework=custom",
+            'agentman.default_model="openai/gpt-4o"',
+            f'agentman.agentfile.sha256="{config.source_sha256}"',
+            'maintainer="Jane Doe"',
+            "org.example.tier=gold",
+        ]
+
+    def test_created_time(self):
+        """Test the creation time label is always there, dated by the Agentfile unless SOURCE_DATE_EPOCH is set."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            path = Path(temp_dir) / "Agentfile"
+            path.write_text("AGENT helper\n", encoding="utf-8")
+            os.utime(path, (1600000000, 1600000000))
+            config = AgentfileParser().parse_file(str(path))
+            with patch.dict(os.environ, {"SOURCE_DATE_EPOCH": ""}):
+                first = AgentBuilder(config, temp_dir).dockerfile_content()
+                assert AgentBuilder(config, temp_dir).dockerfile_content() == first
+            with patch.dict(os.environ, {"SOURCE_DATE_EPOCH": "1700000000"}):
+                pinned = AgentBuilder(config, temp_dir).created
+
+        assert 'org.opencontainers.image.created="2020-09-13T12:26:40Z"' in first
+        assert pinned == "2023-11-14T22:13:20Z"
+
+        # A configuration built in code has no Agentfile, so it is labelled with the current time
+        config = AgentfileParser().parse_content("AGENT helper\n")
+        with patch.dict(os.environ, {"SOURCE_DATE_EPOCH": ""}):
+            assert "org.opencontainers.image.created=" in AgentBuilder(config, ".").dockerfile_content()
+
+        with patch.dict(os.environ, {"SOURCE_DATE_EPOCH": "yesterday"}):
+            with pytest.raises(ValueError, match="SOURCE_DATE_EPOCH must be a whole number of seconds"):
+                AgentBuilder(config, ".")
+
+    def test_created_time_from_git(self):
+        """Test a committed, unmodified Agentfile is dated by its last commit rather than its modification time."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            path = Path(temp_dir) / "Agentfile"
+            path.write_text("AGENT helper\n", encoding="utf-8")
+            git = {"cwd": temp_dir, "check": True, "capture_output": True}
+            date = {"GIT_COMMITTER_DATE": "@1500000000 +0000", "GIT_AUTHOR_DATE": "@1500000000 +0000"}
+            try:
+                subprocess.run(["git", "init", "-q"], **git)
+                subprocess.run(["git", "add", "Agentfile"], **git)
+                subprocess.run(
+                    ["git", "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-qm", "add"],
+                    env={**os.environ, **date},
+                    **git,
+                )
+            except (OSError, subprocess.CalledProcessError):
+                pytest.skip("git is not available")
+
+            with patch.dict(os.environ, {"SOURCE_DATE_EPOCH": ""}):
+                assert build_timestamp(str(path)) == "2017-07-14T02:40:00Z"
+                path.write_text("AGENT helper\nAGENT other\n", encoding="utf-8")
+                os.utime(path, (1600000000, 1600000000))
+                assert build_timestamp(str(path)) == "2020-09-13T12:26:40Z"
+
+
+class TestCmdMode:
+    """Test how a custom CMD combines with the generated agent."""
+
+    def test_append_runs_both_under_supervisor(self):
+        """Test CMD_MODE append generates a supervisor that runs the CMD and the agent."""
+        content = """
+FROM yeahdongcn/agentman-base:latest
+CMD_MODE append
+AGENT helper
+CMD ["python", "my_custom.py"]
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            AgentBuilder(config, temp_dir).build_all()
+            dockerfile = (Path(temp_dir) / "Dockerfile").read_text(encoding='utf-8')
+            supervisor = (Path(temp_dir) / "supervise.py").read_text(encoding='utf-8')
+            manifest = json.loads((Path(temp_dir) / "agentman.json").read_text(encoding="utf-8"))
+
+        assert "COPY supervise.py ." in dockerfile
+        assert dockerfile.rstrip().endswith('CMD ["python", "supervise.py"]')
+        assert 'CMD ["python", "my_custom.py"]' not in dockerfile
+        assert 'COMMANDS = [["python", "my_custom.py"], ["python", "agent.py"]]' in supervisor
+        compile(supervisor, "supervise.py", "exec")
+        assert manifest["cmd_mode"] == "append"
+
+    def test_override_keeps_custom_cmd(self):
+        """Test CMD_MODE override keeps only the custom CMD and records the choice."""
+        content = """
+CMD_MODE override
+AGENT helper
+CMD ["python", "my_custom.py"]
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            AgentBuilder(config, temp_dir).build_all()
+            dockerfile = (Path(temp_dir) / "Dockerfile").read_text(encoding='utf-8')
+
+        assert dockerfile.rstrip().endswith('CMD ["python", "my_custom.py"]')
+        assert not (Path(temp_dir) / "supervise.py").exists()
+        assert config.resolved_cmd_mode == "override"
+
+
+    def test_default_cmd_per_framework_and_serve(self):
+        """Test the emitted CMD starts each framework's agent, as a server under SERVE, unless a CMD is given."""
+        for content, cmd in [
+            ("AGENT helper\n", '["python", "agent.py"]'),
+            (
+                "SERVE mcp 8080\nAGENT helper\n",
+                '["python", "agent.py", "--server", "--transport", "http", "--port", "8080"]',
+            ),
+            ("FRAMEWORK agno\nAGENT helper\n", '["python", "agent.py"]'),
+            (
+                "FRAMEWORK agno\nSHUTDOWN_GRACE 30s\nSERVE http 8000\nAGENT helper\n",
+                '["uvicorn", "agent:app", "--host", "0.0.0.0", "--port", "8000", "--timeout-graceful-shutdown", "30"]',
+            ),
+            ('FRAMEWORK agno\nSERVE http 8000\nAGENT helper\nCMD ["python", "agent.py"]\n', '["python", "agent.py"]'),
+        ]:
+            config = AgentfileParser().parse_content(content)
+            with tempfile.TemporaryDirectory() as temp_dir:
+                dockerfile = AgentBuilder(config, temp_dir).dockerfile_content()
+
+            assert dockerfile.rstrip().splitlines()[-1] == f"CMD {cmd}", content
+            assert config.resolved_cmd_mode == "generated", content
+
+
+class TestFailOnWarn:
+    """Test suite for builds with fail_on_warn."""
+
+    def test_warnings_stop_the_build(self):
+        """Test a parser warning fails the build before any file is generated."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agentfile = Path(temp_dir) / "Agentfile"
+            agentfile.write_text("EXPOSE 8080\nEXPOSE 8080\nAGENT helper\n", encoding="utf-8")
+            output_dir = Path(temp_dir) / "agent"
+            with pytest.raises(ValueError, match=r"1 warning\(s\) reported and --fail-on-warn is set"):
+                build_from_agentfile(str(agentfile), str(output_dir), fail_on_warn=True)
+            assert not output_dir.exists()
+
+
+class TestInstructionFiles:
+    """Test how INSTRUCTION_FILE contents reach the image."""
+
+    def setup_method(self):
+        """Set up an Agentfile whose agent reads prompts/coder.md."""
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.source = Path(self.temp_dir.name)
+        (self.source / "prompts").mkdir()
+        (self.source / "prompts" / "coder.md").write_text('Write "tested" code.\n', encoding="utf-8")
+
+    def teardown_method(self):
+        """Clean up the Agentfile and its output."""
+        self.temp_dir.cleanup()
+
+    def build(self, framework: str, **options) -> Path:
+        """Build the Agentfile with a framework and return the output directory."""
+        agentfile = self.source / "Agentfile"
+        content = f"FRAMEWORK {framework}\nMODEL openai/gpt-4o\nAGENT coder\nINSTRUCTION_FILE prompts/coder.md\n"
+        agentfile.write_text(content, encoding="utf-8")
+        output = self.source / framework
+        build_from_agentfile(str(agentfile), str(output), **options)
+        return output
+
+    def test_files_are_copied_and_read_at_startup(self):
+        """Test the file is written to the build context, copied into the image and read by the agent."""
+        for framework in ["fast-agent", "agno"]:
+            output = self.build(framework)
+            agent = (output / "agent.py").read_text(encoding="utf-8")
+
+            assert (output / "instructions" / "coder.md").read_text(encoding="utf-8") == 'Write "tested" code.'
+            assert "COPY instructions/ instructions/" in (output / "Dockerfile").read_text(encoding="utf-8")
+            assert "from pathlib import Path" in agent
+            assert 'Path("instructions/coder.md").read_text(encoding="utf-8")' in agent
+            compile(agent, "agent.py", "exec")
+
+    def test_inline_instructions_embed_the_text(self):
+        """Test inline_instructions puts the text in the agent and copies nothing."""
+        output = self.build("fast-agent", inline_instructions=True)
+        agent = (output / "agent.py").read_text(encoding="utf-8")
+
+        assert 'instruction="""Write "tested" code.""",' in agent
+        assert "Path(" not in agent
+        assert "instructions/" not in (output / "Dockerfile").read_text(encoding="utf-8")
+        assert not (output / "instructions").exists()
+
+
+class TestRuntimeSecrets:
+    """Test suite for secrets written from the environment when the container starts."""
+
+    CONTENT = """
+MODEL openai/gpt-4o
+SECRET OPENAI_API_KEY sk-inline-openai
+SECRET GITHUB_TOKEN ghp-inline-github
+SECRET generic
+BASE_URL http://localhost:11434/v1
+API_KEY ollama-inline-key
+SECRET DEEPSEEK_API_KEY
+MCP_SERVER github
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-github
+ENV GITHUB_TOKEN ${GITHUB_TOKEN}
+AGENT helper
+SERVERS github
+"""
+
+    def test_secrets_file_is_written_at_start(self):
+        """Test the entrypoint fills the provider keys and server env from the environment and runs the CMD."""
+        config = AgentfileParser().parse_content(self.CONTENT)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            AgentBuilder(config, temp_dir, runtime_secrets=True).build_all()
+            dockerfile = (Path(temp_dir) / "Dockerfile").read_text(encoding="utf-8")
+            entrypoint = (Path(temp_dir) / "write_secrets.py").read_text(encoding="utf-8")
+            assert not (Path(temp_dir) / "fastagent.secrets.yaml").exists()
+
+        assert "COPY write_secrets.py ." in dockerfile
+        assert 'ENTRYPOINT ["python", "write_secrets.py"]' in dockerfile
+        assert "fastagent.secrets.yaml" not in dockerfile
+
+        namespace = {"__name__": "write_secrets"}
+        exec(compile(entrypoint, "write_secrets.py", "exec"), namespace)
+        environment = {"OPENAI_API_KEY": "sk-run", "GITHUB_TOKEN": "ghp-run", "GENERIC_BASE_URL": "http://ollama:11434"}
+        with patch.dict(os.environ, environment, clear=True):
+            assert namespace["fill"](namespace["LAYOUT"]) == {
+                "openai": {"api_key": "sk-run"},
+                "generic": {"base_url": "http://ollama:11434"},
+                "mcp": {"servers": {"github": {"env": {"GITHUB_TOKEN": "ghp-run"}}}},
+            }
+
+    def test_bare_provider_key(self):
+        """Test a bare SECRET of a well-known key becomes the provider's api_key, at build time and at start."""
+        content = "MODEL anthropic/claude-3-5-sonnet\nSECRET ANTHROPIC_API_KEY\nAGENT helper\n"
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            framework = AgentBuilder(config, temp_dir).framework
+            assert framework._build_secrets_data() == {"anthropic": {"api_key": "<your-api-key-here>"}}
+            AgentBuilder(config, temp_dir, runtime_secrets=True).build_all()
+            entrypoint = (Path(temp_dir) / "write_secrets.py").read_text(encoding="utf-8")
+
+        namespace = {"__name__": "write_secrets"}
+        exec(compile(entrypoint, "write_secrets.py", "exec"), namespace)
+        with patch.dict(os.environ, {"ANTHROPIC_API_KEY": "sk-ant-run"}, clear=True):
+            assert namespace["fill"](namespace["LAYOUT"]) == {"anthropic": {"api_key": "sk-ant-run"}}
+
+    def test_no_secret_value_reaches_the_image(self):
+        """Test inline secret values appear in none of the files the Dockerfile builds from, in any mode."""
+        for options in [{}, {"combined_config": True}, {"runtime_secrets": True}]:
+            config = AgentfileParser().parse_content(self.CONTENT)
+            with tempfile.TemporaryDirectory() as temp_dir:
+                AgentBuilder(config, temp_dir, **options).build_all()
+                files = {path.name: path.read_text(encoding="utf-8") for path in Path(temp_dir).iterdir()}
+
+            for value in ["sk-inline-openai", "ghp-inline-github", "ollama-inline-key"]:
+                assert [name for name, text in files.items() if value in text] == [], options
+
+    def test_inline_values_become_environment_references(self):
+        """Test the copied secrets file refers to the environment instead of holding inline values."""
+        config = AgentfileParser().parse_content(self.CONTENT)
+        framework = AgentBuilder(config, ".").framework
+
+        assert framework._build_secrets_data() == {
+            "openai": {"api_key": "${OPENAI_API_KEY}"},
+            "generic": {"base_url": "${GENERIC_BASE_URL}", "api_key": "${GENERIC_API_KEY}"},
+            "deepseek": {"api_key": "<your-api-key-here>"},
+            "mcp": {"servers": {"github": {"env": {"GITHUB_TOKEN": "${GITHUB_TOKEN}"}}}},
+        }
+
+    def test_unsupported_combinations(self):
+        """Test agno, --combined-config and an Agentfile ENTRYPOINT are rejected."""
+        for content, options, message in [
+            ("FRAMEWORK agno\nAGENT helper\n", {}, "only FRAMEWORK fast-agent reads"),
+            ("AGENT helper\n", {"combined_config": True}, "--combined-config puts the secrets"),
+            ('AGENT helper\nENTRYPOINT ["tini", "--"]\n', {}, "remove ENTRYPOINT from the Agentfile"),
+        ]:
+            config = AgentfileParser().parse_content(content)
+            with tempfile.TemporaryDirectory() as temp_dir:
+                with pytest.raises(ValueError, match=message):
+                    AgentBuilder(config, temp_dir, runtime_secrets=True, **options)
+
+
+class TestRootless:
+    """Test suite for images that run as an unprivileged user."""
+
+    def test_user_owns_the_generated_files(self):
+        """Test the user is created before the files are copied, owns them and runs the CMD."""
+        config = AgentfileParser().parse_content("MODEL openai/gpt-4o\nAGENT helper\nHEALTHCHECK CMD true\n")
+        lines = AgentBuilder(config, ".", rootless=True, runtime_secrets=True).dockerfile_content().split("\n")
+
+        useradd = next(i for i, line in enumerate(lines) if line.startswith("RUN groupadd --system agent"))
+        assert lines.index("WORKDIR /app") < useradd
+        assert lines[useradd + 2] == "    && chown agent:agent ."
+        copies = [i for i, line in enumerate(lines) if line.startswith("COPY ") and "requirements.txt" not in line]
+        assert copies and min(copies) > useradd
+        assert "COPY --chown=agent:agent agent.py ." in lines
+        assert "COPY --chown=agent:agent write_secrets.py ." in lines
+        assert "COPY --chown=agent:agent <<'FASTAGENT_CONFIG' fastagent.config.yaml" in lines
+        assert "COPY check_configs.py ." in lines
+        cmd = next(i for i, line in enumerate(lines) if line.startswith("CMD "))
+        assert lines[cmd - 2 : cmd] == ["USER agent", ""]
+
+    def test_alpine_base_uses_busybox_commands(self):
+        """Test Alpine and BusyBox bases, which lack groupadd and useradd, create the user with adduser."""
+        for image in ["python:3.11-alpine", "alpine:3.20", "ghcr.io/team/busybox:1.36"]:
+            config = AgentfileParser().parse_content(f"FROM {image}\nAGENT helper\n")
+            lines = AgentBuilder(config, ".", rootless=True).dockerfile_content().split("\n")
+
+            addgroup = lines.index("RUN addgroup -S agent \\")
+            assert lines[addgroup + 1] == "    && adduser -S -G agent agent \\"
+            assert lines[addgroup + 2] == "    && chown agent:agent ."
+            assert "groupadd" not in "\n".join(lines)
+
+    def test_embedded_config_is_owned_too(self):
+        """Test the heredoc COPY of the fast-agent config gets the owner and its body is left as is."""
+        config = AgentfileParser().parse_content("AGENT helper\n")
+        dockerfile = AgentBuilder(config, ".", rootless=True).dockerfile_content()
+
+        assert "COPY --chown=agent:agent <<'" in dockerfile
+        assert dockerfile.count("--chown=agent:agent") == dockerfile.count("\nCOPY ") - 2
+
+    def test_agentfile_user_wins(self):
+        """Test an Agentfile USER, or no --rootless, leaves the user handling to the Agentfile."""
+        for content, options in [
+            ("AGENT helper\nRUN useradd app\nUSER app\n", {"rootless": True}),
+            ("AGENT helper\n", {}),
+        ]:
+            config = AgentfileParser().parse_content(content)
+            dockerfile = AgentBuilder(config, ".", **options).dockerfile_content()
+            assert "groupadd" not in dockerfile
+            assert "--chown" not in dockerfile
+            assert "USER agent" not in dockerfile
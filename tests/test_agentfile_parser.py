@@ -11,11 +11,22 @@ Tests cover all aspects of the AgentfileParser including:
 - Error handling and validation
 """
 
+import ast
+import hashlib
+import json
 import pytest
+import random
+import re
 import tempfile
 import os
+import threading
+import time
+import tracemalloc
+from pathlib import Path
+from unittest.mock import patch
 
 from agentman.agentfile_parser import (
+    AgentfileError,
     AgentfileParser,
     AgentfileConfig,
     MCPServer,
@@ -23,8 +34,17 @@ from agentman.agentfile_parser import (
     Router,
     Chain,
     Orchestrator,
+    Healthcheck,
     SecretValue,
-    SecretContext
+    Serve,
+    ServerAuth,
+    SecretContext,
+    import_claude_config,
+    import_mcp_json,
+    parse,
+    parse_ast,
+    parse_dotenv,
+    resolve_includes,
 )
 
 
@@ -351,6 +371,32 @@ BASE_URL https://api.openai.com/v1
         assert secret.values["API_KEY"] == "sk-test123"
         assert secret.values["BASE_URL"] == "https://api.openai.com/v1"
 
+    def test_secret_key_value_pairs(self):
+        """Test SECRET NAME=value pairs create one secret each, splitting on the first =."""
+        content = 'SECRET OPENAI_API_KEY=sk-xxx ANTHROPIC_API_KEY="sk yyy" CA_CERT=TUlJQg==\nSECRET DB_URL a=b\n'
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert [(secret.name, secret.value) for secret in config.secrets] == [
+            ("OPENAI_API_KEY", "sk-xxx"),
+            ("ANTHROPIC_API_KEY", "sk yyy"),
+            ("CA_CERT", "TUlJQg=="),
+            ("DB_URL", "a=b"),
+        ]
+        assert [(d.code, d.column) for d in parser.diagnostics] == [
+            ("secret-inline-value", 8),
+            ("secret-inline-value", 30),
+            ("secret-inline-value", 57),
+            ("secret-inline-value", 15),
+        ]
+
+    def test_secret_key_value_pairs_need_equals(self):
+        """Test a SECRET line with NAME=value pairs rejects a bare name among them."""
+        with pytest.raises(AgentfileError, match="SECRET ANTHROPIC_API_KEY is not NAME=value") as error:
+            self.parser.parse_content("SECRET OPENAI_API_KEY=sk-xxx ANTHROPIC_API_KEY\n")
+
+        assert error.value.column == 30
+
     def test_env_key_value_syntax_server_context(self):
         """Test parsing ENV KEY=VALUE syntax in SERVER context."""
         content = """
@@ -378,6 +424,16 @@ TRANSPORT stdio
         assert server.env["GITHUB_PERSONAL_ACCESS_TOKEN"] == "ABC123"
         assert server.env["API_BASE_URL"] == "https://api.github.com/v1"
 
+    def test_env_several_pairs_server_context(self):
+        """Test a server ENV line takes several KEY=VALUE pairs, quoted values with spaces included."""
+        content = 'MCP_SERVER fetch\nCOMMAND uvx\nENV FOO=1 BAR=2 GREETING="hi there" TOKEN=a=b\nENV LEGACY two words\n'
+        server = self.parser.parse_content(content).servers["fetch"]
+
+        assert server.env == {"FOO": "1", "BAR": "2", "GREETING": "hi there", "TOKEN": "a=b", "LEGACY": "two words"}
+
+        with pytest.raises(ValueError, match="needs KEY=VALUE pairs, got 'BAR'"):
+            AgentfileParser().parse_content("MCP_SERVER fetch\nCOMMAND uvx\nENV FOO=1 BAR\n")
+
     def test_env_key_value_syntax_dockerfile_context(self):
         """Test parsing ENV KEY=VALUE syntax as Dockerfile instruction."""
         content = """
@@ -440,7 +496,7 @@ INSTRUCTION Given a GitHub repository URL, find the latest **official release**
             Instead, continue checking additional releases until you find the most recent release that meets the criteria.
 SERVERS fetch github-mcp-server
 """
-        config = self.parser.parse_content(content)
+        config = AgentfileParser(external_servers=["fetch", "github-mcp-server"]).parse_content(content)
 
         assert len(config.agents) == 1
         assert "github-release-checker" in config.agents
@@ -469,7 +525,7 @@ INSTRUCTION This is a very long instruction that spans multiple lines \\
             and provide comprehensive responses.
 SERVERS server1 server2
 """
-        config = self.parser.parse_content(content)
+        config = AgentfileParser(external_servers=["server1", "server2"]).parse_content(content)
 
         assert len(config.agents) == 1
         agent = config.agents["complex-agent"]
@@ -602,3 +658,3041 @@ class TestDataClasses:
 
 if __name__ == "__main__":
     pytest.main([__file__])
+
+
+class TestServerPackages:
+    """Test suite for npx/uvx package classification."""
+
+    def test_npx_package_with_pin(self):
+        """Test a pinned npx package is split into name and version."""
+        content = """
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem@2025.1.14 /data
+"""
+        config = AgentfileParser().parse_content(content)
+        package = config.servers["filesystem"].package
+
+        assert package.installer == "npm"
+        assert package.name == "@modelcontextprotocol/server-filesystem"
+        assert package.version == "2025.1.14"
+        assert package.arg_index == 1
+
+    def test_uvx_package_forms(self):
+        """Test uvx packages with @, == and --from forms."""
+        content = """
+MCP_SERVER time
+COMMAND uvx
+ARGS mcp-server-time@0.6.2
+
+MCP_SERVER git
+COMMAND uvx
+ARGS --from mcp-server-git==0.6.0 mcp-server-git --repository /repo
+
+MCP_SERVER fetch
+COMMAND uvx
+ARGS --python 3.12 mcp-server-fetch
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.servers["time"].package.version == "0.6.2"
+        assert config.servers["git"].package.name == "mcp-server-git"
+        assert config.servers["git"].package.version == "0.6.0"
+        assert config.servers["fetch"].package.name == "mcp-server-fetch"
+        assert config.servers["fetch"].package.version is None
+
+    def test_uvx_short_python_flag(self):
+        """Test uvx -p selects the Python version, while npx -p names the package."""
+        content = """
+MCP_SERVER fetch
+COMMAND uvx
+ARGS -p 3.12 mcp-server-fetch==2025.4.7
+
+MCP_SERVER memory
+COMMAND npx
+ARGS -y -p @modelcontextprotocol/server-memory@0.6.2 mcp-server-memory
+"""
+        config = AgentfileParser().parse_content(content)
+
+        fetch = config.servers["fetch"].package
+        assert (fetch.name, fetch.version, fetch.arg_index) == ("mcp-server-fetch", "2025.4.7", 2)
+        memory = config.servers["memory"].package
+        assert (memory.name, memory.version, memory.arg_index) == ("@modelcontextprotocol/server-memory", "0.6.2", 2)
+
+    def test_non_launcher_command(self):
+        """Test servers not launched through npx/uvx have no package."""
+        content = """
+MCP_SERVER custom
+COMMAND python
+ARGS -m my_server
+"""
+        config = AgentfileParser().parse_content(content)
+        assert config.servers["custom"].package is None
+
+    def test_invalid_pin(self):
+        """Test a malformed version pin is rejected."""
+        content = """
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem@latest
+"""
+        with pytest.raises(ValueError, match="Invalid version pin 'latest'"):
+            AgentfileParser().parse_content(content)
+
+    def test_pin_package_updates_args(self):
+        """Test pinning rewrites the package argument in place."""
+        content = """
+MCP_SERVER fetch
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-fetch
+"""
+        config = AgentfileParser().parse_content(content)
+        config.servers["fetch"].pin_package("1.0.0")
+
+        assert config.servers["fetch"].args == ["-y", "@modelcontextprotocol/server-fetch@1.0.0"]
+
+
+class TestListForms:
+    """Test suite for plain and JSON-array list values."""
+
+    def test_args_json_array(self):
+        """Test ARGS accepts a JSON array with whitespace inside elements."""
+        content = """
+MCP_SERVER filesystem
+COMMAND npx
+ARGS ["-y", "@modelcontextprotocol/server-filesystem", "--root", "/data with spaces"]
+"""
+        config = AgentfileParser().parse_content(content)
+        assert config.servers["filesystem"].args == [
+            "-y",
+            "@modelcontextprotocol/server-filesystem",
+            "--root",
+            "/data with spaces",
+        ]
+
+    def test_servers_json_array(self):
+        """Test SERVERS accepts a JSON array."""
+        content = """
+AGENT helper
+SERVERS ["fetch", "github"]
+"""
+        config = AgentfileParser(external_servers=["fetch", "github"]).parse_content(content)
+        assert config.agents["helper"].servers == ["fetch", "github"]
+
+    def test_tolerant_array(self):
+        """Test single-quoted arrays are parsed like CMD."""
+        content = """
+AGENT helper
+SERVERS ['fetch', 'github']
+"""
+        config = AgentfileParser(external_servers=["fetch", "github"]).parse_content(content)
+        assert config.agents["helper"].servers == ["fetch", "github"]
+
+    def test_array_keeps_commas_and_spaces(self):
+        """Test array elements keep commas and spaces for ARGS and SEQUENCE alike."""
+        content = """
+MCP_SERVER search
+COMMAND uvx
+ARGS ["mcp-search", "--query", "cats, dogs", "--region", "eu west"]
+AGENT a
+AGENT b
+CHAIN pipeline
+SEQUENCE ["a",   "b"]
+"""
+        config = AgentfileParser().parse_content(content)
+        assert config.servers["search"].args == ["mcp-search", "--query", "cats, dogs", "--region", "eu west"]
+        assert config.chains["pipeline"].sequence == ["a", "b"]
+
+    def test_malformed_array_shows_the_text(self):
+        """Test a missing ], an open quote or an empty element is an error that quotes the array."""
+        cases = {
+            'ARGS ["-y", "@modelcontextprotocol/server-fetch"': "missing the closing ]: "
+            '["-y", "@modelcontextprotocol/server-fetch"',
+            "ARGS ['-y, 'server-fetch']": "Malformed JSON array: ['-y, 'server-fetch']",
+            'ARGS ["-y",, "server-fetch"]': 'Malformed JSON array: ["-y",, "server-fetch"]',
+        }
+        for line, expected in cases.items():
+            with pytest.raises(AgentfileError) as error:
+                AgentfileParser().parse_content(f"MCP_SERVER fetch\nCOMMAND npx\n{line}\n")
+            assert expected in str(error.value)
+            assert (error.value.line, error.value.column) == (3, 6)
+
+    def test_mixed_forms_last_wins(self):
+        """Test mixing array and plain forms keeps the last value and records a notice."""
+        content = """
+AGENT helper
+SERVERS ["fetch", "github"]
+SERVERS filesystem
+"""
+        parser = AgentfileParser(external_servers=["filesystem"])
+        config = parser.parse_content(content)
+
+        assert config.agents["helper"].servers == ["filesystem"]
+        assert [d.code for d in parser.diagnostics] == ["mixed-list-form"]
+        assert parser.diagnostics[0].line == 4
+
+    def test_comma_separated_names(self):
+        """Test name lists accept commas with or without spaces."""
+        content = """
+AGENT helper
+SERVERS fetch, github,filesystem,
+
+ROUTER route
+AGENTS a,b
+
+CHAIN pipeline
+SEQUENCE a , b, c
+
+ORCHESTRATOR boss
+AGENTS a, b
+
+AGENT a
+AGENT b
+AGENT c
+"""
+        config = AgentfileParser(external_servers=["fetch", "github", "filesystem"]).parse_content(content)
+
+        assert config.agents["helper"].servers == ["fetch", "github", "filesystem"]
+        assert config.routers["route"].agents == ["a", "b"]
+        assert config.chains["pipeline"].sequence == ["a", "b", "c"]
+        assert config.orchestrators["boss"].agents == ["a", "b"]
+
+    def test_commas_kept_in_args(self):
+        """Test ARGS values keep their commas."""
+        content = """
+MCP_SERVER custom
+COMMAND my-server
+ARGS --tags a,b
+"""
+        config = AgentfileParser().parse_content(content)
+        assert config.servers["custom"].args == ["--tags", "a,b"]
+
+
+class TestServerReferences:
+    """Test suite for the servers agents use."""
+
+    def test_undefined_server_suggests_the_closest(self):
+        """Test a misspelled server is an error that names the agent and suggests the defined one."""
+        content = """
+MCP_SERVER filesystem
+COMMAND npx
+
+AGENT helper
+SERVERS filesytem
+"""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content(content)
+
+        assert "Agent helper uses server filesytem, which is not defined; did you mean filesystem?" in str(error.value)
+        assert (error.value.line, error.value.column) == (6, 9)
+
+    def test_undefined_server_without_a_match(self):
+        """Test the error suggests a block when no defined server is close."""
+        with pytest.raises(AgentfileError, match="add an MCP_SERVER github block"):
+            AgentfileParser().parse_content("AGENT helper\nSERVERS github\n")
+
+    def test_external_servers(self):
+        """Test servers the base image provides need no MCP_SERVER block."""
+        config = AgentfileParser(external_servers=["github"]).parse_content("AGENT helper\nSERVERS github\n")
+
+        assert config.agents["helper"].servers == ["github"]
+        assert config.external_servers == ["github"]
+
+    def test_agno_tools(self):
+        """Test names agno turns into built-in tools need no MCP_SERVER block."""
+        config = AgentfileParser().parse_content("FRAMEWORK agno\nAGENT helper\nSERVERS web_search\n")
+
+        assert config.agents["helper"].servers == ["web_search"]
+
+
+class TestRouterTargets:
+    """Test suite for the agents routers route to."""
+
+    def test_undefined_target(self):
+        """Test an unknown target is an error at the ROUTER line that suggests the closest agent."""
+        content = """
+AGENT writer
+AGENT editor
+ROUTER route
+AGENTS writer edtor
+"""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content(content)
+
+        assert "Router route routes to edtor, which is not defined; did you mean editor?" in str(error.value)
+        assert (error.value.line, error.value.source) == (4, "ROUTER route")
+
+    def test_no_targets(self):
+        """Test a router needs AGENTS."""
+        with pytest.raises(AgentfileError, match="Router route has no AGENTS to route to"):
+            AgentfileParser().parse_content("ROUTER route\nINSTRUCTION Pick one\n")
+
+    def test_workflow_targets(self):
+        """Test routing to a workflow needs route_to_workflows, and routing to itself is never allowed."""
+        content = "AGENT a\nAGENT b\nCHAIN pipeline\nSEQUENCE a b\nROUTER route\nAGENTS a pipeline\n"
+        with pytest.raises(AgentfileError, match="routes to CHAIN pipeline, which is not an AGENT"):
+            AgentfileParser().parse_content(content)
+
+        config = AgentfileParser(route_to_workflows=True).parse_content(content)
+        assert config.routers["route"].agents == ["a", "pipeline"]
+
+        with pytest.raises(AgentfileError, match="Router route routes to itself"):
+            AgentfileParser(route_to_workflows=True).parse_content("AGENT a\nROUTER route\nAGENTS a route\n")
+
+    def test_single_target_warns(self):
+        """Test a router with one target is a warning naming it."""
+        parser = AgentfileParser()
+        parser.parse_content("AGENT a\nROUTER route\nAGENTS a\nDEFAULT true\n")
+
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("single-route", 2)]
+        assert "only routes to a" in parser.diagnostics[0].message
+
+    def test_human_input(self):
+        """Test HUMAN_INPUT in a ROUTER is a setting of the router, not an unknown keyword."""
+        parser = AgentfileParser()
+        config = parser.parse_content("AGENT a\nAGENT b\nROUTER route\nAGENTS a b\nHUMAN_INPUT true\n")
+        inline = AgentfileParser().parse_content("AGENT a\nAGENT b\nROUTER route agents=a,b human_input=yes\n")
+
+        assert config.routers["route"].human_input is True
+        assert inline.routers["route"] == config.routers["route"]
+        assert not [d for d in parser.diagnostics if d.code != "implicit-default"]
+        assert "human_input=True" in config.routers["route"].to_decorator_string()
+        plain = AgentfileParser().parse_content("AGENT a\nROUTER route\nAGENTS a\n")
+        assert plain.routers["route"].human_input is False
+
+
+class TestOrchestratorAgents:
+    """Test suite for the agents and workflows orchestrators coordinate."""
+
+    def test_workflows_are_allowed(self):
+        """Test an orchestrator may coordinate chains and routers as well as agents."""
+        content = """
+AGENT a
+AGENT b
+CHAIN pipeline
+SEQUENCE a b
+ROUTER pick
+AGENTS a b
+ORCHESTRATOR planner
+AGENTS a pipeline pick
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.orchestrators["planner"].agents == ["a", "pipeline", "pick"]
+        assert 'agents=["a", "pipeline", "pick"]' in config.orchestrators["planner"].to_decorator_string()
+
+    def test_undefined_agent(self):
+        """Test an unknown name is an error at the ORCHESTRATOR line that suggests the closest one."""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content("AGENT writer\nORCHESTRATOR planner\nAGENTS writr\n")
+
+        assert "Orchestrator planner coordinates writr, which is not defined; did you mean writer?" in str(
+            error.value
+        )
+        assert (error.value.line, error.value.source) == (2, "ORCHESTRATOR planner")
+
+    def test_no_agents_or_itself(self):
+        """Test an orchestrator needs AGENTS, and cannot coordinate itself."""
+        with pytest.raises(AgentfileError, match="Orchestrator planner has no AGENTS to coordinate"):
+            AgentfileParser().parse_content("ORCHESTRATOR planner\nPLAN_TYPE iterative\n")
+        with pytest.raises(AgentfileError, match="Orchestrator planner coordinates itself"):
+            AgentfileParser().parse_content("AGENT a\nORCHESTRATOR planner\nAGENTS a planner\n")
+
+    def test_agno_needs_agents(self):
+        """Test FRAMEWORK agno rejects workflow members and a second orchestrator."""
+        content = (
+            "FRAMEWORK agno\nAGENT a\nAGENT b\nCHAIN pipeline\nSEQUENCE a b\nORCHESTRATOR lead\nAGENTS a pipeline\n"
+        )
+        with pytest.raises(AgentfileError, match="coordinates pipeline, but FRAMEWORK agno can only make AGENTs"):
+            AgentfileParser().parse_content(content)
+
+        content = "FRAMEWORK agno\nAGENT a\nORCHESTRATOR one\nAGENTS a\nORCHESTRATOR two\nAGENTS a\n"
+        with pytest.raises(AgentfileError, match="ORCHESTRATOR one and two are both declared") as error:
+            AgentfileParser().parse_content(content)
+        assert error.value.line == 5
+
+    def test_instruction(self):
+        """Test INSTRUCTION takes unquoted words, a quoted value with spaces and a heredoc, and rejects empty text."""
+        base = "AGENT a\nORCHESTRATOR planner\nAGENTS a\n"
+        for line, expected in [
+            ("INSTRUCTION Plan the   release", "Plan the release"),
+            ('INSTRUCTION "Plan the release, then  check it"', "Plan the release, then  check it"),
+            ("INSTRUCTION <<EOF\nPlan first.\nThen delegate.\nEOF", "Plan first.\nThen delegate."),
+        ]:
+            config = AgentfileParser().parse_content(base + line + "\n")
+            assert config.orchestrators["planner"].instruction == expected
+
+        with pytest.raises(ValueError, match="INSTRUCTION requires instruction text"):
+            AgentfileParser().parse_content(base + 'INSTRUCTION ""\n')
+
+
+class TestParallel:
+    """Test suite for PARALLEL workflows."""
+
+    CONTENT = """
+AGENT legal
+AGENT finance
+AGENT security
+AGENT summarizer
+PARALLEL review
+FAN_OUT legal, finance, security
+FAN_IN summarizer
+INSTRUCTION Review the contract
+CHAIN pipeline
+SEQUENCE review summarizer
+DEFAULT true
+"""
+
+    def test_parallel_block(self):
+        """Test FAN_OUT, FAN_IN and INSTRUCTION are read, and a chain may run the parallel as a step."""
+        config = AgentfileParser().parse_content(self.CONTENT)
+        parallel = config.parallels["review"]
+
+        assert (parallel.fan_out, parallel.fan_in, parallel.instruction) == (
+            ["legal", "finance", "security"],
+            "summarizer",
+            "Review the contract",
+        )
+        assert config.entity_keyword("review") == "PARALLEL"
+        assert config.workflow_graph()["review"] == ["legal", "finance", "security", "summarizer"]
+        assert parallel.to_decorator_string() == (
+            "@fast.parallel(\n"
+            '    name="review",\n'
+            '    fan_out=["legal", "finance", "security"],\n'
+            '    fan_in="summarizer",\n'
+            '    instruction="""Review the contract"""\n'
+            ")"
+        )
+
+    def test_inline_attributes(self):
+        """Test the declaration line takes fan_out and fan_in, and FAN_IN may be left out."""
+        content = 'AGENT a\nAGENT b\nPARALLEL both fan_out="a,b"\nPARALLEL merged fan_out=a fan_in=b\n'
+        config = AgentfileParser().parse_content(content)
+
+        assert (config.parallels["both"].fan_out, config.parallels["both"].fan_in) == (["a", "b"], None)
+        assert "fan_in" not in config.parallels["both"].to_decorator_string()
+        assert config.parallels["merged"].fan_in == "b"
+
+    def test_fan_in_names_one_agent(self):
+        """Test FAN_IN with several names is an error."""
+        with pytest.raises(AgentfileError, match="FAN_IN names one agent to combine the answers, got a, b"):
+            AgentfileParser().parse_content("AGENT a\nAGENT b\nPARALLEL p\nFAN_OUT a\nFAN_IN a, b\n")
+
+    def test_undefined_targets(self):
+        """Test FAN_OUT and FAN_IN must name defined agents or workflows other than the parallel itself."""
+        for body, message in [
+            ("", "Parallel review has no FAN_OUT agents to send the request to"),
+            ("FAN_OUT legl\n", "Parallel review fans out to legl, which is not defined; did you mean legal?"),
+            ("FAN_OUT legal\nFAN_IN sumarizer\n", "Parallel review fans in to sumarizer, which is not defined"),
+            ("FAN_OUT legal review\n", "Parallel review fans out to itself"),
+        ]:
+            with pytest.raises(AgentfileError, match=message) as error:
+                AgentfileParser().parse_content("AGENT legal\nAGENT summarizer\nPARALLEL review\n" + body)
+            assert (error.value.line, error.value.source) == (3, "PARALLEL review")
+
+    def test_agno_team(self):
+        """Test FRAMEWORK agno takes a parallel of agents as its team, but not next to an orchestrator."""
+        AgentfileParser().parse_content("FRAMEWORK agno\n" + self.CONTENT.split("CHAIN")[0])
+
+        content = "FRAMEWORK agno\nAGENT a\nCHAIN c\nSEQUENCE a\nPARALLEL p\nFAN_OUT a c\n"
+        with pytest.raises(AgentfileError, match="PARALLEL p runs c, but FRAMEWORK agno can only make AGENTs"):
+            AgentfileParser().parse_content(content)
+        content = "FRAMEWORK agno\nAGENT a\nORCHESTRATOR lead\nAGENTS a\nPARALLEL p\nFAN_OUT a\n"
+        with pytest.raises(AgentfileError, match="ORCHESTRATOR lead and PARALLEL p are both declared"):
+            AgentfileParser().parse_content(content)
+
+
+class TestPrompts:
+    """Test suite for PROMPT texts shared between instructions."""
+
+    CONTENT = """
+PROMPT review_style <<EOF
+Be terse.
+Cite line numbers.
+EOF
+PROMPT tone "Stay friendly"
+AGENT reviewer
+INSTRUCTION @review_style
+AGENT mentor
+INSTRUCTION Explain the review. {{prompt:tone}}
+CHAIN pipeline
+SEQUENCE reviewer mentor
+INSTRUCTION <<EOF
+{{prompt:review_style}}
+{{prompt:tone}}
+EOF
+DEFAULT true
+"""
+
+    def test_prompts_expand_into_instructions(self):
+        """Test @name replaces the whole INSTRUCTION and {{prompt:name}} is replaced where it stands."""
+        config = AgentfileParser().parse_content(self.CONTENT)
+
+        assert config.prompts == {"review_style": "Be terse.\nCite line numbers.", "tone": "Stay friendly"}
+        assert config.agents["reviewer"].instruction == "Be terse.\nCite line numbers."
+        assert config.agents["mentor"].instruction == "Explain the review. Stay friendly"
+        assert config.chains["pipeline"].instruction == "Be terse.\nCite line numbers.\nStay friendly"
+
+    def test_undefined_prompt(self):
+        """Test an unknown prompt name is an error at the declaration that uses it, suggesting the closest one."""
+        for instruction in ["@review", "Check {{prompt:review}} first"]:
+            content = f"PROMPT reviews Be terse\nAGENT a\nINSTRUCTION {instruction}\n"
+            message = "AGENT a uses PROMPT review, which is not defined; did you mean reviews"
+            with pytest.raises(AgentfileError, match=message) as error:
+                AgentfileParser().parse_content(content)
+            assert (error.value.line, error.value.source) == (2, "AGENT a")
+
+    def test_invalid_prompt(self):
+        """Test a PROMPT needs a valid name and some text, and is declared once per file."""
+        for content, message in [
+            ("PROMPT\n", "PROMPT requires a prompt name"),
+            ("PROMPT tone\n", "PROMPT tone requires text"),
+            ("PROMPT my.tone Be kind\n", "Invalid PROMPT name my.tone"),
+            ("PROMPT tone Be kind\nPROMPT tone Be brief\n", "PROMPT tone is already declared on line 1"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(content)
+
+    def test_configuration_built_in_code(self):
+        """Test validate() reports unknown prompts and expand_prompts() fills in known ones."""
+        config = AgentfileConfig(prompts={"tone": "Stay friendly"})
+        config.agents["a"] = Agent(name="a", instruction="{{prompt:tone}} {{prompt:missing}}")
+
+        assert [finding.code for finding in config.validate()] == ["undefined-prompt"]
+        config.expand_prompts()
+        assert config.agents["a"].instruction == "Stay friendly {{prompt:missing}}"
+
+
+class TestHumanInputMode:
+    """Test suite for HUMAN_INPUT_MODE."""
+
+    def test_modes(self):
+        """Test console is the default, and webhook keeps its URL until another mode replaces it."""
+        assert AgentfileParser().parse_content("AGENT a\n").human_input_mode == "console"
+
+        config = AgentfileParser().parse_content("HUMAN_INPUT_MODE webhook https://hooks.example.com/input\n")
+        assert (config.human_input_mode, config.human_input_webhook) == ("webhook", "https://hooks.example.com/input")
+
+        content = "HUMAN_INPUT_MODE webhook https://hooks.example.com/input\nHUMAN_INPUT_MODE Disabled\n"
+        config = AgentfileParser().parse_content(content)
+        assert (config.human_input_mode, config.human_input_webhook) == ("disabled", None)
+
+    def test_invalid_mode(self):
+        """Test unknown modes, a webhook without an http(s) URL and a URL for another mode are errors."""
+        for line, message in [
+            ("HUMAN_INPUT_MODE slack", "Unsupported HUMAN_INPUT_MODE: slack. Supported: console, webhook, disabled"),
+            ("HUMAN_INPUT_MODE webhook", "HUMAN_INPUT_MODE webhook requires the URL to post questions to"),
+            ("HUMAN_INPUT_MODE webhook hooks.example.com", "Invalid HUMAN_INPUT_MODE webhook URL: hooks.example.com"),
+            ("HUMAN_INPUT_MODE console https://hooks.example.com", "HUMAN_INPUT_MODE console takes no URL"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(line + "\n")
+
+    def test_disabled_rejects_human_input(self):
+        """Test HUMAN_INPUT true on an agent or workflow is an error when the mode is disabled."""
+        for block in ["AGENT a\nHUMAN_INPUT true\n", "AGENT a\nAGENT b\nROUTER pick\nAGENTS a b\nHUMAN_INPUT true\n"]:
+            with pytest.raises(AgentfileError, match="sets HUMAN_INPUT true, but HUMAN_INPUT_MODE disabled") as error:
+                AgentfileParser().parse_content("HUMAN_INPUT_MODE disabled\n" + block)
+            assert error.value.source.split()[0] in ["AGENT", "ROUTER"]
+        AgentfileParser().parse_content("HUMAN_INPUT_MODE disabled\nAGENT a\n")
+
+    def test_agno_rejects_webhook(self):
+        """Test FRAMEWORK agno, which has no human input handler to replace, rejects webhook mode."""
+        content = "FRAMEWORK agno\nHUMAN_INPUT_MODE webhook https://hooks.example.com/input\nAGENT a\n"
+        with pytest.raises(AgentfileError, match="FRAMEWORK agno does not have") as error:
+            AgentfileParser().parse_content(content)
+        assert error.value.line == 2
+
+
+class TestServe:
+    """Test suite for SERVE."""
+
+    def test_serve_sets_port_and_cmd(self):
+        """Test SERVE exposes its port once, and starts fast-agent as a server unless the Agentfile sets a CMD."""
+        config = AgentfileParser().parse_content("EXPOSE 8080\nSERVE MCP 8080\nAGENT a\n")
+        assert config.serve == Serve(protocol="mcp", port=8080)
+        assert config.serve.endpoint == "/mcp"
+        assert config.expose_ports == [8080]
+        assert config.cmd == ["python", "agent.py", "--server", "--transport", "http", "--port", "8080"]
+
+        config = AgentfileParser().parse_content('SERVE mcp 9000\nAGENT a\nCMD ["python", "agent.py"]\n')
+        assert (config.expose_ports, config.cmd) == ([9000], ["python", "agent.py"])
+
+        config = AgentfileParser().parse_content("FRAMEWORK agno\nSERVE http 8000 /ask\nAGENT a\n")
+        assert (config.serve.endpoint, config.expose_ports) == ("/ask", [8000])
+
+    def test_invalid_serve(self):
+        """Test SERVE needs a known protocol, a port number and a path starting with /."""
+        for line, message in [
+            ("SERVE mcp", "SERVE requires a protocol and a port"),
+            ("SERVE grpc 8080", "Unsupported SERVE protocol: grpc. Supported: mcp, http"),
+            ("SERVE mcp web", "Invalid port number: web"),
+            ("SERVE mcp 70000", "SERVE 70000 is not a port number between 1 and 65535"),
+            ("SERVE mcp 8080 mcp", "SERVE path must start with /, got mcp"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(line + "\nAGENT a\n")
+
+    def test_framework_protocols(self):
+        """Test fast-agent serves MCP on /mcp and agno serves HTTP."""
+        for content, message in [
+            ("AGENT a\nSERVE http 8000\n", "FRAMEWORK fast-agent cannot SERVE http; use SERVE mcp"),
+            ("FRAMEWORK agno\nSERVE mcp 8080\nAGENT a\n", "FRAMEWORK agno cannot SERVE mcp; use SERVE http"),
+            ("AGENT a\nSERVE mcp 8080 /agents\n", "fast-agent serves MCP on /mcp, so SERVE cannot use /agents"),
+        ]:
+            with pytest.raises(AgentfileError, match=message) as error:
+                AgentfileParser().parse_content(content)
+            assert error.value.line == 2
+        AgentfileParser().parse_content("SERVE mcp 8080 /mcp\nAGENT a\n")
+
+    def test_serve_needs_an_agent_and_a_free_port(self):
+        """Test SERVE with nothing to serve, or on the port of a server in the container, is an error."""
+        with pytest.raises(AgentfileError, match="SERVE mcp 8080 has no AGENT or workflow to serve"):
+            AgentfileParser().parse_content("SERVE mcp 8080\n")
+
+        content = "SERVE mcp 8080\nMCP_SERVER local\nTRANSPORT http\nURL http://localhost:8080/mcp\nAGENT a\n"
+        with pytest.raises(AgentfileError, match="SERVE mcp 8080 uses the port server local listens on") as error:
+            AgentfileParser().parse_content(content)
+        assert error.value.line == 1
+        AgentfileParser().parse_content(content.replace("localhost", "tools.example.com"))
+
+
+class TestChainSteps:
+    """Test suite for chain steps and workflows that include themselves."""
+
+    NESTED = """
+AGENT fetcher
+AGENT summarizer
+AGENT writer
+
+CHAIN inner
+SEQUENCE fetcher summarizer
+
+CHAIN middle
+SEQUENCE inner writer
+
+CHAIN outer
+SEQUENCE middle {last}
+"""
+
+    def test_nested_chains(self):
+        """Test chains can run chains three levels deep."""
+        config = AgentfileParser().parse_content(self.NESTED.format(last="writer"))
+
+        assert config.chains["outer"].sequence == ["middle", "writer"]
+        assert config.workflow_graph()["outer"] == ["middle", "writer"]
+
+    def test_cycle_prints_the_path(self):
+        """Test a chain reached again through nested chains is an error that prints the cycle."""
+        content = self.NESTED.replace("SEQUENCE fetcher summarizer", "SEQUENCE fetcher outer").format(last="writer")
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content(content)
+
+        assert "CHAIN inner includes itself: inner -> outer -> middle -> inner" in str(error.value)
+        assert error.value.line == 6
+
+    def test_chain_including_itself(self):
+        """Test a chain in its own SEQUENCE is a cycle."""
+        with pytest.raises(AgentfileError, match="CHAIN loop includes itself: loop -> loop"):
+            AgentfileParser().parse_content("AGENT a\nCHAIN loop\nSEQUENCE a loop\n")
+
+    def test_undefined_step(self):
+        """Test an unknown step suggests the closest agent or workflow."""
+        content = self.NESTED.format(last="writer").replace("SEQUENCE middle", "SEQUENCE midle")
+        with pytest.raises(AgentfileError, match="Chain outer runs midle, which is not defined; did you mean middle"):
+            AgentfileParser().parse_content(content)
+
+    def test_empty_sequence(self):
+        """Test a chain needs a SEQUENCE."""
+        with pytest.raises(AgentfileError, match="Chain pipeline has no SEQUENCE of steps"):
+            AgentfileParser().parse_content("AGENT a\nCHAIN pipeline\nCUMULATIVE true\n")
+
+
+class TestWorkflowModels:
+    """Test suite for MODEL inside CHAIN and ORCHESTRATOR blocks."""
+
+    CONTENT = """MODEL openai/gpt-4o-mini
+AGENT fetcher
+AGENT writer
+MODEL anthropic/claude-3-haiku
+AGENT reviewer
+
+CHAIN pipeline
+SEQUENCE fetcher writer
+MODEL qwen/qwen-max
+DEFAULT true
+
+ORCHESTRATOR planner
+AGENTS fetcher reviewer
+MODEL deepseek/deepseek-chat
+"""
+
+    def test_workflow_model_is_not_the_default(self):
+        """Test MODEL in a chain or orchestrator belongs to the workflow instead of replacing the file's MODEL."""
+        config = AgentfileParser().parse_content(self.CONTENT)
+
+        assert config.default_model == "openai/gpt-4o-mini"
+        assert config.chains["pipeline"].model == "qwen/qwen-max"
+        assert config.orchestrators["planner"].model == "deepseek/deepseek-chat"
+
+    def test_chain_model_overrides_the_file_model(self):
+        """Test chain steps without a MODEL run with the chain's, and everything else keeps its own."""
+        config = AgentfileParser().parse_content(self.CONTENT)
+
+        assert config.model_for("fetcher") == "qwen/qwen-max"
+        assert config.model_for("writer") == "anthropic/claude-3-haiku"
+        assert config.model_for("reviewer") == "openai/gpt-4o-mini"
+        assert config.model_for("planner") == "deepseek/deepseek-chat"
+
+    def test_conflicting_chain_models_warn(self):
+        """Test a step in chains with different models warns and keeps the first chain's model."""
+        second_chain = "DEFAULT true\nCHAIN again\nSEQUENCE fetcher\nMODEL openai/gpt-4o"
+        content = self.CONTENT.replace("DEFAULT true", second_chain)
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("chain-model-conflict", 11)]
+        assert config.model_for("fetcher") == "qwen/qwen-max"
+
+    def test_empty_chain_model(self):
+        """Test an empty chain MODEL is an error rather than a silent fallback."""
+        with pytest.raises(AgentfileError, match="MODEL requires a model name"):
+            AgentfileParser().parse_content('AGENT a\nCHAIN pipeline\nSEQUENCE a\nMODEL ""\n')
+
+
+class TestDefaultEntity:
+    """Test suite for DEFAULT across agents and workflows."""
+
+    def test_more_than_one_default(self):
+        """Test DEFAULT on two entities is an error listing every one with its line."""
+        content = """
+AGENT a
+DEFAULT true
+AGENT b
+DEFAULT true
+CHAIN pipeline
+SEQUENCE a b
+DEFAULT true
+"""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content(content)
+
+        assert (
+            "DEFAULT is set on more than one agent or workflow: AGENT a (line 2), AGENT b (line 4), "
+            "CHAIN pipeline (line 6)" in str(error.value)
+        )
+        assert error.value.line == 4
+
+    def test_fallback_is_reported(self):
+        """Test a missing DEFAULT warns which entity starts when it is not the only top-level one."""
+        parser = AgentfileParser()
+        config = parser.parse_content("AGENT a\nAGENT b\nCHAIN pipeline\nSEQUENCE a b\n")
+
+        assert config.fallback_entity() == "a"
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("implicit-default", 1)]
+        assert "starts AGENT a, the first agent declared" in parser.diagnostics[0].message
+
+    def test_no_warning_when_the_fallback_is_expected(self):
+        """Test one entity, a DEFAULT, or a single top-level entity declared first, need no warning."""
+        for content in [
+            "AGENT a\n",
+            "AGENT a\nAGENT b\nDEFAULT true\n",
+            "AGENT a\nAGENT b\nROUTER route\nAGENTS a b\nDEFAULT yes\n",
+            "FRAMEWORK agno\nAGENT a\nAGENT b\n",
+        ]:
+            parser = AgentfileParser()
+            parser.parse_content(content)
+            assert not parser.diagnostics, content
+
+
+class TestDockerServers:
+    """Test suite for docker-launched MCP servers."""
+
+    def test_docker_server_requires_opt_in(self):
+        """Test docker-launched servers are rejected without ALLOW_DOCKER."""
+        content = """
+MCP_SERVER github
+COMMAND docker
+ARGS run -i --rm mcp/github
+"""
+        with pytest.raises(AgentfileError, match="ALLOW_DOCKER true") as error:
+            AgentfileParser().parse_content(content)
+        assert (error.value.line, error.value.instruction) == (2, "MCP_SERVER")
+        assert str(error.value).startswith("Error parsing line 2: MCP_SERVER github\n")
+
+    def test_docker_server_allowed(self):
+        """Test ALLOW_DOCKER accepts docker-launched servers."""
+        content = """
+MCP_SERVER github
+COMMAND docker
+ARGS run -i --rm mcp/github
+ALLOW_DOCKER true
+"""
+        config = AgentfileParser().parse_content(content)
+        assert config.servers["github"].allow_docker is True
+        assert config.servers["github"].uses_docker is True
+
+    def test_windows_path_warning(self):
+        """Test Windows-style paths in ARGS produce a portability warning."""
+        content = """
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem C:\\Users\\me\\data
+"""
+        parser = AgentfileParser()
+        parser.parse_content(content)
+        assert [d.code for d in parser.diagnostics] == ["windows-path"]
+
+
+class TestInlineAttributes:
+    """Test suite for key=value attributes on declaration lines."""
+
+    def test_agent_inline_attributes(self):
+        """Test inline attributes set the same fields as sub-instructions."""
+        content = """
+AGENT greeter model=openai.gpt-4o-mini default=true human_input=false servers=fetch,github instruction="Say hi"
+"""
+        agent = AgentfileParser(external_servers=["fetch", "github"]).parse_content(content).agents["greeter"]
+        assert agent.model == "openai.gpt-4o-mini"
+        assert agent.default is True
+        assert agent.human_input is False
+        assert agent.servers == ["fetch", "github"]
+        assert agent.instruction == "Say hi"
+
+    def test_sub_instruction_overrides_inline(self):
+        """Test sub-instruction lines override inline attributes."""
+        content = """
+AGENT greeter model=openai.gpt-4o-mini
+MODEL anthropic/claude-3-sonnet
+"""
+        agent = AgentfileParser().parse_content(content).agents["greeter"]
+        assert agent.model == "anthropic/claude-3-sonnet"
+
+    def test_server_and_workflow_inline_attributes(self):
+        """Test inline attributes on MCP_SERVER, ROUTER and CHAIN."""
+        content = """
+MCP_SERVER fetch command=uvx args="mcp-server-fetch --verbose" transport=stdio
+ROUTER route agents=a,b
+CHAIN pipeline sequence=a,b cumulative=true
+AGENT a
+AGENT b
+"""
+        config = AgentfileParser().parse_content(content)
+        assert config.servers["fetch"].command == "uvx"
+        assert config.servers["fetch"].args == ["mcp-server-fetch", "--verbose"]
+        assert config.routers["route"].agents == ["a", "b"]
+        assert config.chains["pipeline"].cumulative is True
+
+    def test_inline_attributes_are_validated(self):
+        """Test inline values go through sub-instruction validation."""
+        with pytest.raises(ValueError, match="Invalid transport type: ftp"):
+            AgentfileParser().parse_content("MCP_SERVER fetch transport=ftp")
+
+    def test_remote_transports_are_kept_distinct(self):
+        """Test http and streamable-http are accepted and stored as written."""
+        content = """
+MCP_SERVER search transport=http url=https://mcp.example.com/mcp
+MCP_SERVER docs transport=streamable-http url=https://docs.example.com/mcp
+"""
+        servers = AgentfileParser().parse_content(content).servers
+        assert servers["search"].transport == "http"
+        assert servers["docs"].transport == "streamable-http"
+
+    def test_elicitation_mode_is_validated(self):
+        """Test ELICITATION_MODE only takes the supported modes."""
+        with pytest.raises(ValueError, match="Invalid ELICITATION_MODE: always. Supported: auto, forms, none"):
+            AgentfileParser().parse_content("MCP_SERVER s\nCOMMAND uvx\nELICITATION_MODE always")
+
+    def test_remote_transport_requires_url(self):
+        """Test a network transport without a URL is reported at the server declaration."""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content("\nMCP_SERVER docs\nTRANSPORT streamable-http\n")
+        assert error.value.line == 2
+        assert error.value.message == "Server docs uses TRANSPORT streamable-http, which needs a URL"
+
+    def test_stdio_transport_requires_command(self):
+        """Test a stdio server without a COMMAND is reported at the server declaration."""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content("AGENT a\nSERVERS docs\n\nMCP_SERVER docs\nARGS mcp-server-docs\n")
+        assert error.value.line == 4
+        assert error.value.message.startswith("Server docs uses TRANSPORT stdio, which needs a COMMAND")
+
+    def test_unknown_inline_attribute(self):
+        """Test unknown keys list the valid attributes."""
+        with pytest.raises(ValueError, match="Valid attributes: instruction, instruction_file, servers, tools"):
+            AgentfileParser().parse_content("AGENT greeter colour=blue")
+
+
+class TestParserDirectives:
+    """Test suite for escape and syntax parser directives."""
+
+    def test_escape_directive_changes_continuation(self):
+        """Test backticks continue lines and backslashes stay literal under escape=`."""
+        content = """# escape=`
+# syntax=docker/dockerfile:1
+
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem `
+    C:\\data\\
+"""
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+        assert config.directives == {"escape": "`", "syntax": "docker/dockerfile:1"}
+        assert config.servers["filesystem"].args == ["-y", "@modelcontextprotocol/server-filesystem", "C:\\data\\"]
+
+    def test_directive_after_instruction(self):
+        """Test directives after the first instruction are rejected."""
+        content = """FROM python:3.11
+# escape=`
+"""
+        with pytest.raises(ValueError, match="must appear at the top"):
+            AgentfileParser().parse_content(content)
+
+    def test_invalid_escape_character(self):
+        """Test only backslash and backtick are accepted as escape characters."""
+        with pytest.raises(ValueError, match="Invalid escape character"):
+            AgentfileParser().parse_content("# escape=!\nFROM python:3.11")
+
+    def test_plain_comment_is_not_a_directive(self):
+        """Test ordinary comments at the top are still comments."""
+        config = AgentfileParser().parse_content("# Agentfile for my agent\nFROM python:3.11")
+        assert not config.directives
+        assert config.escape_char == "\\"
+
+
+class TestFileFlags:
+    """Test suite for ADD and COPY flags."""
+
+    def test_flags_are_structured_and_emitted_first(self):
+        """Test flags are split from the paths and re-emitted before them."""
+        digest = "sha256:" + "a" * 64
+        content = f"""
+ADD https://example.com/data.tar.gz /app/data/ --checksum={digest}
+COPY --chown=1000:1000 --link config/ /app/config/
+"""
+        config = AgentfileParser().parse_content(content)
+        add, copy = config.dockerfile_instructions
+
+        assert add.flags == [f"--checksum={digest}"]
+        assert add.args == ["https://example.com/data.tar.gz", "/app/data/"]
+        assert add.flag("checksum") == digest
+        assert add.to_dockerfile_line() == f"ADD --checksum={digest} https://example.com/data.tar.gz /app/data/"
+        assert copy.flag("link") == ""
+        assert copy.sources == ["config/"]
+        assert copy.to_dockerfile_line() == "COPY --chown=1000:1000 --link config/ /app/config/"
+
+    def test_invalid_checksum(self):
+        """Test malformed checksums are rejected."""
+        with pytest.raises(ValueError, match="Invalid checksum"):
+            AgentfileParser().parse_content("ADD --checksum=md5:abc https://example.com/a /a")
+
+    def test_flag_not_valid_for_instruction(self):
+        """Test instruction-specific flags are enforced."""
+        with pytest.raises(ValueError, match="Unknown COPY flag '--checksum'"):
+            AgentfileParser().parse_content("COPY --checksum=sha256:" + "a" * 64 + " a /a")
+        with pytest.raises(ValueError, match="Unknown ADD flag '--from'"):
+            AgentfileParser().parse_content("ADD --from=builder /a /a")
+
+
+class TestBaseUrl:
+    """Test suite for per-agent BASE_URL overrides."""
+
+    def test_base_url_in_agent_and_secret_contexts(self):
+        """Test BASE_URL overrides an agent endpoint without breaking secret contexts."""
+        content = """
+SECRET OPENAI
+API_KEY sk-example
+BASE_URL https://api.openai.com/v1
+
+AGENT local
+MODEL openai/llama3
+BASE_URL http://localhost:11434/v1
+
+AGENT proxied base_url=$PROXY_BASE_URL
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.secrets[0].values["BASE_URL"] == "https://api.openai.com/v1"
+        assert config.agents["local"].base_url == "http://localhost:11434/v1"
+        assert config.agents["proxied"].base_url == "$PROXY_BASE_URL"
+
+    def test_invalid_base_url(self):
+        """Test BASE_URL must be a URL or a secret reference."""
+        with pytest.raises(ValueError, match="Invalid BASE_URL: localhost:11434"):
+            AgentfileParser().parse_content("AGENT local\nBASE_URL localhost:11434")
+
+
+class TestTemperature:
+    """Test suite for TEMPERATURE on agents and at the top level."""
+
+    CONTENT = """
+TEMPERATURE 0.3
+AGENT writer
+TEMPERATURE 0.9
+AGENT extractor temperature=0
+AGENT helper
+"""
+
+    def test_agents_inherit_the_file_temperature(self):
+        """Test agents keep their own TEMPERATURE, including 0, and the rest use the file's."""
+        config = AgentfileParser().parse_content(self.CONTENT)
+
+        assert config.default_temperature == 0.3
+        assert [config.agents[name].temperature for name in ["writer", "extractor", "helper"]] == [0.9, 0.0, None]
+        assert [config.model_settings_for(name) for name in ["writer", "extractor", "helper"]] == [
+            {"temperature": 0.9},
+            {"temperature": 0.0},
+            {"temperature": 0.3},
+        ]
+
+    def test_unset_is_left_out(self):
+        """Test no TEMPERATURE leaves the provider default in place."""
+        config = AgentfileParser().parse_content("AGENT helper\n")
+
+        assert config.default_temperature is None
+        assert config.model_settings_for("helper") == {}
+        assert "request_params" not in config.agents["helper"].to_decorator_string()
+
+    def test_invalid_temperature(self):
+        """Test values outside 0 to 2, and values that are not numbers, are rejected."""
+        for value, message in [("2.5", "between 0 and 2, got 2.5"), ("-0.1", "between 0 and 2"), ("hot", "Invalid")]:
+            with pytest.raises(AgentfileError, match=message) as error:
+                AgentfileParser().parse_content(f"AGENT helper\nTEMPERATURE {value}\n")
+            assert error.value.line == 2
+
+    def test_temperature_after_an_agent_block_warns(self):
+        """Test TEMPERATURE made top-level by a Dockerfile instruction warns, as MODEL does."""
+        parser = AgentfileParser()
+        config = parser.parse_content("AGENT helper\nRUN true\nTEMPERATURE 1\n")
+
+        assert config.default_temperature == 1.0
+        assert [d.code for d in parser.diagnostics] == ["implicit-end"]
+
+
+class TestMaxTokens:
+    """Test suite for MAX_TOKENS on agents and at the top level."""
+
+    def test_agents_inherit_the_file_limit(self):
+        """Test agents keep their own MAX_TOKENS and the rest use the file's, next to other settings."""
+        content = "MAX_TOKENS 1024\nAGENT writer max_tokens=4096\nTEMPERATURE 0.9\nAGENT helper\n"
+        config = AgentfileParser().parse_content(content)
+
+        assert config.default_max_tokens == 1024
+        assert config.model_settings_for("writer") == {"temperature": 0.9, "max_tokens": 4096}
+        assert config.model_settings_for("helper") == {"max_tokens": 1024}
+
+    def test_invalid_max_tokens(self):
+        """Test zero, negative, fractional and non-numeric limits are rejected."""
+        for value, message in [("0", "at least 1"), ("-5", "at least 1"), ("1.5", "Invalid"), ("lots", "Invalid")]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(f"AGENT helper\nMAX_TOKENS {value}\n")
+        with pytest.raises(AgentfileError, match="at least 1"):
+            AgentfileParser().parse_content("MAX_TOKENS 0\n")
+
+
+class TestSamplingSettings:
+    """Test suite for TOP_P, TOP_K and STOP."""
+
+    def test_settings_are_parsed(self):
+        """Test the settings parse in order, keeping quoted multi-word stop sequences whole."""
+        content = 'AGENT writer\nTOP_P 0.95\nTOP_K 40\nSTOP "###" "END OF ANSWER"\nAGENT helper\n'
+        config = AgentfileParser().parse_content(content)
+
+        assert config.model_settings_for("writer") == {"top_p": 0.95, "top_k": 40, "stop": ["###", "END OF ANSWER"]}
+        assert config.model_settings_for("helper") == {}
+        assert config.agents["helper"].stop is None
+
+    def test_array_form_and_default(self):
+        """Test STOP takes the JSON array form and, like the other settings, a top-level default."""
+        config = AgentfileParser().parse_content('STOP ["\\n\\n", "Human:"]\nTOP_K 5\nAGENT helper\n')
+
+        assert config.model_settings_for("helper") == {"top_k": 5, "stop": ["\n\n", "Human:"]}
+
+    def test_invalid_settings(self):
+        """Test TOP_P outside 0 to 1, TOP_K below 1 and empty stop sequences are rejected."""
+        for line, message in [
+            ("TOP_P 1.5", "TOP_P must be between 0 and 1, got 1.5"),
+            ("TOP_K 0", "TOP_K must be at least 1"),
+            ("TOP_K 2.5", "Invalid number for TOP_K"),
+            ('STOP "" "###"', "STOP sequences cannot be empty"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(f"AGENT helper\n{line}\n")
+
+
+class TestAgentTools:
+    """Test suite for TOOLS."""
+
+    CONTENT = """
+MCP_SERVER filesystem
+COMMAND npx
+MCP_SERVER fetch
+COMMAND uvx
+AGENT reader
+SERVERS filesystem fetch
+"""
+
+    def test_tools_are_grouped_by_server(self):
+        """Test TOOLS keeps its entries in order, wildcards included, and groups them by server for fast-agent."""
+        config = AgentfileParser().parse_content(
+            self.CONTENT + "TOOLS filesystem.read_file filesystem.list_* fetch.*\n"
+        )
+        agent = config.agents["reader"]
+
+        assert agent.tools == ["filesystem.read_file", "filesystem.list_*", "fetch.*"]
+        assert agent.tool_filters() == {"filesystem": ["read_file", "list_*"], "fetch": ["*"]}
+        assert 'tools={"filesystem": ["read_file", "list_*"], "fetch": ["*"]}' in agent.to_decorator_string()
+
+    def test_entries_need_a_server_and_a_tool(self):
+        """Test entries without exactly one dot between a server and a tool are rejected."""
+        for entry in ["read_file", "filesystem.", ".read_file", "a.b.c"]:
+            with pytest.raises(AgentfileError, match=f"Invalid TOOLS entry: {re.escape(entry)}"):
+                AgentfileParser().parse_content(self.CONTENT + f"TOOLS {entry}\n")
+
+    def test_server_must_be_one_of_the_agents(self):
+        """Test an entry naming a server the agent does not use is an error with a suggestion."""
+        message = "lists tool filesytem.read_file, but filesytem is not in its SERVERS; did you mean filesystem"
+        with pytest.raises(AgentfileError, match=message) as error:
+            AgentfileParser().parse_content(self.CONTENT + "TOOLS filesytem.read_file\n")
+
+        assert error.value.line == 6
+
+    def test_agno_warns(self):
+        """Test FRAMEWORK agno, which cannot filter tools, keeps TOOLS but warns."""
+        parser = AgentfileParser()
+        parser.parse_content("FRAMEWORK agno\n" + self.CONTENT + "TOOLS filesystem.read_file\n")
+
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("unsupported-tools", 7)]
+
+
+class TestAgentResources:
+    """Test suite for RESOURCES."""
+
+    CONTENT = "MCP_SERVER docs\nCOMMAND npx\nAGENT writer\nSERVERS docs\n"
+
+    def test_uris_are_kept_in_order(self):
+        """Test RESOURCES keeps its URIs, and known schemes raise no warning."""
+        parser = AgentfileParser()
+        config = parser.parse_content(self.CONTENT + "RESOURCES file:///app/docs/spec.md https://example.com/a,b\n")
+
+        assert config.agents["writer"].resources == ["file:///app/docs/spec.md", "https://example.com/a,b"]
+        assert not parser.diagnostics
+
+    def test_unknown_scheme_warns(self):
+        """Test a scheme only a server could serve is a warning, not an error."""
+        parser = AgentfileParser()
+        config = parser.parse_content(self.CONTENT + "RESOURCES repo://notes/today\n")
+
+        assert config.agents["writer"].resources == ["repo://notes/today"]
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("unknown-resource-scheme", 5)]
+
+    def test_invalid_uris_and_missing_servers(self):
+        """Test entries without a scheme are rejected, and so is an agent with no SERVERS to read them from."""
+        with pytest.raises(AgentfileError, match="Invalid RESOURCES URI: docs/spec.md"):
+            AgentfileParser().parse_content(self.CONTENT + "RESOURCES docs/spec.md\n")
+        with pytest.raises(AgentfileError, match="Agent helper lists RESOURCES but no SERVERS"):
+            AgentfileParser().parse_content("AGENT helper\nRESOURCES file:///app/spec.md\n")
+
+    def test_agno_warns(self):
+        """Test FRAMEWORK agno, which does not read MCP resources, warns."""
+        parser = AgentfileParser()
+        parser.parse_content("FRAMEWORK agno\n" + self.CONTENT + "RESOURCES file:///app/docs/spec.md\n")
+
+        assert [d.code for d in parser.diagnostics] == ["unsupported-resources"]
+
+
+class TestServerHeaders:
+    """Test suite for HEADER."""
+
+    REMOTE = "MCP_SERVER remote\nTRANSPORT http\nURL https://mcp.example.com/mcp\n"
+
+    def test_headers_reach_the_client_config(self):
+        """Test repeated HEADER lines keep their order, with secret references left for the container to fill in."""
+        content = self.REMOTE + 'HEADER Authorization "Bearer ${TOKEN}"\nHEADER X-Team agents\nEND\nSECRET TOKEN\n'
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert config.servers["remote"].to_config_dict()["headers"] == {
+            "Authorization": "Bearer ${TOKEN}",
+            "X-Team": "agents",
+        }
+        assert not parser.diagnostics
+
+    def test_build_args_are_expanded(self):
+        """Test a build arg in a header value is replaced like in other values."""
+        parser = AgentfileParser(build_args={"TEAM": "search"})
+        config = parser.parse_content(self.REMOTE + "HEADER X-Team ${TEAM}\n")
+
+        assert config.servers["remote"].headers == {"X-Team": "search"}
+
+    def test_invalid_headers(self):
+        """Test headers need a valid name and a value, and a server reached over HTTP."""
+        for content, message in [
+            (self.REMOTE + "HEADER X-Team\n", "HEADER requires a name and a value"),
+            (self.REMOTE + "HEADER X:Team agents\n", "Invalid HEADER name: X:Team"),
+            ("MCP_SERVER here\nCOMMAND uvx\nHEADER X-Team agents\n", "Server here sets HEADER, but TRANSPORT stdio"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(content)
+
+    def test_secret_values_stay_out_of_headers(self):
+        """Test a header holding a SECRET's value is rejected, and a reference no SECRET provides warns."""
+        with pytest.raises(AgentfileError, match=r"contains the value of SECRET TOKEN.*write \$\{TOKEN\} instead"):
+            AgentfileParser().parse_content(self.REMOTE + "HEADER Authorization Bearer s3cret\nSECRET TOKEN s3cret\n")
+
+        parser = AgentfileParser()
+        parser.parse_content(self.REMOTE + "HEADER Authorization 'Bearer ${TOKEN}'\n")
+        assert [d.code for d in parser.diagnostics] == ["undeclared-header-reference"]
+
+
+class TestServerAuth:
+    """Test suite for AUTH."""
+
+    REMOTE = "MCP_SERVER remote\nTRANSPORT http\nURL https://mcp.example.com/mcp\n"
+
+    def test_bearer_and_basic(self):
+        """Test both forms read a SECRET reference and become the server's Authorization header."""
+        config = AgentfileParser().parse_content(
+            self.REMOTE + "AUTH bearer $GITHUB_TOKEN\n"
+            "MCP_SERVER my-api\nTRANSPORT sse\nURL https://api.example.com/sse\nAUTH basic bot:${PASS}\n"
+            "SECRET GITHUB_TOKEN\nSECRET PASS\n"
+        )
+
+        assert config.servers["remote"].auth == ServerAuth(type="bearer", secret="GITHUB_TOKEN")
+        assert config.servers["my-api"].auth == ServerAuth(type="basic", secret="PASS", user="bot")
+        assert config.servers["remote"].to_config_dict()["headers"] == {"Authorization": "Bearer ${GITHUB_TOKEN}"}
+        assert config.servers["my-api"].to_config_dict()["headers"] == {"Authorization": "${AGENTMAN_AUTH_MY_API}"}
+
+    def test_invalid_auth(self):
+        """Test AUTH needs a known type and a secret reference rather than the credentials."""
+        for line, message in [
+            ("AUTH bearer\n", "AUTH requires a type and credentials"),
+            ("AUTH digest $TOKEN\n", "Invalid AUTH type: digest"),
+            ("AUTH basic $TOKEN\n", "AUTH basic requires user:\\$SECRET"),
+            ("AUTH bearer ghp_example\n", "takes a \\$SECRET reference, not the credentials themselves"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(self.REMOTE + line + "SECRET TOKEN\n")
+
+    def test_secret_must_be_declared(self):
+        """Test a reference to an undeclared secret names the server and the secret."""
+        with pytest.raises(AgentfileError, match="Server remote authenticates with SECRET GITHUB_TOKN, which is not"):
+            AgentfileParser().parse_content(self.REMOTE + "AUTH bearer $GITHUB_TOKN\nSECRET GITHUB_TOKEN\n")
+
+    def test_auth_needs_a_network_server_without_authorization_header(self):
+        """Test AUTH is rejected on stdio servers and next to HEADER Authorization."""
+        for content, message in [
+            ("MCP_SERVER here\nCOMMAND uvx\nAUTH bearer $TOKEN\n", "Server here sets AUTH, but TRANSPORT stdio"),
+            (self.REMOTE + "AUTH bearer $TOKEN\nHEADER Authorization x\n", "sets both AUTH and HEADER Authorization"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(content + "SECRET TOKEN\n")
+
+
+class TestTimeoutsAndRetries:
+    """Test suite for REQUEST_TIMEOUT and MAX_RETRIES."""
+
+    def test_agent_settings_and_defaults(self):
+        """Test agents set both or inherit them from the top level, with the timeout in seconds."""
+        content = """
+REQUEST_TIMEOUT 1m
+MAX_RETRIES 2
+AGENT writer
+REQUEST_TIMEOUT 2m30s
+MAX_RETRIES 0
+AGENT helper
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.model_settings_for("writer") == {"request_timeout": 150, "max_retries": 0}
+        assert config.model_settings_for("helper") == {"request_timeout": 60, "max_retries": 2}
+
+    def test_server_settings(self):
+        """Test servers take both, as sub-instructions or inline, and pass them to the client config."""
+        content = """
+MCP_SERVER fetch
+COMMAND uvx
+REQUEST_TIMEOUT 45s
+MAX_RETRIES 3
+END
+SERVER time command=uvx max_retries=1
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.servers["fetch"].to_config_dict() == {
+            "transport": "stdio",
+            "command": "uvx",
+            "read_timeout_seconds": 45,
+            "max_retries": 3,
+        }
+        assert config.servers["time"].max_retries == 1
+        assert config.default_request_timeout is None
+
+    def test_invalid_settings(self):
+        """Test timeouts under a second, bad durations, negative retries and retries over the cap are rejected."""
+        for line, message in [
+            ("REQUEST_TIMEOUT 0s", "REQUEST_TIMEOUT must be at least 1s, got 0s"),
+            ("REQUEST_TIMEOUT soon", "Invalid duration: soon"),
+            ("MAX_RETRIES -1", "MAX_RETRIES must be between 0 and 10, got -1"),
+            ("MAX_RETRIES 11", "MAX_RETRIES must be between 0 and 10, got 11"),
+            ("MAX_RETRIES few", "Invalid number for MAX_RETRIES"),
+        ]:
+            for block in ["AGENT helper", "MCP_SERVER fetch\nCOMMAND uvx"]:
+                with pytest.raises(AgentfileError, match=message):
+                    AgentfileParser().parse_content(f"{block}\n{line}\n")
+
+
+class TestServerStartupAndReadTimeouts:
+    """Test suite for STARTUP_TIMEOUT and READ_TIMEOUT."""
+
+    def test_timeouts_reach_the_client_config(self):
+        """Test both take durations, as sub-instructions or inline, and are left out when unset."""
+        content = """
+MCP_SERVER filesystem
+COMMAND npx
+STARTUP_TIMEOUT 1m
+READ_TIMEOUT 30s
+END
+SERVER remote transport=sse url=http://localhost:8080/sse read_timeout=2m
+SERVER time command=uvx
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.servers["filesystem"].to_config_dict() == {
+            "transport": "stdio",
+            "command": "npx",
+            "startup_timeout_seconds": 60,
+            "read_transport_sse_timeout_seconds": 30,
+        }
+        assert config.servers["remote"].read_timeout == 120
+        assert config.servers["time"].to_config_dict() == {"transport": "stdio", "command": "uvx"}
+
+    def test_invalid_timeouts(self):
+        """Test timeouts under a second and bad durations are rejected, and neither is allowed outside a server."""
+        for instruction in ["STARTUP_TIMEOUT", "READ_TIMEOUT"]:
+            for line, message in [
+                (f"{instruction} 0s", f"{instruction} must be at least 1s, got 0s"),
+                (f"{instruction} later", "Invalid duration: later"),
+                (f"{instruction}", f"{instruction} requires a duration"),
+            ]:
+                with pytest.raises(AgentfileError, match=message):
+                    AgentfileParser().parse_content(f"MCP_SERVER fetch\nCOMMAND uvx\n{line}\n")
+            with pytest.raises(AgentfileError, match=instruction):
+                AgentfileParser().parse_content(f"AGENT helper\n{instruction} 30s\n")
+
+
+class TestServerShorthand:
+    """Test suite for one-line server declarations."""
+
+    def test_launchers_and_urls(self):
+        """Test npx:, uvx:, sse: and http: expand into the command, arguments, transport and URL."""
+        content = """
+MCP_SERVER fetch npx:@modelcontextprotocol/server-fetch
+MCP_SERVER time uvx:mcp-server-time
+MCP_SERVER api sse:https://example.com/sse
+MCP_SERVER search http:https://mcp.example.com/mcp?team=agents
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.servers["fetch"].to_config_dict() == {
+            "transport": "stdio",
+            "command": "npx",
+            "args": ["-y", "@modelcontextprotocol/server-fetch"],
+        }
+        assert config.servers["fetch"].package.name == "@modelcontextprotocol/server-fetch"
+        assert (config.servers["time"].command, config.servers["time"].args) == ("uvx", ["mcp-server-time"])
+        assert config.servers["api"].to_config_dict() == {"transport": "sse", "url": "https://example.com/sse"}
+        assert config.servers["search"].url == "https://mcp.example.com/mcp?team=agents"
+
+    def test_later_settings_override(self):
+        """Test inline attributes and sub-instructions after the shorthand override what it set."""
+        content = """
+MCP_SERVER time uvx:mcp-server-time request_timeout=30s
+ARGS mcp-server-time --local-timezone UTC
+ENV TZ UTC
+"""
+        server = AgentfileParser().parse_content(content).servers["time"]
+
+        assert server.args == ["mcp-server-time", "--local-timezone", "UTC"]
+        assert server.request_timeout == 30
+        assert server.env == {"TZ": "UTC"}
+
+    def test_invalid_shorthand(self):
+        """Test an unknown scheme lists the supported ones, and a scheme needs a target."""
+        for line, message in [
+            ("MCP_SERVER fetch pipx:mcp-server-fetch", r"Unknown server shorthand pipx:\. Supported: npx, uvx, sse"),
+            ("MCP_SERVER fetch npx:", "Server shorthand npx: requires a package or URL"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(line + "\n")
+
+
+class TestImportMcp:
+    """Test suite for IMPORT_MCP and import_claude_config."""
+
+    CLAUDE_CONFIG = json.dumps(
+        {
+            "mcpServers": {
+                "filesystem": {"command": "npx", "args": ["-y", "@modelcontextprotocol/server-filesystem", "/data"]},
+                "github": {
+                    "command": "npx",
+                    "args": ["-y", "@modelcontextprotocol/server-github"],
+                    "env": {"GITHUB_PERSONAL_ACCESS_TOKEN": "${GITHUB_TOKEN}"},
+                },
+            }
+        }
+    )
+
+    MCP_JSON = json.dumps(
+        {
+            "inputs": [
+                {"type": "promptString", "id": "github-token", "password": True},
+                {"type": "promptString", "id": "api-token", "password": True},
+            ],
+            "servers": {
+                "github": {
+                    "type": "stdio",
+                    "command": "npx",
+                    "args": ["-y", "@modelcontextprotocol/server-github"],
+                    "env": {"GITHUB_PERSONAL_ACCESS_TOKEN": "${input:github-token}", "LOG_DIR": "${env:HOME}/logs"},
+                },
+                "docs": {
+                    "type": "sse",
+                    "url": "https://docs.example.com/sse",
+                    "headers": {"Authorization": "Bearer ${input:api-token}"},
+                },
+            },
+        }
+    )
+
+    def parser(self, **options):
+        files = {os.path.normpath("config/claude_desktop_config.json"): self.CLAUDE_CONFIG}
+
+        def resolver(path):
+            if path not in files:
+                raise FileNotFoundError(2, "No such file or directory")
+            return files[path]
+
+        return AgentfileParser(resolver=resolver, **options)
+
+    def test_servers_are_imported(self):
+        """Test each entry becomes a server with its name, command, arguments and environment."""
+        config = self.parser().parse_content("IMPORT_MCP config/claude_desktop_config.json\nAGENT a\nSERVERS github\n")
+
+        assert list(config.servers) == ["filesystem", "github"]
+        assert config.servers["filesystem"].package.name == "@modelcontextprotocol/server-filesystem"
+        assert config.servers["github"].to_config_dict() == {
+            "transport": "stdio",
+            "command": "npx",
+            "args": ["-y", "@modelcontextprotocol/server-github"],
+            "env": {"GITHUB_PERSONAL_ACCESS_TOKEN": "${GITHUB_TOKEN}"},
+        }
+        assert config.servers["github"].line == 1
+
+    def test_conflicts_need_allow_override(self):
+        """Test a name declared both ways is an error, unless overrides are allowed and the later one wins."""
+        before = "MCP_SERVER github\nCOMMAND /server/github-mcp-server\nIMPORT_MCP config/claude_desktop_config.json\n"
+        after = "IMPORT_MCP config/claude_desktop_config.json\nMCP_SERVER github\nCOMMAND /server/github-mcp-server\n"
+
+        with pytest.raises(AgentfileError, match="defines server github, which is already declared on line 1"):
+            self.parser().parse_content(before)
+        with pytest.raises(AgentfileError, match="MCP_SERVER github is already imported from .* on line 1"):
+            self.parser().parse_content(after)
+
+        assert self.parser(allow_override=True).parse_content(before).servers["github"].command == "npx"
+        overridden = self.parser(allow_override=True).parse_content(after).servers["github"]
+        assert (overridden.command, overridden.args, overridden.env) == ("/server/github-mcp-server", [], {})
+
+    def test_invalid_imports(self):
+        """Test a missing file, bad JSON and malformed entries are errors naming the file."""
+        for data, message in [
+            ("{", "invalid JSON"),
+            ("[]", "expected a JSON object with an mcpServers object"),
+            ('{"mcpServers": {"fetch": {"args": ["mcp-server-fetch"]}}}', "server fetch needs a command"),
+            ('{"mcpServers": {"fetch": {"command": "uvx", "args": "mcp-server-fetch"}}}', "args of server fetch"),
+            ('{"mcpServers": {"fetch": {"command": "uvx", "env": {"PORT": 80}}}}', "env of server fetch"),
+        ]:
+            self.CLAUDE_CONFIG = data
+            with pytest.raises(AgentfileError, match=message):
+                self.parser().parse_content("IMPORT_MCP config/claude_desktop_config.json\n")
+
+        with pytest.raises(AgentfileError, match="Cannot read IMPORT_MCP file missing.json"):
+            self.parser().parse_content("IMPORT_MCP missing.json\n")
+
+    def test_mcp_json_is_imported(self):
+        """Test a VS Code mcp.json is told apart by its servers, with its inputs turned into SECRETs."""
+        self.CLAUDE_CONFIG = self.MCP_JSON
+        parser = self.parser()
+        config = parser.parse_content("SECRET API_TOKEN\nIMPORT_MCP config/claude_desktop_config.json\n")
+
+        assert config.servers["github"].to_config_dict() == {
+            "transport": "stdio",
+            "command": "npx",
+            "args": ["-y", "@modelcontextprotocol/server-github"],
+            "env": {"GITHUB_PERSONAL_ACCESS_TOKEN": "${GITHUB_TOKEN}", "LOG_DIR": "${HOME}/logs"},
+        }
+        assert config.servers["docs"].to_config_dict() == {
+            "transport": "sse",
+            "url": "https://docs.example.com/sse",
+            "headers": {"Authorization": "Bearer ${API_TOKEN}"},
+        }
+        assert config.secret_names() == ["API_TOKEN", "GITHUB_TOKEN"]
+        assert not parser.diagnostics
+
+    def test_invalid_mcp_json(self):
+        """Test unknown types, inputs without an id and references to undeclared inputs are errors."""
+        for data, message in [
+            ({"servers": {"docs": {"type": "websocket", "url": "wss://x"}}}, "server docs has unsupported type"),
+            ({"servers": {}, "inputs": [{"type": "promptString"}]}, "every input needs an id"),
+            ({"servers": {"docs": {"url": "https://x/${input:key}"}}}, r"uses \$\{input:key\}, which is not in"),
+            ({"servers": {"docs": {"command": "uvx", "env": {"N": 1}}}}, "env of server docs must map names"),
+        ]:
+            with pytest.raises(ValueError, match=message):
+                import_mcp_json(data)
+
+    def test_library_function(self):
+        """Test import_claude_config reads the parsed JSON without an Agentfile."""
+        servers = import_claude_config(json.loads(self.CLAUDE_CONFIG))
+
+        assert servers["filesystem"] == MCPServer(
+            name="filesystem", command="npx", args=["-y", "@modelcontextprotocol/server-filesystem", "/data"]
+        )
+
+
+class TestServerCwd:
+    """Test suite for CWD."""
+
+    def test_cwd_reaches_the_client_config(self):
+        """Test CWD sets the directory a stdio server is started in, as a sub-instruction or inline."""
+        content = 'MCP_SERVER fetch\nCOMMAND uvx\nCWD "/app/tools/my fetcher"\nMCP_SERVER time command=uvx cwd=/srv\n'
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert config.servers["fetch"].to_config_dict()["cwd"] == "/app/tools/my fetcher"
+        assert config.servers["time"].cwd == "/srv"
+        assert not parser.diagnostics
+
+    def test_cwd_must_be_absolute(self):
+        """Test a relative or missing directory is rejected."""
+        for line, message in [
+            ("CWD tools/fetcher", "CWD must be an absolute path in the image, got tools/fetcher"),
+            ("CWD", "CWD requires a directory"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(f"MCP_SERVER fetch\nCOMMAND uvx\n{line}\n")
+
+    def test_cwd_on_remote_server_warns(self):
+        """Test CWD on a server reached over the network warns that it is ignored."""
+        parser = AgentfileParser()
+        parser.parse_content("MCP_SERVER remote\nTRANSPORT sse\nURL http://localhost:8080/sse\nCWD /app\n")
+
+        assert [d.code for d in parser.diagnostics] == ["server-cwd-ignored"]
+
+
+class TestImageEnv:
+    """Test suite for the structured image environment."""
+
+    def test_pairs_and_legacy_form(self):
+        """Test several pairs per line, quoting and the legacy KEY value form."""
+        content = """
+ENV LOG_LEVEL=info GREETING="hello world"
+ENV DATA_DIR /app/data dir
+MCP_SERVER fetch
+COMMAND uvx
+ENV SERVER_ONLY=1
+"""
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert config.image_env == {"LOG_LEVEL": "info", "GREETING": "hello world", "DATA_DIR": "/app/data dir"}
+        assert [i.to_dockerfile_line() for i in config.dockerfile_instructions] == [
+            'ENV LOG_LEVEL=info GREETING="hello world"',
+            "ENV DATA_DIR /app/data dir",
+        ]
+        assert config.servers["fetch"].env == {"SERVER_ONLY": "1"}
+        assert not parser.diagnostics
+
+    def test_redefinition_warns_and_last_wins(self):
+        """Test conflicting redefinitions keep the last value like Docker does."""
+        parser = AgentfileParser()
+        config = parser.parse_content("ENV MODE=dev\nENV MODE=dev OTHER=1\nENV MODE prod")
+
+        assert config.image_env == {"MODE": "prod", "OTHER": "1"}
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("env-redefined", 3)]
+
+    def test_mixed_pair_forms_rejected(self):
+        """Test a bare word after KEY=VALUE pairs is rejected."""
+        with pytest.raises(ValueError, match="needs KEY=VALUE pairs, got 'B'"):
+            AgentfileParser().parse_content("ENV A=1 B")
+
+
+class TestVolumes:
+    """Test suite for VOLUME."""
+
+    def test_volumes_are_tracked_and_passed_through(self):
+        """Test both VOLUME forms are recorded in order and stay in place as Dockerfile instructions."""
+        content = 'VOLUME /app/data "/app/my cache"\nRUN true\nVOLUME ["/app/logs", "/app/data"]\n'
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert config.volumes == ["/app/data", "/app/my cache", "/app/logs"]
+        assert [inst.instruction for inst in config.dockerfile_instructions] == ["VOLUME", "RUN", "VOLUME"]
+        assert [(d.code, d.message, d.line) for d in parser.diagnostics] == [
+            ("volume-duplicate", "Volume /app/data is already declared", 3)
+        ]
+
+    def test_volumes_of_other_stages(self):
+        """Test only the agent stage's volumes are recorded."""
+        config = AgentfileParser().parse_content("FROM node:20 AS builder\nVOLUME /cache\nFROM python:3.11\n")
+        assert config.volumes == []
+
+        content = "FROM python:3.11 AS agent\nVOLUME /data\nFROM busybox AS tools\nVOLUME /tools\n"
+        assert AgentfileParser().parse_content(content).volumes == ["/data"]
+
+
+class TestLabels:
+    """Test suite for LABEL."""
+
+    def test_pairs_keep_their_quotes(self):
+        """Test every key=value pair is recorded with its value as written, a later LABEL replacing a key."""
+        content = 'LABEL maintainer="Jane Doe <jane@example.com>" "com.example.tier"=gold version=$VERSION\n'
+        config = AgentfileParser().parse_content(content + "LABEL version=2\n")
+
+        assert config.labels == {
+            "maintainer": '"Jane Doe <jane@example.com>"',
+            "com.example.tier": "gold",
+            "version": "2",
+        }
+        assert [inst.instruction for inst in config.dockerfile_instructions] == ["LABEL", "LABEL"]
+
+    def test_invalid_labels(self):
+        """Test a LABEL without pairs, or with a word that is not one, is an error."""
+        for line, message in [
+            ("LABEL", "LABEL requires at least one key=value pair"),
+            ("LABEL maintainer Jane", "LABEL needs key=value pairs, got maintainer"),
+            ("LABEL =gold", "LABEL needs key=value pairs, got =gold"),
+        ]:
+            with pytest.raises(AgentfileError, match=message):
+                AgentfileParser().parse_content(line + "\n")
+
+    def test_labels_of_other_stages(self):
+        """Test only the agent stage's labels are recorded, and the digest is of the whole Agentfile."""
+        content = "FROM node:20 AS builder\nLABEL stage=build\nFROM python:3.11\nLABEL stage=agent\n"
+        config = AgentfileParser().parse_content(content)
+
+        assert config.labels == {"stage": "agent"}
+        assert config.source_sha256 == hashlib.sha256(content.encode("utf-8")).hexdigest()
+
+
+class TestHealthcheck:
+    """Test suite for HEALTHCHECK."""
+
+    def test_forms_and_flags(self):
+        """Test the flags become seconds and a count, and each form renders back as a Dockerfile line."""
+        for line, expected, rendered in [
+            ("HEALTHCHECK NONE", Healthcheck(), "HEALTHCHECK NONE"),
+            (
+                'HEALTHCHECK --interval=1m --timeout=5s --start-period=1m30s --retries=3 CMD ["curl", "-f", "x"]',
+                Healthcheck(command=["curl", "-f", "x"], interval=60, timeout=5, start_period=90, retries=3),
+                'HEALTHCHECK --interval=60s --timeout=5s --start-period=90s --retries=3 CMD ["curl", "-f", "x"]',
+            ),
+            (
+                "HEALTHCHECK CMD curl -f http://localhost/ || exit 1",
+                Healthcheck(command=["curl -f http://localhost/ || exit 1"], shell=True),
+                "HEALTHCHECK CMD curl -f http://localhost/ || exit 1",
+            ),
+        ]:
+            config = AgentfileParser().parse_content(line + "\n")
+            assert config.healthcheck == expected, line
+            assert config.healthcheck.to_dockerfile_line() == rendered
+            assert [inst.instruction for inst in config.dockerfile_instructions] == ["HEALTHCHECK"]
+
+    def test_invalid_healthcheck(self):
+        """Test unknown flags, flags without values, bad values and a missing CMD are errors."""
+        for line, message in [
+            ("HEALTHCHECK --every=5s CMD true", "Unknown HEALTHCHECK flag '--every'. Valid flags: interval, timeout"),
+            ("HEALTHCHECK --retries CMD true", "HEALTHCHECK --retries needs a value"),
+            ("HEALTHCHECK --retries=three CMD true", "HEALTHCHECK --retries must be a whole number, got three"),
+            ("HEALTHCHECK --interval=soon CMD true", "Invalid duration: soon"),
+            ("HEALTHCHECK --interval=5s", "HEALTHCHECK takes NONE, or optional flags followed by CMD and a command"),
+            ("HEALTHCHECK curl localhost", "HEALTHCHECK takes NONE, or optional flags followed by CMD and a command"),
+        ]:
+            with pytest.raises(AgentfileError, match=re.escape(message)):
+                AgentfileParser().parse_content(line + "\n")
+
+    def test_serve_adds_a_healthcheck(self):
+        """Test SERVE gives the image a HEALTHCHECK of its server unless the Agentfile sets one."""
+        config = AgentfileParser().parse_content("FRAMEWORK agno\nSERVE http 8000\nAGENT a\n")
+        assert config.healthcheck is None
+        assert "urlopen('http://localhost:8000/health', timeout=5)" in config.image_healthcheck().command[-1]
+
+        config = AgentfileParser().parse_content("SERVE mcp 8080\nAGENT a\n")
+        assert "create_connection(('localhost', 8080), timeout=5)" in config.image_healthcheck().command[-1]
+
+        config = AgentfileParser().parse_content("SERVE mcp 8080\nHEALTHCHECK NONE\nAGENT a\n")
+        assert config.image_healthcheck() == Healthcheck()
+        assert AgentfileParser().parse_content("AGENT a\n").image_healthcheck() is None
+
+
+class TestParseWarnings:
+    """Test suite for non-fatal warnings collected while parsing."""
+
+    def test_suspicious_definitions_warn_and_parse(self):
+        """Test each suspicious construct is reported with its line and the configuration is still built."""
+        content = """EXPOSE 8080
+ENV LOG_LEVEL = debug
+SECRET OPENAI_API_KEY sk-plaintext
+SECRET ANTHROPIC_API_KEY $ANTHROPIC_KEY
+EXPOSE 8080
+MCP_SERVER search
+COMMAND npx
+TRANSPORT http
+URL https://mcp.example.com/mcp
+"""
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert [(d.code, d.line, d.column) for d in parser.diagnostics] == [
+            ("env-malformed", 2, 5),
+            ("secret-inline-value", 3, 23),
+            ("expose-duplicate", 5, 8),
+            ("server-command-and-url", 6, None),
+        ]
+        assert parser.diagnostics[0].message == "ENV LOG_LEVEL value starts with '='; write ENV LOG_LEVEL=debug"
+        assert config.expose_ports == [8080]
+        assert config.image_env["LOG_LEVEL"] == "= debug"
+
+    def test_server_env_name_is_checked(self):
+        """Test server ENV names that are not variable names are flagged."""
+        parser = AgentfileParser()
+        parser.parse_content("MCP_SERVER fetch\nCOMMAND uvx\nENV MY-TOKEN=abc")
+
+        assert [(d.code, d.message) for d in parser.diagnostics] == [
+            ("env-malformed", "ENV name 'MY-TOKEN' is not a valid variable name")
+        ]
+
+
+    def test_server_alias_is_deprecated(self):
+        """Test each SERVER declaration warns with its line and name, and the spelling is recorded."""
+        parser = AgentfileParser()
+        config = parser.parse_content("MCP_SERVER fetch\nCOMMAND uvx\n\nserver git\nCOMMAND uvx")
+
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("server-alias", 4)]
+        assert parser.diagnostics[0].message.startswith("SERVER git uses the deprecated SERVER keyword")
+        assert [server.keyword for server in config.servers.values()] == ["MCP_SERVER", "SERVER"]
+
+    def test_server_alias_can_be_rejected(self):
+        """Test the alias is an error when the parser is told not to allow it."""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser(allow_server_alias=False).parse_content("AGENT a\nSERVER git\n")
+        assert (error.value.line, error.value.instruction) == (2, "SERVER")
+        assert "declare MCP_SERVER git" in error.value.message
+
+
+class TestEnd:
+    """Test suite for closing blocks with END."""
+
+    def test_end_makes_following_env_top_level(self):
+        """Test ENV after END is a Dockerfile ENV rather than a server variable."""
+        content = "MCP_SERVER fetch\nCOMMAND uvx\nENV TOKEN=abc\nEND\nENV LOG_LEVEL=debug\n"
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert config.servers["fetch"].env == {"TOKEN": "abc"}
+        assert config.image_env == {"LOG_LEVEL": "debug"}
+        assert not parser.diagnostics
+
+    def test_end_closes_agent_blocks(self):
+        """Test MODEL after END sets the default model instead of the agent's."""
+        config = AgentfileParser().parse_content("AGENT a\nMODEL openai/gpt-4o\nEND\nMODEL anthropic/claude-3-haiku")
+
+        assert config.agents["a"].model == "openai/gpt-4o"
+        assert config.default_model == "anthropic/claude-3-haiku"
+
+    def test_model_after_a_server_block_is_the_default(self):
+        """Test interleaved AGENT, MODEL, MCP_SERVER and MODEL set both the agent and the default model."""
+        content = """
+AGENT coder
+MODEL openai/gpt-4o
+
+MCP_SERVER fetch
+COMMAND uvx
+MODEL anthropic/claude-3-haiku
+"""
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert config.agents["coder"].model == "openai/gpt-4o"
+        assert config.default_model == "anthropic/claude-3-haiku"
+        assert "fetch" in config.servers
+        assert not parser.diagnostics
+
+    def test_blank_lines_do_not_close_blocks(self):
+        """Test a blank line inside a block keeps it open, so only END or a declaration closes it."""
+        config = AgentfileParser().parse_content("AGENT a\n\nMODEL openai/gpt-4o\n\nEND\n\nMODEL openai/o3-mini\n")
+
+        assert config.agents["a"].model == "openai/gpt-4o"
+        assert config.default_model == "openai/o3-mini"
+
+    def test_implicitly_closed_block_warns(self):
+        """Test ENV after a block another instruction closed is flagged once, naming the block."""
+        content = "MCP_SERVER fetch\nCOMMAND uvx\nRUN echo hi\nENV A=1\nENV B=2\n"
+        parser = AgentfileParser()
+        config = parser.parse_content(content)
+
+        assert config.image_env == {"A": "1", "B": "2"}
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("implicit-end", 4)]
+        assert parser.diagnostics[0].message.startswith(
+            "ENV is top-level because RUN on line 3 closed the MCP_SERVER fetch block"
+        )
+
+    def test_unrelated_blocks_do_not_warn(self):
+        """Test only instructions the closed block could have taken are flagged."""
+        parser = AgentfileParser()
+        parser.parse_content("SECRET GITHUB_TOKEN\nRUN echo hi\nENV A=1\nAGENT a\nEXPOSE 80\nENV B=2\n")
+
+        assert not parser.diagnostics
+
+    def test_end_outside_a_block_is_an_error(self):
+        """Test END needs an open block and takes no arguments."""
+        with pytest.raises(ValueError, match="END without an open MCP_SERVER"):
+            AgentfileParser().parse_content("FROM python:3.11\nEND")
+        with pytest.raises(ValueError, match="END takes no arguments"):
+            AgentfileParser().parse_content("AGENT a\nEND a")
+
+
+class TestSubInstructionContext:
+    """Test suite for sub-instructions the open block does not accept."""
+
+    def test_wrong_block_lists_valid_sub_instructions(self):
+        """Test a known sub-instruction in the wrong block is an error naming the block and what it accepts."""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content("CHAIN pipeline\nSEQUENCE a b\nAGENTS a b\n")
+
+        assert (error.value.line, error.value.column, error.value.instruction) == (3, 1, "AGENTS")
+        assert error.value.message == (
+            "CHAIN pipeline does not support AGENTS; valid: SEQUENCE, MODEL, INSTRUCTION, INSTRUCTION_FILE, "
+            "CUMULATIVE, CONTINUE_WITH_FINAL, DEFAULT"
+        )
+
+    def test_server_settings_are_rejected_on_agents(self):
+        """Test settings that used to be dropped silently now fail."""
+        match = "AGENT helper does not support ARGS; valid: INSTRUCTION, INSTRUCTION_FILE"
+        with pytest.raises(ValueError, match=match):
+            AgentfileParser().parse_content("AGENT helper\nARGS --verbose\n")
+
+    def test_misspelt_sub_instruction_warns(self):
+        """Test an unknown keyword inside a block warns, and stays a Dockerfile instruction as before."""
+        parser = AgentfileParser()
+        config = parser.parse_content("AGENT helper\nMODLE openai/gpt-4o\n")
+
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("unknown-sub-instruction", 2)]
+        assert parser.diagnostics[0].message.startswith("AGENT helper does not support MODLE; valid: INSTRUCTION")
+        assert config.agents["helper"].model is None
+        assert config.dockerfile_instructions[-1].instruction == "MODLE"
+
+    def test_misspelt_sub_instruction_fails_strict_parsers(self):
+        """Test strict parsers turn the warning into an error on the misspelt line."""
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser(strict=True).parse_content("MCP_SERVER fetch\nCOMMAND uvx\nCOMAND uvx\n")
+        assert error.value.line == 3
+        assert "[unknown-sub-instruction]" in error.value.message
+
+    def test_unknown_top_level_instruction_does_not_warn(self):
+        """Test keywords outside a block keep passing through without a warning."""
+        parser = AgentfileParser()
+        parser.parse_content("AGENT a\nEND\nUNKNOWN INSTRUCTION args\n")
+
+        assert not parser.diagnostics
+
+
+class TestQuotedEscapes:
+    """Test suite for backslash escapes inside quoted values."""
+
+    def test_escapes_are_unescaped(self):
+        """Test quotes, backslashes, newlines and tabs in a quoted INSTRUCTION and server ENV."""
+        content = r"""
+MCP_SERVER fetch
+COMMAND uvx
+ENV GREETING="Say \"hi\""
+AGENT api
+INSTRUCTION "Reply with {\"ok\": true}\nIndent with\t'tabs' and keep C:\\data"
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.agents["api"].instruction == "Reply with {\"ok\": true}\nIndent with\t'tabs' and keep C:\\data"
+        assert config.servers["fetch"].env == {"GREETING": 'Say "hi"'}
+        assert AgentfileParser().parse_content("AGENT a\nINSTRUCTION 'it\\'s'").agents["a"].instruction == "it's"
+
+    def test_escaped_quote_outside_quotes(self):
+        """Test an escaped quote in an unquoted value is a literal quote that opens nothing."""
+        content = r"""
+AGENT a
+INSTRUCTION If it does, return \"Done.\" \
+            Otherwise, return \"Not yet.\"
+SERVERS fetch
+MCP_SERVER fetch
+COMMAND uvx
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.agents["a"].instruction == 'If it does, return "Done." Otherwise, return "Not yet."'
+        assert list(config.servers) == ["fetch"]
+
+    def test_invalid_escape_is_an_error(self):
+        """Test an unknown escape names the sequence and the line instead of dropping the backslash."""
+        with pytest.raises(AgentfileError, match=r"line 2: .*\n.*Invalid escape sequence \\d"):
+            AgentfileParser().parse_content('AGENT a\nINSTRUCTION "C:\\data"')
+
+    def test_raw_values_keep_backslashes(self):
+        """Test unquoted words, JSON arrays, triple quotes and Dockerfile instructions are left alone."""
+        content = r'''
+RUN echo "cost: \$5"
+MCP_SERVER fs
+COMMAND npx
+ARGS ["--root", "C:\\data", "say \"hi\""]
+AGENT a
+INSTRUCTION Use C:\data as is
+AGENT b
+INSTRUCTION """Keep \n literal"""
+'''
+        config = AgentfileParser().parse_content(content)
+
+        assert config.dockerfile_instructions[0].passthrough_text() == r'RUN echo "cost: \$5"'
+        assert config.servers["fs"].args == ["--root", "C:\\data", 'say "hi"']
+        assert config.agents["a"].instruction == r"Use C:\data as is"
+        assert config.agents["b"].instruction == r"Keep \n literal"
+
+    def test_escape_directive_changes_the_escape(self):
+        """Test backticks escape quoted values under escape=`, and backslashes are literal."""
+        config = AgentfileParser().parse_content('# escape=`\nAGENT a\nINSTRUCTION "C:\\data `"x`"`n"')
+
+        assert config.agents["a"].instruction == 'C:\\data "x"\n'
+
+
+class TestQuotedContinuation:
+    """Test suite for quoted values that span lines."""
+
+    def test_instruction_spans_three_lines(self):
+        """Test an open double quote keeps reading lines, newlines, blank lines and # included."""
+        content = """
+AGENT writer
+INSTRUCTION "You write release notes.
+  # Group changes by area
+
+Keep them short."
+SERVERS fetch
+MCP_SERVER fetch
+COMMAND uvx
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.agents["writer"].instruction == (
+            "You write release notes.\n  # Group changes by area\n\nKeep them short."
+        )
+        assert config.agents["writer"].servers == ["fetch"]
+        assert config.servers["fetch"].command == "uvx"
+
+    def test_trailing_backslash_inside_quotes_is_not_a_continuation(self):
+        """Test a Windows path ending in a backslash inside quotes stays part of the value."""
+        content = r'''AGENT saver
+INSTRUCTION "Save reports to C:\\reports\\
+and nowhere else"
+MODEL gpt-4o
+'''
+        config = AgentfileParser().parse_content(content)
+
+        assert config.agents["saver"].instruction == "Save reports to C:\\reports\\\nand nowhere else"
+        assert config.agents["saver"].model == "gpt-4o"
+
+    def test_apostrophes_and_dockerfile_quotes_do_not_span_lines(self):
+        """Test a quote inside a word is literal, and RUN keeps Docker continuation inside quotes."""
+        content = 'AGENT a\nINSTRUCTION Don\'t stop\nRUN echo "one \\\n  two"\nAGENT b\n'
+        config = AgentfileParser().parse_content(content)
+
+        assert config.agents["a"].instruction == "Don't stop"
+        assert config.dockerfile_instructions[0].passthrough_text() == 'RUN echo "one \\\n  two"'
+        assert list(config.agents) == ["a", "b"]
+
+    def test_unterminated_quote(self):
+        """Test a quote that never closes names the line and column it opened on."""
+        with pytest.raises(AgentfileError, match="Unterminated quoted string starting on line 2") as error:
+            AgentfileParser().parse_content('AGENT a\nINSTRUCTION Say "hi\nAGENT b\n')
+
+        assert error.value.column == 17
+
+
+class TestLongLines:
+    """Test suite for very long physical lines."""
+
+    PROMPT = "Answer in full sentences. " * 100_000  # About 2.6 MB on one line
+
+    def test_multi_megabyte_instruction(self):
+        """Test a pasted multi-megabyte prompt parses, plain and quoted, without holding many copies of it."""
+        tracemalloc.start()
+        try:
+            quoted = AgentfileParser().parse_content(f'AGENT a\nINSTRUCTION "{self.PROMPT.strip()}"\nMODEL gpt-4o\n')
+            peak = tracemalloc.get_traced_memory()[1]
+        finally:
+            tracemalloc.stop()
+        plain = AgentfileParser().parse_content(f"AGENT a\nINSTRUCTION {self.PROMPT}\nMODEL gpt-4o\n")
+
+        assert quoted.agents["a"].instruction == plain.agents["a"].instruction == self.PROMPT.strip()
+        assert quoted.agents["a"].model == "gpt-4o"
+        assert peak < 10 * len(self.PROMPT)
+
+    def test_max_line_size(self):
+        """Test a line over max_line_size is an error naming it, without printing the whole line."""
+        content = f"AGENT a\nINSTRUCTION {self.PROMPT}\n"
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser(max_line_size=1_000_000).parse_content(content)
+
+        assert error.value.line == 2
+        length = len(content.splitlines()[1])
+        assert f"Line 2 is {length} characters long, over the limit of 1000000" in str(error.value)
+        assert len(str(error.value)) < 200
+        assert AgentfileParser(max_line_size=3_000_000).parse_content(content).agents["a"].instruction
+
+
+class TestBuildStages:
+    """Test suite for multi-stage FROM ... AS."""
+
+    def test_stages_and_agent_stage(self):
+        """Test each FROM starts a stage and instructions record the stage they belong to."""
+        content = 'FROM node:20 AS Builder\nRUN npm ci\nCMD ["node"]\nENV STAGE=build\nFROM python:3.11\nRUN true\n'
+        config = AgentfileParser().parse_content(content)
+
+        assert [(stage.image, stage.name, stage.line) for stage in config.stages] == [
+            ("node:20", "builder", 1),
+            ("python:3.11", None, 5),
+        ]
+        assert [inst.stage for inst in config.dockerfile_instructions] == [0, 0, 0, 0, 1, 1]
+        assert [inst.instruction for inst in config.agent_instructions] == ["FROM", "RUN"]
+        assert config.agent_stage == 1
+        assert config.base_image == "python:3.11"
+        assert config.cmd == ["python", "agent.py"]
+        assert config.image_env == {}
+
+    def test_invalid_stages(self):
+        """Test a malformed AS clause and a repeated stage name are errors."""
+        with pytest.raises(AgentfileError, match="FROM takes an image and an optional AS name"):
+            AgentfileParser().parse_content("FROM node:20 builder\n")
+        with pytest.raises(AgentfileError, match="Build stage builder is already declared on line 1"):
+            AgentfileParser().parse_content("FROM node:20 AS builder\nFROM node:22 AS builder\n")
+
+
+class TestInlineComments:
+    """Test suite for trailing comments on Agentman instructions."""
+
+    def test_comments_after_values_are_dropped(self):
+        """Test comments after a transport, an ARGS list and a boolean are not arguments."""
+        content = """
+MCP_SERVER remote
+TRANSPORT sse   # remote server
+URL https://mcp.example.com/sse#events
+MCP_SERVER fetch
+COMMAND uvx
+ARGS mcp-server-fetch --verbose  # log every request
+AGENT helper
+USE_HISTORY false # keep turns independent
+SERVERS fetch remote
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert config.servers["remote"].transport == "sse"
+        assert config.servers["remote"].url == "https://mcp.example.com/sse#events"
+        assert config.servers["fetch"].args == ["mcp-server-fetch", "--verbose"]
+        assert config.agents["helper"].use_history is False
+
+    def test_quoted_hash_is_kept(self):
+        """Test a # inside quotes is part of the value."""
+        content = 'AGENT helper\nINSTRUCTION "Post updates to #channel" # not this\n'
+        config = AgentfileParser().parse_content(content)
+
+        assert config.agents["helper"].instruction == "Post updates to #channel"
+
+    def test_comment_line_inside_continuation(self):
+        """Test a whitespace-indented comment line in a continued instruction is skipped."""
+        content = (
+            "MCP_SERVER fetch\nCOMMAND npx\nARGS -y \\\n    # pinned below\n    @modelcontextprotocol/server-fetch\n"
+        )
+        config = AgentfileParser().parse_content(content)
+
+        assert config.servers["fetch"].args == ["-y", "@modelcontextprotocol/server-fetch"]
+
+    def test_dockerfile_instructions_keep_hash(self):
+        """Test Dockerfile instructions are passed through with their # untouched."""
+        config = AgentfileParser().parse_content("RUN echo hi # shell comment\n")
+
+        assert config.dockerfile_instructions[0].to_dockerfile_line() == "RUN echo hi # shell comment"
+
+
+class TestShutdownGrace:
+    """Test suite for the SHUTDOWN_GRACE instruction."""
+
+    def test_durations(self):
+        """Test durations are converted to seconds."""
+        parser = AgentfileParser()
+        assert parser.parse_content("AGENT a").shutdown_grace == 10
+        assert AgentfileParser().parse_content("SHUTDOWN_GRACE 20s").shutdown_grace == 20
+        assert AgentfileParser().parse_content("SHUTDOWN_GRACE 1m30s").shutdown_grace == 90
+        assert AgentfileParser().parse_content("SHUTDOWN_GRACE 1h").shutdown_grace == 3600
+
+    def test_invalid_duration(self):
+        """Test values that are not durations are rejected."""
+        for value in ["20", "soon", "1s1m"]:
+            with pytest.raises(ValueError, match="Invalid duration"):
+                AgentfileParser().parse_content(f"SHUTDOWN_GRACE {value}")
+
+
+class TestCmdMode:
+    """Test suite for CMD conflicts with the generated agent."""
+
+    def test_custom_cmd_with_agents_warns(self):
+        """Test a CMD that skips agent.py is reported unless CMD_MODE acknowledges it."""
+        parser = AgentfileParser()
+        config = parser.parse_content('AGENT helper\nCMD ["python", "my_custom.py"]')
+
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("cmd-conflict", 2)]
+        assert "CMD_MODE append" in parser.diagnostics[0].message
+        assert config.resolved_cmd_mode == "override"
+
+        parser = AgentfileParser()
+        parser.parse_content('CMD_MODE override\nAGENT helper\nCMD ["python", "my_custom.py"]')
+        assert not parser.diagnostics
+
+    def test_generated_cmd_does_not_warn(self):
+        """Test CMD lines that still run agent.py are not conflicts."""
+        parser = AgentfileParser()
+        config = parser.parse_content('AGENT helper\nCMD ["python", "agent.py"]')
+
+        assert not parser.diagnostics
+        assert config.resolved_cmd_mode == "generated"
+
+    def test_invalid_modes(self):
+        """Test unknown modes and append without a custom CMD are rejected."""
+        with pytest.raises(ValueError, match="Unsupported CMD_MODE: merge"):
+            AgentfileParser().parse_content("CMD_MODE merge")
+        with pytest.raises(ValueError, match="CMD_MODE append needs a CMD"):
+            AgentfileParser().parse_content("CMD_MODE append\nAGENT helper")
+
+
+class TestEntrypoint:
+    """Test suite for ENTRYPOINT."""
+
+    def test_forms(self):
+        """Test both forms become the argument list, keeping arguments with spaces whole."""
+        for line, expected in [
+            ('ENTRYPOINT ["./entrypoint.sh"]', ["./entrypoint.sh"]),
+            ('ENTRYPOINT ["./run.sh", "--log dir", "/app/my logs"]', ["./run.sh", "--log dir", "/app/my logs"]),
+            ('ENTRYPOINT ./run.sh "/app/my logs"', ["./run.sh", "/app/my logs"]),
+        ]:
+            config = AgentfileParser().parse_content(line + "\nAGENT a\n")
+            assert config.entrypoint == expected, line
+            assert config.dockerfile_instructions[0].to_dockerfile_line() == f"ENTRYPOINT {json.dumps(expected)}"
+
+        with pytest.raises(AgentfileError, match="ENTRYPOINT requires at least one argument"):
+            AgentfileParser().parse_content("ENTRYPOINT\n")
+
+    def test_entrypoint_of_other_stages(self):
+        """Test an earlier build stage's ENTRYPOINT is not the agent image's."""
+        config = AgentfileParser().parse_content('FROM node:20 AS builder\nENTRYPOINT ["node"]\nFROM python:3.11\n')
+        assert config.entrypoint == []
+
+    def test_both_shell_forms_warn(self):
+        """Test ENTRYPOINT and CMD both in shell form warn at the ENTRYPOINT; one exec form is enough not to."""
+        parser = AgentfileParser()
+        parser.parse_content("AGENT a\nENTRYPOINT ./entrypoint.sh\nCMD python agent.py\n")
+        assert [(d.code, d.line) for d in parser.diagnostics] == [("shell-form-entrypoint", 2)]
+
+        for content in [
+            'ENTRYPOINT ["./entrypoint.sh"]\nCMD python agent.py\n',
+            'ENTRYPOINT ./entrypoint.sh\nCMD ["python", "agent.py"]\n',
+            "FROM node:20 AS builder\nENTRYPOINT node\nCMD build.js\nFROM python:3.11\nCMD python agent.py\n",
+        ]:
+            parser = AgentfileParser()
+            parser.parse_content(content + "AGENT a\n")
+            assert not parser.diagnostics, content
+
+
+class TestUnsetValues:
+    """Test suite for unset versus explicitly set workflow settings."""
+
+    def test_plan_iterations_matrix(self):
+        """Test PLAN_ITERATIONS is omitted when unset and emitted whenever set."""
+        config = AgentfileParser().parse_content(
+            "AGENT a\nORCHESTRATOR unset\nAGENTS a\n\nORCHESTRATOR five\nAGENTS a\nPLAN_ITERATIONS 5\n\n"
+            "ORCHESTRATOR one agents=a plan_iterations=1"
+        )
+        unset, five, one = config.orchestrators.values()
+
+        assert unset.plan_iterations is None
+        assert "plan_iterations" not in unset.to_decorator_string()
+        assert "plan_iterations=5" in five.to_decorator_string()
+        assert "plan_iterations=1" in one.to_decorator_string()
+
+    def test_invalid_plan_iterations(self):
+        """Test zero, negative and non-numeric PLAN_ITERATIONS are rejected."""
+        for value, message in [("0", "at least 1"), ("-2", "at least 1"), ("many", "Invalid number")]:
+            with pytest.raises(ValueError, match=message):
+                AgentfileParser().parse_content(f"ORCHESTRATOR planner\nPLAN_ITERATIONS {value}")
+
+    def test_empty_router_model_and_chain_instruction(self):
+        """Test empty strings are rejected instead of silently meaning unset."""
+        with pytest.raises(ValueError, match="MODEL requires a model name"):
+            AgentfileParser().parse_content('ROUTER route\nMODEL ""')
+        with pytest.raises(ValueError, match="INSTRUCTION requires instruction text"):
+            AgentfileParser().parse_content('CHAIN pipeline\nINSTRUCTION ""')
+
+        content = "ROUTER route\nAGENTS a b\n\nCHAIN pipeline\nSEQUENCE a\nAGENT a\nAGENT b"
+        config = AgentfileParser().parse_content(content)
+        assert config.routers["route"].model is None
+        assert 'model=' not in config.routers["route"].to_decorator_string()
+        assert config.chains["pipeline"].instruction is None
+        assert "instruction=" not in config.chains["pipeline"].to_decorator_string()
+
+
+class TestHeredocs:
+    """Test suite for BuildKit heredocs in passthrough instructions."""
+
+    def test_heredoc_body_is_not_parsed(self):
+        """Test heredoc bodies are kept in the raw text instead of being parsed as instructions."""
+        config = AgentfileParser().parse_content("RUN <<EOF\nAGENT not_an_agent\nEOF\nAGENT real")
+
+        assert list(config.agents) == ["real"]
+        assert config.dockerfile_instructions[0].passthrough_text() == "RUN <<EOF\nAGENT not_an_agent\nEOF"
+
+    def test_unterminated_heredoc(self):
+        """Test a heredoc without its closing delimiter is rejected."""
+        with pytest.raises(ValueError, match="Unterminated heredoc, missing closing EOF"):
+            AgentfileParser().parse_content("RUN <<EOF\necho hello")
+
+    def test_instruction_heredoc_is_verbatim(self):
+        """Test INSTRUCTION heredocs keep newlines, indentation, quotes and # characters."""
+        content = '''
+AGENT writer
+INSTRUCTION <<EOF
+You write "release notes".
+  # Keep this heading
+  - Use 'bullets'
+EOF
+SERVERS fetch
+
+ROUTER route
+AGENTS writer
+INSTRUCTION <<-END
+\tPick the best agent.
+\tEND
+
+CHAIN pipeline
+SEQUENCE writer
+INSTRUCTION <<"EOT"
+Step one.
+
+Step two.
+EOT
+'''
+        config = AgentfileParser(external_servers=["fetch"]).parse_content(content)
+
+        instruction = 'You write "release notes".\n  # Keep this heading\n  - Use \'bullets\''
+        assert config.agents["writer"].instruction == instruction
+        assert config.agents["writer"].servers == ["fetch"]
+        assert config.routers["route"].instruction == "Pick the best agent."
+        assert config.chains["pipeline"].instruction == "Step one.\n\nStep two."
+
+    def test_unterminated_instruction_heredoc(self):
+        """Test the error names the line that opened the heredoc."""
+        with pytest.raises(ValueError, match="Error parsing line 3: INSTRUCTION <<EOF"):
+            AgentfileParser().parse_content("AGENT writer\n\nINSTRUCTION <<EOF\nNever closed\n")
+
+
+class TestTripleQuotes:
+    """Test suite for triple-quoted multi-line values."""
+
+    def test_triple_quoted_values_span_lines(self):
+        """Test triple-quoted values keep newlines, # lines and unescaped quotes."""
+        content = '''
+SECRET CERT """-----BEGIN-----
+abc
+
+# not a comment
+-----END-----"""
+
+MCP_SERVER github
+COMMAND npx
+ENV GREETING """Say "hi", it's fine"""
+
+AGENT writer
+INSTRUCTION """You write "release notes".
+  - Use 'bullets'
+AGENT not_an_agent"""
+SERVERS github
+'''
+        config = AgentfileParser().parse_content(content)
+
+        assert config.secrets[0].value == "-----BEGIN-----\nabc\n\n# not a comment\n-----END-----"
+        assert config.servers["github"].env == {"GREETING": 'Say "hi", it\'s fine'}
+        instruction = 'You write "release notes".\n  - Use \'bullets\'\nAGENT not_an_agent'
+        assert config.agents["writer"].instruction == instruction
+        assert list(config.agents) == ["writer"]
+        assert config.agents["writer"].servers == ["github"]
+
+    def test_unterminated_triple_quote(self):
+        """Test the error names the line that opened the value."""
+        with pytest.raises(ValueError, match="Unterminated triple-quoted string starting on line 3"):
+            AgentfileParser().parse_content('AGENT writer\n\nINSTRUCTION """Never closed\nAGENT other\n')
+
+    def test_image_env_is_rewritten_for_docker(self):
+        """Test top-level ENV triple quotes become Docker double quotes, and cannot span lines."""
+        config = AgentfileParser().parse_content('ENV GREETING="""Say "hi" """ COUNT=2')
+
+        assert config.image_env == {"GREETING": 'Say "hi" ', "COUNT": "2"}
+        assert config.dockerfile_instructions[0].passthrough_text() == 'ENV GREETING="Say \\"hi\\" " COUNT="2"'
+
+        with pytest.raises(ValueError, match="ENV GREETING cannot span lines"):
+            AgentfileParser().parse_content('ENV GREETING="""one\ntwo"""')
+
+    def test_run_keeps_shell_quoting(self):
+        """Test shell instructions are not joined across lines by triple quotes."""
+        config = AgentfileParser().parse_content('RUN echo """\nAGENT a')
+
+        assert config.dockerfile_instructions[0].passthrough_text() == 'RUN echo """'
+        assert list(config.agents) == ["a"]
+
+    def test_generated_code_escapes_instruction(self):
+        """Test instructions with quotes and backslashes produce valid Python literals."""
+        config = AgentfileParser().parse_content('AGENT a\nINSTRUCTION """Use C:\\new and end with "quotes\\""""')
+        instruction = config.agents["a"].instruction
+        decorator = config.agents["a"].to_decorator_string()
+        literal = decorator.split("instruction=", 1)[1].rsplit("\n", 1)[0].rstrip(",")
+
+        assert instruction == 'Use C:\\new and end with "quotes\\"'
+        assert ast.literal_eval(literal) == instruction
+
+
+class TestEnvFile:
+    """Test suite for ENV_FILE and dotenv parsing."""
+
+    FILES = {
+        "project/shared.env": "# Shared by every server\nTOKEN=abc\nLOG_LEVEL=info\n",
+        "project/servers/fetch.env": 'export FETCH_KEY="k 1"  # quoted\nLOG_LEVEL=debug\n',
+    }
+
+    def parser(self):
+        """Return a parser that reads FILES, relative to a project directory."""
+
+        def resolver(path):
+            if path not in self.FILES:
+                raise FileNotFoundError(2, "No such file or directory")
+            return self.FILES[path]
+
+        parser = AgentfileParser(resolver=resolver)
+        parser.base_dir = "project"
+        return parser
+
+    def test_top_level_and_server_files(self):
+        """Test a top-level file reaches every server, and a server's own file and ENV take precedence."""
+        content = """
+ENV_FILE shared.env
+MCP_SERVER fetch
+COMMAND uvx
+ENV_FILE ./servers/fetch.env
+ENV TOKEN=own
+MCP_SERVER git
+COMMAND uvx
+"""
+        config = self.parser().parse_content(content)
+
+        assert config.env == {"TOKEN": "abc", "LOG_LEVEL": "info"}
+        assert config.servers["fetch"].env == {"TOKEN": "own", "LOG_LEVEL": "debug", "FETCH_KEY": "k 1"}
+        assert config.servers["git"].env == {"TOKEN": "abc", "LOG_LEVEL": "info"}
+
+    def test_missing_file(self):
+        """Test a missing file is an error naming it, unless it is marked --optional."""
+        with pytest.raises(AgentfileError, match="Cannot read ENV_FILE project/local.env") as error:
+            self.parser().parse_content("ENV_FILE local.env\n")
+        assert (error.value.line, error.value.column) == (1, 10)
+
+        config = self.parser().parse_content("ENV_FILE --optional local.env\nMCP_SERVER git\nCOMMAND uvx\n")
+        assert config.servers["git"].env == {}
+
+    def test_dotenv_syntax(self):
+        """Test quoting, escapes, comments and export prefixes."""
+        content = (
+            "# comment\n\nA=1\nexport B = \"two \\\"words\\\"\\nnext\"  # trailing\nC='lit $X # kept'\n"
+            "D=plain # comment\nE=\nF=\"multi\nline\"\nG=a#b\n"
+        )
+        assert parse_dotenv(content) == {
+            "A": "1",
+            "B": 'two "words"\nnext',
+            "C": "lit $X # kept",
+            "D": "plain",
+            "E": "",
+            "F": "multi\nline",
+            "G": "a#b",
+        }
+
+    def test_dotenv_errors(self):
+        """Test malformed lines and unterminated quotes name the line."""
+        with pytest.raises(ValueError, match="line 2 is not NAME=value"):
+            parse_dotenv("A=1\nnot a variable\n")
+        with pytest.raises(ValueError, match="line 1: unterminated \" value for A"):
+            parse_dotenv('A="open\nB=2\n')
+        self.FILES = {**self.FILES, "project/bad.env": "oops\n"}
+        with pytest.raises(AgentfileError, match="project/bad.env: line 1 is not NAME=value"):
+            self.parser().parse_content("ENV_FILE bad.env\n")
+
+
+class TestInstructionFile:
+    """Test suite for INSTRUCTION_FILE."""
+
+    FILES = {
+        "project/prompts/coder.md": "# Coder\n\nWrite small, tested changes.\n",
+        "project/prompts/triage.md": "Pick the agent that fits.\n",
+        "project/shared/base.agentfile": "CHAIN pipeline\nSEQUENCE coder\nINSTRUCTION_FILE ../prompts/triage.md\n",
+        "project/prompts/empty.md": "\n",
+    }
+
+    def parser(self, extra_files=None):
+        """Return a parser that reads FILES and extra_files, relative to a project directory."""
+        files = {**self.FILES, **(extra_files or {})}
+
+        def resolver(path):
+            if path not in files:
+                raise FileNotFoundError(2, "No such file or directory")
+            return files[path]
+
+        parser = AgentfileParser(resolver=resolver)
+        parser.base_dir = "project"
+        return parser
+
+    def test_files_are_read_relative_to_the_agentfile(self):
+        """Test agents and workflows read their instruction from a file, whose path is kept relative to the Agentfile.
+
+        A path in an included file is relative to that file, and the file's final newline is dropped.
+        """
+        content = """
+AGENT coder
+INSTRUCTION_FILE ./prompts/coder.md
+ROUTER triage agents=coder instruction_file=prompts/triage.md
+INCLUDE shared/base.agentfile
+"""
+        config = self.parser().parse_content(content)
+
+        assert config.agents["coder"].instruction == "# Coder\n\nWrite small, tested changes."
+        assert config.agents["coder"].instruction_file == "prompts/coder.md"
+        assert config.routers["triage"].instruction == "Pick the agent that fits."
+        assert config.chains["pipeline"].instruction_file == "prompts/triage.md"
+
+    def test_missing_and_empty_files_are_errors(self):
+        """Test a file that cannot be read, or holds no instruction, fails parsing at its line."""
+        for path, message in [
+            ("prompts/missing.md", "Cannot read INSTRUCTION_FILE project/prompts/missing.md: No such file"),
+            ("prompts/empty.md", "INSTRUCTION_FILE project/prompts/empty.md is empty"),
+        ]:
+            with pytest.raises(AgentfileError, match=message) as error:
+                self.parser().parse_content(f"AGENT coder\nINSTRUCTION_FILE {path}\n")
+            assert error.value.line == 2
+
+    def test_instruction_and_file_are_rejected_together(self):
+        """Test a block cannot set both INSTRUCTION and INSTRUCTION_FILE, in either order."""
+        for lines in [
+            "INSTRUCTION Code.\nINSTRUCTION_FILE prompts/coder.md",
+            "INSTRUCTION_FILE prompts/coder.md\nINSTRUCTION Code.",
+        ]:
+            with pytest.raises(AgentfileError, match="AGENT coder sets both INSTRUCTION"):
+                self.parser().parse_content(f"AGENT coder\n{lines}\n")
+
+    def test_redeclared_block_may_switch_to_a_file(self):
+        """Test a block overriding an included one may use a file where the included one set INSTRUCTION."""
+        parser = self.parser({"project/base.agentfile": "AGENT coder\nINSTRUCTION Code.\n"})
+        config = parser.parse_content("INCLUDE base.agentfile\nAGENT coder\nINSTRUCTION_FILE prompts/coder.md\n")
+
+        assert config.agents["coder"].instruction_file == "prompts/coder.md"
+
+
+class TestInclude:
+    """Test suite for INCLUDE."""
+
+    COMMON = """
+SECRET GITHUB_TOKEN ghp_common
+
+MCP_SERVER github
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-github
+
+AGENT helper
+INSTRUCTION Shared helper
+"""
+
+    def test_include_merges_and_later_definitions_win(self):
+        """Test included definitions are merged, and definitions after the INCLUDE override them by name."""
+        files = {"agents/common.agentfile": self.COMMON}
+        content = """
+MODEL anthropic/claude-3-sonnet-20241022
+INCLUDE ./common.agentfile
+SECRET GITHUB_TOKEN ghp_local
+
+AGENT helper
+INSTRUCTION Local helper
+SERVERS github
+"""
+        parser = AgentfileParser(resolver=files.__getitem__)
+        parser.base_dir = "agents"
+        config = parser.parse_content(content)
+
+        assert list(config.servers) == ["github"]
+        assert config.agents["helper"].instruction == "Local helper"
+        assert config.agents["helper"].servers == ["github"]
+        assert [secret.value for secret in config.secrets] == ["ghp_local"]
+
+    def test_nested_includes_resolve_relative_to_the_including_file(self):
+        """Test paths in an included file are relative to that file's directory."""
+        files = {
+            "shared/base.agentfile": "INCLUDE ../servers/github.agentfile\nAGENT helper\nSERVERS github",
+            "servers/github.agentfile": "MCP_SERVER github\nCOMMAND npx",
+        }
+        config = AgentfileParser(resolver=files.__getitem__).parse_content("INCLUDE shared/base.agentfile")
+
+        assert list(config.servers) == ["github"]
+        assert config.agents["helper"].servers == ["github"]
+
+    def test_cyclic_include_names_the_chain(self):
+        """Test an include cycle is reported with every file in it."""
+        files = {"a.agentfile": "INCLUDE b.agentfile", "b.agentfile": "INCLUDE a.agentfile"}
+
+        with pytest.raises(ValueError, match="Cyclic INCLUDE: a.agentfile -> b.agentfile -> a.agentfile"):
+            AgentfileParser(resolver=files.__getitem__).parse_content("INCLUDE a.agentfile")
+
+    def test_parse_file_includes_from_disk(self):
+        """Test parse_file resolves includes next to the Agentfile and reports errors with the file name."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            Path(temp_dir, "common.agentfile").write_text(self.COMMON, encoding="utf-8")
+            Path(temp_dir, "broken.agentfile").write_text("\nAGENT\n", encoding="utf-8")
+            agentfile = Path(temp_dir, "Agentfile")
+
+            agentfile.write_text("INCLUDE common.agentfile\n", encoding="utf-8")
+            assert list(AgentfileParser().parse_file(str(agentfile)).agents) == ["helper"]
+
+            agentfile.write_text("INCLUDE broken.agentfile\n", encoding="utf-8")
+            with pytest.raises(ValueError, match=r"In .*broken.agentfile: Error parsing line 2: AGENT"):
+                AgentfileParser().parse_file(str(agentfile))
+
+            with pytest.raises(ValueError, match="Cannot read included file"):
+                AgentfileParser().parse_content(f"INCLUDE {temp_dir}/missing.agentfile")
+
+    def test_remote_includes_resolve_against_the_url(self):
+        """Test a fetched Agentfile's relative INCLUDEs are fetched from next to it, pinned ones verified."""
+        common = self.COMMON.encode("utf-8")
+        pages = {
+            "https://configs.example.com/agents/shared/common.agentfile": common,
+            "https://configs.example.com/agents/servers.agentfile": b"MCP_SERVER fetch\nCOMMAND uvx\n",
+        }
+        content = (
+            f"INCLUDE shared/common.agentfile sha256:{hashlib.sha256(common).hexdigest()}\n"
+            "INCLUDE servers.agentfile\n"
+        )
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agentfile = Path(temp_dir, "support.agentfile")
+            agentfile.write_text(content, encoding="utf-8")
+            parser = AgentfileParser(source_url="https://configs.example.com/agents/support.agentfile", fetch=pages.get)
+            with patch.dict(os.environ, {"XDG_CACHE_HOME": temp_dir}):
+                config = parser.parse_file(str(agentfile))
+
+                assert list(config.servers) == ["github", "fetch"]
+                assert list(config.agents) == ["helper"]
+
+                agentfile.write_text(f"INCLUDE shared/common.agentfile sha256:{'0' * 64}\n", encoding="utf-8")
+                with pytest.raises(ValueError, match="Checksum mismatch for https://configs.example.com/agents/shared"):
+                    parser._fresh().parse_file(str(agentfile))
+
+                agentfile.write_text("ENV_FILE keys.env\n", encoding="utf-8")
+                with pytest.raises(ValueError, match="fetched file .*; ENV_FILE reads local files only"):
+                    parser._fresh().parse_file(str(agentfile))
+
+    def test_include_targets_that_are_not_files(self):
+        """Test plain HTTP needs insecure_http, other URL schemes are rejected and local files can be pinned."""
+        with pytest.raises(ValueError, match="Refusing to fetch http://example.com/a.agentfile over plain HTTP"):
+            AgentfileParser().parse_content("INCLUDE http://example.com/a.agentfile")
+        with pytest.raises(ValueError, match="INCLUDE s3://bucket/a.agentfile is not a file path or an HTTPS URL"):
+            AgentfileParser().parse_content("INCLUDE s3://bucket/a.agentfile")
+        with pytest.raises(ValueError, match="ENV_FILE https://example.com/keys.env is a URL; ENV_FILE reads local"):
+            AgentfileParser().parse_content("ENV_FILE https://example.com/keys.env")
+
+        files = {"common.agentfile": self.COMMON}
+        digest = hashlib.sha256(self.COMMON.encode("utf-8")).hexdigest()
+        config = AgentfileParser(resolver=files.__getitem__).parse_content(f"INCLUDE common.agentfile sha256:{digest}")
+        assert list(config.agents) == ["helper"]
+        with pytest.raises(ValueError, match="Checksum mismatch for common.agentfile"):
+            AgentfileParser(resolver=files.__getitem__).parse_content(f"INCLUDE common.agentfile sha256:{'0' * 64}")
+
+
+class TestDuplicateNames:
+    """Test suite for names declared more than once."""
+
+    def test_duplicate_agent_reports_both_lines(self):
+        """Test redeclaring an agent in the same file is an error naming the first declaration."""
+        content = "AGENT coder\nINSTRUCTION Write code\n\nAGENT coder\nINSTRUCTION Review code\n"
+
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content(content)
+
+        assert error.value.line == 4
+        assert error.value.message == "AGENT coder is already declared on line 1"
+
+    def test_duplicate_server_is_rejected(self):
+        """Test servers are checked too, whichever keyword declared them."""
+        with pytest.raises(ValueError, match="MCP_SERVER fetch is already declared on line 1"):
+            AgentfileParser().parse_content("MCP_SERVER fetch\nCOMMAND uvx\nSERVER fetch\nCOMMAND npx")
+
+    def test_name_shared_across_kinds_is_rejected(self):
+        """Test an agent and a workflow cannot share a name, even across an INCLUDE."""
+        files = {"research.agentfile": "AGENT research\nINSTRUCTION Research"}
+        parser = AgentfileParser(resolver=files.__getitem__)
+
+        with pytest.raises(AgentfileError) as error:
+            parser.parse_content("INCLUDE research.agentfile\nCHAIN research\nSEQUENCE research")
+
+        assert error.value.message == "CHAIN research reuses the name of AGENT research on research.agentfile line 1"
+
+    def test_agents_and_servers_may_share_a_name(self):
+        """Test server names are a separate namespace from agent names."""
+        config = AgentfileParser().parse_content("MCP_SERVER github\nCOMMAND npx\nAGENT github\nSERVERS github")
+        assert list(config.agents) == ["github"]
+        assert list(config.servers) == ["github"]
+
+
+class TestAgentfileError:
+    """Test suite for structured parse errors."""
+
+    def test_error_points_at_the_offending_token(self):
+        """Test an invalid value is reported with its file, line, column and instruction."""
+        content = "MCP_SERVER fetch\nCOMMAND uvx\n  TRANSPORT   carrier-pigeon\n"
+
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content(content)
+
+        assert (error.value.file, error.value.line, error.value.column) == ("Agentfile", 3, 15)
+        assert error.value.instruction == "TRANSPORT"
+        assert error.value.message.startswith("Invalid transport type: carrier-pigeon. Supported: stdio")
+        assert str(error.value) == f"Error parsing line 3: TRANSPORT   carrier-pigeon\n{error.value.message}"
+
+    def test_missing_argument_points_at_the_instruction(self):
+        """Test an instruction without its arguments is reported at the instruction itself."""
+        content = "AGENT helper\nROUTER triage\nAGENTS\n"
+
+        with pytest.raises(AgentfileError) as error:
+            AgentfileParser().parse_content(content)
+
+        assert (error.value.line, error.value.column, error.value.instruction) == (3, 1, "AGENTS")
+        assert error.value.to_diagnostic().to_dict() == {
+            "severity": "error",
+            "code": "parse-error",
+            "message": "AGENTS requires at least one agent name",
+            "line": 3,
+            "column": 1,
+        }
+
+    def test_error_in_included_file_keeps_its_position(self):
+        """Test position fields describe the included file while the text shows the INCLUDE chain."""
+        files = {"servers.agentfile": "MCP_SERVER fetch\nEXPOSE http"}
+
+        with pytest.raises(ValueError) as error:
+            AgentfileParser(resolver=files.__getitem__).parse_content("FROM python:3.11\nINCLUDE servers.agentfile")
+
+        assert isinstance(error.value, AgentfileError)
+        assert (error.value.file, error.value.line, error.value.column) == ("servers.agentfile", 2, 8)
+        assert str(error.value) == (
+            "Error parsing line 2: INCLUDE servers.agentfile\n"
+            "In servers.agentfile: Error parsing line 2: EXPOSE http\nInvalid port number: http"
+        )
+
+    def test_split_reports_part_columns(self):
+        """Test quoted parts are one part starting at their opening quote."""
+        parts, columns = AgentfileParser()._split_with_columns('ARGS "two words"  x')
+
+        assert parts == ['ARGS', '"two words"', 'x']
+        assert columns == [1, 6, 19]
+
+
+class TestResolveIncludes:
+    """Test suite for reading INCLUDE targets ahead of parsing."""
+
+    @staticmethod
+    def fan_out(count):
+        """Return an Agentfile including count files, each of which includes a shared file."""
+        files = {f"part{i}.agentfile": f"INCLUDE shared.agentfile\nAGENT agent{i}" for i in range(count)}
+        files["shared.agentfile"] = "MCP_SERVER fetch\nCOMMAND uvx"
+        content = "\n".join(f"INCLUDE part{i}.agentfile" for i in range(count))
+        return content, files
+
+    def test_includes_are_read_concurrently(self):
+        """Test a slow resolver is called in parallel, once per distinct file."""
+        content, files = self.fan_out(40)
+        calls = []
+
+        def slow_resolver(path):
+            calls.append(path)
+            time.sleep(0.05)
+            return files[path]
+
+        parser = AgentfileParser(resolver=slow_resolver, max_workers=20)
+        started = time.monotonic()
+        config = parser.parse_content(content)
+        elapsed = time.monotonic() - started
+
+        # Serially this takes over two seconds
+        assert elapsed < 1.0
+        assert sorted(calls) == sorted(files)
+        assert len(config.agents) == 40
+        assert list(config.servers) == ["fetch"]
+
+    def test_single_file_starts_no_threads(self):
+        """Test content without INCLUDE is parsed without touching the resolver."""
+        assert resolve_includes("AGENT helper", ".", None) == {}
+
+    def test_errors_are_reported_together_in_path_order(self):
+        """Test every unreadable file is listed, sorted by path, whatever order the reads finish in."""
+        content, files = self.fan_out(6)
+        for name in ["part4.agentfile", "part1.agentfile"]:
+            del files[name]
+
+        def resolver(path):
+            time.sleep(0.01 * (hash(path) % 5))
+            if path not in files:
+                raise FileNotFoundError(2, "No such file or directory")
+            return files[path]
+
+        with pytest.raises(ValueError) as error:
+            AgentfileParser(resolver=resolver).parse_content(content)
+        assert str(error.value).endswith(
+            "Cannot read included files:\n"
+            "  part1.agentfile: No such file or directory\n"
+            "  part4.agentfile: No such file or directory"
+        )
+
+    def test_cycles_are_read_once_and_reported_by_the_parser(self):
+        """Test a cycle does not loop while reading ahead and still fails parsing."""
+        files = {"a.agentfile": "INCLUDE b.agentfile", "b.agentfile": "INCLUDE a.agentfile"}
+        calls = []
+
+        def resolver(path):
+            calls.append(path)
+            return files[path]
+
+        with pytest.raises(ValueError, match="Cyclic INCLUDE"):
+            AgentfileParser(resolver=resolver).parse_content("INCLUDE a.agentfile")
+        assert sorted(calls) == ["a.agentfile", "b.agentfile"]
+
+    def test_cancelled_read_ahead_stops(self):
+        """Test a set cancel event stops reading before any file is resolved."""
+        cancel = threading.Event()
+        cancel.set()
+        content, files = self.fan_out(3)
+
+        with pytest.raises(ValueError, match="cancelled"):
+            AgentfileParser(resolver=files.__getitem__, cancel=cancel).parse_content(content)
+
+
+class TestBuildArgs:
+    """Test suite for build arg placeholders in Agentman values."""
+
+    CONTENT = """
+ARG HOST=localhost:8080
+ARG MODEL_NAME
+MODEL ${MODEL_NAME}
+
+MCP_SERVER remote
+TRANSPORT sse
+URL https://${HOST}/sse
+ENV PRICE $$5
+ENV TOKEN ${GITHUB_TOKEN}
+
+AGENT helper
+INSTRUCTION Use a ${TONE:-friendly} tone, not '${HOST}'
+"""
+
+    def test_placeholders_are_expanded(self):
+        """Test passed build args, ARG defaults, inline defaults and $$ escapes."""
+        config = AgentfileParser(build_args={"MODEL_NAME": "openai/gpt-4o"}).parse_content(self.CONTENT)
+
+        assert config.default_model == "openai/gpt-4o"
+        assert config.servers["remote"].url == "https://localhost:8080/sse"
+        assert config.servers["remote"].env == {"PRICE": "$5", "TOKEN": "${GITHUB_TOKEN}"}
+        assert config.agents["helper"].instruction == "Use a friendly tone, not '${HOST}'"
+
+    def test_build_args_override_arg_defaults(self):
+        """Test a passed build arg wins over the ARG default."""
+        build_args = {"MODEL_NAME": "generic.llama3", "HOST": "example.com", "TONE": "formal"}
+        config = AgentfileParser(build_args=build_args).parse_content(self.CONTENT)
+
+        assert config.servers["remote"].url == "https://example.com/sse"
+        assert config.agents["helper"].instruction == "Use a formal tone, not '${HOST}'"
+
+    def test_dockerfile_instructions_are_left_to_docker(self):
+        """Test passthrough instructions keep their placeholders for docker build to expand."""
+        config = AgentfileParser().parse_content("ARG VERSION=1.0\nRUN pip install tool==${VERSION}")
+
+        assert config.dockerfile_instructions[1].passthrough_text() == "RUN pip install tool==${VERSION}"
+
+    def test_unresolved_build_arg(self):
+        """Test an ARG without a value is an error naming the variable and line."""
+        with pytest.raises(ValueError, match=r"Error parsing line 4: MODEL \$\{MODEL_NAME\}\nBuild arg MODEL_NAME"):
+            AgentfileParser().parse_content(self.CONTENT)
+
+
+class TestEnvLookup:
+    """Test suite for ${env:NAME} placeholders."""
+
+    CONTENT = "SECRET OPENAI_API_KEY ${env:OPENAI_API_KEY}\nMCP_SERVER s\nCOMMAND npx\nENV HOME_DIR $${env:HOME}"
+
+    def test_lookup_is_opt_in(self):
+        """Test placeholders are kept and flagged when no lookup is configured."""
+        parser = AgentfileParser()
+        config = parser.parse_content(self.CONTENT)
+
+        assert config.secrets[0].value == "${env:OPENAI_API_KEY}"
+        assert [d.code for d in parser.diagnostics] == ["env-lookup-disabled"]
+        assert parser.diagnostics[0].line == 1
+
+    def test_lookup_reads_the_environment(self):
+        """Test placeholders are replaced from the lookup, and $$ still escapes them."""
+        environment = {"OPENAI_API_KEY": "sk-local"}
+        config = AgentfileParser(env_lookup=environment.get).parse_content(self.CONTENT)
+
+        assert config.secrets[0].value == "sk-local"
+        assert config.servers["s"].env == {"HOME_DIR": "${env:HOME}"}
+
+    def test_missing_variable(self):
+        """Test an unset variable is an error instead of an empty value."""
+        with pytest.raises(ValueError, match="Error parsing line 1.*\nEnvironment variable OPENAI_API_KEY is not set"):
+            AgentfileParser(env_lookup={}.get).parse_content(self.CONTENT)
+
+
+class TestParseString:
+    """Test suite for parse_string, parse_bytes and parse."""
+
+    CONTENT = """
+ARG MODEL_NAME=generic.qwen3:8b
+MODEL ${MODEL_NAME}
+AGENT helper
+"""
+
+    def test_same_parser_parses_again(self):
+        """Test each call starts from scratch, so the second result does not carry the first's definitions."""
+        parser = AgentfileParser(build_args={"MODEL_NAME": "anthropic/claude-3-haiku"})
+        first = parser.parse_string("AGENT one\nMODEL openai/gpt-4o\n")
+        second = parser.parse_string("AGENT two\n")
+
+        assert list(first.agents) == ["one"] and list(second.agents) == ["two"]
+        assert second.agents["two"].model is None
+        assert parser.config == AgentfileConfig()
+        assert parser.parse_string(self.CONTENT).default_model == "anthropic/claude-3-haiku"
+
+    def test_includes_are_relative_to_base_dir(self):
+        """Test base_dir and the resolver are used for INCLUDE, and parse_bytes decodes UTF-8."""
+        files = {"project/tools.agentfile": "AGENT helper\nINSTRUCTION Helpé\n"}
+        parser = AgentfileParser(resolver=files.__getitem__)
+        content = "INCLUDE ./tools.agentfile\n".encode("utf-8")
+        config = parser.parse_bytes(content, source_name="Agentfile", base_dir="project")
+
+        assert config.agents["helper"].instruction == "Helpé"
+        assert config.source_name == "Agentfile"
+
+    def test_concurrent_calls(self):
+        """Test several threads can share one parser."""
+        parser = AgentfileParser()
+        results = {}
+
+        def run(index):
+            results[index] = parser.parse_string(f"AGENT a{index}\nMCP_SERVER s{index}\nCOMMAND uvx\n")
+
+        threads = [threading.Thread(target=run, args=(index,)) for index in range(8)]
+        for thread in threads:
+            thread.start()
+        for thread in threads:
+            thread.join()
+
+        assert all(list(results[index].servers) == [f"s{index}"] for index in range(8))
+
+    def test_package_level_parse(self):
+        """Test parse takes the parser options, and errors are raised as from parse_content."""
+        assert parse(self.CONTENT).default_model == "generic.qwen3:8b"
+        with pytest.raises(AgentfileError, match="Line 1 is 12 characters long"):
+            parse("AGENT helper", max_line_size=5)
+
+
+class TestStatements:
+    """Test suite for the ordered statements parse_ast returns."""
+
+    CONTENT = '''# escape=\\
+# Base image
+FROM python:3.11
+
+# The fetcher
+mcp_server fetch   # inline comment
+  COMMAND uvx
+  ARGS ["--root", "/data with spaces"]
+  ENV TOKEN="a b"
+END
+RUN <<EOF
+# shell comment
+echo hi
+EOF
+AGENT helper
+INSTRUCTION """Fetch
+pages"""
+SERVERS fetch
+CHAIN pipeline
+SEQUENCE helper
+'''
+
+    def test_statements_keep_source_order_and_blocks(self):
+        """Test declarations hold their sub-instructions, with keywords upper-cased and arguments as written."""
+        statements = parse_ast(self.CONTENT)
+
+        assert [(s.keyword, s.line, s.end_line) for s in statements] == [
+            ("FROM", 3, 3),
+            ("MCP_SERVER", 6, 6),
+            ("RUN", 11, 14),
+            ("AGENT", 15, 15),
+            ("CHAIN", 19, 19),
+        ]
+        server = statements[1]
+        assert [(c.keyword, c.args, c.column) for c in server.children] == [
+            ("COMMAND", ["uvx"], 3),
+            ("ARGS", ['["--root",', '"/data with spaces"]'], 3),
+            ("ENV", ['TOKEN="a b"'], 3),
+            ("END", [], 1),
+        ]
+        assert [(c.keyword, c.line, c.end_line) for c in statements[3].children] == [
+            ("INSTRUCTION", 16, 17),
+            ("SERVERS", 18, 18),
+        ]
+        assert statements[2].heredocs == ["# shell comment\necho hi"]
+
+    def test_comments_attach_to_the_next_statement(self):
+        """Test comment lines go with the statement after them and trailing comments with their line."""
+        statements = parse_ast(self.CONTENT)
+
+        assert statements[0].comments == ["# Base image"]
+        assert statements[1].comments == ["# The fetcher"]
+        assert statements[1].comment == "# inline comment"
+        assert statements[3].comments == []
+
+    def test_included_statements_stay_in_their_file(self):
+        """Test INCLUDE is a single statement and closes the block before it."""
+        files = {"tools.agentfile": "AGENT helper\nINSTRUCTION Help\n"}
+        parser = AgentfileParser(resolver=files.__getitem__)
+        config = parser.parse_content("MCP_SERVER fetch\nCOMMAND uvx\nINCLUDE tools.agentfile\nEXPOSE 80\n")
+
+        assert [(s.keyword, len(s.children)) for s in parser.statements] == [
+            ("MCP_SERVER", 1),
+            ("INCLUDE", 0),
+            ("EXPOSE", 0),
+        ]
+        assert list(config.agents) == ["helper"]
+
+    def test_config_dicts_follow_declaration_order(self):
+        """Test the configuration lists definitions in the order the statements declare them."""
+        content = "AGENT b\nAGENT a\nAGENT c\nMCP_SERVER z\nCOMMAND uvx\nMCP_SERVER y\nCOMMAND uvx\n"
+        config = parse(content)
+        statements = parse_ast(content)
+
+        assert list(config.agents) == [s.args[0] for s in statements if s.keyword == "AGENT"] == ["b", "a", "c"]
+        assert list(config.servers) == ["z", "y"]
+
+
+class TestParserDefaults:
+    """Test suite for the default base image, framework and CMD, and strict parsing."""
+
+    def test_defaults_are_unchanged_without_options(self):
+        """Test a parser without options gives the same configuration as before."""
+        config = AgentfileParser().parse_content("AGENT helper\n")
+
+        assert (config.base_image, config.framework, config.cmd) == (
+            "yeahdongcn/agentman-base:latest",
+            "fast-agent",
+            ["python", "agent.py"],
+        )
+
+    def test_defaults_apply_only_when_the_agentfile_is_silent(self):
+        """Test the defaults fill in what the Agentfile leaves out, and its own instructions win."""
+        parser = AgentfileParser(
+            default_base_image="registry.local/agent:1", default_framework="agno", default_cmd=["python", "main.py"]
+        )
+        config = parser.parse_string("AGENT helper\n")
+        declared = parser.parse_string("FROM python:3.12\nFRAMEWORK fast-agent\nCMD [\"python\", \"agent.py\"]\n")
+
+        assert config.base_image == "registry.local/agent:1"
+        assert (config.framework, config.cmd) == ("agno", ["python", "main.py"])
+        assert (declared.base_image, declared.framework, declared.cmd) == (
+            "python:3.12",
+            "fast-agent",
+            ["python", "agent.py"],
+        )
+        assert parser.parse_string("FROM golang AS builder\nFROM python:3.12\n").cmd == ["python", "main.py"]
+
+    def test_unsupported_default_framework(self):
+        """Test an unknown default framework is rejected when the parser is created."""
+        with pytest.raises(ValueError, match="Unsupported framework: crew"):
+            AgentfileParser(default_framework="crew")
+
+    def test_strict_fails_on_the_first_warning(self):
+        """Test strict parsing raises the first warning as an error with its position."""
+        content = "EXPOSE 8080\nEXPOSE 8080\nEXPOSE 8080\n"
+        assert AgentfileParser().parse_content(content).expose_ports == [8080]
+
+        with pytest.raises(AgentfileError) as excinfo:
+            AgentfileParser(strict=True).parse_content(content)
+
+        assert excinfo.value.line == 2
+        assert excinfo.value.instruction == "EXPOSE"
+        assert str(excinfo.value).endswith("\nPort 8080 is already exposed [expose-duplicate]")
+
+
+class TestFuzz:
+    """Randomized checks that the tokenizer and the parser fail cleanly instead of crashing or dropping text."""
+
+    WORDS = ["AGENT", "MCP_SERVER", "ROUTER", "INSTRUCTION", "ARGS", "ENV", "SERVERS", "END", "RUN", "FROM", "a=1"]
+    PIECES = WORDS + ['"', "'", '"""', "\\", "[", "]", ",", "#", "<<EOF", "EOF", "${x}", " ", "  ", "\t", "\n", "x"]
+    # Exception types that mean the parser hit a bug rather than a bad Agentfile
+    CRASHES = (IndexError, KeyError, TypeError, AttributeError, RecursionError, UnboundLocalError)
+
+    def random_text(self, rng, pieces):
+        """Return an instruction followed by a random mix of Agentfile syntax, or random characters."""
+        if rng.random() < 0.2:
+            return "".join(chr(rng.randrange(1, 0x250)) for _ in range(rng.randrange(40)))
+        # Triple quotes and newlines are rarer, or nearly every sample is an unterminated value
+        weights = [0.05 if piece in ['"""', "\n"] else 1 for piece in pieces]
+        return rng.choice(self.WORDS) + " " + "".join(rng.choices(pieces, weights, k=rng.randrange(20)))
+
+    def test_tokenizer_keeps_every_character(self):
+        """Test splitting a line keeps all its non-space text, or raises an AgentfileError."""
+        rng = random.Random(776)
+        pieces = [piece for piece in self.PIECES if piece != "\n"]
+        for _ in range(2000):
+            line = self.random_text(rng, pieces).replace("\n", " ")
+            for unescape in [False, True]:
+                try:
+                    parts, columns = AgentfileParser()._split_with_columns(line, unescape=unescape)
+                except AgentfileError as e:
+                    assert e.column is not None and line[e.column - 1] in "\"'", line
+                    continue
+                except ValueError as e:
+                    assert unescape and "Invalid escape sequence" in str(e), line
+                    continue
+                assert len(parts) == len(columns), line
+                if not unescape:
+                    assert re.sub(r"\s", "", "".join(parts)) == re.sub(r"\s", "", line), line
+
+    def test_parser_raises_only_value_errors(self):
+        """Test random content parses or raises a ValueError, never an internal error."""
+        rng = random.Random(20261014)
+        for _ in range(500):
+            content = "\n".join(self.random_text(rng, self.PIECES) for _ in range(rng.randrange(1, 8)))
+            try:
+                AgentfileParser().parse_content(content)
+            except ValueError as e:
+                assert not isinstance(e.__cause__, self.CRASHES), f"{content!r}: {e.__cause__!r}"
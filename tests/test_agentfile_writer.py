@@ -0,0 +1,274 @@
+"""Tests for writing configurations back to Agentfile text."""
+
+import io
+import json
+import tempfile
+from contextlib import redirect_stdout
+from dataclasses import replace
+from pathlib import Path
+
+from agentman.agentfile_parser import Agent, AgentfileConfig, AgentfileParser, MCPServer, SecretValue
+from agentman.agentfile_writer import write_agentfile
+from agentman.cli import configure_subcommands, create_argument_parser
+
+AGENTFILE = """# syntax=docker/dockerfile:1
+FROM yeahdongcn/agentman-base:latest
+MODEL anthropic/claude-3-sonnet-20241022
+TEMPERATURE 1
+SHUTDOWN_GRACE 1m30s
+HUMAN_INPUT_MODE webhook https://hooks.example.com/input
+SECRET GITHUB_TOKEN ghp_example
+SECRET REMOTE_TOKEN
+SECRET openai
+API_KEY sk-example
+BASE_URL https://api.openai.com/v1
+
+PROMPT report_style <<EOF
+Use short sections.
+Put sources last.
+EOF
+
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem "/data/my files"
+CWD "/app/tools/my files"
+ENV ROOT /data
+
+MCP_SERVER remote
+TRANSPORT sse
+URL http://localhost:8080/sse
+ELICITATION_MODE forms
+HEADER Authorization "Bearer ${REMOTE_TOKEN}"
+STARTUP_TIMEOUT 1m
+READ_TIMEOUT 30s
+REQUEST_TIMEOUT 90s
+MAX_RETRIES 2
+
+AGENT researcher
+INSTRUCTION Research the topic and cite sources
+TEMPERATURE 0.25
+MAX_TOKENS 4096
+STOP "###" "END OF ANSWER"
+TOP_P 0.5
+REQUEST_TIMEOUT 2m
+SERVERS filesystem remote
+TOOLS filesystem.read_* remote.*
+RESOURCES file:///data/index.md
+USE_HISTORY false
+
+AGENT writer model=openai/gpt-4o default=true
+INSTRUCTION Write the report. {{prompt:report_style}}
+
+ROUTER route agents=researcher,writer model=openai/gpt-4o-mini human_input=true
+
+CHAIN pipeline
+SEQUENCE researcher writer
+CUMULATIVE true
+CONTINUE_WITH_FINAL false
+
+PARALLEL review
+FAN_OUT researcher writer
+FAN_IN writer
+INSTRUCTION Review the draft
+
+ORCHESTRATOR planner
+AGENTS researcher writer
+PLAN_TYPE iterative
+PLAN_ITERATIONS 3
+
+RUN apt-get update
+EXPOSE 8080
+CMD ["python", "agent.py", "--verbose"]
+"""
+
+
+class TestAgentfileWriter:
+    """Test suite for the Agentfile writer."""
+
+    def test_round_trip(self):
+        """Test the written Agentfile parses back to an identical configuration."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+        rendered = write_agentfile(config, source="Agentfile")
+
+        assert AgentfileParser().parse_content(rendered) == config
+        assert write_agentfile(AgentfileParser().parse_content(rendered), source="Agentfile") == rendered
+
+    def test_canonical_output(self):
+        """Test directives stay first and lists that need it use the array form."""
+        rendered = write_agentfile(AgentfileParser().parse_content(AGENTFILE), source="Agentfile")
+
+        assert rendered.startswith("# syntax=docker/dockerfile:1\n# Rendered from Agentfile\n")
+        assert 'ARGS ["-y", "@modelcontextprotocol/server-filesystem", "/data/my files"]' in rendered
+        assert "CONTINUE_WITH_FINAL false" in rendered
+        assert "ROUTER route\nAGENTS researcher writer\nMODEL openai/gpt-4o-mini\n" in rendered
+        assert "PARALLEL review\nFAN_OUT researcher writer\nFAN_IN writer\n" in rendered
+        assert "PROMPT report_style <<EOF\nUse short sections.\nPut sources last.\nEOF\n" in rendered
+
+    def test_multiline_instruction_round_trip(self):
+        """Test multi-line instructions are written as heredocs that parse back unchanged."""
+        config = AgentfileParser().parse_content("AGENT writer\nINSTRUCTION <<EOF\nLine one\n  EOF indented\nEOF\n")
+        text = write_agentfile(config)
+
+        assert "INSTRUCTION <<EOF\nLine one\n  EOF indented\nEOF" in text
+        assert AgentfileParser().parse_content(text) == config
+
+    def test_instruction_file_round_trip(self):
+        """Test an instruction read from a file is written as its INSTRUCTION_FILE, not as the text."""
+        parser = AgentfileParser(resolver=lambda path: "Line one\nLine two\n")
+        config = parser.parse_content('AGENT writer\nINSTRUCTION_FILE "my prompts/writer.md"\n')
+        text = write_agentfile(config)
+
+        assert 'INSTRUCTION_FILE "my prompts/writer.md"' in text
+        assert "Line one" not in text
+        assert parser.parse_string(text) == config
+
+    def test_auth_round_trip(self):
+        """Test AUTH is written with its secret as a reference, for both bearer and basic credentials."""
+        content = (
+            "SECRET TOKEN\nSECRET PASS\n"
+            "MCP_SERVER a\nTRANSPORT http\nURL https://a.example.com/mcp\nAUTH bearer ${TOKEN}\n"
+            "MCP_SERVER b\nTRANSPORT sse\nURL https://b.example.com/sse\nAUTH basic bot:$PASS\n"
+        )
+        config = AgentfileParser().parse_content(content)
+        text = write_agentfile(config)
+
+        assert "AUTH bearer $TOKEN" in text
+        assert "AUTH basic bot:$PASS" in text
+        assert AgentfileParser().parse_content(text) == config
+
+    def test_serve_round_trip(self):
+        """Test SERVE is written with its path, leaving out the EXPOSE and CMD it implies."""
+        for content, expected in [
+            ("SERVE mcp 8000\nAGENT a\n", "SERVE mcp 8000\n"),
+            ("FRAMEWORK agno\nSERVE http 8000 /ask\nAGENT a\n", "SERVE http 8000 /ask\n"),
+        ]:
+            config = AgentfileParser().parse_content(content)
+            text = write_agentfile(config)
+
+            assert expected in text
+            assert "EXPOSE" not in text and "CMD" not in text
+            assert AgentfileParser().parse_content(text) == config
+
+    def test_imported_mcp_json_round_trip(self):
+        """Test stdio and sse servers imported from an mcp.json, with their input secrets, write back unchanged."""
+        mcp_json = {
+            "inputs": [{"type": "promptString", "id": "github-token", "password": True}],
+            "servers": {
+                "github": {
+                    "type": "stdio",
+                    "command": "npx",
+                    "args": ["-y", "@modelcontextprotocol/server-github"],
+                    "env": {"GITHUB_PERSONAL_ACCESS_TOKEN": "${input:github-token}", "LOG_DIR": "${env:HOME}/logs"},
+                },
+                "docs": {
+                    "type": "sse",
+                    "url": "https://docs.example.com/sse",
+                    "headers": {"Authorization": "Bearer ${input:github-token}"},
+                },
+            },
+        }
+        parser = AgentfileParser(resolver=lambda path: json.dumps(mcp_json))
+        config = parser.parse_content("IMPORT_MCP .vscode/mcp.json\n")
+        text = write_agentfile(config)
+
+        assert "SECRET GITHUB_TOKEN\n" in text
+        assert "ENV GITHUB_PERSONAL_ACCESS_TOKEN '${GITHUB_TOKEN}'\n" in text
+        assert AgentfileParser().parse_content(text) == config
+
+    def test_multiline_values_round_trip(self):
+        """Test multi-line secret and server ENV values are written triple-quoted and parse back unchanged."""
+        content = 'SECRET CERT """one\ntwo"""\nMCP_SERVER s\nCOMMAND npx\nENV BANNER """a\n\nb"""\n'
+        config = AgentfileParser().parse_content(content)
+        text = write_agentfile(config)
+
+        assert 'SECRET CERT """one\ntwo"""' in text
+        assert AgentfileParser().parse_content(text) == config
+
+    def test_values_that_need_quotes_round_trip(self):
+        """Test values with spaces, comments, quotes or placeholders are quoted so they parse back unchanged."""
+        config = AgentfileConfig(default_model="generic.llama3")
+        config.servers["local"] = MCPServer(name="local", command="/opt/my tools/server", env={"GREETING": "a  b"})
+        config.agents["writer"] = Agent(
+            name="writer", instruction='Use # for headings, "quote" sources', servers=["local"], default=True
+        )
+        config.secrets.append(SecretValue(name="TOKEN", value="${NOT_A_BUILD_ARG:-x}"))
+        text = write_agentfile(config)
+
+        assert 'COMMAND "/opt/my tools/server"' in text
+        assert 'INSTRUCTION "Use # for headings, \\"quote\\" sources"' in text
+        assert "SECRET TOKEN '${NOT_A_BUILD_ARG:-x}'" in text
+        assert "USE_HISTORY" not in text and "DEFAULT true" in text
+        assert AgentfileParser().parse_content(text) == config
+
+    def test_config_built_in_code_gets_image_instructions(self):
+        """Test FROM, ENV, EXPOSE and CMD are written for a configuration without Dockerfile instructions."""
+        config = AgentfileConfig(
+            base_image="python:3.12-slim",
+            expose_ports=[8080],
+            cmd=["python", "agent.py", "--verbose"],
+            image_env={"LOG_LEVEL": "debug", "BANNER": "hello world"},
+        )
+        config.agents["helper"] = Agent(name="helper")
+        text = write_agentfile(config)
+
+        assert text.startswith(
+            'FROM python:3.12-slim\nENV LOG_LEVEL=debug\nENV BANNER="hello world"\nEXPOSE 8080\n'
+            'CMD ["python", "agent.py", "--verbose"]\n'
+        )
+        parsed = AgentfileParser().parse_content(text)
+        assert replace(parsed, dockerfile_instructions=[], stages=[]) == config
+        assert write_agentfile(parsed) == text
+
+    def test_defaults_are_left_out(self):
+        """Test a configuration with only defaults writes no image instructions or settings."""
+        config = AgentfileConfig()
+        config.agents["helper"] = Agent(name="helper")
+
+        assert write_agentfile(config) == "AGENT helper\nINSTRUCTION You are a helpful agent.\n"
+
+
+class TestRender:
+    """Test suite for the render command."""
+
+    def render(self, *argv) -> str:
+        parser = create_argument_parser("agentman")
+        configure_subcommands(parser)
+        args = parser.parse_args(["render", *argv])
+        output = io.StringIO()
+        with redirect_stdout(output):
+            args.func(args)
+        return output.getvalue()
+
+    def test_blocks_name_the_file_they_came_from(self):
+        """Test each block is annotated with its file, and the rendered text parses back to the same config."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            Path(temp_dir, "shared").mkdir()
+            Path(temp_dir, "shared", "servers.agentfile").write_text(
+                "MCP_SERVER fetch\nCOMMAND uvx\nARGS mcp-server-fetch\n\nAGENT helper\nSERVERS fetch\n",
+                encoding="utf-8",
+            )
+            Path(temp_dir, "Agentfile").write_text(
+                "INCLUDE shared/servers.agentfile\nAGENT coder\nSERVERS fetch\n", encoding="utf-8"
+            )
+            text = self.render(temp_dir)
+            config = AgentfileParser().parse_file(str(Path(temp_dir, "Agentfile")))
+
+        assert "# from shared/servers.agentfile\nMCP_SERVER fetch\n" in text
+        assert "# from shared/servers.agentfile\nAGENT helper\n" in text
+        assert "# from Agentfile\nAGENT coder\n" in text
+        assert AgentfileParser().parse_content(text) == config
+
+    def test_profile_and_set(self):
+        """Test --profile applies Agentfile.<profile> and --set applies instructions after it, later ones winning."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            Path(temp_dir, "Agentfile").write_text("MODEL anthropic/claude-3-haiku\nAGENT helper\n", encoding="utf-8")
+            Path(temp_dir, "Agentfile.dev").write_text(
+                "MODEL generic.llama3\nAGENT helper\nINSTRUCTION Debug mode\n", encoding="utf-8"
+            )
+            text = self.render("--profile", "dev", temp_dir)
+            assert "MODEL generic.llama3\n" in text
+            assert "# from Agentfile.dev\nAGENT helper\nINSTRUCTION Debug mode\n" in text
+
+            text = self.render("--profile", "dev", "--set", "MODEL=openai/gpt-4o", "--set", "TEMPERATURE=0.2", temp_dir)
+            assert "MODEL openai/gpt-4o\nTEMPERATURE 0.2\n" in text
+            assert AgentfileParser().parse_content(text).default_model == "openai/gpt-4o"
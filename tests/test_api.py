@@ -0,0 +1,75 @@
+"""Tests for the public library API."""
+
+import os
+import subprocess
+import sys
+import tempfile
+from pathlib import Path
+
+import pytest
+
+from agentman import api
+from agentman.agent_builder import AgentBuilder
+
+AGENTFILE = """
+FROM yeahdongcn/agentman-base:latest
+MODEL anthropic/claude-3-sonnet-20241022
+
+MCP_SERVER fetch
+COMMAND uvx
+ARGS mcp-server-fetch
+
+AGENT helper
+INSTRUCTION Fetch pages
+SERVERS fetch
+"""
+
+
+class TestApi:
+    """Test suite for agentman.api."""
+
+    def test_every_exported_name_exists(self):
+        """Test __all__ only names what the module defines."""
+        assert [name for name in api.__all__ if not hasattr(api, name)] == []
+
+    def test_parse_file_and_parse_agree(self):
+        """Test a file and its content parse to the same configuration."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            path = Path(temp_dir) / "Agentfile"
+            path.write_text(AGENTFILE, encoding="utf-8")
+            from_file = api.parse_file(str(path))
+
+        assert from_file == api.parse(AGENTFILE)
+        assert isinstance(from_file.servers["fetch"], api.MCPServer)
+        with pytest.raises(api.AgentfileError, match="SERVERS can only be used within a context"):
+            api.parse("SERVERS fetch\n")
+
+    def test_generate_dockerfile_matches_the_build(self):
+        """Test the returned Dockerfile is the one a build writes, and nothing is written."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            dockerfile = api.generate_dockerfile(api.parse(AGENTFILE), source_dir=temp_dir)
+            assert list(Path(temp_dir).iterdir()) == []
+
+            AgentBuilder(api.parse(AGENTFILE), temp_dir, temp_dir).build_all()
+            assert (Path(temp_dir) / "Dockerfile").read_text(encoding="utf-8") == dockerfile
+
+        assert dockerfile.startswith("# syntax=docker/dockerfile:1\nFROM yeahdongcn/agentman-base:latest\n")
+        assert "# Check the generated configuration files" not in api.generate_dockerfile(
+            api.parse(AGENTFILE), verify_configs=False
+        )
+
+    def test_manifest_keys_are_stable(self):
+        """Test the manifest other tools read keeps its top-level keys."""
+        manifest = api.build_manifest(api.parse(AGENTFILE))
+
+        assert list(manifest)[:3] == ["schema_version", "agentman_version", "framework"]
+        assert {"default_model", "servers", "agents", "secrets", "env", "cmd"} <= set(manifest)
+        assert list(manifest["servers"]) == ["fetch"]
+
+    def test_importing_does_not_load_the_cli(self):
+        """Test tools importing the API do not pay for the command-line modules."""
+        code = "import sys, agentman.api; print('agentman.cli' in sys.modules)"
+        env = {**os.environ, "PYTHONPATH": os.pathsep.join(sys.path)}
+        result = subprocess.run([sys.executable, "-c", code], capture_output=True, text=True, check=True, env=env)
+
+        assert result.stdout.strip() == "False"
@@ -0,0 +1,93 @@
+"""Tests for the build-time check of generated configuration files."""
+
+import subprocess
+import sys
+import tempfile
+from pathlib import Path
+
+from agentman.agent_builder import AgentBuilder
+from agentman.agentfile_parser import AgentfileParser
+from agentman.config_check import CONFIG_CHECK_FILENAME, build_config_check_script
+
+AGENTFILE = """
+MODEL anthropic/claude-3-sonnet-20241022
+SECRET ANTHROPIC_API_KEY
+MCP_SERVER fetch
+COMMAND uvx
+ARGS mcp-server-fetch
+AGENT helper
+SERVERS fetch
+"""
+
+
+def build(content=AGENTFILE, **options):
+    """Build an Agentfile into a temporary directory and return it with the builder."""
+    temp_dir = tempfile.TemporaryDirectory()
+    builder = AgentBuilder(AgentfileParser().parse_content(content), temp_dir.name, **options)
+    builder.build_all()
+    return temp_dir, builder
+
+
+def run_check(directory):
+    """Run the generated check script the way the Dockerfile does."""
+    return subprocess.run(
+        [sys.executable, CONFIG_CHECK_FILENAME], cwd=directory, capture_output=True, text=True, check=False
+    )
+
+
+class TestConfigCheck:
+    """Test suite for the generated config check script."""
+
+    def test_generated_files_pass(self):
+        """Test the check accepts what the builder generates, for both frameworks."""
+        for content in [AGENTFILE, "FRAMEWORK agno\n" + AGENTFILE]:
+            temp_dir, _ = build(content)
+            with temp_dir:
+                result = run_check(temp_dir.name)
+            assert result.returncode == 0, result.stderr
+
+    def test_corrupted_yaml_fails_naming_the_file(self):
+        """Test badly indented YAML fails the check with the file name."""
+        temp_dir, _ = build()
+        with temp_dir:
+            config = Path(temp_dir.name) / "fastagent.config.yaml"
+            config.write_text(config.read_text(encoding="utf-8").replace("\n  ", "\n ", 1), encoding="utf-8")
+            result = run_check(temp_dir.name)
+
+        assert result.returncode == 1
+        assert result.stderr.startswith("fastagent.config.yaml: ")
+
+    def test_truncated_json_and_wrong_shapes_fail(self):
+        """Test truncated JSON, missing keys and non-mapping sections are each reported."""
+        cases = {
+            "agentman.json": ('{"schema_version": 1, "framework": "fast-', "agentman.json: "),
+            "fastagent.config.yaml": ("logger: {}\n", "fastagent.config.yaml: missing default_model"),
+            "fastagent.secrets.yaml": ("mcp:\n  servers:\n    fetch: [1]\n", "mcp.servers.fetch should be a mapping"),
+        }
+        for name, (text, expected) in cases.items():
+            temp_dir, _ = build()
+            with temp_dir:
+                (Path(temp_dir.name) / name).write_text(text, encoding="utf-8")
+                result = run_check(temp_dir.name)
+            assert result.returncode == 1
+            assert expected in result.stderr
+
+    def test_malformed_env_line_fails(self):
+        """Test a .env line that is not NAME=value is reported with its number."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            (Path(temp_dir) / CONFIG_CHECK_FILENAME).write_text(build_config_check_script([".env"]), encoding="utf-8")
+            (Path(temp_dir) / ".env").write_text("# comment\nOK=1\nnot a variable\n", encoding="utf-8")
+            result = run_check(temp_dir)
+
+        assert result.returncode == 1
+        assert result.stderr.strip() == ".env: line 3 is not NAME=value"
+
+    def test_dockerfile_runs_the_check_unless_disabled(self):
+        """Test the Dockerfile runs and removes the script, and verify_configs=False leaves both out."""
+        for verify in [True, False]:
+            temp_dir, _ = build(verify_configs=verify)
+            with temp_dir:
+                dockerfile = (Path(temp_dir.name) / "Dockerfile").read_text(encoding="utf-8")
+                has_script = (Path(temp_dir.name) / CONFIG_CHECK_FILENAME).exists()
+            assert (f"RUN python {CONFIG_CHECK_FILENAME} && rm {CONFIG_CHECK_FILENAME}" in dockerfile) is verify
+            assert has_script is verify
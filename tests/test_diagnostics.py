@@ -0,0 +1,63 @@
+"""Tests for diagnostic rendering."""
+
+import io
+
+from agentman.agentfile_parser import AgentfileParser
+from agentman.diagnostics import Diagnostic, format_diagnostics, render_diagnostic, use_color
+
+AGENTFILE = """FROM yeahdongcn/agentman-base:latest
+
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem@2025.1.14 C:\\data
+"""
+
+
+class TTY(io.StringIO):
+    """A string buffer that claims to be a terminal."""
+
+    def isatty(self):
+        return True
+
+
+class TestDiagnostics:
+    """Test suite for diagnostic rendering."""
+
+    def test_render_snippet(self):
+        """Test the snippet rendering with a caret under the offending token."""
+        parser = AgentfileParser()
+        parser.parse_content(AGENTFILE)
+
+        rendered = render_diagnostic(parser.diagnostics[0], AGENTFILE.split("\n"), "Agentfile")
+        assert rendered == (
+            "Agentfile:5:59: warning: Server filesystem uses Windows paths (C:\\data) that do not exist "
+            "inside the Linux container [windows-path]\n"
+            " 5 | ARGS -y @modelcontextprotocol/server-filesystem@2025.1.14 C:\\data\n"
+            "   |                                                           ^^^^^^^"
+        )
+
+    def test_render_without_column(self):
+        """Test diagnostics with a line but no column show the source line only."""
+        diagnostic = Diagnostic("info", "example", "Something to know", 3)
+        assert render_diagnostic(diagnostic, AGENTFILE.split("\n"), "Agentfile") == (
+            "Agentfile:3: info: Something to know [example]\n" " 3 | MCP_SERVER filesystem"
+        )
+
+    def test_render_without_location(self):
+        """Test diagnostics without a position render as a single line."""
+        diagnostic = Diagnostic("error", "example", "Broken")
+        assert render_diagnostic(diagnostic, [], "Agentfile") == "Agentfile: error: Broken [example]"
+
+    def test_plain_when_not_a_terminal(self):
+        """Test non-terminal streams get the one-line format."""
+        diagnostic = Diagnostic("warning", "example", "Careful", 3, 1)
+        assert format_diagnostics([diagnostic], AGENTFILE, "Agentfile", io.StringIO()) == (
+            "Agentfile: line 3: warning: Careful [example]"
+        )
+
+    def test_color_on_terminal(self):
+        """Test terminals get colored snippets unless color is disabled."""
+        diagnostic = Diagnostic("warning", "example", "Careful", 3, 1)
+        assert "\033[33m" in format_diagnostics([diagnostic], AGENTFILE, "Agentfile", TTY())
+        assert not use_color(TTY(), no_color=True)
+        assert "\033[" not in format_diagnostics([diagnostic], AGENTFILE, "Agentfile", TTY(), no_color=True)
@@ -3,7 +3,10 @@
 
 import tempfile
 import os
+import subprocess
+import sys
 from pathlib import Path
+from unittest.mock import patch
 
 from agentman.agentfile_parser import AgentfileParser
 from agentman.agent_builder import AgentBuilder
@@ -59,5 +62,192 @@ CMD ["python", "agent.py"]
         print("\n✅ All checks passed! EXPOSE and CMD instructions are properly included.")
 
 
+PASSTHROUGH = """RUN --mount=type=cache,target=/root/.cache  pip install -r req.txt
+COPY --chown=1001:1001 . .
+RUN --mount=type=secret,id=token,env="API_TOKEN" \\
+    --network=none \\
+      curl -H "Authorization: Bearer $API_TOKEN"   https://example.com/a,b=c
+RUN <<EOF
+set -e
+echo "inline  script"   # not an Agentfile instruction
+EOF
+COPY <<-"EOT" /app/config.ini
+\t[server]
+\tport = 8080
+\tEOT
+ENV A="x  y"    B=z"""
+
+
+def test_passthrough_instructions_are_verbatim():
+    """Test passthrough instructions keep their spacing, quoting, continuations and heredocs."""
+    config = AgentfileParser().parse_content("FROM python:3.11-slim\n" + PASSTHROUGH + "\nMODEL generic.llama3\n")
+
+    with tempfile.TemporaryDirectory() as temp_dir:
+        builder = AgentBuilder(config, temp_dir)
+        builder._generate_dockerfile()
+        dockerfile_content = (Path(temp_dir) / "Dockerfile").read_text(encoding="utf-8")
+
+    assert PASSTHROUGH + "\n" in dockerfile_content
+    assert [inst.instruction for inst in config.dockerfile_instructions] == [
+        "FROM", "RUN", "COPY", "RUN", "RUN", "COPY", "ENV"
+    ]
+    assert config.default_model == "generic.llama3"
+
+    # The split form is still available for analysis
+    mount = config.dockerfile_instructions[1]
+    assert mount.to_dockerfile_line() == "RUN --mount=type=cache,target=/root/.cache pip install -r req.txt"
+    assert config.dockerfile_instructions[4].args == ["<<EOF"]
+    assert config.image_env == {"A": "x  y", "B": "z"}
+
+
+MULTI_STAGE = """FROM node:20 AS builder
+WORKDIR /src
+RUN npm ci && npm run build
+EXPOSE 9000
+
+FROM --platform=linux/amd64 python:3.11-slim AS runtime
+COPY --from=builder /src/dist /opt/server
+MCP_SERVER local
+COMMAND node
+ARGS /opt/server/index.js
+AGENT helper
+SERVERS local
+"""
+
+
+def test_multi_stage_build():
+    """Test earlier stages are emitted as written and the generated agent goes into the last one."""
+    config = AgentfileParser().parse_content(MULTI_STAGE)
+
+    with tempfile.TemporaryDirectory() as temp_dir:
+        AgentBuilder(config, temp_dir)._generate_dockerfile()
+        dockerfile_content = (Path(temp_dir) / "Dockerfile").read_text(encoding="utf-8")
+
+    builder_stage, agent_stage = dockerfile_content.split("FROM --platform=linux/amd64 python:3.11-slim AS runtime\n")
+    assert builder_stage == (
+        "# syntax=docker/dockerfile:1\n"
+        "FROM node:20 AS builder\nWORKDIR /src\nRUN npm ci && npm run build\nEXPOSE 9000\n\n"
+    )
+    assert "COPY --from=builder /src/dist /opt/server" in agent_stage
+    assert "COPY agent.py ." in agent_stage
+    assert "EXPOSE" not in agent_stage
+    assert config.base_image == "python:3.11-slim"
+    assert config.expose_ports == []
+
+
+def test_stage_named_agent_receives_the_agent():
+    """Test a stage named agent gets the generated files even when other stages follow it."""
+    content = "FROM python:3.11-slim AS agent\nEXPOSE 8080\nFROM busybox AS tools\nCMD [\"sh\"]\nEXPOSE 9000\n"
+    config = AgentfileParser().parse_content(content)
+    config.override_base_image("python:3.12-slim")
+
+    with tempfile.TemporaryDirectory() as temp_dir:
+        AgentBuilder(config, temp_dir)._generate_dockerfile()
+        dockerfile_content = (Path(temp_dir) / "Dockerfile").read_text(encoding="utf-8")
+
+    tools = dockerfile_content.index("FROM busybox AS tools")
+    assert tools < dockerfile_content.index("FROM python:3.12-slim AS agent")
+    assert dockerfile_content.rstrip().endswith('CMD ["python", "agent.py"]')
+    assert (config.cmd, config.expose_ports, config.agent_stage) == (["python", "agent.py"], [8080], 0)
+
+
+ORDERED = """FROM python:3.11-slim
+MODEL generic/llama3
+SECRET ZETA_TOKEN
+SECRET ALPHA_TOKEN
+MCP_SERVER zeta
+COMMAND uvx
+ARGS mcp-server-zeta
+MCP_SERVER alpha
+COMMAND npx
+ARGS -y alpha-mcp
+MCP_SERVER middle
+URL http://middle:8080/sse
+TRANSPORT sse
+AGENT writer
+SERVERS zeta alpha
+MODEL qwen/qwen-max
+AGENT reviewer
+SERVERS middle zeta
+MODEL deepseek/deepseek-chat
+ROUTER pick
+AGENTS writer reviewer
+CHAIN flow
+SEQUENCE reviewer writer
+DEFAULT true
+ORCHESTRATOR plan
+AGENTS writer reviewer
+EXPOSE 9090
+EXPOSE 8080
+"""
+
+
+# A configuration parsed from a string has no Agentfile to date the image by, so pin the creation time
+REPRODUCIBLE = {"SOURCE_DATE_EPOCH": "1700000000"}
+
+
+def _generate(content):
+    """Parse content afresh and return the Dockerfile generated for it."""
+    return AgentBuilder(AgentfileParser().parse_content(content), ".").dockerfile_content()
+
+
+def test_dockerfile_output_is_deterministic():
+    """Test generating the same Agentfile repeatedly gives byte-identical Dockerfiles.
+
+    The embedded configuration sorts its keys, and lists keep the order they were declared in.
+    """
+    with patch.dict(os.environ, REPRODUCIBLE):
+        first = _generate(ORDERED)
+        assert all(_generate(ORDERED) == first for _ in range(50))
+
+    assert '\\"secrets\\":[\\"ZETA_TOKEN\\",\\"ALPHA_TOKEN\\"]' in first
+    assert '\\"servers\\":[\\"zeta\\",\\"alpha\\"]' in first
+    assert first.index("EXPOSE 9090") < first.index("EXPOSE 8080")
+
+
+def test_dockerfile_output_does_not_depend_on_hash_seed():
+    """Test the Dockerfile is the same in processes that hash strings differently."""
+    code = (
+        "import sys; from agentman.agentfile_parser import AgentfileParser; "
+        "from agentman.agent_builder import AgentBuilder; "
+        "config = AgentfileParser().parse_content(sys.stdin.read()); "
+        "sys.stdout.write(AgentBuilder(config, '.').dockerfile_content())"
+    )
+    outputs = set()
+    for seed in ("1", "2", "3"):
+        env = {**os.environ, **REPRODUCIBLE, "PYTHONPATH": os.pathsep.join(sys.path), "PYTHONHASHSEED": seed}
+        result = subprocess.run(
+            [sys.executable, "-c", code], input=ORDERED, capture_output=True, text=True, check=True, env=env
+        )
+        outputs.add(result.stdout)
+
+    with patch.dict(os.environ, REPRODUCIBLE):
+        assert outputs == {_generate(ORDERED)}
+
+
+def test_serve_healthcheck():
+    """Test SERVE adds a HEALTHCHECK before the CMD, and an Agentfile HEALTHCHECK replaces it in place."""
+    dockerfile = _generate("FRAMEWORK agno\nSERVE http 8000\nAGENT a\n").splitlines()
+    healthcheck = next(line for line in dockerfile if line.startswith("HEALTHCHECK"))
+
+    assert healthcheck.startswith("HEALTHCHECK --interval=30s --timeout=10s --start-period=10s --retries=3 CMD [")
+    assert "http://localhost:8000/health" in healthcheck
+    assert dockerfile.index("EXPOSE 8000") < dockerfile.index(healthcheck) < len(dockerfile) - 1
+    assert dockerfile[-1].startswith("CMD ")
+
+    dockerfile = _generate("SERVE mcp 8080\nHEALTHCHECK --retries=5 CMD true\nRUN echo ok\nAGENT a\n").splitlines()
+    assert [line for line in dockerfile if line.startswith("HEALTHCHECK")] == ["HEALTHCHECK --retries=5 CMD true"]
+    assert dockerfile.index("HEALTHCHECK --retries=5 CMD true") < dockerfile.index("RUN echo ok")
+
+
+def test_entrypoint_with_cmd_override():
+    """Test ENTRYPOINT and a CMD overriding the default are both written in exec form, in place."""
+    content = 'AGENT a\nENTRYPOINT ["./entrypoint.sh"]\nRUN chmod +x entrypoint.sh\nCMD ["--verbose"]\n'
+    dockerfile = _generate(content).splitlines()
+
+    assert dockerfile.index('ENTRYPOINT ["./entrypoint.sh"]') < dockerfile.index("RUN chmod +x entrypoint.sh")
+    assert dockerfile[-1] == 'CMD ["--verbose"]'
+
+
 if __name__ == "__main__":
     test_dockerfile_generation_with_expose_and_cmd()
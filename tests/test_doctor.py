@@ -0,0 +1,117 @@
+"""Tests for the doctor environment checks."""
+
+import json
+import subprocess
+import tempfile
+from pathlib import Path
+from types import SimpleNamespace
+
+from agentman.agentfile_parser import AgentfileParser
+from agentman.doctor import STATUS_FAIL, STATUS_PASS, STATUS_WARN, image_registry, run_checks, run_host_checks
+
+
+class FakeRunner:
+    """Answers docker commands, optionally failing some of them."""
+
+    def __init__(self, failing=()):
+        self.failing = failing
+
+    def __call__(self, cmd, check=True, capture_output=True, text=True):
+        if cmd[1] in self.failing:
+            raise subprocess.CalledProcessError(1, cmd)
+        if cmd[1] == "version":
+            return SimpleNamespace(stdout=json.dumps({"Version": "27.0.3", "ApiVersion": "1.46"}))
+        return SimpleNamespace(stdout="github.com/docker/buildx v0.16.1")
+
+
+def which(name):
+    return f"/usr/bin/{name}"
+
+
+def reachable(url):
+    return 401
+
+
+class TestDoctor:
+    """Test suite for doctor checks."""
+
+    def test_generic_checks(self):
+        """Test checks without an Agentfile."""
+        checks = run_checks(runner=FakeRunner(), which=which, probe=reachable)
+
+        assert [c.name for c in checks] == ["docker", "docker daemon", "buildx", "registry"]
+        assert all(c.status == STATUS_PASS for c in checks)
+        assert "27.0.3" in checks[1].message
+
+    def test_missing_docker(self):
+        """Test a missing docker command fails and skips the daemon checks."""
+        checks = run_checks(runner=FakeRunner(), which=lambda name: None, probe=reachable)
+
+        assert [c.name for c in checks] == ["docker", "registry"]
+        assert checks[0].status == STATUS_FAIL
+        assert "Install docker" in str(checks[0])
+
+    def test_agentfile_features(self):
+        """Test checks for the features an Agentfile uses."""
+        content = """# syntax=docker/dockerfile:1
+FROM ghcr.io/example/base:latest
+
+MCP_SERVER github
+COMMAND docker
+ARGS run -i --rm mcp/github
+ALLOW_DOCKER true
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            checks = run_checks(
+                config,
+                runner=FakeRunner(failing=["buildx"]),
+                which=which,
+                probe=lambda url: 200 if url == "https://ghcr.io/v2/" else 404,
+                socket_path=Path(temp_dir) / "docker.sock",
+            )
+
+        statuses = {c.name: c.status for c in checks}
+        assert statuses["buildx"] == STATUS_FAIL
+        assert statuses["registry"] == STATUS_PASS
+        assert statuses["docker socket"] == STATUS_FAIL
+        assert statuses["pip"] == STATUS_PASS
+
+    def test_buildx_required_for_the_config_heredoc(self):
+        """Test fast-agent, whose config is written as a heredoc, needs buildx and agno only warns."""
+        for framework, status in [("fast-agent", STATUS_FAIL), ("agno", STATUS_WARN)]:
+            config = AgentfileParser().parse_content(f"FRAMEWORK {framework}\nAGENT helper\n")
+            checks = run_checks(config, runner=FakeRunner(failing=["buildx"]), which=which, probe=reachable)
+            assert {c.name: c.status for c in checks}["buildx"] == status
+
+    def test_unreachable_registry(self):
+        """Test an unreachable registry is a warning."""
+
+        def unreachable(url):
+            raise OSError("timed out")
+
+        checks = run_checks(runner=FakeRunner(), which=which, probe=unreachable)
+        assert checks[-1].status == STATUS_WARN
+
+    def test_image_registry(self):
+        """Test registry hosts are derived from image references."""
+        assert image_registry("yeahdongcn/agentman-base:latest") == "docker.io"
+        assert image_registry("python:3.11") == "docker.io"
+        assert image_registry("ghcr.io/org/image") == "ghcr.io"
+        assert image_registry("localhost:5000/image") == "localhost:5000"
+
+    def test_host_checks(self):
+        """Test the checks for running an agent with the host Python."""
+        config = AgentfileParser().parse_content(
+            "MCP_SERVER fetch\nCOMMAND uvx\n\nMCP_SERVER remote\nTRANSPORT sse\nURL http://localhost:8080/sse"
+        )
+        checks = run_host_checks(config, which=lambda name: None, find_spec=lambda name: None, version_info=(3, 9, 6))
+
+        assert [c.name for c in checks] == ["python", "fast-agent", "uvx"]
+        assert all(c.status == STATUS_FAIL for c in checks)
+        assert "Use Python 3.10 or newer" in str(checks[0])
+        assert "pip install fast-agent-mcp" in str(checks[1])
+
+        checks = run_host_checks(config, which=which, find_spec=lambda name: object(), version_info=(3, 12, 1))
+        assert all(c.status == STATUS_PASS for c in checks)
@@ -0,0 +1,87 @@
+"""Tests for running generated agents on the host."""
+
+import io
+import shutil
+import tempfile
+from contextlib import redirect_stdout
+from pathlib import Path
+
+import pytest
+
+from agentman.agentfile_parser import AgentfileParser
+from agentman.dryrun import DRYRUN_PREFIX, dry_run
+
+AGENTFILE = """
+MODEL anthropic/claude-3-sonnet-20241022
+
+MCP_SERVER fetch
+COMMAND uvx
+ARGS mcp-server-fetch
+
+AGENT researcher
+INSTRUCTION Research the topic
+SERVERS fetch
+
+AGENT writer
+INSTRUCTION Write the report
+DEFAULT true
+"""
+
+
+class FakeRunner:
+    """Records the command and what the agent would see in its working directory."""
+
+    def __init__(self, returncode=0):
+        self.returncode = returncode
+        self.calls = []
+
+    def __call__(self, cmd, cwd=None, check=False):
+        cwd = Path(cwd)
+        self.calls.append(
+            {
+                "cmd": cmd,
+                "cwd": cwd,
+                "agent": (cwd / "agent.py").read_text(encoding="utf-8"),
+                "prompt": (cwd / "prompt.txt").read_text(encoding="utf-8") if (cwd / "prompt.txt").exists() else None,
+            }
+        )
+        return type("Result", (), {"returncode": self.returncode})()
+
+
+class TestDryRun:
+    """Test suite for dry_run."""
+
+    def test_runs_selected_agent_with_prompt(self):
+        """Test the selected agent becomes the default and receives the prompt, and the files are removed."""
+        runner = FakeRunner(returncode=3)
+        config = AgentfileParser().parse_content(AGENTFILE)
+        with tempfile.TemporaryDirectory() as source_dir, redirect_stdout(io.StringIO()):
+            code = dry_run(config, source_dir, agent="researcher", prompt="test", runner=runner)
+
+        call = runner.calls[0]
+        assert code == 3
+        assert call["cmd"][1:] == ["agent.py"]
+        assert call["cwd"].name.startswith(DRYRUN_PREFIX)
+        assert call["prompt"] == "test\n"
+        assert 'name="researcher"' in call["agent"] and "default=True" in call["agent"]
+        assert 'name="writer"' not in call["agent"]
+        assert not call["cwd"].exists()
+
+    def test_keep_preserves_the_directory(self):
+        """Test --keep leaves the generated files behind and says where they are."""
+        runner = FakeRunner()
+        config = AgentfileParser().parse_content(AGENTFILE)
+        with tempfile.TemporaryDirectory() as source_dir, redirect_stdout(io.StringIO()) as output:
+            dry_run(config, source_dir, keep=True, runner=runner)
+
+        work_dir = runner.calls[0]["cwd"]
+        assert work_dir.exists() and runner.calls[0]["prompt"] is None
+        assert f"Kept generated files in {work_dir}" in output.getvalue()
+        shutil.rmtree(work_dir)
+
+    def test_unknown_agent(self):
+        """Test an unknown --agent lists the available names."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+
+        with pytest.raises(ValueError, match="Available: researcher, writer"):
+            dry_run(config, agent="editor", runner=FakeRunner())
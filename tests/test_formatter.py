@@ -0,0 +1,97 @@
+"""Tests for canonical Agentfile formatting."""
+
+import pytest
+
+from agentman.agentfile_parser import AgentfileParser
+from agentman.formatter import format_agentfile
+
+MESSY = """# escape=\\
+# Base image
+from python:3.11
+run apt-get update && \\
+    apt-get install -y   curl
+
+
+# The fetcher
+mcp_server fetch   # inline comment
+command    uvx
+    ARGS ["--root", "/data with spaces"] # trailing
+
+
+  env TOKEN="a b"
+end
+env LOG_LEVEL=debug
+SECRET GITHUB_TOKEN
+SECRET openai
+api_key sk-example
+agent helper
+# Keep it short
+instruction   Fetch   pages
+servers fetch
+cmd ["python", "agent.py"]
+# The end
+"""
+
+FORMATTED = """# escape=\\
+
+# Base image
+FROM python:3.11
+RUN apt-get update && \\
+    apt-get install -y   curl
+
+# The fetcher
+MCP_SERVER fetch  # inline comment
+COMMAND uvx
+ARGS ["--root", "/data with spaces"]  # trailing
+ENV TOKEN="a b"
+END
+
+ENV LOG_LEVEL=debug
+SECRET GITHUB_TOKEN
+
+SECRET openai
+API_KEY sk-example
+
+AGENT helper
+# Keep it short
+INSTRUCTION Fetch pages
+SERVERS fetch
+
+CMD ["python", "agent.py"]
+
+# The end
+"""
+
+
+class TestFormatter:
+    """Test suite for format_agentfile."""
+
+    def test_canonical_form(self):
+        """Test keywords are upper-cased, blocks set apart and comments kept before their statements."""
+        assert format_agentfile(MESSY) == FORMATTED
+
+    def test_formatting_is_idempotent(self):
+        """Test formatted text is left unchanged."""
+        assert format_agentfile(FORMATTED) == FORMATTED
+
+    def test_configuration_is_unchanged(self):
+        """Test the formatted file parses to the same configuration."""
+        assert AgentfileParser().parse_content(FORMATTED) == AgentfileParser().parse_content(MESSY)
+
+    def test_indent_applies_to_sub_instructions(self):
+        """Test --indent indents sub-instructions and their comments but not END or the declaration."""
+        text = format_agentfile("AGENT a\n# why\nMODEL openai/gpt-4o\nMCP_SERVER s\nCOMMAND uvx\nEND\n", indent=4)
+
+        assert text == "AGENT a\n    # why\n    MODEL openai/gpt-4o\n\nMCP_SERVER s\n    COMMAND uvx\nEND\n"
+
+    def test_heredocs_and_multiline_values_are_kept(self):
+        """Test heredoc bodies and triple-quoted values are written as they were."""
+        content = 'AGENT a\ninstruction <<EOF\n  # not a comment\nEOF\nSECRET CERT """one\ntwo"""\n'
+
+        expected = 'AGENT a\nINSTRUCTION <<EOF\n  # not a comment\nEOF\n\nSECRET CERT """one\ntwo"""\n'
+        assert format_agentfile(content) == expected
+
+    def test_broken_file_is_an_error(self):
+        """Test a file that does not parse is reported instead of formatted."""
+        with pytest.raises(ValueError, match="Unterminated"):
+            format_agentfile('AGENT a\nINSTRUCTION """open\n')
@@ -1,11 +1,14 @@
 """Tests for framework support functionality."""
 
+import ast
 import pytest
 from src.agentman.agentfile_parser import AgentfileParser
 from src.agentman.agent_builder import AgentBuilder
 import tempfile
 from pathlib import Path
 
+import yaml
+
 
 class TestFrameworkSupport:
     """Test framework detection and code generation."""
@@ -145,6 +148,197 @@ SERVERS finance
             assert "DuckDuckGoTools()" in code
             assert "YFinanceTools(stock_price=True, analyst_recommendations=True)" in code
 
+    def test_agno_orchestrator_is_the_team(self):
+        """Test an Agno orchestrator names the team, picks its members in order and sets its model."""
+        content = """
+FRAMEWORK agno
+MODEL anthropic/claude-3-sonnet-20241022
+AGENT researcher
+AGENT analyst
+AGENT writer
+ORCHESTRATOR research_lead
+AGENTS writer researcher
+MODEL openai/gpt-4o
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            code = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        team = code[code.index("agentteam = Team(") :]
+        assert 'name="research_lead"' in team
+        assert "members=[writer_agent, researcher_agent]" in team
+        assert 'id="openai/gpt-4o"' in team
+
+    def test_agno_orchestrator_instruction(self):
+        """Test an Agno orchestrator's INSTRUCTION replaces the team's stock instructions."""
+        content = """
+FRAMEWORK agno
+MODEL anthropic/claude-3-sonnet-20241022
+AGENT researcher
+ORCHESTRATOR research_lead
+AGENTS researcher
+INSTRUCTION "Split the question into parts, then merge the answers"
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            code = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert 'instructions="""Split the question into parts, then merge the answers""",' in code
+        assert "Collaborate to provide comprehensive responses" not in code
+
+    def test_agno_parallel_is_the_team(self):
+        """Test an Agno parallel collaborates over its FAN_OUT, led by the FAN_IN agent's model and instruction."""
+        content = """
+FRAMEWORK agno
+MODEL anthropic/claude-3-sonnet-20241022
+AGENT legal
+AGENT finance
+AGENT summarizer
+MODEL openai/gpt-4o
+INSTRUCTION "Merge the reviews into one answer"
+PARALLEL review
+FAN_OUT legal finance
+FAN_IN summarizer
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            code = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        team = code[code.index("agentteam = Team(") :]
+        assert 'name="review"' in team
+        assert "mode='collaborate'" in team
+        assert "members=[legal_agent, finance_agent]" in team
+        assert 'id="openai/gpt-4o"' in team
+        assert 'instructions="""Merge the reviews into one answer""",' in team
+
+    def test_agno_serve_generation(self):
+        """Test SERVE http runs the agent behind a FastAPI app on its port and path instead of a console turn."""
+        config = AgentfileParser().parse_content("FRAMEWORK agno\nSERVE http 8000 /ask\nAGENT helper\n")
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            framework = AgentBuilder(config, temp_dir).framework
+            code = framework.build_agent_content()
+            requirements = framework.get_requirements()
+
+        ast.parse(code)
+        assert '@app.post("/ask")' in code
+        assert "response = await helper_agent.arun(request.message)" in code
+        assert '@app.get("/health")' in code
+        assert 'uvicorn.run(app, host="0.0.0.0", port=8000, timeout_graceful_shutdown=SHUTDOWN_GRACE)' in code
+        assert "print_response(" not in code
+        assert "install_shutdown_handlers" not in code
+        assert {"fastapi", "uvicorn"} <= set(requirements)
+
+        config = AgentfileParser().parse_content("FRAMEWORK agno\nSERVE http 8000\nAGENT a\nAGENT b\n")
+        with tempfile.TemporaryDirectory() as temp_dir:
+            code = AgentBuilder(config, temp_dir).framework.build_agent_content()
+        assert '@app.post("/run")' in code
+        assert "response = await agentteam.arun(request.message)" in code
+
+    def test_temperature_generation(self):
+        """Test TEMPERATURE reaches fast-agent request params and Agno model arguments."""
+        content = """
+MODEL openai/gpt-4o
+TEMPERATURE 0.3
+AGENT writer
+TEMPERATURE 0.9
+AGENT extractor
+MODEL anthropic/claude-3-haiku
+TEMPERATURE 0
+"""
+        config = AgentfileParser().parse_content(content)
+        with tempfile.TemporaryDirectory() as temp_dir:
+            fast_agent = AgentBuilder(config, temp_dir).framework.build_agent_content()
+            config.framework = "agno"
+            agno = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert "from mcp_agent.core.request_params import RequestParams" in fast_agent
+        assert "request_params=RequestParams(temperature=0.9)" in fast_agent
+        assert "request_params=RequestParams(temperature=0.0)" in fast_agent
+        assert "        temperature=0.9,\n    )," in agno
+        assert 'model=Claude(id="anthropic/claude-3-haiku", temperature=0.0),' in agno
+
+    def test_max_tokens_generation(self):
+        """Test MAX_TOKENS becomes maxTokens for fast-agent and max_tokens for Agno."""
+        config = AgentfileParser().parse_content("MODEL anthropic/claude-3-haiku\nMAX_TOKENS 2048\nAGENT writer\n")
+        with tempfile.TemporaryDirectory() as temp_dir:
+            fast_agent = AgentBuilder(config, temp_dir).framework.build_agent_content()
+            config.framework = "agno"
+            agno = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert "request_params=RequestParams(maxTokens=2048)" in fast_agent
+        assert 'model=Claude(id="anthropic/claude-3-haiku", max_tokens=2048),' in agno
+
+    def test_sampling_settings_generation(self):
+        """Test TOP_P, TOP_K and STOP use each framework's names, and top_k reaches OpenAI-like servers."""
+        content = """
+MODEL anthropic/claude-3-haiku
+AGENT writer
+TOP_P 0.9
+TOP_K 40
+STOP "###" "END OF ANSWER"
+AGENT local
+MODEL ollama/llama3
+TOP_K 20
+"""
+        config = AgentfileParser().parse_content(content)
+        with tempfile.TemporaryDirectory() as temp_dir:
+            fast_agent = AgentBuilder(config, temp_dir).framework.build_agent_content()
+            config.framework = "agno"
+            agno = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert 'RequestParams(top_p=0.9, top_k=40, stopSequences=["###", "END OF ANSWER"])' in fast_agent
+        assert 'top_p=0.9, top_k=40, stop_sequences=["###", "END OF ANSWER"]),' in agno
+        assert '        extra_body={"top_k": 20},\n    ),' in agno
+
+    def test_resources_generation(self):
+        """Test the fast-agent main attaches each agent's RESOURCES before the conversation starts."""
+        content = "MCP_SERVER docs\nCOMMAND npx\nAGENT writer\nSERVERS docs\nRESOURCES file:///app/docs/spec.md\n"
+        config = AgentfileParser().parse_content(content)
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agent = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert 'for name, uris in {"writer": ["file:///app/docs/spec.md"]}.items():' in agent
+        assert 'await agent[name].with_resource("Keep this resource in mind.", uri)' in agent
+        compile(agent, "agent.py", "exec")
+
+    def test_basic_auth_generation(self):
+        """Test the fast-agent main encodes AUTH basic credentials into the variable the config reads."""
+        content = (
+            "MCP_SERVER my-api\nTRANSPORT http\nURL https://api.example.com/mcp\nAUTH basic bot:$PASS\n"
+            "SECRET PASS\nAGENT writer\nSERVERS my-api\n"
+        )
+        config = AgentfileParser().parse_content(content)
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agent = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert (
+            'os.environ["AGENTMAN_AUTH_MY_API"] = '
+            '"Basic " + base64.b64encode(f"bot:{os.environ.get(\'PASS\', \'\')}".encode()).decode()'
+        ) in agent
+        assert agent.index("AGENTMAN_AUTH_MY_API") < agent.index("fast = FastAgent(")
+        compile(agent, "agent.py", "exec")
+
+    def test_timeout_and_retries_generation(self):
+        """Test REQUEST_TIMEOUT and MAX_RETRIES reach the request params and the model client."""
+        content = """
+MODEL openai/gpt-4o
+AGENT writer
+REQUEST_TIMEOUT 2m
+MAX_RETRIES 3
+"""
+        config = AgentfileParser().parse_content(content)
+        with tempfile.TemporaryDirectory() as temp_dir:
+            fast_agent = AgentBuilder(config, temp_dir).framework.build_agent_content()
+            config.framework = "agno"
+            agno = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert "RequestParams(request_timeout=120, max_retries=3)" in fast_agent
+        assert '        timeout=120,\n        max_retries=3,\n    ),' in agno
+
     def test_fast_agent_requirements(self):
         """Test FastAgent requirements generation."""
         content = """
@@ -230,6 +424,264 @@ SECRET ANTHROPIC_API_KEY
             assert (Path(temp_dir) / "fastagent.secrets.yaml").exists()
             assert not (Path(temp_dir) / ".env").exists()
 
+    def test_fast_agent_combined_config_matches_split(self):
+        """Test the combined config holds exactly the merged split config and secrets."""
+        content = """
+FROM yeahdongcn/agentman-base:latest
+MODEL anthropic/claude-3-sonnet-20241022
+SECRET ANTHROPIC_API_KEY
+SECRET GITHUB_TOKEN ghp_example
+MCP_SERVER github
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-github
+ENV GITHUB_PERSONAL_ACCESS_TOKEN $GITHUB_TOKEN
+AGENT test
+INSTRUCTION Test agent
+SERVERS github
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as split_dir, tempfile.TemporaryDirectory() as combined_dir:
+            AgentBuilder(config, split_dir).framework.generate_config_files()
+            combined = AgentBuilder(config, combined_dir, combined_config=True)
+            combined.framework.generate_config_files()
+
+            def load(path):
+                with open(path, 'r', encoding='utf-8') as f:
+                    return yaml.safe_load(f)
+
+            def merge(base, overlay):
+                for key, value in overlay.items():
+                    if isinstance(value, dict) and isinstance(base.get(key), dict):
+                        merge(base[key], value)
+                    else:
+                        base[key] = value
+                return base
+
+            split = merge(
+                load(Path(split_dir) / "fastagent.config.yaml"), load(Path(split_dir) / "fastagent.secrets.yaml")
+            )
+            assert load(Path(combined_dir) / "fastagent.config.yaml") == split
+            assert not (Path(combined_dir) / "fastagent.secrets.yaml").exists()
+            assert combined.framework.get_dockerfile_config_lines() == ["COPY fastagent.config.yaml ."]
+
+    def test_fast_agent_config_matches_known_good_config(self):
+        """Test fastagent.config.yaml carries the model, logger defaults and servers the way fast-agent reads them."""
+        content = """
+MODEL anthropic/claude-3-sonnet-20241022
+SECRET GITHUB_PERSONAL_ACCESS_TOKEN
+MCP_SERVER fetch npx:@modelcontextprotocol/server-fetch
+MCP_SERVER github
+MCP_SERVER docs
+TRANSPORT http
+URL https://docs.example.com/mcp
+AUTH bearer $GITHUB_PERSONAL_ACCESS_TOKEN
+AGENT helper
+SERVERS fetch github docs
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            AgentBuilder(config, temp_dir).framework.generate_config_files()
+            with open(Path(temp_dir) / "fastagent.config.yaml", 'r', encoding='utf-8') as f:
+                config_yaml = yaml.safe_load(f)
+
+        assert config_yaml == {
+            "default_model": "anthropic/claude-3-sonnet-20241022",
+            "logger": {
+                "level": "info",
+                "progress_display": True,
+                "show_chat": True,
+                "show_tools": True,
+                "truncate_tools": True,
+            },
+            "mcp": {
+                "servers": {
+                    "fetch": {
+                        "transport": "stdio",
+                        "command": "npx",
+                        "args": ["-y", "@modelcontextprotocol/server-fetch"],
+                    },
+                    "github": {
+                        "transport": "stdio",
+                        "command": "npx",
+                        "args": ["-y", "@modelcontextprotocol/server-github"],
+                        "env": {"GITHUB_PERSONAL_ACCESS_TOKEN": "${GITHUB_PERSONAL_ACCESS_TOKEN}"},
+                    },
+                    "docs": {
+                        "transport": "http",
+                        "url": "https://docs.example.com/mcp",
+                        "headers": {"Authorization": "Bearer ${GITHUB_PERSONAL_ACCESS_TOKEN}"},
+                    },
+                }
+            },
+        }
+
+    def test_fast_agent_embedded_config(self):
+        """Test fastagent.config.yaml is written as a quoted heredoc by default and the secrets are still copied."""
+        content = "MCP_SERVER fetch\nAGENT helper\nSERVERS fetch\n"
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir)
+            dockerfile = builder.dockerfile_content()
+            lines = dockerfile.splitlines()
+            start = lines.index("COPY <<'FASTAGENT_CONFIG' fastagent.config.yaml")
+            end = lines.index("FASTAGENT_CONFIG")
+
+            assert lines[0] == "# syntax=docker/dockerfile:1"
+            assert "\n".join(lines[start + 1 : end]) + "\n" == builder.framework.config_yaml_text()
+            assert lines[end + 1] == "COPY fastagent.secrets.yaml ."
+            assert "COPY fastagent.config.yaml ." not in lines
+
+            # --copy-config, and --combined-config whose file holds the secrets, copy it from the build context
+            for options in [{"copy_config": True}, {"combined_config": True}]:
+                lines = AgentBuilder(config, temp_dir, **options).dockerfile_content().splitlines()
+                assert "COPY fastagent.config.yaml ." in lines
+                assert "FASTAGENT_CONFIG" not in lines
+
+    def test_fast_agent_base_url_per_provider(self):
+        """Test agent BASE_URL overrides become provider base_urls that win over secrets."""
+        content = """
+FROM yeahdongcn/agentman-base:latest
+MODEL generic.llama3
+SECRET OPENAI
+API_KEY sk-example
+BASE_URL https://api.openai.com/v1
+AGENT local
+MODEL openai.gpt-4o
+BASE_URL $LOCAL_BASE_URL
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            AgentBuilder(config, temp_dir).framework.generate_config_files()
+            with open(Path(temp_dir) / "fastagent.config.yaml", 'r', encoding='utf-8') as f:
+                config_yaml = yaml.safe_load(f)
+            with open(Path(temp_dir) / "fastagent.secrets.yaml", 'r', encoding='utf-8') as f:
+                secrets_yaml = yaml.safe_load(f)
+
+        assert config_yaml["openai"] == {"base_url": "${LOCAL_BASE_URL}"}
+        assert secrets_yaml["openai"] == {"api_key": "${OPENAI_API_KEY}"}
+
+    def test_fast_agent_server_transports(self):
+        """Test each TRANSPORT is written with the key fast-agent reads, keeping streamable HTTP apart from SSE."""
+        content = """
+MCP_SERVER local
+COMMAND uvx
+ARGS mcp-server-fetch
+
+MCP_SERVER events
+TRANSPORT sse
+URL https://mcp.example.com/sse
+
+MCP_SERVER search
+TRANSPORT http
+URL https://mcp.example.com/mcp
+
+MCP_SERVER docs
+TRANSPORT streamable-http
+URL https://docs.example.com/mcp
+
+AGENT helper
+SERVERS local events search docs
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            AgentBuilder(config, temp_dir).framework.generate_config_files()
+            with open(Path(temp_dir) / "fastagent.config.yaml", 'r', encoding='utf-8') as f:
+                servers = yaml.safe_load(f)["mcp"]["servers"]
+
+        assert servers["local"] == {"transport": "stdio", "command": "uvx", "args": ["mcp-server-fetch"]}
+        assert servers["events"] == {"transport": "sse", "url": "https://mcp.example.com/sse"}
+        assert servers["search"] == {"transport": "http", "url": "https://mcp.example.com/mcp"}
+        assert servers["docs"] == {"transport": "http", "url": "https://docs.example.com/mcp"}
+
+    def test_fast_agent_elicitation_modes(self):
+        """Test ELICITATION_MODE is written per server as fast-agent's elicitation mode."""
+        content = """
+MCP_SERVER forms
+COMMAND uvx
+ARGS forms-server
+ELICITATION_MODE forms
+
+MCP_SERVER quiet command=uvx args=quiet-server elicitation_mode=auto
+
+MCP_SERVER plain
+COMMAND uvx
+ARGS plain-server
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            AgentBuilder(config, temp_dir).framework.generate_config_files()
+            with open(Path(temp_dir) / "fastagent.config.yaml", 'r', encoding='utf-8') as f:
+                servers = yaml.safe_load(f)["mcp"]["servers"]
+
+        assert servers["forms"]["elicitation"] == {"mode": "forms"}
+        assert servers["quiet"]["elicitation"] == {"mode": "auto-cancel"}
+        assert "elicitation" not in servers["plain"]
+
+    def test_agno_rejects_elicitation(self):
+        """Test agno reports elicitation as unsupported instead of dropping it."""
+        content = "FRAMEWORK agno\nMCP_SERVER forms\nCOMMAND uvx\nELICITATION_MODE forms\n"
+        with pytest.raises(ValueError, match="FRAMEWORK agno does not support MCP elicitation"):
+            AgentfileParser().parse_content(content)
+
+    def test_fast_agent_conflicting_base_urls(self):
+        """Test agents sharing a provider cannot use different endpoints under fast-agent."""
+        content = """
+AGENT local
+MODEL openai.gpt-4o
+BASE_URL http://localhost:11434/v1
+AGENT remote
+MODEL openai.gpt-4o-mini
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            with pytest.raises(ValueError, match="one base_url per provider"):
+                AgentBuilder(config, temp_dir).framework.generate_config_files()
+
+    def test_agno_base_url_per_agent(self):
+        """Test Agno agents get their own base_url."""
+        content = """
+FRAMEWORK agno
+AGENT local
+MODEL openai/llama3
+BASE_URL http://localhost:11434/v1
+AGENT claude
+MODEL anthropic/claude-3-sonnet-20241022
+BASE_URL $CLAUDE_BASE_URL
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            content = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert 'base_url="http://localhost:11434/v1",' in content
+        assert 'client_params={"base_url": os.getenv("CLAUDE_BASE_URL")}' in content
+
+    def test_generated_agents_handle_shutdown_signals(self):
+        """Test both frameworks trap SIGTERM/SIGINT and honour SHUTDOWN_GRACE."""
+        for framework in ["fast-agent", "agno"]:
+            content = f"""
+FRAMEWORK {framework}
+SHUTDOWN_GRACE 20s
+AGENT test
+INSTRUCTION Test agent
+"""
+            config = AgentfileParser().parse_content(content)
+
+            with tempfile.TemporaryDirectory() as temp_dir:
+                code = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+            compile(code, "agent.py", "exec")
+            assert "SHUTDOWN_GRACE = 20" in code
+            assert "for signum in (signal.SIGTERM, signal.SIGINT):" in code
+            assert "install_shutdown_handlers(" in code.split("def main()", 1)[1]
+
     def test_agno_config_generation(self):
         """Test Agno config file generation."""
         content = """
@@ -271,7 +723,7 @@ INSTRUCTION Test agent
 
             with open(Path(temp_dir) / "Dockerfile", 'r') as f:
                 dockerfile_content = f.read()
-                assert "COPY fastagent.config.yaml ." in dockerfile_content
+                assert "COPY <<'FASTAGENT_CONFIG' fastagent.config.yaml" in dockerfile_content
                 assert "COPY fastagent.secrets.yaml ." in dockerfile_content
                 assert "COPY .env ." not in dockerfile_content
 
@@ -352,3 +804,91 @@ HUMAN_INPUT false
         assert agent.use_history is True
         assert agent.human_input is False
         assert agent.servers == ["web_search"]
+
+    def test_fast_agent_workflow_models(self):
+        """Test a chain's MODEL reaches its steps' decorators and an orchestrator's MODEL its planner."""
+        content = """FROM yeahdongcn/agentman-base:latest
+MODEL openai/gpt-4o-mini
+AGENT fetcher
+AGENT writer
+CHAIN pipeline
+SEQUENCE fetcher writer
+MODEL qwen/qwen-max
+DEFAULT true
+ORCHESTRATOR planner
+AGENTS fetcher writer
+MODEL deepseek/deepseek-chat
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agent_py = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        assert agent_py.count('model="qwen/qwen-max"') == 2
+        assert 'model="openai/gpt-4o-mini"' not in agent_py
+        planner = agent_py[agent_py.index("@fast.orchestrator(") :]
+        assert 'model="deepseek/deepseek-chat"' in planner
+
+    def test_fast_agent_human_input_modes(self):
+        """Test webhook mode posts questions to its URL instead of the console, and disabled removes the handler."""
+        content = "HUMAN_INPUT_MODE webhook https://hooks.example.com/input\nAGENT helper\nHUMAN_INPUT true\n"
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agent_py = AgentBuilder(config, temp_dir).framework.build_agent_content()
+
+        ast.parse(agent_py)
+        assert 'HUMAN_INPUT_WEBHOOK = "https://hooks.example.com/input"' in agent_py
+        assert "fast.app._human_input_callback = webhook_input_callback" in agent_py
+        assert agent_py.index("import urllib.request") < agent_py.index("fast = FastAgent(")
+
+        for content, expected in [
+            ("HUMAN_INPUT_MODE disabled\nAGENT helper\n", "fast.app._human_input_callback = None"),
+            ("AGENT helper\nHUMAN_INPUT true\n", None),
+        ]:
+            config = AgentfileParser().parse_content(content)
+            with tempfile.TemporaryDirectory() as temp_dir:
+                agent_py = AgentBuilder(config, temp_dir).framework.build_agent_content()
+            assert ("_human_input_callback" in agent_py) == bool(expected)
+            assert expected is None or expected in agent_py
+
+    def test_fast_agent_serve_generation(self):
+        """Test SERVE mcp exposes its port and starts the generated agent as an MCP server."""
+        config = AgentfileParser().parse_content("EXPOSE 9090\nSERVE mcp 8080\nAGENT helper\n")
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir)
+            dockerfile = builder.dockerfile_content().splitlines()
+            agent_py = builder.framework.build_agent_content()
+
+        ast.parse(agent_py)
+        assert "async with fast.run() as agent:" in agent_py
+        assert "serves the agents on port 8080" in agent_py
+        assert [line for line in dockerfile if line.startswith(("EXPOSE", "CMD"))] == [
+            "EXPOSE 9090",
+            "EXPOSE 8080",
+            'CMD ["python", "agent.py", "--server", "--transport", "http", "--port", "8080"]',
+        ]
+
+    def test_fast_agent_parallel_generation(self):
+        """Test a parallel becomes a @fast.parallel decorator a chain can run as a step."""
+        content = """
+AGENT legal
+AGENT finance
+AGENT summarizer
+PARALLEL review
+FAN_OUT legal finance
+FAN_IN summarizer
+CHAIN pipeline
+SEQUENCE review summarizer
+DEFAULT true
+"""
+        config = AgentfileParser().parse_content(content)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agent_py = AgentBuilder(config, temp_dir, annotate=True).framework.build_agent_content()
+
+        decorator = '@fast.parallel(\n    name="review",\n    fan_out=["legal", "finance"],\n    fan_in="summarizer"\n)'
+        assert decorator in agent_py
+        assert 'sequence=["review", "summarizer"]' in agent_py
+        assert "# Agentfile:5 PARALLEL review" in agent_py
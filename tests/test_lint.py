@@ -0,0 +1,135 @@
+"""Tests for Agentfile lint rules."""
+
+from agentman.agentfile_parser import AgentfileParser
+from agentman.diagnostics import Diagnostic
+from agentman.lint import MAX_INSTRUCTION_LENGTH, RULES, Rule, lint_config
+
+
+class TestLint:
+    """Test suite for lint rules."""
+
+    def test_unpinned_package(self):
+        """Test unpinned npx/uvx packages are flagged."""
+        content = """
+MCP_SERVER fetch
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-fetch
+
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem@2025.1.14 /data
+"""
+        diagnostics = lint_config(AgentfileParser().parse_content(content))
+
+        assert [d.code for d in diagnostics] == ["unpinned-package"]
+        assert "fetch" in diagnostics[0].message
+        assert diagnostics[0].severity == "warning"
+
+    def test_add_without_checksum(self):
+        """Test URL downloads without a checksum are flagged."""
+        content = """
+ADD https://example.com/data.tar.gz /app/data/
+ADD --checksum=sha256:""" + "b" * 64 + """ https://example.com/other.tar.gz /app/other/
+ADD local.tar.gz /app/local/
+"""
+        diagnostics = lint_config(AgentfileParser().parse_content(content))
+
+        assert [d.code for d in diagnostics] == ["add-without-checksum"]
+        assert diagnostics[0].line == 2
+        assert "https://example.com/data.tar.gz" in diagnostics[0].message
+
+    def test_base_url_provider_mismatch(self):
+        """Test a BASE_URL on another provider's public host is flagged."""
+        content = """
+AGENT wrong
+MODEL anthropic/claude-3-sonnet-20241022
+BASE_URL https://api.openai.com/v1
+
+AGENT local
+MODEL openai/llama3
+BASE_URL http://localhost:11434/v1
+"""
+        diagnostics = lint_config(AgentfileParser().parse_content(content))
+
+        assert [d.code for d in diagnostics] == ["base-url-provider-mismatch"]
+        assert diagnostics[0].line == 2
+        assert "openai BASE_URL" in diagnostics[0].message
+
+    def test_inline_secret_context_values(self):
+        """Test values typed into a SECRET block are flagged, references are not."""
+        content = """
+SECRET openai
+API_KEY sk-example
+BASE_URL $OPENAI_BASE_URL
+
+SECRET anthropic
+API_KEY $ANTHROPIC_API_KEY
+"""
+        diagnostics = lint_config(AgentfileParser().parse_content(content))
+
+        assert [(d.code, d.line) for d in diagnostics] == [("secret-inline-value", 2)]
+        assert "SECRET openai sets API_KEY inline" in diagnostics[0].message
+
+    def test_expose_without_listener(self):
+        """Test EXPOSE is flagged when only the generated agent runs."""
+        content = """
+AGENT helper
+EXPOSE 8080
+"""
+        diagnostics = lint_config(AgentfileParser().parse_content(content))
+
+        assert [(d.code, d.line) for d in diagnostics] == [("expose-without-listener", 3)]
+
+        served = lint_config(AgentfileParser().parse_content(content + 'CMD ["python", "server.py"]\n'))
+        assert "expose-without-listener" not in [d.code for d in served]
+
+    def test_single_step_chain(self):
+        """Test chains with one step are flagged."""
+        content = """
+AGENT a
+AGENT b
+CHAIN one
+SEQUENCE a
+CHAIN two
+SEQUENCE a b
+"""
+        diagnostics = lint_config(AgentfileParser().parse_content(content))
+
+        assert [(d.code, d.line) for d in diagnostics] == [("single-step-chain", 4)]
+
+    def test_long_instruction(self):
+        """Test INSTRUCTIONs over the length threshold are flagged."""
+        content = f"""
+AGENT short
+INSTRUCTION {"x" * MAX_INSTRUCTION_LENGTH}
+AGENT long
+INSTRUCTION {"x" * (MAX_INSTRUCTION_LENGTH + 1)}
+"""
+        diagnostics = lint_config(AgentfileParser().parse_content(content))
+
+        assert [(d.code, d.line) for d in diagnostics] == [("long-instruction", 4)]
+        assert "Agent long" in diagnostics[0].message
+
+    def test_disabled_rules_are_skipped(self):
+        """Test rules can be disabled by ID."""
+        content = """
+AGENT a
+CHAIN one
+SEQUENCE a
+EXPOSE 8080
+"""
+        config = AgentfileParser().parse_content(content)
+
+        assert [d.code for d in lint_config(config, disabled=["single-step-chain"])] == ["expose-without-listener"]
+
+    def test_custom_rules(self):
+        """Test callers can run their own rules alongside the built-in ones."""
+
+        def check_models(config):
+            return [Diagnostic("warning", "no-model", f"Agent {a.name} has no MODEL") for a in config.agents.values()]
+
+        config = AgentfileParser().parse_content("AGENT a\n")
+        rules = RULES + [Rule("no-model", "agents without a MODEL", check_models)]
+
+        assert [d.code for d in lint_config(config, rules=rules)] == ["no-model"]
+        assert len({rule.id for rule in RULES}) == len(RULES)
@@ -0,0 +1,107 @@
+"""Tests for Agentfile.lock generation and consumption."""
+
+import io
+import tempfile
+from contextlib import redirect_stderr, redirect_stdout
+from pathlib import Path
+
+import pytest
+
+from agentman.agent_builder import AgentBuilder
+from agentman.agentfile_parser import AgentfileParser
+from agentman.lockfile import LockApplier, generate_lock, lockfile_path, package_name, read_lock, write_lock
+
+AGENTFILE = """
+FROM yeahdongcn/agentman-base:latest
+MODEL anthropic/claude-3-sonnet-20241022
+
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem /data
+
+MCP_SERVER time
+COMMAND uvx
+ARGS mcp-server-time
+"""
+
+
+def fake_registry(url):
+    """Answer registry lookups with fixed versions."""
+    if "registry.npmjs.org" in url:
+        return {"version": "2025.1.14"}
+    return {"info": {"version": "1.2.3"}}
+
+
+class TestLockfile:
+    """Test suite for the lockfile module."""
+
+    def test_package_name(self):
+        """Test version constraints are stripped from specs."""
+        assert package_name("npm", "@modelcontextprotocol/server-fetch@1.0.0") == "@modelcontextprotocol/server-fetch"
+        assert package_name("npm", "@modelcontextprotocol/server-fetch") == "@modelcontextprotocol/server-fetch"
+        assert package_name("npm", "left-pad@1.3.0") == "left-pad"
+        assert package_name("pip", "fast-agent-mcp>=0.2.33") == "fast-agent-mcp"
+        assert package_name("pip", "uvicorn[standard]==0.30") == "uvicorn"
+        assert package_name("uv", "mcp-server-time@1.2.3") == "mcp-server-time"
+
+    def test_lockfile_path(self):
+        """Test the lockfile sits next to the Agentfile."""
+        assert lockfile_path("/work/Agentfile") == Path("/work/Agentfile.lock")
+
+    def test_generate_and_read_lock(self):
+        """Test a generated lock round-trips through disk."""
+        lock = generate_lock({"npm": ["@modelcontextprotocol/server-fetch"], "pip": ["mcp"], "uv": []}, fake_registry)
+
+        assert lock["packages"]["npm:@modelcontextprotocol/server-fetch"]["version"] == "2025.1.14"
+        assert lock["packages"]["pip:mcp"]["version"] == "1.2.3"
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            path = Path(temp_dir) / "Agentfile.lock"
+            write_lock(path, lock)
+            assert read_lock(path) == lock
+            assert read_lock(Path(temp_dir) / "missing.lock") is None
+
+    def test_stale_lock_warns(self):
+        """Test missing lock entries are recorded and left unpinned."""
+        applier = LockApplier({"packages": {}})
+        assert applier.pin("pip", "mcp") == "mcp"
+        assert applier.missing == ["mcp"]
+
+    def test_stale_lock_warning_goes_to_stderr(self):
+        """Test the stale lockfile warning is kept out of stdout, which may be piped elsewhere."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+        stdout, stderr = io.StringIO(), io.StringIO()
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir, lock={"packages": {}})
+            with redirect_stdout(stdout), redirect_stderr(stderr):
+                builder.build_all()
+
+        assert "Lockfile is stale" not in stdout.getvalue()
+        assert "Lockfile is stale" in stderr.getvalue()
+
+    def test_frozen_lock_errors(self):
+        """Test --frozen-lock turns missing entries into errors."""
+        applier = LockApplier({"packages": {}}, frozen=True)
+        with pytest.raises(ValueError, match="missing from the lockfile"):
+            applier.pin("pip", "mcp")
+
+    def test_build_pins_versions(self):
+        """Test generation pins server packages and requirements."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+        builder = AgentBuilder(config)
+        lock = generate_lock(builder.get_packages(), fake_registry)
+
+        config = AgentfileParser().parse_content(AGENTFILE)
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir, lock=lock, frozen_lock=True)
+            builder.build_all()
+
+            assert config.servers["filesystem"].args == [
+                "-y",
+                "@modelcontextprotocol/server-filesystem@2025.1.14",
+                "/data",
+            ]
+            assert config.servers["time"].args == ["mcp-server-time@1.2.3"]
+
+            requirements = (Path(temp_dir) / "requirements.txt").read_text(encoding='utf-8')
+            assert "fast-agent-mcp==1.2.3" in requirements
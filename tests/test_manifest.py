@@ -0,0 +1,157 @@
+"""Tests for the build manifest embedded into agent images."""
+
+import json
+import tempfile
+from pathlib import Path
+
+from agentman.agent_builder import AgentBuilder
+from agentman.agentfile_parser import AgentfileParser
+from agentman.manifest import (
+    MANIFEST_LABEL,
+    build_manifest,
+    describe_manifest,
+    manifest_from_labels,
+    manifest_label_value,
+)
+
+AGENTFILE = """
+FROM yeahdongcn/agentman-base:latest
+MODEL anthropic/claude-3-sonnet-20241022
+SECRET GITHUB_TOKEN ghp_supersecret
+
+MCP_SERVER github
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-github $HOME
+ENV GITHUB_PERSONAL_ACCESS_TOKEN $GITHUB_TOKEN
+
+AGENT helper
+INSTRUCTION Say "hello" and cost $5
+SERVERS github
+DEFAULT true
+"""
+
+
+def _docker_unquote(value: str) -> str:
+    """Undo Dockerfile double-quote escaping the way the Docker parser does."""
+    assert value.startswith('"') and value.endswith('"')
+    result = []
+    chars = iter(value[1:-1])
+    for char in chars:
+        result.append(next(chars) if char == "\\" else char)
+    return "".join(result)
+
+
+class TestManifest:
+    """Test suite for manifest generation."""
+
+    def test_manifest_excludes_secret_values(self):
+        """Test the manifest lists secret and env names but not their values."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+        manifest = build_manifest(config)
+
+        serialized = json.dumps(manifest)
+        assert "ghp_supersecret" not in serialized
+        assert manifest["secrets"] == ["GITHUB_TOKEN"]
+        assert manifest["run_hints"]["run_flags"][-2:] == ["-e", "ANTHROPIC_API_KEY"]
+        assert manifest["servers"]["github"]["env"] == ["GITHUB_PERSONAL_ACCESS_TOKEN"]
+        assert manifest["agents"]["helper"]["default"] is True
+
+        config = AgentfileParser().parse_content("ENV API_TOKEN=not-in-manifest")
+        manifest = build_manifest(config)
+        assert manifest["env"] == ["API_TOKEN"]
+        assert "not-in-manifest" not in json.dumps(manifest)
+
+    def test_unset_plan_iterations_is_omitted(self):
+        """Test orchestrator settings left at the framework default are not serialized."""
+        config = AgentfileParser().parse_content(
+            "AGENT a\nORCHESTRATOR unset\nAGENTS a\n\nORCHESTRATOR five\nAGENTS a\nPLAN_ITERATIONS 5"
+        )
+        orchestrators = build_manifest(config)["orchestrators"]
+
+        assert "plan_iterations" not in orchestrators["unset"]
+        assert orchestrators["five"]["plan_iterations"] == 5
+        assert "instruction" not in orchestrators["unset"]
+
+    def test_orchestrator_instruction(self):
+        """Test an orchestrator's INSTRUCTION is part of the manifest."""
+        config = AgentfileParser().parse_content(
+            'AGENT a\nORCHESTRATOR planner\nAGENTS a\nINSTRUCTION "Plan, then delegate"\n'
+        )
+
+        assert build_manifest(config)["orchestrators"]["planner"]["instruction"] == "Plan, then delegate"
+
+    def test_header_values_are_left_out(self):
+        """Test the manifest lists the headers a server sends but not their values."""
+        config = AgentfileParser().parse_content(
+            "MCP_SERVER remote\nTRANSPORT sse\nURL https://mcp.example.com/sse\n"
+            "HEADER Authorization 'Bearer ${TOKEN}'\nSECRET TOKEN\n"
+        )
+
+        assert build_manifest(config)["servers"]["remote"]["headers"] == ["Authorization"]
+
+    def test_label_value_round_trip(self):
+        """Test the LABEL encoding survives Dockerfile unquoting."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+        manifest = build_manifest(config)
+
+        label = manifest_label_value(manifest)
+        assert "$" not in label.replace("\\$", "")
+        assert manifest_from_labels({MANIFEST_LABEL: _docker_unquote(label)}) == manifest
+
+    def test_missing_label(self):
+        """Test images without the label are reported as such."""
+        assert manifest_from_labels(None) is None
+        assert manifest_from_labels({"maintainer": "someone"}) is None
+
+    def test_describe_manifest(self):
+        """Test the human-readable summary."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+        summary = describe_manifest(build_manifest(config))
+
+        assert "Framework:     fast-agent" in summary
+        assert "Shutdown:      10s grace after SIGTERM" in summary
+        assert "github (stdio): npx -y @modelcontextprotocol/server-github $HOME" in summary
+        assert "helper: model=anthropic/claude-3-sonnet-20241022 [default]" in summary
+
+    def test_build_embeds_manifest(self):
+        """Test the builder writes the manifest and the Dockerfile label."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir)
+            builder.build_all()
+
+            manifest = json.loads((Path(temp_dir) / "agentman.json").read_text(encoding='utf-8'))
+            assert manifest == build_manifest(config)
+
+            dockerfile = (Path(temp_dir) / "Dockerfile").read_text(encoding='utf-8')
+            assert "COPY agentman.json ." in dockerfile
+            assert f"LABEL {MANIFEST_LABEL}=" in dockerfile
+
+    def test_docker_socket_mount(self):
+        """Test docker-launched servers record the socket mount and install the CLI."""
+        content = """
+MCP_SERVER github
+COMMAND docker
+ARGS run -i --rm mcp/github
+ALLOW_DOCKER true
+"""
+        config = AgentfileParser().parse_content(content)
+        assert build_manifest(config)["mounts"] == ["/var/run/docker.sock:/var/run/docker.sock"]
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir)
+            builder._generate_dockerfile()
+            dockerfile = (Path(temp_dir) / "Dockerfile").read_text(encoding='utf-8')
+            assert "COPY --from=docker:cli /usr/local/bin/docker /usr/local/bin/docker" in dockerfile
+
+    def test_escape_directive_in_dockerfile(self):
+        """Test the escape directive is re-emitted and used for the label."""
+        config = AgentfileParser().parse_content("# escape=`\n" + AGENTFILE)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir)
+            builder._generate_dockerfile()
+            dockerfile = (Path(temp_dir) / "Dockerfile").read_text(encoding='utf-8')
+            assert dockerfile.startswith("# syntax=docker/dockerfile:1\n# escape=`\nFROM ")
+            assert '`"schema_version`"' in dockerfile
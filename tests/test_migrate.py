@@ -0,0 +1,96 @@
+"""Tests for migrating older Agentfile syntax."""
+
+import pytest
+
+from agentman.agentfile_parser import AgentfileParser
+from agentman.migrate import (
+    MIGRATIONS,
+    migrate_content,
+    migrate_default_flag,
+    migrate_flat_provider_secret,
+    migrate_server_alias,
+)
+
+
+def apply(migration, content):
+    """Run one migration over content and return the text, rewrite count and notes."""
+    lines, rewritten, notes = migration(content.split("\n"), AgentfileParser().logical_lines(content))
+    return "\n".join(lines), rewritten, notes
+
+
+class TestMigrations:
+    """Test suite for the individual migrations."""
+
+    def test_rules_are_unique(self):
+        """Test every migration has its own rule ID."""
+        rules = [migration.rule for migration in MIGRATIONS]
+        assert len(rules) == len(set(rules))
+
+    def test_server_alias(self):
+        """Test SERVER becomes MCP_SERVER, leaving heredoc bodies and comments alone."""
+        content = "# SERVER in a comment\nSERVER fetch\nCOMMAND uvx\nRUN <<EOF\nSERVER nope\nEOF\n  server git"
+
+        text, rewritten, notes = apply(migrate_server_alias, content)
+
+        assert text == (
+            "# SERVER in a comment\nMCP_SERVER fetch\nCOMMAND uvx\nRUN <<EOF\nSERVER nope\nEOF\n  MCP_SERVER git"
+        )
+        assert (rewritten, notes) == (2, [])
+
+    def test_flat_provider_secret(self):
+        """Test inline provider keys move into provider groups, and other secrets stay flat."""
+        content = "SECRET OPENAI_API_KEY sk-1\nSECRET GITHUB_TOKEN ghp\nSECRET ANTHROPIC_API_KEY \\\n  sk-2"
+
+        text, rewritten, notes = apply(migrate_flat_provider_secret, content)
+
+        assert text.split("\n")[:3] == ["SECRET openai", "API_KEY sk-1", "SECRET GITHUB_TOKEN ghp"]
+        assert rewritten == 1
+        assert notes == ["line 3: move SECRET ANTHROPIC_API_KEY into a provider group by hand"]
+
+    def test_default_flag(self):
+        """Test DEFAULT values are written as true and several defaults are reported."""
+        content = "AGENT a\nDEFAULT yes\n\nAGENT b\nDEFAULT true\n\nAGENT c\nDEFAULT false"
+
+        text, rewritten, notes = apply(migrate_default_flag, content)
+
+        assert "DEFAULT yes" not in text and "DEFAULT false" in text
+        assert rewritten == 1
+        assert notes == ["several entities are DEFAULT (a, b); keep DEFAULT true on only one"]
+
+
+class TestMigrateContent:
+    """Test suite for migrate_content."""
+
+    def test_migrated_file_keeps_its_configuration(self):
+        """Test the migrated file parses to the same agents, servers and generated secrets."""
+        content = """# Shared tools
+SERVER fetch
+COMMAND uvx
+ARGS mcp-server-fetch
+
+SECRET OPENAI_API_KEY sk-example
+
+AGENT helper
+SERVERS fetch
+DEFAULT 1
+"""
+        result = migrate_content(content)
+        before, after = AgentfileParser().parse_content(content), AgentfileParser().parse_content(result.text)
+
+        assert result.text.startswith("# Shared tools\nMCP_SERVER fetch\n")
+        assert result.applied == {"server-alias": 1, "flat-provider-secret": 1, "default-flag": 1}
+        assert result.manual == []
+        assert after.servers == before.servers and after.agents == before.agents
+        assert after.secrets[0].values == {"API_KEY": "sk-example"}
+
+    def test_current_syntax_is_unchanged(self):
+        """Test a file without deprecated syntax is returned as is."""
+        content = "MCP_SERVER fetch\nCOMMAND uvx\n\nAGENT helper\nDEFAULT true\n"
+        result = migrate_content(content)
+
+        assert result.text == content and result.applied == {}
+
+    def test_broken_file_is_not_rewritten(self):
+        """Test a file that does not parse is reported instead of migrated."""
+        with pytest.raises(ValueError, match="AGENT requires an agent name"):
+            migrate_content("SERVER fetch\nAGENT\n")
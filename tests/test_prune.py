@@ -0,0 +1,98 @@
+"""Tests for pruning unused definitions."""
+
+import io
+import json
+import tempfile
+from contextlib import redirect_stdout
+from pathlib import Path
+
+import pytest
+
+from agentman.agent_builder import build_from_agentfile
+from agentman.agentfile_parser import AgentfileParser
+from agentman.prune import prune_unused
+
+AGENTFILE = """
+FRAMEWORK fast-agent
+MODEL anthropic/claude-3-sonnet-20241022
+
+MCP_SERVER fetch
+COMMAND uvx
+ARGS mcp-server-fetch
+
+MCP_SERVER github
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-github@1.0.0
+
+MCP_SERVER unused
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem@1.0.0 /data
+
+AGENT researcher
+INSTRUCTION Research the topic
+SERVERS fetch
+
+AGENT writer
+INSTRUCTION Write the report
+
+AGENT reviewer
+INSTRUCTION Review pull requests
+SERVERS github
+
+CHAIN pipeline
+SEQUENCE researcher writer
+DEFAULT true
+
+ROUTER triage
+AGENTS reviewer
+"""
+
+
+class TestPruneUnused:
+    """Test suite for prune_unused."""
+
+    def test_prunes_what_the_default_never_reaches(self):
+        """Test agents, workflows and their servers outside the default entity's graph are dropped."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+        pruned = prune_unused(config)
+
+        assert pruned == ["agent reviewer", "router triage", "server github", "server unused"]
+        assert list(config.agents) == ["researcher", "writer"]
+        assert list(config.chains) == ["pipeline"]
+        assert not config.routers
+        assert list(config.servers) == ["fetch"]
+
+    def test_without_default_only_servers_are_pruned(self):
+        """Test every agent stays reachable when nothing is marked DEFAULT."""
+        config = AgentfileParser().parse_content(AGENTFILE.replace("DEFAULT true\n", ""))
+
+        assert prune_unused(config) == ["server unused"]
+        assert list(config.agents) == ["researcher", "writer", "reviewer"]
+
+    def test_unused_definitions_are_still_validated(self):
+        """Test pruning does not hide errors in definitions it would drop."""
+        with pytest.raises(ValueError, match="PLAN_ITERATIONS must be at least 1"):
+            build_from_agentfile_content(AGENTFILE + "\nORCHESTRATOR planner\nAGENTS reviewer\nPLAN_ITERATIONS 0\n")
+
+    def test_build_leaves_pruned_definitions_out(self):
+        """Test the generated agent, manifest and Dockerfile agree on what was pruned."""
+        output, files = build_from_agentfile_content(AGENTFILE)
+
+        assert "Pruned unused definitions: agent reviewer, router triage, server github, server unused" in output
+        assert "reviewer" not in files["agent.py"]
+        assert "server-github" not in files["Dockerfile"]
+        manifest = json.loads(files["agentman.json"])
+        assert sorted(manifest["agents"]) == ["researcher", "writer"]
+        assert list(manifest["servers"]) == ["fetch"]
+
+
+def build_from_agentfile_content(content):
+    """Build an Agentfile with pruning and return the printed output and the generated files."""
+    with tempfile.TemporaryDirectory() as temp_dir:
+        agentfile = Path(temp_dir) / "Agentfile"
+        agentfile.write_text(content, encoding="utf-8")
+        output_dir = Path(temp_dir) / "agent"
+        with redirect_stdout(io.StringIO()) as output:
+            build_from_agentfile(str(agentfile), str(output_dir), prune=True)
+        files = {path.name: path.read_text(encoding="utf-8") for path in output_dir.iterdir() if path.is_file()}
+    return output.getvalue(), files
@@ -0,0 +1,144 @@
+"""Tests for reading image labels from a registry, and the inspect command that uses it."""
+
+import argparse
+import hashlib
+import io
+import json
+import subprocess
+import tempfile
+from contextlib import redirect_stdout
+from pathlib import Path
+from unittest.mock import patch
+
+import pytest
+
+from agentman.cli import inspect_cli
+from agentman.manifest import MANIFEST_LABEL
+from agentman.registry import docker_credentials, fetch_image_labels, parse_image_reference
+
+LABELS = {MANIFEST_LABEL: json.dumps({"framework": "fast-agent", "base_image": "python:3.11-slim"})}
+
+
+def _document(content: dict):
+    body = json.dumps(content).encode()
+    return f"sha256:{hashlib.sha256(body).hexdigest()}", body
+
+
+class FakeRegistry:
+    """Serves one image behind a bearer token challenge and records the requests."""
+
+    def __init__(self, multi_platform: bool = False):
+        self.requests = []
+        config_digest, self.config = _document({"config": {"Labels": LABELS}})
+        self.manifest_digest, self.manifest = _document(
+            {
+                "mediaType": "application/vnd.oci.image.manifest.v1+json",
+                "config": {"digest": config_digest},
+                "layers": [],
+            }
+        )
+        self.documents = {f"manifests/{self.manifest_digest}": self.manifest, f"blobs/{config_digest}": self.config}
+        self.tag_digest = self.manifest_digest
+        if multi_platform:
+            arm_digest, arm = _document({"config": {"digest": "sha256:" + "0" * 64}})
+            self.tag_digest, index = _document(
+                {
+                    "mediaType": "application/vnd.oci.image.index.v1+json",
+                    "manifests": [
+                        {"digest": arm_digest, "platform": {"os": "linux", "architecture": "arm64"}},
+                        {"digest": self.manifest_digest, "platform": {"os": "linux", "architecture": "amd64"}},
+                    ],
+                }
+            )
+            self.documents.update({f"manifests/{self.tag_digest}": index, f"manifests/{arm_digest}": arm})
+
+    def __call__(self, method, url, headers):
+        self.requests.append((method, url, headers.get("Authorization")))
+        if url.startswith("https://auth.example.com/token"):
+            return 200, {}, json.dumps({"token": "pull-token"}).encode()
+        if headers.get("Authorization") != "Bearer pull-token":
+            challenge = 'Bearer realm="https://auth.example.com/token",service="registry.example.com"'
+            return 401, {"WWW-Authenticate": challenge}, b""
+        path = url.split("/v2/team/agent/", 1)[1]
+        if path == "manifests/v1":
+            return 200, {"Docker-Content-Digest": self.tag_digest}, b""
+        if path in self.documents:
+            return 200, {}, self.documents[path]
+        return 404, {}, b""
+
+
+class TestRegistry:
+    """Test suite for reading image labels from a registry."""
+
+    def test_parse_image_reference(self):
+        """Test references get Docker's default registry, library namespace and tag."""
+        assert parse_image_reference("python") == ("registry-1.docker.io", "library/python", "latest")
+        assert parse_image_reference("team/agent:v1") == ("registry-1.docker.io", "team/agent", "v1")
+        assert parse_image_reference("localhost:5000/agent") == ("localhost:5000", "agent", "latest")
+        digest = "sha256:" + "a" * 64
+        assert parse_image_reference(f"ghcr.io/team/agent@{digest}") == ("ghcr.io", "team/agent", digest)
+
+    def test_labels_from_manifest_and_config_blob(self):
+        """Test the tag is resolved with a HEAD request and the labels are read from the config blob."""
+        registry = FakeRegistry()
+
+        assert fetch_image_labels("registry.example.com/team/agent:v1", registry, credentials="") == LABELS
+        methods = [(method, url.rsplit("/", 2)[-2]) for method, url, _ in registry.requests]
+        assert methods[:3] == [("HEAD", "manifests"), ("GET", "auth.example.com"), ("HEAD", "manifests")]
+        assert methods[3:] == [("GET", "manifests"), ("GET", "blobs")]
+
+    def test_multi_platform_image_reads_linux_amd64(self):
+        """Test an image index is followed to its linux/amd64 manifest."""
+        assert fetch_image_labels("registry.example.com/team/agent:v1", FakeRegistry(True), credentials="") == LABELS
+
+    def test_digest_mismatch_is_rejected(self):
+        """Test content that does not match the digest it was fetched by is refused."""
+        registry = FakeRegistry()
+        registry.documents[f"manifests/{registry.manifest_digest}"] = registry.config
+
+        with pytest.raises(ValueError, match="does not match"):
+            fetch_image_labels("registry.example.com/team/agent:v1", registry, credentials="")
+
+    def test_docker_login_credentials(self):
+        """Test credentials are read from the docker config, with Docker Hub under its legacy key."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            path = Path(temp_dir) / "config.json"
+            auths = {"https://index.docker.io/v1/": {"auth": "aHViOnB3"}, "ghcr.io": {"auth": "Z2g6cHc="}}
+            path.write_text(json.dumps({"auths": auths}), encoding="utf-8")
+            assert docker_credentials("registry-1.docker.io", path) == "aHViOnB3"
+            assert docker_credentials("ghcr.io", path) == "Z2g6cHc="
+            assert docker_credentials("quay.io", path) is None
+
+
+class TestInspect:
+    """Test suite for the inspect command."""
+
+    def inspect(self, *argv) -> str:
+        args = argparse.Namespace(image="team/agent:v1", json="--json" in argv, registry="--registry" in argv)
+        output = io.StringIO()
+        with redirect_stdout(output):
+            inspect_cli(args)
+        return output.getvalue()
+
+    def test_local_image(self):
+        """Test an image the docker daemon has is read from it without contacting the registry."""
+        local = subprocess.CompletedProcess([], 0, stdout=json.dumps(LABELS))
+        with patch("agentman.cli.subprocess.run", return_value=local):
+            with patch("agentman.cli.fetch_image_labels") as fetch:
+                output = self.inspect("--json")
+
+        assert json.loads(output)["framework"] == "fast-agent"
+        fetch.assert_not_called()
+
+    def test_registry_fallback(self):
+        """Test an image the docker daemon lacks, or any image with --registry, is read from the registry."""
+        missing = subprocess.CalledProcessError(1, "docker", stderr="No such image")
+        with patch("agentman.cli.subprocess.run", side_effect=missing):
+            with patch("agentman.cli.fetch_image_labels", return_value=LABELS) as fetch:
+                assert "Framework:     fast-agent" in self.inspect()
+        fetch.assert_called_once_with("team/agent:v1")
+
+        with patch("agentman.cli.subprocess.run") as run:
+            with patch("agentman.cli.fetch_image_labels", return_value=LABELS):
+                self.inspect("--registry")
+        run.assert_not_called()
@@ -0,0 +1,69 @@
+"""Tests for fetching remote Agentfiles."""
+
+import hashlib
+import tempfile
+from pathlib import Path
+
+import pytest
+
+from agentman.remote import fetch_agentfile, is_remote
+
+CONTENT = b"FROM yeahdongcn/agentman-base:latest\nAGENT helper\n"
+DIGEST = hashlib.sha256(CONTENT).hexdigest()
+URL = "https://configs.example.com/agents/support.agentfile"
+
+
+class FakeFetch:
+    """Serves fixed content and counts requests."""
+
+    def __init__(self, content=CONTENT):
+        self.content = content
+        self.calls = []
+
+    def __call__(self, url):
+        self.calls.append(url)
+        return self.content
+
+
+class TestRemote:
+    """Test suite for remote Agentfile fetching."""
+
+    def test_is_remote(self):
+        """Test URLs are told apart from local paths."""
+        assert is_remote(URL)
+        assert is_remote("http://localhost/Agentfile")
+        assert not is_remote("Agentfile")
+        assert not is_remote("/tmp/Agentfile")
+
+    def test_fetch_and_cache(self):
+        """Test content is cached under its digest and pinned fetches reuse the cache."""
+        fetch = FakeFetch()
+        with tempfile.TemporaryDirectory() as temp_dir:
+            path = fetch_agentfile(URL, cache=Path(temp_dir), fetch=fetch)
+            assert path == Path(temp_dir) / DIGEST / "support.agentfile"
+            assert path.read_bytes() == CONTENT
+
+            assert fetch_agentfile(URL, sha256=f"sha256:{DIGEST}", cache=Path(temp_dir), fetch=fetch) == path
+            assert len(fetch.calls) == 1
+
+    def test_checksum_mismatch(self):
+        """Test a wrong digest fails and caches nothing."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            with pytest.raises(ValueError, match="Checksum mismatch"):
+                fetch_agentfile(URL, sha256="0" * 64, cache=Path(temp_dir), fetch=FakeFetch())
+            assert not any(Path(temp_dir).iterdir())
+
+    def test_plain_http_refused(self):
+        """Test plain HTTP needs --insecure-http."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            with pytest.raises(ValueError, match="--insecure-http"):
+                fetch_agentfile("http://configs.example.com/Agentfile", cache=Path(temp_dir), fetch=FakeFetch())
+            path = fetch_agentfile(
+                "http://configs.example.com/Agentfile", insecure_http=True, cache=Path(temp_dir), fetch=FakeFetch()
+            )
+            assert path.name == "Agentfile"
+
+    def test_invalid_digest(self):
+        """Test malformed digests are rejected."""
+        with pytest.raises(ValueError, match="Invalid sha256 digest"):
+            fetch_agentfile(URL, sha256="abc", fetch=FakeFetch())
@@ -0,0 +1,77 @@
+"""Tests for docker build and run hints."""
+
+import io
+import tempfile
+from contextlib import redirect_stdout
+from pathlib import Path
+
+from agentman.agent_builder import build_from_agentfile
+from agentman.agentfile_parser import AgentfileParser
+from agentman.run_hints import build_run_hints
+
+BASE_RUN_FLAGS = ["--rm", "--add-host", "host.docker.internal:host-gateway"]
+
+# (Agentfile, has prompt.txt, expected build flags, expected run flags after the common ones)
+CASES = [
+    ("FRAMEWORK agno\nAGENT a", False, [], []),
+    ("AGENT a", False, [], ["-it"]),
+    ("AGENT a", True, [], []),
+    ("FRAMEWORK agno\nAGENT a\nHUMAN_INPUT true", True, [], ["-it"]),
+    ("HUMAN_INPUT_MODE webhook https://hooks.example.com/input\nAGENT a\nHUMAN_INPUT true", True, [], []),
+    ("AGENT a\nMCP_SERVER s\nCOMMAND uvx\nELICITATION_MODE forms", True, [], ["-it"]),
+    ("AGENT a\nMCP_SERVER s\nCOMMAND uvx\nELICITATION_MODE auto", True, [], []),
+    ("FRAMEWORK agno\nSECRET GITHUB_TOKEN\nSECRET DEBUG false", False, [], ["-e", "GITHUB_TOKEN"]),
+    ("FRAMEWORK agno\nEXPOSE 8080\nEXPOSE 9090", False, [], ["-p", "8080:8080", "-p", "9090:9090"]),
+    ("SERVE mcp 8080\nAGENT a", False, [], ["-p", "8080:8080"]),
+    ('FRAMEWORK agno\nVOLUME ["/app/data", "/cache"]', False, [], ["-v", "app-data:/app/data", "-v", "cache:/cache"]),
+    ("FRAMEWORK agno\nSHUTDOWN_GRACE 30s", False, [], ["--stop-timeout", "30"]),
+    ("FRAMEWORK agno\nSHUTDOWN_GRACE 5s", False, [], []),
+    (
+        "FRAMEWORK agno\nARG VERSION\nARG CHANNEL=stable\n"
+        "RUN --mount=type=secret,id=npm_token npm ci\nRUN --mount=type=secret,target=/run/secrets/pip_conf pip i",
+        False,
+        ["--secret", "id=npm_token,env=npm_token", "--secret", "id=pip_conf,env=pip_conf", "--build-arg", "VERSION"],
+        [],
+    ),
+    (
+        "FRAMEWORK agno\nMCP_SERVER github\nCOMMAND docker\nARGS run -i ghcr.io/github/github-mcp-server\n"
+        "ALLOW_DOCKER true",
+        False,
+        [],
+        ["-v", "/var/run/docker.sock:/var/run/docker.sock"],
+    ),
+]
+
+
+class TestRunHints:
+    """Test suite for build_run_hints."""
+
+    def test_cases(self):
+        """Test each configuration yields exactly the expected flags."""
+        for content, has_prompt_file, build_flags, run_flags in CASES:
+            hints = build_run_hints(AgentfileParser().parse_content(content), has_prompt_file)
+
+            assert hints.build_flags == build_flags, content
+            assert hints.run_flags == BASE_RUN_FLAGS + run_flags, content
+
+    def test_render_is_shell_quoted(self):
+        """Test the rendered commands can be pasted into a shell."""
+        hints = build_run_hints(AgentfileParser().parse_content("FRAMEWORK agno\nEXPOSE 8080"))
+
+        assert hints.render("my agent", "out dir").split("\n") == [
+            "# Build the image",
+            "docker build -t 'my agent' 'out dir'",
+            "# Run the agent",
+            "docker run --rm --add-host host.docker.internal:host-gateway -p 8080:8080 'my agent'",
+        ]
+
+    def test_build_prints_hints(self):
+        """Test build_from_agentfile prints the hints for the requested tag."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agentfile = Path(temp_dir) / "Agentfile"
+            agentfile.write_text("FRAMEWORK agno\nSECRET GITHUB_TOKEN\nAGENT a\n", encoding="utf-8")
+            with redirect_stdout(io.StringIO()) as output:
+                build_from_agentfile(str(agentfile), str(Path(temp_dir) / "agent"), run_hints_tag="demo:latest")
+
+        output = output.getvalue()
+        assert "docker run --rm --add-host host.docker.internal:host-gateway -e GITHUB_TOKEN demo:latest" in output
@@ -0,0 +1,92 @@
+"""Tests for servers declared by name from the server registry."""
+
+import json
+import os
+import tempfile
+
+import pytest
+
+from agentman.agentfile_parser import AgentfileError, AgentfileParser
+from agentman.server_registry import BUILTIN_SERVERS, load_server_registry
+
+
+class TestServerRegistry:
+    """Test suite for the server registry."""
+
+    def setup_method(self):
+        self.temp_dir = tempfile.TemporaryDirectory()
+
+    def teardown_method(self):
+        self.temp_dir.cleanup()
+
+    def write_registry(self, entries) -> str:
+        path = os.path.join(self.temp_dir.name, "servers.json")
+        with open(path, "w", encoding="utf-8") as f:
+            f.write(entries if isinstance(entries, str) else json.dumps(entries))
+        return path
+
+    def test_bare_name_resolves(self):
+        """Test a block with no COMMAND or URL gets the registry's command, arguments and env references."""
+        content = "MCP_SERVER github\nMCP_SERVER fetch\nSECRET GITHUB_PERSONAL_ACCESS_TOKEN\n"
+        config = AgentfileParser().parse_content(content)
+
+        assert config.servers["github"].to_config_dict() == {
+            "transport": "stdio",
+            "command": "npx",
+            "args": ["-y", "@modelcontextprotocol/server-github"],
+            "env": {"GITHUB_PERSONAL_ACCESS_TOKEN": "${GITHUB_PERSONAL_ACCESS_TOKEN}"},
+        }
+        assert config.servers["github"].package.name == "@modelcontextprotocol/server-github"
+        assert (config.servers["fetch"].command, config.servers["fetch"].args) == ("uvx", ["mcp-server-fetch"])
+
+    def test_explicit_settings_win(self):
+        """Test ARGS, ENV and a top-level ENV_FILE override the registry, and a COMMAND skips it."""
+        parser = AgentfileParser(resolver=lambda path: "BRAVE_API_KEY=from-file\n")
+        config = parser.parse_content(
+            "ENV_FILE keys.env\n"
+            "MCP_SERVER filesystem\nARGS -y @modelcontextprotocol/server-filesystem /data\n"
+            "MCP_SERVER brave-search\n"
+            "MCP_SERVER github\nENV GITHUB_PERSONAL_ACCESS_TOKEN ${GITHUB_TOKEN}\n"
+            "MCP_SERVER memory\nCOMMAND /opt/memory-server\n"
+        )
+
+        assert config.servers["filesystem"].args == ["-y", "@modelcontextprotocol/server-filesystem", "/data"]
+        assert config.servers["filesystem"].command == "npx"
+        assert config.servers["brave-search"].env == {"BRAVE_API_KEY": "from-file"}
+        assert config.servers["github"].env["GITHUB_PERSONAL_ACCESS_TOKEN"] == "${GITHUB_TOKEN}"
+        assert (config.servers["memory"].command, config.servers["memory"].args) == ("/opt/memory-server", [])
+
+    def test_unknown_bare_name_fails(self):
+        """Test a name the registry does not know asks for COMMAND or URL."""
+        with pytest.raises(AgentfileError, match="Server docs is not in the server registry and sets no COMMAND"):
+            AgentfileParser().parse_content("MCP_SERVER docs\n")
+
+    def test_registry_file_adds_and_replaces_entries(self):
+        """Test entries of a registry file are added to the built-in ones, replacing those of the same name."""
+        path = self.write_registry(
+            {
+                "docs": {"transport": "sse", "url": "https://docs.example.com/sse"},
+                "fetch": {"command": "npx", "args": ["-y", "fetch-mcp"]},
+            }
+        )
+        registry = load_server_registry(path)
+        config = AgentfileParser(server_registry=registry).parse_content("MCP_SERVER docs\nMCP_SERVER fetch\n")
+
+        assert config.servers["docs"].to_config_dict() == {"transport": "sse", "url": "https://docs.example.com/sse"}
+        assert config.servers["fetch"].args == ["-y", "fetch-mcp"]
+        assert registry["github"] == BUILTIN_SERVERS["github"]
+        assert load_server_registry() == BUILTIN_SERVERS
+
+    def test_invalid_registry_file(self):
+        """Test a registry file that is not JSON or has malformed entries is rejected, naming the file."""
+        for entries, message in [
+            ("{", "invalid JSON in the server registry"),
+            ([], "must be a JSON object of server names to entries"),
+            ({"docs": {"url": "https://docs.example.com", "headers": {}}}, "entry docs has unknown key headers"),
+            ({"docs": {"command": "npx", "args": "-y docs"}}, "args of entry docs must be a list"),
+            ({"docs": {"args": ["docs"]}}, "entry docs needs a command or a url"),
+        ]:
+            path = self.write_registry(entries)
+            with pytest.raises(ValueError, match=message) as error:
+                load_server_registry(path)
+            assert str(error.value).startswith(path)
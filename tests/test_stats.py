@@ -0,0 +1,67 @@
+"""Tests for build statistics."""
+
+import json
+import tempfile
+from pathlib import Path
+
+from agentman.agent_builder import build_from_agentfile
+from agentman.stats import Stats
+
+AGENTFILE = """FROM yeahdongcn/agentman-base:latest
+MODEL anthropic/claude-3-sonnet-20241022
+SECRET ANTHROPIC_API_KEY
+
+MCP_SERVER filesystem
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-filesystem C:\\data
+
+AGENT researcher
+INSTRUCTION Research things
+
+AGENT writer
+INSTRUCTION Write things
+
+CHAIN pipeline
+SEQUENCE researcher writer
+DEFAULT true
+"""
+
+
+class TestStats:
+    """Test suite for build statistics."""
+
+    def test_build_records_stats(self):
+        """Test building from an Agentfile fills in counts and timings."""
+        with tempfile.TemporaryDirectory() as temp_dir:
+            agentfile = Path(temp_dir) / "Agentfile"
+            agentfile.write_text(AGENTFILE, encoding='utf-8')
+            output_dir = Path(temp_dir) / "agent"
+
+            stats = Stats()
+            build_from_agentfile(str(agentfile), str(output_dir), stats=stats)
+
+            assert (stats.agents, stats.servers, stats.workflows, stats.secrets) == (2, 1, 1, 1)
+            assert stats.warnings == 1
+            assert stats.files == len(list(output_dir.iterdir()))
+            assert stats.output_bytes > 0
+            assert stats.layers >= 3
+            assert set(stats.timings) == {"parse", "validate", "generate"}
+
+            assert json.loads(json.dumps(stats.to_dict()))["agents"] == 2
+
+    def test_phase_accumulates(self):
+        """Test repeated phases add up."""
+        stats = Stats()
+        with stats.phase("validate"):
+            pass
+        first = stats.timings["validate"]
+        with stats.phase("validate"):
+            pass
+        assert stats.timings["validate"] >= first
+
+    def test_render_table(self):
+        """Test the human-readable table."""
+        stats = Stats(agents=2, timings={"parse": 0.0015})
+        table = stats.render_table()
+        assert "Agents           2" in table
+        assert "Parse time       1.5 ms" in table
@@ -0,0 +1,101 @@
+"""Tests for the semantic checks of Agentfile configurations."""
+
+from agentman.agentfile_parser import Agent, AgentfileConfig, AgentfileParser, Chain, MCPServer, Router
+from agentman.validation import find_cycle
+
+
+class TestValidation:
+    """Test suite for AgentfileConfig.validate."""
+
+    def test_config_built_in_code(self):
+        """Test a configuration built without parsing gets the same checks, located by path."""
+        config = AgentfileConfig(
+            servers={"fetch": MCPServer(name="fetch", command="uvx"), "docs": MCPServer(name="docs", transport="sse")},
+            agents={"coder": Agent(name="coder", servers=["fetch", "fech"])},
+            routers={"route": Router(name="route", agents=["coder", "missing"])},
+            chains={"pipeline": Chain(name="pipeline")},
+            expose_ports=[8080, 70000],
+        )
+
+        findings = config.validate()
+
+        assert [(f.severity, f.code, f.path) for f in findings] == [
+            ("error", "missing-url", "servers.docs.url"),
+            ("error", "undefined-server", "agents.coder.servers[1]"),
+            ("error", "invalid-route", "routers.route.agents[1]"),
+            ("error", "empty-sequence", "chains.pipeline.sequence"),
+            ("warning", "implicit-default", "agents.coder"),
+            ("error", "invalid-port", "expose_ports[1]"),
+        ]
+        assert "did you mean fetch?" in findings[1].message
+        assert findings[1].line is None
+
+    def test_valid_config_has_no_findings(self):
+        """Test a consistent configuration validates cleanly."""
+        config = AgentfileConfig(
+            servers={"fetch": MCPServer(name="fetch", command="uvx")},
+            agents={"a": Agent(name="a", servers=["fetch"]), "b": Agent(name="b")},
+            chains={"pipeline": Chain(name="pipeline", sequence=["a", "b"], default=True)},
+        )
+
+        assert config.validate() == []
+
+    def test_external_servers_and_workflow_routes(self):
+        """Test the escape hatches recorded on the configuration apply to validate as well."""
+        config = AgentfileConfig(
+            agents={"a": Agent(name="a", servers=["github"]), "b": Agent(name="b")},
+            chains={"pipeline": Chain(name="pipeline", sequence=["a", "b"])},
+            routers={"route": Router(name="route", agents=["a", "pipeline"], default=True)},
+            external_servers=["github"],
+        )
+        assert [f.code for f in config.validate()] == ["invalid-route"]
+
+        config.route_to_workflows = True
+        assert config.validate() == []
+
+    def test_cycle_and_defaults(self):
+        """Test cycles and a second DEFAULT are reported with the path of the entity at fault."""
+        config = AgentfileConfig(
+            agents={"a": Agent(name="a", default=True)},
+            chains={
+                "outer": Chain(name="outer", sequence=["inner"], default=True),
+                "inner": Chain(name="inner", sequence=["a", "outer"]),
+            },
+        )
+
+        findings = config.validate()
+
+        assert [(f.code, f.path) for f in findings] == [
+            ("workflow-cycle", "chains.outer"),
+            ("multiple-defaults", "chains.outer.default"),
+        ]
+        assert findings[0].message == "CHAIN outer includes itself: outer -> inner -> outer"
+
+    def test_parser_can_collect_every_finding(self):
+        """Test a parser with validate off returns the configuration so every finding can be listed."""
+        content = "AGENT a\nSERVERS fetch\nCHAIN pipeline\nCUMULATIVE true\n"
+        config = AgentfileParser(validate=False).parse_content(content)
+
+        assert [(f.code, f.line) for f in config.validate()] == [
+            ("undefined-server", 1),
+            ("empty-sequence", 3),
+            ("implicit-default", 1),
+        ]
+
+    def test_findings_serialize(self):
+        """Test findings convert to dictionaries for --format json and print with their path."""
+        finding = AgentfileConfig(chains={"c": Chain(name="c")}).validate()[0]
+
+        assert finding.to_dict() == {
+            "severity": "error",
+            "code": "empty-sequence",
+            "path": "chains.c.sequence",
+            "message": "Chain c has no SEQUENCE of steps",
+            "line": None,
+        }
+        assert str(finding) == "chains.c.sequence: error: Chain c has no SEQUENCE of steps [empty-sequence]"
+
+    def test_find_cycle(self):
+        """Test find_cycle returns the path around the first cycle, or None."""
+        assert find_cycle({"a": ["b"], "b": ["c"], "c": []}) is None
+        assert find_cycle({"a": ["b", "x"], "b": ["c"], "c": ["b"]}) == ["b", "c", "b"]
@@ -0,0 +1,145 @@
+"""Tests for offline dependency vendoring."""
+
+import tempfile
+from pathlib import Path
+
+import pytest
+
+from agentman.agent_builder import AgentBuilder
+from agentman.agentfile_parser import AgentfileParser
+from agentman.vendor import check_offline_compatible, collect_packages, vendor_packages
+
+AGENTFILE = """
+FROM yeahdongcn/agentman-base:latest
+MODEL anthropic/claude-3-sonnet-20241022
+
+MCP_SERVER fetch
+COMMAND npx
+ARGS -y @modelcontextprotocol/server-fetch
+
+MCP_SERVER time
+COMMAND uvx
+ARGS mcp-server-time
+"""
+
+
+class FakeRunner:
+    """Records vendoring commands and creates a file per package.
+
+    Like npm, an install into a prefix removes the packages it was not asked for.
+    """
+
+    def __init__(self):
+        self.calls = []
+
+    def __call__(self, cmd, check=True):
+        self.calls.append(cmd)
+        if "--dest" in cmd:
+            dest, packages = Path(cmd[cmd.index("--dest") + 1]), cmd[cmd.index("--dest") + 2 :]
+        else:
+            dest, packages = Path(cmd[cmd.index("--prefix") + 1]), cmd[cmd.index("--no-save") + 1 :]
+            for path in dest.glob("*.pkg"):
+                path.unlink()
+        for package in packages:
+            name = package.replace("/", "_").replace("@", "")
+            (dest / f"{name}.pkg").write_text(name, encoding='utf-8')
+
+
+class TestVendor:
+    """Test suite for the vendor module."""
+
+    def test_collect_packages(self):
+        """Test packages are grouped by installer."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+        packages = collect_packages(config, ["mcp", "fast-agent-mcp>=0.2.33", "mcp"])
+
+        assert packages["pip"] == ["fast-agent-mcp>=0.2.33", "mcp"]
+        assert packages["npm"] == ["@modelcontextprotocol/server-fetch"]
+        assert packages["uv"] == ["mcp-server-time"]
+
+    def test_vendoring_is_incremental(self):
+        """Test already vendored packages are not downloaded again."""
+        packages = {"pip": ["mcp"], "npm": ["@modelcontextprotocol/server-fetch"], "uv": []}
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            vendor_dir = Path(temp_dir) / "vendor"
+            runner = FakeRunner()
+            manifest = vendor_packages(packages, vendor_dir, runner)
+            assert len(runner.calls) == 2
+            assert list(manifest["pip:mcp"]["files"]) == ["pip/mcp.pkg"]
+
+            runner = FakeRunner()
+            vendor_packages(packages, vendor_dir, runner)
+            assert runner.calls == []
+
+            # A modified file invalidates its content digest
+            (vendor_dir / "pip" / "mcp.pkg").write_text("tampered", encoding='utf-8')
+            vendor_packages(packages, vendor_dir, runner)
+            assert runner.calls == [["pip", "download", "--dest", str(vendor_dir / "pip"), "mcp"]]
+
+    def test_npm_packages_are_installed_together(self):
+        """Test npm packages share one install, so vendoring one does not prune another."""
+        packages = {"pip": [], "npm": ["@modelcontextprotocol/server-fetch", "server-memory"], "uv": []}
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            vendor_dir = Path(temp_dir) / "vendor"
+            runner = FakeRunner()
+            manifest = vendor_packages(packages, vendor_dir, runner)
+            assert runner.calls == [
+                ["npm", "install", "--prefix", str(vendor_dir / "npm"), "--no-save"] + packages["npm"]
+            ]
+            files = ["npm/modelcontextprotocol_server-fetch.pkg", "npm/server-memory.pkg"]
+            assert sorted(manifest["npm"]["files"]) == files
+
+            runner = FakeRunner()
+            vendor_packages(packages, vendor_dir, runner)
+            assert runner.calls == []
+
+            # Adding a package reinstalls all of them rather than just the new one
+            packages["npm"].append("server-time")
+            vendor_packages(packages, vendor_dir, runner)
+            assert runner.calls[0][-3:] == packages["npm"]
+            assert len(list((vendor_dir / "npm").glob("*.pkg"))) == 3
+
+    def test_network_instructions_are_rejected(self):
+        """Test build-time network access errors with an offline alternative."""
+        config = AgentfileParser().parse_content("FROM python:3.11-slim\nADD https://example.com/data.tgz /data/\n")
+        with pytest.raises(ValueError, match="COPY it instead"):
+            check_offline_compatible(config)
+
+        config = AgentfileParser().parse_content("FROM python:3.11-slim\nRUN pip install requests\n")
+        with pytest.raises(ValueError, match="cannot be used with --offline"):
+            check_offline_compatible(config)
+
+    def test_docker_servers_are_rejected(self):
+        """Test a docker-launched server is rejected, as its docker CLI is pulled from a registry."""
+        content = "MCP_SERVER github\nCOMMAND docker\nARGS run -i --rm mcp/github\nALLOW_DOCKER true\n"
+        config = AgentfileParser().parse_content(content)
+        with pytest.raises(ValueError, match="MCP server github is launched through docker"):
+            check_offline_compatible(config)
+
+    def test_offline_dockerfile(self):
+        """Test offline builds install from the vendor directory."""
+        config = AgentfileParser().parse_content(AGENTFILE)
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            builder = AgentBuilder(config, temp_dir, offline=True)
+            builder._generate_dockerfile()
+
+            content = (Path(temp_dir) / "Dockerfile").read_text(encoding='utf-8')
+            assert "COPY vendor/ /opt/agentman/vendor/" in content
+            assert "--no-index --find-links /opt/agentman/vendor/pip" in content
+            assert "ENV NPM_CONFIG_OFFLINE=true" in content
+            assert "ENV UV_OFFLINE=1" in content
+            assert "RUN pip install --no-cache-dir -r requirements.txt" not in content
+
+    def test_offline_npm_packages_go_to_the_workdir(self):
+        """Test vendored npm packages are copied to the image's WORKDIR."""
+        config = AgentfileParser().parse_content(AGENTFILE + "WORKDIR /srv\nWORKDIR agent\n")
+
+        with tempfile.TemporaryDirectory() as temp_dir:
+            AgentBuilder(config, temp_dir, offline=True)._generate_dockerfile()
+
+            content = (Path(temp_dir) / "Dockerfile").read_text(encoding='utf-8')
+            assert "RUN mkdir -p /srv/agent && cp -r /opt/agentman/vendor/npm/. /srv/agent/" in content
+            assert "/app" not in content